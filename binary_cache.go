@@ -0,0 +1,53 @@
+package iso
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// binaryExtractPath returns where to extract the Linux iso binary for arch.
+// With ISO_CACHE_DIR set the binary goes to $ISO_CACHE_DIR/bin, named by a
+// hash of its content so every project and worktree shares one copy per iso
+// version and .iso stays free of generated files. Otherwise it goes to isoDir.
+func binaryExtractPath(isoDir, arch string, content []byte) (string, error) {
+	cacheDir := os.Getenv("ISO_CACHE_DIR")
+	if cacheDir == "" {
+		return filepath.Join(isoDir, fmt.Sprintf("iso-linux-%s", arch)), nil
+	}
+
+	binDir := filepath.Join(cacheDir, "bin")
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create binary cache dir %s: %w", binDir, err)
+	}
+
+	sum := sha256.Sum256(content)
+	return filepath.Join(binDir, fmt.Sprintf("iso-linux-%s-%s", arch, hex.EncodeToString(sum[:8]))), nil
+}
+
+// writeFileAtomic writes data to path via a temporary file in the same
+// directory and a rename, so concurrent iso processes never see (or execute)
+// a partially written file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,42 @@
+package iso
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBinaryExtractPath(t *testing.T) {
+	isoDir := t.TempDir()
+
+	t.Setenv("ISO_CACHE_DIR", "")
+	got, err := binaryExtractPath(isoDir, "arm64", []byte("v1"))
+	if err != nil || got != filepath.Join(isoDir, "iso-linux-arm64") {
+		t.Fatalf("binaryExtractPath() without ISO_CACHE_DIR = %q, %v", got, err)
+	}
+
+	cacheDir := t.TempDir()
+	t.Setenv("ISO_CACHE_DIR", cacheDir)
+	v1, err := binaryExtractPath(isoDir, "arm64", []byte("v1"))
+	if err != nil {
+		t.Fatalf("binaryExtractPath() error = %v", err)
+	}
+	if !strings.HasPrefix(v1, filepath.Join(cacheDir, "bin", "iso-linux-arm64-")) {
+		t.Fatalf("binaryExtractPath() = %q, want a path in the binary cache", v1)
+	}
+	if v2, _ := binaryExtractPath(isoDir, "arm64", []byte("v2")); v2 == v1 {
+		t.Fatalf("binaryExtractPath() returned %q for different binaries", v1)
+	}
+
+	if err := writeFileAtomic(v1, []byte("v1"), 0755); err != nil {
+		t.Fatalf("writeFileAtomic() error = %v", err)
+	}
+	info, err := os.Stat(v1)
+	if err != nil || info.Mode().Perm() != 0755 {
+		t.Fatalf("written binary: %v, %v", info, err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(v1)); len(entries) != 1 {
+		t.Fatalf("binary cache has %d entries, want no leftover temp files", len(entries))
+	}
+}
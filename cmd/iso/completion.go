@@ -0,0 +1,421 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"miren.dev/mflags"
+)
+
+// completionFlag is a flag offered by shell completion
+type completionFlag struct {
+	name  string
+	short rune
+	// value is set for flags that take a value; --session values complete
+	// to the current project's sessions
+	value bool
+}
+
+// completionCommand is a command offered by shell completion. Peers commands
+// are named "peers <sub>".
+type completionCommand struct {
+	name  string
+	usage string
+	flags []completionFlag
+}
+
+// completionCommands lists the user-facing commands and their flags for
+// `iso completion`, in registration order. dispatchCommand fills it in from
+// the flag sets the commands parse with, so completion can't drift from them.
+var completionCommands []completionCommand
+
+// listFlagSets holds the flag sets of the commands with StringList flags, by
+// command name
+var listFlagSets = make(map[string]*flagSet)
+
+// flagSet is an mflags.FlagSet that also records the flags defined on it for
+// shell completion
+type flagSet struct {
+	*mflags.FlagSet
+	name  string
+	flags []completionFlag
+	// lists holds the values of the StringList flags, by flag name
+	lists map[string]*[]string
+}
+
+// newFlagSet returns an empty flag set for the named command
+func newFlagSet(name string) *flagSet {
+	return &flagSet{FlagSet: mflags.NewFlagSet(name), name: name}
+}
+
+func (fs *flagSet) String(name string, short rune, value, usage string) *string {
+	fs.flags = append(fs.flags, completionFlag{name: name, short: short, value: true})
+	return fs.FlagSet.String(name, short, value, usage)
+}
+
+func (fs *flagSet) Int(name string, short rune, value int, usage string) *int {
+	fs.flags = append(fs.flags, completionFlag{name: name, short: short, value: true})
+	return fs.FlagSet.Int(name, short, value, usage)
+}
+
+func (fs *flagSet) Bool(name string, short rune, value bool, usage string) *bool {
+	fs.flags = append(fs.flags, completionFlag{name: name, short: short})
+	return fs.FlagSet.Bool(name, short, value, usage)
+}
+
+// StringList defines a flag that can be given more than once, each time with
+// one value. mflags keeps only the last value of a flag, so takeListFlags
+// collects these from the arguments before they're parsed.
+func (fs *flagSet) StringList(name, usage string) *[]string {
+	fs.String(name, 0, "", usage)
+	if fs.lists == nil {
+		fs.lists = make(map[string]*[]string)
+	}
+	values := new([]string)
+	fs.lists[name] = values
+	return values
+}
+
+// internalCommand reports whether a command is only run by iso itself (in the
+// container), and so isn't offered by completion
+func internalCommand(name string) bool {
+	return strings.HasPrefix(name, "_") || strings.HasPrefix(name, "in-env ")
+}
+
+// dispatchCommand registers the command parsing fs with dispatcher, under the
+// flag set's name, and records it for completion unless it's internal
+func dispatchCommand(dispatcher *mflags.Dispatcher, fs *flagSet, handler func(fs *mflags.FlagSet, args []string) error, usage string) {
+	if fs.lists != nil {
+		listFlagSets[fs.name] = fs
+	}
+	if !internalCommand(fs.name) {
+		completionCommands = append(completionCommands, completionCommand{name: fs.name, usage: usage, flags: fs.flags})
+	}
+	dispatcher.Dispatch(fs.name, mflags.NewCommand(fs.FlagSet, handler, mflags.WithUsage(usage)))
+}
+
+// registerCompletionCommand registers the 'completion' command
+func registerCompletionCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("completion")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("usage: iso completion bash|zsh|fish")
+		}
+
+		var out strings.Builder
+		switch args[0] {
+		case "bash":
+			writeBashCompletion(&out)
+		case "zsh":
+			writeZshCompletion(&out)
+		case "fish":
+			writeFishCompletion(&out)
+		default:
+			return fmt.Errorf("unsupported shell %q (supported: bash, zsh, fish)", args[0])
+		}
+		fmt.Print(out.String())
+		return nil
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "Output a shell completion script (bash, zsh or fish)")
+}
+
+// Shell commands the completion scripts run for dynamic values
+const (
+	completionSessionsCmd = "iso list --sessions 2>/dev/null"
+	completionContextsCmd = "docker context ls --format '{{.Name}}' 2>/dev/null"
+)
+
+// topLevelCommands returns the first word of every completion command, once
+func topLevelCommands() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, c := range completionCommands {
+		name, _, _ := strings.Cut(c.name, " ")
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// peersCommands returns the commands nested under `iso peers`
+func peersCommands() []completionCommand {
+	var cmds []completionCommand
+	for _, c := range completionCommands {
+		if sub, ok := strings.CutPrefix(c.name, "peers "); ok {
+			cmds = append(cmds, completionCommand{name: sub, usage: c.usage, flags: c.flags})
+		}
+	}
+	return cmds
+}
+
+// flagWords returns the --long and -short spellings of flags
+func flagWords(flags []completionFlag) []string {
+	var words []string
+	for _, f := range flags {
+		words = append(words, "--"+f.name)
+		if f.short != 0 {
+			words = append(words, "-"+string(f.short))
+		}
+	}
+	return words
+}
+
+func writeBashCompletion(w io.Writer) {
+	var peers []string
+	for _, c := range peersCommands() {
+		peers = append(peers, c.name)
+	}
+
+	fmt.Fprintf(w, `# bash completion for iso
+_iso() {
+	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+	local i=1
+	COMPREPLY=()
+
+	# Skip the global --context NAME and --timeout DURATION
+	while [[ ${COMP_WORDS[i]} == --context || ${COMP_WORDS[i]} == --timeout ]]; do
+		if (( COMP_CWORD == i + 1 )); then
+			[[ ${COMP_WORDS[i]} == --context ]] && COMPREPLY=($(compgen -W "$(%s)" -- "$cur"))
+			return
+		fi
+		i=$((i + 2))
+	done
+
+	if (( COMP_CWORD == i )); then
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+		[[ $cur == -* ]] && COMPREPLY+=($(compgen -W "--context --timeout" -- "$cur"))
+		return
+	fi
+
+	local cmd="${COMP_WORDS[i]}"
+	if [[ $cmd == peers ]]; then
+		if (( COMP_CWORD == i + 1 )); then
+			COMPREPLY=($(compgen -W "%s" -- "$cur"))
+			return
+		fi
+		cmd="peers ${COMP_WORDS[i+1]}"
+	fi
+
+	case "$prev" in
+	--session|-s)
+		COMPREPLY=($(compgen -W "$(%s)" -- "$cur"))
+		return
+		;;
+	esac
+
+	if [[ $cmd == completion ]]; then
+		COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur"))
+		return
+	fi
+
+	[[ $cur == -* ]] || return
+	local flags=""
+	case "$cmd" in
+`, completionContextsCmd, strings.Join(topLevelCommands(), " "), strings.Join(peers, " "), completionSessionsCmd)
+
+	for _, c := range completionCommands {
+		if len(c.flags) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\t%q) flags=%q ;;\n", c.name, strings.Join(flagWords(c.flags), " "))
+	}
+
+	fmt.Fprint(w, `	esac
+	COMPREPLY=($(compgen -W "$flags" -- "$cur"))
+}
+complete -o default -F _iso iso
+`)
+}
+
+// zshQuote escapes s for use inside a single-quoted zsh completion spec
+func zshQuote(s string) string {
+	return strings.NewReplacer(`'`, `'\''`, `[`, `\[`, `]`, `\]`, `:`, `\:`).Replace(s)
+}
+
+// zshDescribe writes the body of a zsh array of name:description entries
+func zshDescribe(w io.Writer, cmds []completionCommand) {
+	for _, c := range cmds {
+		fmt.Fprintf(w, "\t\t'%s:%s'\n", c.name, zshQuote(c.usage))
+	}
+}
+
+func writeZshCompletion(w io.Writer) {
+	var top []completionCommand
+	for _, c := range completionCommands {
+		if !strings.HasPrefix(c.name, "peers ") {
+			top = append(top, c)
+		}
+	}
+	top = append(top, completionCommand{name: "peers", usage: "Manage peer containers for distributed testing"})
+
+	fmt.Fprintf(w, `#compdef iso
+
+_iso_sessions() {
+	local -a sessions
+	sessions=(${(f)"$(%s)"})
+	_describe -t sessions 'session' sessions
+}
+
+_iso_contexts() {
+	local -a contexts
+	contexts=(${(f)"$(%s)"})
+	_describe -t contexts 'docker context' contexts
+}
+
+_iso() {
+	local -a commands peers_commands
+	commands=(
+`, completionSessionsCmd, completionContextsCmd)
+	zshDescribe(w, top)
+	fmt.Fprint(w, "\t)\n\tpeers_commands=(\n")
+	zshDescribe(w, peersCommands())
+	fmt.Fprint(w, `	)
+
+	# Skip the global --context NAME and --timeout DURATION
+	while [[ $words[2] == (--context|--timeout) ]]; do
+		if (( CURRENT == 3 )); then
+			[[ $words[2] == --context ]] && _iso_contexts
+			return
+		fi
+		words=($words[1] $words[4,-1])
+		(( CURRENT -= 2 ))
+	done
+
+	if (( CURRENT == 2 )); then
+		if [[ $PREFIX == -* ]]; then
+			compadd -- --context --timeout
+		else
+			_describe -t commands 'iso command' commands
+		fi
+		return
+	fi
+
+	local cmd=$words[2]
+	shift words
+	(( CURRENT-- ))
+	if [[ $cmd == peers ]]; then
+		if (( CURRENT == 2 )); then
+			_describe -t commands 'peers command' peers_commands
+			return
+		fi
+		cmd="peers $words[2]"
+		shift words
+		(( CURRENT-- ))
+	fi
+
+	case $cmd in
+`)
+
+	for _, c := range completionCommands {
+		if len(c.flags) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\t(%s)\n\t\t_arguments -s -S \\\n", strings.ReplaceAll(c.name, " ", `\ `))
+		for _, f := range c.flags {
+			action := ""
+			if f.value {
+				action = ":" + f.name + ": "
+				if f.name == "session" {
+					action = ":session:_iso_sessions"
+				}
+			}
+			switch {
+			case f.short == 0:
+				fmt.Fprintf(w, "\t\t\t'--%s%s' \\\n", f.name, action)
+			case action == "":
+				fmt.Fprintf(w, "\t\t\t'(-%c --%s)'{-%c,--%s} \\\n", f.short, f.name, f.short, f.name)
+			default:
+				fmt.Fprintf(w, "\t\t\t'(-%c --%s)'{-%c,--%s}'%s' \\\n", f.short, f.name, f.short, f.name, action)
+			}
+		}
+		rest := "'*::argument:_default'"
+		if c.name == "run" {
+			rest = "'*::command:_normal'"
+		}
+		fmt.Fprintf(w, "\t\t\t%s\n\t\t;;\n", rest)
+	}
+
+	fmt.Fprint(w, `	(completion)
+		_values 'shell' bash zsh fish
+		;;
+	(*)
+		_default
+		;;
+	esac
+}
+
+if [[ $funcstack[1] == _iso ]]; then
+	_iso "$@"
+else
+	compdef _iso iso
+fi
+`)
+}
+
+// fishQuote escapes s for use inside a single-quoted fish string
+func fishQuote(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
+
+func writeFishCompletion(w io.Writer) {
+	fmt.Fprint(w, `# fish completion for iso
+
+# __iso_cmd prints the command being completed ("" before one is typed,
+# "peers up" for peers commands), skipping the global --context NAME and
+# --timeout DURATION
+function __iso_cmd
+	set -l tokens (commandline -opc)
+	set -e tokens[1]
+	while contains -- "$tokens[1]" --context --timeout
+		set -e tokens[1]
+		set -q tokens[1]; and set -e tokens[1]
+	end
+	if test "$tokens[1]" = peers
+		echo peers $tokens[2]
+		return
+	end
+	echo $tokens[1]
+end
+
+function __iso_using
+	set -l cmd (__iso_cmd)
+	test "$cmd" = "$argv"
+end
+
+`)
+	fmt.Fprintf(w, "complete -c iso -n '__iso_using \"\"' -f -l context -x -a \"(%s)\" -d 'Docker context to use'\n", completionContextsCmd)
+	fmt.Fprint(w, "complete -c iso -n '__iso_using \"\"' -f -l timeout -x -d 'Cancel Docker operations after this long'\n")
+
+	for _, c := range completionCommands {
+		if strings.HasPrefix(c.name, "peers ") {
+			continue
+		}
+		fmt.Fprintf(w, "complete -c iso -n '__iso_using \"\"' -f -a %s -d '%s'\n", c.name, fishQuote(c.usage))
+	}
+	fmt.Fprint(w, "complete -c iso -n '__iso_using \"\"' -f -a peers -d 'Manage peer containers for distributed testing'\n")
+	for _, c := range peersCommands() {
+		fmt.Fprintf(w, "complete -c iso -n '__iso_using peers' -f -a %s -d '%s'\n", c.name, fishQuote(c.usage))
+	}
+
+	fmt.Fprint(w, "complete -c iso -n '__iso_using completion' -f -a 'bash zsh fish'\n")
+
+	for _, c := range completionCommands {
+		for _, f := range c.flags {
+			fmt.Fprintf(w, "complete -c iso -n '__iso_using %s' -l %s", c.name, f.name)
+			if f.short != 0 {
+				fmt.Fprintf(w, " -s %c", f.short)
+			}
+			if f.name == "session" {
+				fmt.Fprintf(w, " -x -a '(%s)'", completionSessionsCmd)
+			} else if f.value {
+				fmt.Fprint(w, " -r")
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/moby/term"
+)
+
+// Detached runs execute under _internal-job, which gives the command a
+// pseudo-terminal, copies its output to JOB_PATH.log and serves attach
+// clients (_internal-job-attach, run by `iso attach`) on JOB_PATH.sock.
+// Clients send framed input and window sizes and receive the raw output.
+
+// Frame types sent by attach clients
+const (
+	jobFrameInput  = 'i'
+	jobFrameResize = 'r'
+)
+
+// jobReplayLines is how much of a job's earlier output a client is sent when
+// it attaches
+const jobReplayLines = 50
+
+// Ctrl+P followed by Ctrl+Q detaches from a job, as with docker attach
+const (
+	ctrlP = 0x10
+	ctrlQ = 0x11
+)
+
+// writeJobFrame writes a frame: its type, the payload length and the payload
+func writeJobFrame(w io.Writer, kind byte, payload []byte) error {
+	frame := make([]byte, 5, 5+len(payload))
+	frame[0] = kind
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	_, err := w.Write(append(frame, payload...))
+	return err
+}
+
+// readJobFrame reads a frame written by writeJobFrame
+func readJobFrame(r io.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(header[1:])
+	if n > 1<<20 {
+		return 0, nil, fmt.Errorf("job frame too large (%d bytes)", n)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// jobServer copies a job's terminal output to its log and the attached
+// clients, and the clients' input and window sizes to its terminal
+type jobServer struct {
+	pty     *os.File
+	logPath string
+
+	mu      sync.Mutex
+	log     *os.File
+	clients map[net.Conn]bool
+	closed  bool
+}
+
+// Write appends job output to the log and sends it to the attached clients,
+// dropping clients that can't keep up. It never fails: a job blocks once its
+// terminal's buffer fills, so output must be consumed even if the log can't
+// be written.
+func (s *jobServer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, _ = s.log.Write(p)
+	for conn := range s.clients {
+		conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write(p); err != nil {
+			conn.Close()
+			delete(s.clients, conn)
+		}
+	}
+	return len(p), nil
+}
+
+// serve accepts attach clients on ln until it's closed
+func (s *jobServer) serve(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle sends a new client the end of the log and, from then on, the job's
+// output, and forwards the client's input until it disconnects
+func (s *jobServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	// Holding mu while replaying keeps output from being missed or repeated
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	replay, err := logTail(s.logPath, jobReplayLines)
+	if err == nil {
+		conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Write(replay)
+	}
+	if err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.clients[conn] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, conn)
+		s.mu.Unlock()
+	}()
+
+	for {
+		kind, payload, err := readJobFrame(conn)
+		if err != nil {
+			return
+		}
+		switch kind {
+		case jobFrameInput:
+			if _, err := s.pty.Write(payload); err != nil {
+				return
+			}
+		case jobFrameResize:
+			if len(payload) == 4 {
+				_ = setPtySize(s.pty, binary.BigEndian.Uint16(payload), binary.BigEndian.Uint16(payload[2:]))
+			}
+		}
+	}
+}
+
+// close disconnects the attached clients, which then find the exit code
+func (s *jobServer) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for conn := range s.clients {
+		conn.Close()
+	}
+	clear(s.clients)
+}
+
+// logTail returns the last n lines of the log at path
+func logTail(path string, n int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	offset, err := tailOffset(f, n)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(f)
+}
+
+// runJob runs command as the detached job at jobPath: on a pseudo-terminal,
+// with its output going to jobPath.log and attach clients served on
+// jobPath.sock. The exit code is recorded in jobPath.exit when it finishes.
+func runJob(jobPath string, command []string) error {
+	if err := os.MkdirAll(filepath.Dir(jobPath), 0755); err != nil {
+		return fmt.Errorf("failed to create jobs directory: %w", err)
+	}
+	// The logs live on a tmpfs, so don't let finished ones pile up
+	pruneFinishedJobs(filepath.Dir(jobPath), keepFinishedJobs)
+
+	// The pid tells readers of the log whether the job is still running if
+	// it's killed before recording an exit code. It's written first so a
+	// reader never finds the log without it.
+	if err := os.WriteFile(jobPath+".pid", []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		return fmt.Errorf("failed to write job pid file: %w", err)
+	}
+	logFile, err := os.OpenFile(jobPath+".log", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create job log: %w", err)
+	}
+	defer logFile.Close()
+
+	master, slave, err := openPty()
+	if err != nil {
+		return err
+	}
+	defer master.Close()
+
+	server := &jobServer{pty: master, logPath: jobPath + ".log", log: logFile, clients: make(map[net.Conn]bool)}
+	ln, err := net.Listen("unix", jobPath+".sock")
+	if err != nil {
+		slave.Close()
+		return fmt.Errorf("failed to listen for attach clients: %w", err)
+	}
+	go server.serve(ln)
+
+	outputDone := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(server, master)
+		close(outputDone)
+	}()
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+
+	exitCode := 0
+	err = cmd.Start()
+	slave.Close()
+	if err == nil {
+		err = cmd.Wait()
+	}
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				exitCode = 128 + int(status.Signal())
+			}
+		} else {
+			fmt.Fprintf(server, "iso: failed to execute command: %v\r\n", err)
+			exitCode = 127
+		}
+	}
+
+	// Output still buffered in the terminal can be read until the last
+	// process holding it exits; don't wait on background processes that
+	// keep it open
+	select {
+	case <-outputDone:
+	case <-time.After(time.Second):
+	}
+
+	writeErr := os.WriteFile(jobPath+".exit", []byte(strconv.Itoa(exitCode)), 0644)
+	ln.Close()
+	server.close()
+	if writeErr != nil {
+		return fmt.Errorf("failed to write job exit code: %w", writeErr)
+	}
+	return nil
+}
+
+// detachKeys spots the Ctrl+P Ctrl+Q sequence in terminal input
+type detachKeys struct {
+	pending bool
+}
+
+// filter returns the part of input to send to the job and whether the detach
+// sequence was typed. A Ctrl+P is held back until the next key shows whether
+// it starts the sequence.
+func (d *detachKeys) filter(input []byte) ([]byte, bool) {
+	out := make([]byte, 0, len(input)+1)
+	for _, b := range input {
+		if d.pending {
+			d.pending = false
+			if b == ctrlQ {
+				return out, true
+			}
+			out = append(out, ctrlP)
+		}
+		if b == ctrlP {
+			d.pending = true
+			continue
+		}
+		out = append(out, b)
+	}
+	return out, false
+}
+
+// attachJob connects stdin and stdout to the running job at jobPath until
+// the job exits, returning its exit code as an ExitError, or until Ctrl+P
+// Ctrl+Q detaches, leaving it running. With stdin a terminal, the terminal is
+// put into raw mode, so keys like Ctrl+C reach the job, and its size is kept
+// in sync with the job's.
+func attachJob(jobPath string) error {
+	jobID := filepath.Base(jobPath)
+
+	conn, err := net.Dial("unix", jobPath+".sock")
+	if err != nil {
+		if _, statErr := os.Stat(jobPath + ".log"); os.IsNotExist(statErr) {
+			return fmt.Errorf("job %s not found", jobID)
+		}
+		return fmt.Errorf("job %s is not running, see its output with: iso logs --job %s", jobID, jobID)
+	}
+	defer conn.Close()
+
+	var sendMu sync.Mutex
+	send := func(kind byte, payload []byte) error {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		return writeJobFrame(conn, kind, payload)
+	}
+
+	fd := os.Stdin.Fd()
+	isTerminal := term.IsTerminal(fd)
+	if isTerminal {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return fmt.Errorf("failed to set terminal to raw mode: %w", err)
+		}
+		defer term.RestoreTerminal(fd, state)
+
+		sendSize := func() {
+			if ws, err := term.GetWinsize(fd); err == nil {
+				payload := make([]byte, 4)
+				binary.BigEndian.PutUint16(payload, ws.Height)
+				binary.BigEndian.PutUint16(payload[2:], ws.Width)
+				_ = send(jobFrameResize, payload)
+			}
+		}
+		sendSize()
+
+		winch := make(chan os.Signal, 1)
+		signal.Notify(winch, syscall.SIGWINCH)
+		defer signal.Stop(winch)
+		go func() {
+			for range winch {
+				sendSize()
+			}
+		}()
+	}
+
+	detached := make(chan struct{})
+	go func() {
+		var keys detachKeys
+		buf := make([]byte, 4096)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if n > 0 {
+				input, detach := buf[:n], false
+				if isTerminal {
+					input, detach = keys.filter(input)
+				}
+				if len(input) > 0 {
+					if err := send(jobFrameInput, input); err != nil {
+						return
+					}
+				}
+				if detach {
+					close(detached)
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	outputDone := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(os.Stdout, conn)
+		close(outputDone)
+	}()
+
+	select {
+	case <-detached:
+		fmt.Fprintf(os.Stderr, "\r\niso: detached from job %s, attach again with: iso attach --job %s\r\n", jobID, jobID)
+		return nil
+	case <-outputDone:
+	}
+
+	data, err := os.ReadFile(jobPath + ".exit")
+	if err != nil {
+		if pidFileAlive(jobPath + ".pid") {
+			return fmt.Errorf("lost the connection to job %s", jobID)
+		}
+		return fmt.Errorf("job %s stopped without recording an exit code", jobID)
+	}
+	exitCode, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("invalid exit code for job %s: %w", jobID, err)
+	}
+	if exitCode != 0 {
+		return &ExitError{Code: exitCode}
+	}
+	return nil
+}
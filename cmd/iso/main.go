@@ -1,20 +1,31 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"crypto/rand"
 	_ "embed"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
+	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/docker/go-units"
 	"miren.dev/iso"
 	"miren.dev/mflags"
 	"miren.dev/trifle"
@@ -56,28 +67,77 @@ func main() {
 			os.Exit(exitErr.Code)
 		}
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if errors.Is(err, iso.ErrNoIsoDir) {
+			printInitHint()
+		}
 		os.Exit(1)
 	}
 }
 
-func run() error {
-	dispatcher := mflags.NewDispatcher("iso")
+// printInitHint tells first-time users how to set up the current directory
+// after a command failed because it isn't an iso project
+func printInitHint() {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return
+	}
+	suggestion := iso.SuggestInit(cwd)
+
+	fmt.Fprintf(os.Stderr, "\nHint: %s is not an iso project yet. To set it up:\n", cwd)
+	if suggestion.Template != "" {
+		fmt.Fprintf(os.Stderr, "  %-30s # start from the bundled %s template\n", "iso init --template "+suggestion.Template, suggestion.Template)
+	}
+	fmt.Fprintf(os.Stderr, "  %-30s # generate .iso/Dockerfile and services.yml with Claude\n", "iso init")
+	if suggestion.ComposeFile != "" {
+		fmt.Fprintf(os.Stderr, "Found %s: `iso init` takes its services into account, or copy them into .iso/services.yml.\n", suggestion.ComposeFile)
+	}
+	fmt.Fprintln(os.Stderr, "Run `iso init --list-templates` to see all templates.")
+}
 
-	// Register commands
+// registerCommands registers every iso command with dispatcher
+func registerCommands(dispatcher *mflags.Dispatcher) {
 	registerRunCommand(dispatcher)
+	registerShellCommand(dispatcher)
 	registerBuildCommand(dispatcher)
+	registerPushCommand(dispatcher)
 	registerStartCommand(dispatcher)
 	registerStopCommand(dispatcher)
 	registerResetCommand(dispatcher)
+	registerRestartCommand(dispatcher)
+	registerLogsCommand(dispatcher)
+	registerAttachCommand(dispatcher)
+	registerCpCommand(dispatcher)
 	registerStatusCommand(dispatcher)
+	registerInspectCommand(dispatcher)
 	registerListCommand(dispatcher)
+	registerSessionsCommand(dispatcher)
+	registerOverviewCommand(dispatcher)
+	registerDfCommand(dispatcher)
 	registerPruneCommand(dispatcher)
+	registerGcCommand(dispatcher)
+	registerReapCommand(dispatcher)
 	registerCleanupCommand(dispatcher)
+	registerCleanCommand(dispatcher)
 	registerInitCommand(dispatcher)
+	registerValidateCommand(dispatcher)
 	registerInternalInitCommand(dispatcher)
+	registerInternalInitReadyCommand(dispatcher)
+	registerInternalIdleCommand(dispatcher)
+	registerInternalProbeCommand(dispatcher)
+	registerInternalSecretCommand(dispatcher)
+	registerInternalSignalCommand(dispatcher)
+	registerInternalChownCommand(dispatcher)
+	registerInternalJobCommand(dispatcher)
+	registerInternalJobAttachCommand(dispatcher)
+	registerInternalJobLogCommand(dispatcher)
+	registerInternalJobsCommand(dispatcher)
+	registerInternalHostsCommand(dispatcher)
 	registerInEnvCommand(dispatcher)
 	registerAgentHelpCommand(dispatcher)
 	registerVersionCommand(dispatcher)
+	registerCompletionCommand(dispatcher)
+	registerDebugContainerCommand(dispatcher)
+	registerServeCommand(dispatcher)
 
 	// Peers commands
 	registerPeersUpCommand(dispatcher)
@@ -85,9 +145,186 @@ func run() error {
 	registerPeersExecCommand(dispatcher)
 	registerPeersShellCommand(dispatcher)
 	registerPeersStatusCommand(dispatcher)
+}
+
+func run() error {
+	dispatcher := mflags.NewDispatcher("iso")
+	registerCommands(dispatcher)
+
+	args, global, err := parseGlobalFlags(os.Args[1:])
+	if err != nil {
+		return err
+	}
+	if global.context != "" {
+		iso.SetDockerContext(global.context)
+	}
+
+	args, err = takeListFlags(args)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := operationContext(args, global.timeout)
+	defer cancel()
+	iso.SetOperationContext(ctx)
 
 	// Execute the dispatcher
-	return dispatcher.Execute(os.Args[1:])
+	err = dispatcher.Execute(args)
+	var exitErr *ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		switch ctx.Err() {
+		case context.Canceled:
+			fmt.Fprintf(os.Stderr, "Error: interrupted: %v\n", err)
+			return &ExitError{Code: 130}
+		case context.DeadlineExceeded:
+			return fmt.Errorf("gave up after --timeout %s: %w", global.timeout, err)
+		}
+	}
+	return err
+}
+
+// globalFlags are the options given before the command, which apply to any
+// command
+type globalFlags struct {
+	// context selects the Docker context
+	context string
+	// timeout bounds the Docker operations of the whole invocation
+	timeout time.Duration
+}
+
+// parseGlobalFlags strips the leading global flags --context NAME and
+// --timeout DURATION (or --flag=VALUE) from args
+func parseGlobalFlags(args []string) ([]string, globalFlags, error) {
+	var flags globalFlags
+	for len(args) > 0 {
+		name, value, hasValue := strings.Cut(args[0], "=")
+		if name != "--context" && name != "--timeout" {
+			break
+		}
+		if hasValue {
+			args = args[1:]
+		} else if len(args) >= 2 && !strings.HasPrefix(args[1], "-") {
+			value = args[1]
+			args = args[2:]
+		} else {
+			args = args[1:]
+		}
+
+		switch name {
+		case "--context":
+			if value == "" {
+				return nil, flags, fmt.Errorf("--context requires a Docker context name")
+			}
+			flags.context = value
+		case "--timeout":
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return nil, flags, fmt.Errorf("invalid --timeout %q (expected a positive duration like 10m)", value)
+			}
+			flags.timeout = timeout
+		}
+	}
+	return args, flags, nil
+}
+
+// takeListFlags removes the StringList flags of the command args runs
+// (--flag VALUE or --flag=VALUE, up to a "--") from args and appends their
+// values to the flag set's lists, in order
+func takeListFlags(args []string) ([]string, error) {
+	if len(args) == 0 || listFlagSets[args[0]] == nil {
+		return args, nil
+	}
+	fs := listFlagSets[args[0]]
+
+	// Values of the command's other flags are skipped, so one that happens
+	// to look like a list flag isn't taken
+	takesValue := make(map[string]bool)
+	for _, flag := range fs.flags {
+		if flag.value {
+			takesValue["--"+flag.name] = true
+			if flag.short != 0 {
+				takesValue["-"+string(flag.short)] = true
+			}
+		}
+	}
+
+	rest := []string{args[0]}
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		name, value, hasValue := strings.Cut(arg, "=")
+		values := fs.lists[strings.TrimPrefix(name, "--")]
+		if !strings.HasPrefix(name, "--") || values == nil {
+			rest = append(rest, arg)
+			if takesValue[arg] && i+1 < len(args) {
+				i++
+				rest = append(rest, args[i])
+			}
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		*values = append(*values, value)
+	}
+	return rest, nil
+}
+
+// handlesSignals reports whether the command args runs handles SIGINT and
+// SIGTERM itself: commands that forward them to what they run in a
+// container, the daemon, and the internal commands run inside containers
+func handlesSignals(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch args[0] {
+	case "run", "shell", "attach", "serve", "in-env", "__debug-container":
+		return true
+	case "peers":
+		return len(args) > 1 && (args[1] == "exec" || args[1] == "shell")
+	}
+	return strings.HasPrefix(args[0], "_internal-")
+}
+
+// operationContext returns the context the Docker operations of the command
+// args run under. It's cancelled once timeout (if set) expires and, unless
+// the command handles signals itself, on SIGINT or SIGTERM, so a hung pull or
+// build can be interrupted cleanly. A second signal exits immediately, for
+// work that doesn't observe the context.
+func operationContext(args []string, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancelTimeout := context.Background(), context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	if handlesSignals(args) {
+		return ctx, func() {
+			cancel()
+			cancelTimeout()
+		}
+	}
+
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigChan
+		slog.Debug("received signal, cancelling Docker operations", "signal", sig)
+		cancel()
+		sig = <-sigChan
+		os.Exit(128 + int(sig.(syscall.Signal)))
+	}()
+	return ctx, func() {
+		signal.Stop(sigChan)
+		cancel()
+		cancelTimeout()
+	}
 }
 
 // getSession returns the session name and whether it's ephemeral
@@ -137,9 +374,33 @@ func isValidEnvVarName(name string) bool {
 
 // registerRunCommand registers the 'run' command
 func registerRunCommand(dispatcher *mflags.Dispatcher) {
-	fs := mflags.NewFlagSet("run")
+	fs := newFlagSet("run")
 
 	session := fs.String("session", 's', "", "Session name (default: ISO_SESSION env var or ephemeral)")
+	copyOut := fs.StringList("copy-out", "Copy CONTAINER_PATH:HOST_PATH out of the container after a successful run (repeat for multiple)")
+	copyOutAlways := fs.Bool("copy-out-always", 0, false, "Perform --copy-out even if the command exits non-zero")
+	jobs := fs.Int("jobs", 'j', 0, "Max concurrent service starts and image pulls (default: config max_parallel)")
+	interactive := fs.Bool("interactive", 'i', false, "Keep stdin open after local EOF (like docker run -i)")
+	tty := fs.Bool("tty", 't', false, "Allocate a pseudo-TTY even when stdin is not a terminal")
+	profile := fs.StringList("profile", "Activate a service profile (repeat for multiple, default: config active_profiles)")
+	keepGoing := fs.Bool("keep-going", 'k', false, "With --then steps, run every step even after one fails")
+	withService := fs.String("with-service", 0, "", "Start only these services for the run (comma-separated, default: all active services)")
+	service := fs.String("service", 0, "", "Same as --with-service")
+	noServices := fs.Bool("no-services", 0, false, "Start no services for the run")
+	printCommand := fs.Bool("print-command", 0, false, "Print the resolved docker exec (container, workdir, env, command) before running")
+	dryRun := fs.Bool("dry-run", 0, false, "Print the resolved docker exec and exit without running anything")
+	execTimeout := fs.String("exec-timeout", 0, "", "Fail if creating or attaching to the exec takes longer than this (e.g. 10s, default 30s)")
+	captureMetrics := fs.String("capture-metrics", 0, "", "Write peak memory and CPU time of the run as JSON to PATH")
+	metricsInterval := fs.String("metrics-interval", 0, "", "Sample interval for --capture-metrics (e.g. 500ms, default 1s)")
+	teeJSONEvents := fs.String("tee-json-events", 0, "", "Write run phase events (services, container, commands, teardown) as NDJSON to PATH")
+	mountSecret := fs.String("mount-secret", 0, "", "Mount ID=HOST_PATH at /run/secrets/ID on a tmpfs for the run (comma-separated for multiple)")
+	privileged := fs.Bool("privileged", 0, false, "Run in a privileged container, recreating the session container if it isn't one")
+	noAutoRebuild := fs.Bool("no-auto-rebuild", 0, false, "Use the existing image even if .iso/Dockerfile or build_args changed")
+	noPrivileged := fs.Bool("no-privileged", 0, false, "Run in an unprivileged container, recreating the session container if it is privileged")
+	envFile := fs.String("env-file", 0, "", "Read KEY=VALUE environment variables from these dotenv files (comma-separated, later files win)")
+	watch := fs.Bool("watch", 'w', false, "Re-run the command in the same container whenever a project file changes, until interrupted")
+	workdir := fs.String("workdir", 0, "", "Run in this container directory instead of the one matching the current directory (relative paths are joined to the configured workdir)")
+	detach := fs.Bool("detach", 'd', false, "Start the command in the background and print its job ID (see iso attach and iso logs --job)")
 
 	// Allow unknown flags to pass through to the command
 	fs.AllowUnknownFlags(true)
@@ -149,6 +410,40 @@ func registerRunCommand(dispatcher *mflags.Dispatcher) {
 		// Unknown flags come after positional args
 		command := append(args, fs.UnknownFlags()...)
 
+		copyOutSpecs, err := parseCopyOutSpecs(*copyOut)
+		if err != nil {
+			return err
+		}
+
+		secrets, err := parseSecretSpecs(*mountSecret)
+		if err != nil {
+			return err
+		}
+
+		var privilegedOverride *bool
+		switch {
+		case *privileged && *noPrivileged:
+			return fmt.Errorf("--privileged and --no-privileged are mutually exclusive")
+		case *privileged, *noPrivileged:
+			privilegedOverride = privileged
+		}
+
+		var sampleInterval time.Duration
+		if *metricsInterval != "" {
+			sampleInterval, err = time.ParseDuration(*metricsInterval)
+			if err != nil || sampleInterval <= 0 {
+				return fmt.Errorf("invalid --metrics-interval %q (expected a positive duration like 500ms)", *metricsInterval)
+			}
+		}
+
+		var execSetupTimeout time.Duration
+		if *execTimeout != "" {
+			execSetupTimeout, err = time.ParseDuration(*execTimeout)
+			if err != nil || execSetupTimeout <= 0 {
+				return fmt.Errorf("invalid --exec-timeout %q (expected a positive duration like 10s)", *execTimeout)
+			}
+		}
+
 		// Parse environment variables from the command
 		// Environment variables are KEY=VALUE at the start of the command
 		var envVars []string
@@ -170,13 +465,147 @@ func registerRunCommand(dispatcher *mflags.Dispatcher) {
 			break
 		}
 
+		// Variables from --env-file apply first, so KEY=VALUE args override them
+		var fileEnvVars []string
+		for _, path := range splitCommaList(*envFile) {
+			vars, err := parseEnvFile(path)
+			if err != nil {
+				return err
+			}
+			fileEnvVars = mergeEnvVars(fileEnvVars, vars)
+		}
+		envVars = mergeEnvVars(fileEnvVars, envVars)
+
+		// Split the command into steps on --then separators
+		steps := splitSteps(actualCommand)
+		for _, step := range steps {
+			if len(step) == 0 && len(steps) > 1 {
+				return fmt.Errorf("--then must separate two commands (put -- before the command so --then and step flags keep their positions)")
+			}
+		}
+
+		// A bare `iso run` opens the configured shell, like `iso shell`
+		openShell := len(steps) == 1 && len(steps[0]) == 0
+
+		if *watch {
+			switch {
+			case openShell:
+				return fmt.Errorf("--watch requires a command")
+			case len(*copyOut) > 0:
+				return fmt.Errorf("--watch can't be combined with --copy-out")
+			case *dryRun:
+				return fmt.Errorf("--watch can't be combined with --dry-run")
+			}
+		}
+
+		withServices := append(splitCommaList(*withService), splitCommaList(*service)...)
+		if *noServices && len(withServices) > 0 {
+			return fmt.Errorf("--no-services can't be combined with --with-service")
+		}
+
 		sessionName, isEphemeral := getSession(*session)
+
+		// A detached command runs with nothing attached, after iso has exited
+		if *detach {
+			switch {
+			case openShell:
+				return fmt.Errorf("--detach requires a command")
+			case isEphemeral:
+				return fmt.Errorf("--detach requires a persistent session - use --session flag or set ISO_SESSION env var")
+			case len(steps) > 1:
+				return fmt.Errorf("--detach can't be combined with --then")
+			case *interactive, *tty:
+				return fmt.Errorf("--detach can't be combined with --interactive or --tty (attach to a detached run with iso attach)")
+			case *watch, *dryRun, len(*copyOut) > 0, *mountSecret != "", *captureMetrics != "", *teeJSONEvents != "":
+				return fmt.Errorf("--detach can't be combined with --watch, --dry-run, --copy-out, --mount-secret, --capture-metrics or --tee-json-events")
+			}
+		}
+
+		// The daemon handles plain runs in persistent sessions. Ephemeral
+		// sessions and runs with per-invocation overrides or copy-out need a
+		// local client.
+		if iso.DaemonEnabled() && !isEphemeral && !openShell && !*watch && !*detach && len(steps) == 1 && len(*copyOut) == 0 && *jobs == 0 && len(*profile) == 0 && *mountSecret == "" && *captureMetrics == "" && *teeJSONEvents == "" && privilegedOverride == nil && !*noAutoRebuild && !*printCommand && !*dryRun {
+			exitCode, err := iso.DaemonRun(sessionName, actualCommand, iso.RunOptions{
+				EnvVars:      envVars,
+				Interactive:  *interactive,
+				TTY:          *tty,
+				WithServices: withServices,
+				NoServices:   *noServices,
+				WorkDir:      *workdir,
+				ExecTimeout:  execSetupTimeout,
+			})
+			if !errors.Is(err, iso.ErrDaemonUnavailable) {
+				if err != nil {
+					return err
+				}
+				if exitCode != 0 {
+					return &ExitError{Code: exitCode}
+				}
+				return nil
+			}
+			slog.Debug("daemon unavailable, using direct mode", "error", err)
+		}
+
 		client, err := iso.New(sessionName)
 		if err != nil {
 			return err
 		}
 		defer client.Close()
 
+		client.SetMaxParallel(*jobs)
+		client.SetActiveProfiles(*profile)
+		client.SetAutoRebuild(!*noAutoRebuild)
+
+		runOpts := iso.RunOptions{
+			EnvVars:         envVars,
+			Ephemeral:       isEphemeral,
+			Interactive:     *interactive,
+			TTY:             *tty,
+			WithServices:    withServices,
+			NoServices:      *noServices,
+			WorkDir:         *workdir,
+			KeepGoing:       *keepGoing,
+			PrintCommand:    *printCommand,
+			DryRun:          *dryRun,
+			Secrets:         secrets,
+			ExecTimeout:     execSetupTimeout,
+			CaptureMetrics:  *captureMetrics,
+			MetricsInterval: sampleInterval,
+			EventsPath:      *teeJSONEvents,
+			Privileged:      privilegedOverride,
+		}
+		if openShell {
+			runOpts.Interactive = true
+			runOpts.TTY = true
+		}
+
+		if *detach {
+			job, err := client.RunDetached(steps[0], runOpts)
+			if err != nil {
+				return err
+			}
+			fmt.Println(job.ID)
+			fmt.Fprintf(os.Stderr, "iso: started job %s, follow its output with: iso logs -s %s --job %s -f\n", job.ID, sessionName, job.ID)
+			return nil
+		}
+
+		// A dry run creates nothing, so there is nothing to clean up or copy out
+		if *dryRun {
+			if openShell {
+				_, err := client.Shell(runOpts)
+				return err
+			}
+			_, err := client.RunSteps(steps, runOpts)
+			return err
+		}
+
+		// Set up signal handler for interrupts. It stays installed until
+		// cleanup has finished, so a second Ctrl+C can't abort the cleanup
+		// and leak the ephemeral container.
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(sigChan)
+
 		// Set up signal handling for graceful cleanup on interrupt
 		// This ensures ephemeral resources are cleaned up even if Ctrl+C is pressed
 		var cleanupDone bool
@@ -188,7 +617,7 @@ func registerRunCommand(dispatcher *mflags.Dispatcher) {
 
 			// If ephemeral session, clean up everything
 			if isEphemeral {
-				if stopErr := client.Stop(); stopErr != nil {
+				if stopErr := client.Cleanup(iso.DefaultStopTimeout); stopErr != nil {
 					slog.Warn("failed to clean up ephemeral session", "error", stopErr)
 				}
 			}
@@ -197,10 +626,17 @@ func registerRunCommand(dispatcher *mflags.Dispatcher) {
 		// Ensure cleanup runs on exit
 		defer cleanupOnce()
 
-		// Set up signal handler for interrupts
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-		defer signal.Stop(sigChan)
+		// With --watch, start watching before the first run so changes made
+		// while it runs trigger the next one
+		var changes <-chan []string
+		if *watch {
+			watchCtx, stopWatching := context.WithCancel(context.Background())
+			defer stopWatching()
+			changes, err = client.WatchProject(watchCtx, 200*time.Millisecond)
+			if err != nil {
+				return err
+			}
+		}
 
 		// Run command in a goroutine so we can handle signals
 		type result struct {
@@ -209,9 +645,39 @@ func registerRunCommand(dispatcher *mflags.Dispatcher) {
 		}
 		resultChan := make(chan result, 1)
 
+		runOnce := func() result {
+			if openShell {
+				exitCode, err := client.Shell(runOpts)
+				return result{exitCode: exitCode, err: err}
+			}
+			if len(steps) == 1 {
+				exitCode, err := client.RunWithOptions(steps[0], runOpts)
+				return result{exitCode: exitCode, err: err}
+			}
+
+			stepResults, err := client.RunSteps(steps, runOpts)
+			printStepSummary(stepResults, len(steps))
+			return result{exitCode: firstFailure(stepResults), err: err}
+		}
+
 		go func() {
-			exitCode, err := client.Run(actualCommand, envVars, isEphemeral)
-			resultChan <- result{exitCode: exitCode, err: err}
+			for {
+				res := runOnce()
+				if changes == nil || res.err != nil {
+					resultChan <- res
+					return
+				}
+
+				// Watch mode: re-run in the same container on the next change.
+				// Ctrl+C ends the run through the signal handling below.
+				fmt.Fprintf(os.Stderr, "\niso: exited with code %d, waiting for changes (Ctrl+C to stop)\n", res.exitCode)
+				batch, ok := <-changes
+				if !ok {
+					resultChan <- res
+					return
+				}
+				fmt.Fprintf(os.Stderr, "%s\niso: %s changed, re-running\n", watchSeparator(), describeChanges(batch))
+			}
 		}()
 
 		// Wait for either command completion or signal
@@ -221,35 +687,326 @@ func registerRunCommand(dispatcher *mflags.Dispatcher) {
 			if res.err != nil {
 				return res.err
 			}
+
+			// Copy artifacts out before the (possibly ephemeral) container is cleaned up
+			if res.exitCode == 0 || *copyOutAlways {
+				for _, spec := range copyOutSpecs {
+					if err := client.CopyOut(spec.containerPath, spec.hostPath); err != nil {
+						if res.exitCode == 0 {
+							return err
+						}
+						slog.Warn("failed to copy out", "path", spec.containerPath, "error", err)
+					}
+				}
+			}
+
 			if res.exitCode != 0 {
 				return &ExitError{Code: res.exitCode}
 			}
 			return nil
 
 		case sig := <-sigChan:
-			// Received interrupt signal - cleanup will happen via defer
-			slog.Debug("received signal, cleaning up", "signal", sig)
-			return &ExitError{Code: 130} // Standard exit code for SIGINT
+			// Received interrupt signal. The run forwards it to the command;
+			// give the command a chance to exit before cleanup happens via defer
+			client.RestoreTerminal()
+			slog.Debug("received signal", "signal", sig)
+
+			code := 128 + int(sig.(syscall.Signal))
+			if client.CommandRunning() {
+				select {
+				case res := <-resultChan:
+					if res.err == nil && res.exitCode != 0 {
+						code = res.exitCode
+					}
+				case <-sigChan:
+					slog.Debug("received second signal, not waiting for command")
+				case <-time.After(iso.DefaultStopTimeout * time.Second):
+					slog.Warn("command did not exit after signal", "signal", sig)
+				}
+			}
+			if isEphemeral {
+				slog.Info("cleaning up ephemeral session")
+			}
+			return &ExitError{Code: code}
+		}
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "Run a command in the isolated environment (or open a shell without one)")
+}
+
+// watchSeparator returns the line printed between --watch runs, as wide as
+// the terminal (up to 80 columns)
+func watchSeparator() string {
+	width := terminalWidth()
+	if width <= 0 || width > 80 {
+		width = 80
+	}
+	return strings.Repeat("─", width)
+}
+
+// describeChanges names the changed files of a --watch batch, shortening
+// long batches to a count
+func describeChanges(paths []string) string {
+	if len(paths) <= 3 {
+		return strings.Join(paths, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(paths[:3], ", "), len(paths)-3)
+}
+
+// registerShellCommand registers the 'shell' command
+func registerShellCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("shell")
+
+	session := fs.String("session", 's', "", "Session name (defaults to ISO_SESSION env var, or ephemeral if not set)")
+	noAutoRebuild := fs.Bool("no-auto-rebuild", 0, false, "Use the existing image even if .iso/Dockerfile or build_args changed")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("usage: iso shell [--session NAME] (use 'iso run' to run a command)")
+		}
+
+		sessionName, isEphemeral := getSession(*session)
+
+		client, err := iso.New(sessionName)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		client.SetAutoRebuild(!*noAutoRebuild)
+
+		if isEphemeral {
+			defer func() {
+				if stopErr := client.Cleanup(iso.DefaultStopTimeout); stopErr != nil {
+					slog.Warn("failed to clean up ephemeral session", "error", stopErr)
+				}
+			}()
+		}
+
+		exitCode, err := client.Shell(iso.RunOptions{
+			Ephemeral:   isEphemeral,
+			Interactive: true,
+			TTY:         true,
+		})
+		if err != nil {
+			return err
+		}
+		if exitCode != 0 {
+			return &ExitError{Code: exitCode}
+		}
+		return nil
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "Open an interactive shell in the isolated environment")
+}
+
+// parseSecretSpecs parses a comma-separated --mount-secret value of ID=HOST_PATH
+// entries into a map of secret ids to host paths
+func parseSecretSpecs(value string) (map[string]string, error) {
+	secrets := make(map[string]string)
+	for _, spec := range splitCommaList(value) {
+		id, hostPath, ok := strings.Cut(spec, "=")
+		if !ok || id == "" || hostPath == "" {
+			return nil, fmt.Errorf("invalid --mount-secret %q (expected ID=HOST_PATH)", spec)
+		}
+		if _, dup := secrets[id]; dup {
+			return nil, fmt.Errorf("secret %q is mounted more than once", id)
+		}
+		secrets[id] = hostPath
+	}
+	return secrets, nil
+}
+
+// parseEnvFile reads a dotenv-style file of KEY=VALUE lines. Blank lines and
+// # comments are skipped, an "export " prefix is allowed, and values may be
+// single-quoted (literal) or double-quoted (with \n, \" and \\ escapes).
+// Unquoted values end at a " #" comment. A bare KEY takes the host's value,
+// like docker run --env-file.
+func parseEnvFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env file: %w", err)
+	}
+
+	var vars []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, hasValue := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !isValidEnvVarName(key) {
+			return nil, fmt.Errorf("%s:%d: invalid variable name %q", path, i+1, key)
+		}
+		if !hasValue {
+			if hostValue, ok := os.LookupEnv(key); ok {
+				vars = append(vars, key+"="+hostValue)
+			}
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		switch {
+		case strings.HasPrefix(value, "'"):
+			end := strings.Index(value[1:], "'")
+			if end < 0 {
+				return nil, fmt.Errorf("%s:%d: unterminated quote in value of %s", path, i+1, key)
+			}
+			value = value[1 : end+1]
+		case strings.HasPrefix(value, `"`):
+			unquoted, ok := unquoteEnvValue(value[1:])
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: unterminated quote in value of %s", path, i+1, key)
+			}
+			value = unquoted
+		default:
+			if idx := strings.Index(value, " #"); idx >= 0 {
+				value = strings.TrimSpace(value[:idx])
+			}
+		}
+		vars = append(vars, key+"="+value)
+	}
+	return vars, nil
+}
+
+// unquoteEnvValue returns the double-quoted value that s starts with (after
+// the opening quote) up to its closing quote, resolving escapes
+func unquoteEnvValue(s string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			return b.String(), true
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case '"', '\\':
+				b.WriteByte(s[i])
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", false
+}
+
+// mergeEnvVars returns the KEY=VALUE entries of base and overrides, with an
+// override replacing the base entry for the same key
+func mergeEnvVars(base, overrides []string) []string {
+	merged := slices.Clone(base)
+	index := make(map[string]int)
+	for i, kv := range merged {
+		key, _, _ := strings.Cut(kv, "=")
+		index[key] = i
+	}
+	for _, kv := range overrides {
+		key, _, _ := strings.Cut(kv, "=")
+		if i, ok := index[key]; ok {
+			merged[i] = kv
+			continue
+		}
+		index[key] = len(merged)
+		merged = append(merged, kv)
+	}
+	return merged
+}
+
+// splitSteps splits a command on "--then" separators into a sequence of steps
+func splitSteps(command []string) [][]string {
+	steps := [][]string{{}}
+	for _, arg := range command {
+		if arg == "--then" {
+			steps = append(steps, []string{})
+			continue
+		}
+		steps[len(steps)-1] = append(steps[len(steps)-1], arg)
+	}
+	return steps
+}
+
+// printStepSummary reports the status and timing of each step on stderr
+func printStepSummary(results []iso.StepResult, total int) {
+	fmt.Fprintln(os.Stderr)
+	for i, r := range results {
+		command := strings.Join(r.Command, " ")
+		switch {
+		case r.Skipped:
+			fmt.Fprintf(os.Stderr, "step %d/%d skipped: %s\n", i+1, total, command)
+		case r.ExitCode != 0:
+			fmt.Fprintf(os.Stderr, "step %d/%d failed with exit code %d (%s): %s\n", i+1, total, r.ExitCode, r.Duration.Round(time.Millisecond), command)
+		default:
+			fmt.Fprintf(os.Stderr, "step %d/%d ok (%s): %s\n", i+1, total, r.Duration.Round(time.Millisecond), command)
+		}
+	}
+}
+
+// firstFailure returns the exit code of the first failed step, or 0
+func firstFailure(results []iso.StepResult) int {
+	for _, r := range results {
+		if !r.Skipped && r.ExitCode != 0 {
+			return r.ExitCode
+		}
+	}
+	return 0
+}
+
+// splitCommaList splits a comma-separated flag value, dropping empty entries
+func splitCommaList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
 		}
 	}
+	return items
+}
 
-	cmd := mflags.NewCommand(fs, handler,
-		mflags.WithUsage("Run a command in the isolated environment"),
-	)
+// copyOutSpec is a parsed --copy-out CONTAINER_PATH:HOST_PATH entry
+type copyOutSpec struct {
+	containerPath string
+	hostPath      string
+}
 
-	dispatcher.Dispatch("run", cmd)
+// parseCopyOutSpecs parses --copy-out CONTAINER_PATH:HOST_PATH values
+func parseCopyOutSpecs(values []string) ([]copyOutSpec, error) {
+	var specs []copyOutSpec
+	for _, entry := range values {
+		containerPath, hostPath, ok := strings.Cut(entry, ":")
+		if !ok || containerPath == "" || hostPath == "" {
+			return nil, fmt.Errorf("invalid --copy-out value %q (expected CONTAINER_PATH:HOST_PATH)", entry)
+		}
+		specs = append(specs, copyOutSpec{containerPath: containerPath, hostPath: hostPath})
+	}
+	return specs, nil
 }
 
 // registerBuildCommand registers the 'build' command
 func registerBuildCommand(dispatcher *mflags.Dispatcher) {
-	fs := mflags.NewFlagSet("build")
+	fs := newFlagSet("build")
 
 	rebuild := fs.Bool("rebuild", 'r', false, "Force rebuild even if image exists")
+	noCache := fs.Bool("no-cache", 0, false, "Build without reusing cached layers (e.g. to pick up upstream changes)")
+	pull := fs.Bool("pull", 0, false, "Re-pull the Dockerfile's base images before building (needs network access)")
 	session := fs.String("session", 's', "", "Session name (default: ISO_SESSION env var or ephemeral)")
+	targetPlatform := fs.String("target-platform", 0, "", "Build with BuildKit for these platforms (comma-separated, e.g. linux/amd64,linux/arm64)")
+	push := fs.String("push", 0, "", "With --target-platform, push one multi-arch image to this registry reference")
 
 	handler := func(fs *mflags.FlagSet, args []string) error {
 		doRebuild := *rebuild
 
+		platforms := splitCommaList(*targetPlatform)
+		if *push != "" && len(platforms) == 0 {
+			return fmt.Errorf("--push requires --target-platform")
+		}
+
 		sessionName, _ := getSession(*session)
 		client, err := iso.New(sessionName)
 		if err != nil {
@@ -257,24 +1014,58 @@ func registerBuildCommand(dispatcher *mflags.Dispatcher) {
 		}
 		defer client.Close()
 
+		client.SetNoCache(*noCache)
+		client.SetPull(*pull)
+
+		if len(platforms) > 0 {
+			return client.BuildPlatforms(platforms, *push)
+		}
 		if doRebuild {
 			return client.Rebuild()
 		}
 		return client.Build()
 	}
 
-	cmd := mflags.NewCommand(fs, handler,
-		mflags.WithUsage("Build the Docker image"),
-	)
+	dispatchCommand(dispatcher, fs, handler, "Build the Docker image")
+}
+
+// registerPushCommand registers the 'push' command
+func registerPushCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("push")
+
+	tag := fs.String("tag", 't', "", "Registry reference to push to, e.g. ghcr.io/acme/app-iso:latest (default: config shared_image)")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("usage: iso push [--tag REF]")
+		}
+
+		sessionName, _ := getSession("")
+		client, err := iso.New(sessionName)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		ref, err := client.Push(*tag)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Pushed %s\n", ref)
+		return nil
+	}
 
-	dispatcher.Dispatch("build", cmd)
+	dispatchCommand(dispatcher, fs, handler, "Push the built image to a registry for others to pull")
 }
 
 // registerStartCommand registers the 'start' command
 func registerStartCommand(dispatcher *mflags.Dispatcher) {
-	fs := mflags.NewFlagSet("start")
+	fs := newFlagSet("start")
 
 	session := fs.String("session", 's', "", "Session name (required, or use ISO_SESSION env var)")
+	jobs := fs.Int("jobs", 'j', 0, "Max concurrent service starts and image pulls (default: config max_parallel)")
+	profile := fs.StringList("profile", "Activate a service profile (repeat for multiple, default: config active_profiles)")
+	noAutoRebuild := fs.Bool("no-auto-rebuild", 0, false, "Use the existing image even if .iso/Dockerfile or build_args changed")
 
 	handler := func(fs *mflags.FlagSet, args []string) error {
 		// For start command, session is required
@@ -293,31 +1084,35 @@ func registerStartCommand(dispatcher *mflags.Dispatcher) {
 		}
 		defer client.Close()
 
+		client.SetMaxParallel(*jobs)
+		client.SetActiveProfiles(*profile)
+		client.SetAutoRebuild(!*noAutoRebuild)
 		return client.Start()
 	}
 
-	cmd := mflags.NewCommand(fs, handler,
-		mflags.WithUsage("Start a persistent session (requires --session)"),
-	)
-
-	dispatcher.Dispatch("start", cmd)
+	dispatchCommand(dispatcher, fs, handler, "Start a persistent session (requires --session)")
 }
 
 // registerStopCommand registers the 'stop' command
 func registerStopCommand(dispatcher *mflags.Dispatcher) {
-	fs := mflags.NewFlagSet("stop")
+	fs := newFlagSet("stop")
 
 	all := fs.Bool("all", 'a', false, "Stop all ISO-managed containers across all projects")
 	allSessions := fs.Bool("all-sessions", 'S', false, "Stop all sessions for the current project")
 	session := fs.String("session", 's', "", "Session name (required for stopping specific session, or use ISO_SESSION env var)")
+	timeout := fs.Int("timeout", 't', iso.DefaultStopTimeout, "Seconds to wait for containers to exit before killing them (0 kills immediately)")
 
 	handler := func(fs *mflags.FlagSet, args []string) error {
+		if *timeout < 0 {
+			return fmt.Errorf("--timeout must not be negative, got %d", *timeout)
+		}
+
 		if *all {
-			return iso.StopAll()
+			return iso.StopAll(*timeout)
 		}
 
 		if *allSessions {
-			return iso.StopAllSessions()
+			return iso.StopAllSessions(*timeout)
 		}
 
 		// For stopping a specific session, require session name
@@ -336,19 +1131,15 @@ func registerStopCommand(dispatcher *mflags.Dispatcher) {
 		}
 		defer client.Close()
 
-		return client.Stop()
+		return client.Stop(*timeout)
 	}
 
-	cmd := mflags.NewCommand(fs, handler,
-		mflags.WithUsage("Stop and remove a persistent session (requires --session, or use --all/--all-sessions)"),
-	)
-
-	dispatcher.Dispatch("stop", cmd)
+	dispatchCommand(dispatcher, fs, handler, "Stop and remove a persistent session (requires --session, or use --all/--all-sessions)")
 }
 
 // registerResetCommand registers the 'reset' command
 func registerResetCommand(dispatcher *mflags.Dispatcher) {
-	fs := mflags.NewFlagSet("reset")
+	fs := newFlagSet("reset")
 
 	session := fs.String("session", 's', "", "Session name (required, or use ISO_SESSION env var)")
 
@@ -372,28 +1163,166 @@ func registerResetCommand(dispatcher *mflags.Dispatcher) {
 		return client.Reset()
 	}
 
-	cmd := mflags.NewCommand(fs, handler,
-		mflags.WithUsage("Reset a persistent session's container (requires --session)"),
-	)
-
-	dispatcher.Dispatch("reset", cmd)
+	dispatchCommand(dispatcher, fs, handler, "Reset a persistent session's container (requires --session)")
 }
 
-// registerStatusCommand registers the 'status' command
-func registerStatusCommand(dispatcher *mflags.Dispatcher) {
-	fs := mflags.NewFlagSet("status")
+// registerCpCommand registers the 'cp' command
+func registerCpCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("cp")
 
-	session := fs.String("session", 's', "", "Session name (required, or use ISO_SESSION env var)")
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		if len(args) != 2 {
+			return fmt.Errorf("usage: iso cp SESSION:CONTAINER_PATH HOST_PATH, or iso cp HOST_PATH SESSION:CONTAINER_PATH")
+		}
+
+		srcSession, srcPath := parseCopyArg(args[0])
+		dstSession, dstPath := parseCopyArg(args[1])
+		if srcPath == "" || dstPath == "" {
+			return fmt.Errorf("iso cp paths must not be empty")
+		}
+
+		var sessionName string
+		switch {
+		case srcSession != "" && dstSession != "":
+			return fmt.Errorf("iso cp copies between the host and a container; only one side may be SESSION:PATH")
+		case srcSession != "":
+			sessionName = srcSession
+		case dstSession != "":
+			sessionName = dstSession
+		default:
+			return fmt.Errorf("one side of iso cp must be SESSION:PATH")
+		}
+
+		client, err := iso.New(sessionName)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		if srcSession != "" {
+			return client.CopyOut(srcPath, dstPath)
+		}
+		return client.CopyIn(srcPath, dstPath)
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "Copy files between the host and a session container (SESSION:PATH on one side)")
+}
+
+// parseCopyArg splits an `iso cp` argument into a session and a path. Like
+// `docker cp`, arguments starting with / or . and arguments without a colon
+// are host paths (with an empty session).
+func parseCopyArg(arg string) (string, string) {
+	if strings.HasPrefix(arg, "/") || strings.HasPrefix(arg, ".") {
+		return "", arg
+	}
+	session, containerPath, ok := strings.Cut(arg, ":")
+	if !ok || session == "" {
+		return "", arg
+	}
+	return session, containerPath
+}
+
+// registerRestartCommand registers the 'restart' command
+func registerRestartCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("restart")
+
+	session := fs.String("session", 's', "", "Session name (required, or use ISO_SESSION env var)")
 
 	handler := func(fs *mflags.FlagSet, args []string) error {
-		// For status command, session is required
+		// For restart command, session is required
 		var sessionName string
 		if *session != "" {
 			sessionName = *session
 		} else if envSession := os.Getenv("ISO_SESSION"); envSession != "" {
 			sessionName = envSession
 		} else {
-			return fmt.Errorf("session is required for 'iso status' - use --session flag or set ISO_SESSION env var")
+			return fmt.Errorf("session is required for 'iso restart' - use --session flag or set ISO_SESSION env var")
+		}
+
+		client, err := iso.New(sessionName)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		return client.Restart()
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "Recreate a persistent session's container, keeping services and volumes (requires --session)")
+}
+
+// registerLogsCommand registers the 'logs' command
+func registerLogsCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("logs")
+
+	session := fs.String("session", 's', "", "Session name (required, or use ISO_SESSION env var)")
+	service := fs.String("service", 0, "", "Show the logs of this service instead of the main container")
+	follow := fs.Bool("follow", 'f', false, "Keep streaming new output")
+	tail := fs.Int("tail", 'n', -1, "Number of lines to show from the end of the logs (default: all)")
+	job := fs.String("job", 0, "", "Show the output of this detached run (iso run --detach) instead, exiting with its exit code once it finishes")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		if *job != "" && *service != "" {
+			return fmt.Errorf("--job can't be combined with --service")
+		}
+
+		// Ephemeral sessions are gone once their run ends, so there is nothing
+		// to show without a persistent session
+		var sessionName string
+		if *session != "" {
+			sessionName = *session
+		} else if envSession := os.Getenv("ISO_SESSION"); envSession != "" {
+			sessionName = envSession
+		} else {
+			return fmt.Errorf("session is required for 'iso logs' - use --session flag or set ISO_SESSION env var")
+		}
+
+		client, err := iso.New(sessionName)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		if *job != "" {
+			exitCode, err := client.JobLogs(*job, *follow, *tail)
+			if err != nil {
+				return err
+			}
+			if exitCode != 0 {
+				return &ExitError{Code: exitCode}
+			}
+			return nil
+		}
+
+		return client.Logs(iso.LogsOptions{
+			Service: *service,
+			Follow:  *follow,
+			Tail:    *tail,
+		})
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "Show the output of a session's container, one of its services or a detached run")
+}
+
+// registerAttachCommand registers the 'attach' command
+func registerAttachCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("attach")
+
+	session := fs.String("session", 's', "", "Session name (required, or use ISO_SESSION env var)")
+	job := fs.String("job", 0, "", "Attach to this detached run instead of the latest running one")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("usage: iso attach [--session NAME] [--job ID]")
+		}
+
+		var sessionName string
+		if *session != "" {
+			sessionName = *session
+		} else if envSession := os.Getenv("ISO_SESSION"); envSession != "" {
+			sessionName = envSession
+		} else {
+			return fmt.Errorf("session is required for 'iso attach' - use --session flag or set ISO_SESSION env var")
 		}
 
 		client, err := iso.New(sessionName)
@@ -402,45 +1331,232 @@ func registerStatusCommand(dispatcher *mflags.Dispatcher) {
 		}
 		defer client.Close()
 
-		status, err := client.Status()
+		exitCode, err := client.Attach(*job)
+		if err != nil {
+			return err
+		}
+		if exitCode != 0 {
+			return &ExitError{Code: exitCode}
+		}
+		return nil
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "Attach to a session's running detached run, or open a shell if there is none")
+}
+
+// registerStatusCommand registers the 'status' command
+func registerStatusCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("status")
+
+	session := fs.String("session", 's', "", "Session name (required, or use ISO_SESSION env var)")
+	noColor := fs.Bool("no-color", 0, false, "Disable colored output")
+	check := fs.Bool("check", 0, false, "Check health and exit 0 if ready, 1 if the container is down, 2 if services aren't ready")
+	jsonOutput := fs.Bool("json", 'j', false, "Output the status as JSON")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		// For status command, session is required
+		var sessionName string
+		if *session != "" {
+			sessionName = *session
+		} else if envSession := os.Getenv("ISO_SESSION"); envSession != "" {
+			sessionName = envSession
+		} else {
+			return fmt.Errorf("session is required for 'iso status' - use --session flag or set ISO_SESSION env var")
+		}
+
+		if *check {
+			return checkSessionHealth(sessionName)
+		}
+
+		status, err := sessionStatus(sessionName)
 		if err != nil {
 			return err
 		}
 
+		if *jsonOutput {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(status)
+		}
+
 		imageStatus := "does not exist"
 		if status.ImageExists {
 			imageStatus = "exists"
 		}
 
-		slog.Info("image status", "image", status.ImageName, "status", imageStatus)
-		slog.Info("container status", "container", status.ContainerName, "status", status.ContainerState)
+		color := useColor(*noColor)
+		imageColor, containerColor := "", ""
+		if color {
+			imageColor = colorGray
+			if status.ImageExists {
+				imageColor = colorGreen
+			}
+			containerColor = statusColor(status.ContainerState)
+		}
+
+		t := newTable("RESOURCE", "NAME", "STATUS")
+		t.statusColumn = 2
+		t.maxWidth = terminalWidth()
+		t.addRow(imageColor, "image", status.ImageName, imageStatus)
+		t.addRow(containerColor, "container", status.ContainerName, containerStatusDetail(status))
+		for _, sp := range status.ServicePlatforms {
+			t.addRow("", "service", sp.ContainerName, platformLabel(sp.Platform, sp.Emulated))
+		}
+		t.render(os.Stdout)
 
 		return nil
 	}
 
-	cmd := mflags.NewCommand(fs, handler,
-		mflags.WithUsage("Show status of a session (requires --session)"),
-	)
+	dispatchCommand(dispatcher, fs, handler, "Show status of a session (requires --session)")
+}
+
+// containerStatusDetail describes the session container's state for the
+// status table, with its uptime and resource usage while it runs
+func containerStatusDetail(status *iso.Status) string {
+	switch {
+	case status.ContainerState == "running":
+		return fmt.Sprintf("running, up %s, %s memory, %.1f%% CPU",
+			units.HumanDuration(time.Duration(status.UptimeSeconds)*time.Second),
+			units.BytesSize(float64(status.MemoryBytes)), status.CPUPercent)
+	case !status.Created.IsZero():
+		return fmt.Sprintf("%s, created %s ago", status.ContainerState, units.HumanDuration(time.Since(status.Created)))
+	default:
+		return status.ContainerState
+	}
+}
+
+// checkSessionHealth prints a concise health summary for a session and
+// returns an ExitError carrying the status --check exit code
+func checkSessionHealth(sessionName string) error {
+	client, err := iso.New(sessionName)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	report, err := client.CheckHealth()
+	if err != nil {
+		return err
+	}
+
+	if !report.ContainerRunning {
+		fmt.Println("container: down")
+		return &ExitError{Code: 1}
+	}
+	fmt.Println("container: running")
+
+	for _, s := range report.Services {
+		state := "ready"
+		if !s.Running {
+			state = "down"
+		} else if !s.Ready {
+			state = "not ready"
+		}
+		fmt.Printf("service %s: %s\n", s.Name, state)
+	}
+
+	if !report.Healthy() {
+		return &ExitError{Code: 2}
+	}
+	return nil
+}
+
+// platformLabel describes a pinned platform, flagging emulation
+func platformLabel(platform string, emulated bool) string {
+	if emulated {
+		return platform + " (emulated)"
+	}
+	return platform
+}
+
+// sessionStatus returns a session's status, via the daemon when enabled
+func sessionStatus(sessionName string) (*iso.Status, error) {
+	if iso.DaemonEnabled() {
+		status, err := iso.DaemonStatus(sessionName)
+		if !errors.Is(err, iso.ErrDaemonUnavailable) {
+			return status, err
+		}
+		slog.Debug("daemon unavailable, using direct mode", "error", err)
+	}
+
+	client, err := iso.New(sessionName)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	return client.Status()
+}
+
+// registerInspectCommand registers the 'inspect' command
+func registerInspectCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("inspect")
+
+	session := fs.String("session", 's', "", "Session name (defaults to ISO_SESSION env var or 'default')")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		sessionName := *session
+		if sessionName == "" {
+			sessionName = os.Getenv("ISO_SESSION")
+		}
+
+		client, err := iso.New(sessionName)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		inspection, err := client.Inspect()
+		if err != nil {
+			return err
+		}
+		return printJSON(inspection)
+	}
 
-	dispatcher.Dispatch("status", cmd)
+	dispatchCommand(dispatcher, fs, handler, "Print everything ISO knows about a session as JSON: names, volumes, config, services and Docker inspect output")
 }
 
 // registerListCommand registers the 'list' command
 func registerListCommand(dispatcher *mflags.Dispatcher) {
-	fs := mflags.NewFlagSet("list")
+	fs := newFlagSet("list")
 
 	orphaned := fs.Bool("orphaned", 'o', false, "Show only orphaned sessions (project directory missing)")
+	noColor := fs.Bool("no-color", 0, false, "Disable colored output")
+	jsonOutput := fs.Bool("json", 'j', false, "Output the containers as a JSON array")
+	sessionsOnly := fs.Bool("sessions", 0, false, "Print only the current project's session names, one per line")
 
 	handler := func(fs *mflags.FlagSet, args []string) error {
+		if *sessionsOnly {
+			return listProjectSessions()
+		}
+
 		if *orphaned {
-			return listOrphaned()
+			if *jsonOutput {
+				orphanedSessions, err := iso.ListOrphaned()
+				if err != nil {
+					return err
+				}
+				if orphanedSessions == nil {
+					orphanedSessions = []iso.OrphanedSession{}
+				}
+				return printJSON(orphanedSessions)
+			}
+			return listOrphaned(useColor(*noColor))
 		}
 
-		containers, err := iso.ListAll()
+		containers, err := listAllContainers()
 		if err != nil {
 			return err
 		}
 
+		// Always emit a JSON array, even when there are no containers
+		if *jsonOutput {
+			if containers == nil {
+				containers = []iso.IsoContainer{}
+			}
+			return printJSON(containers)
+		}
+
 		if len(containers) == 0 {
 			fmt.Println("No ISO containers found")
 			return nil
@@ -454,317 +1570,1364 @@ func registerListCommand(dispatcher *mflags.Dispatcher) {
 			projectDirs[c.ProjectName] = c.ProjectDir
 		}
 
+		color := useColor(*noColor)
+		width := terminalWidth()
+
 		// Print each project group
 		for projectName, projectContainers := range projectGroups {
 			fmt.Printf("\n%s (%s):\n", projectName, projectDirs[projectName])
-			fmt.Printf("  %-12s %-15s %-20s %s\n", "CONTAINER ID", "NAME", "SESSION", "STATUS")
 
+			t := newTable("CONTAINER ID", "NAME", "SESSION", "STATUS")
+			t.indent = "  "
+			t.statusColumn = 3
+			t.maxWidth = width
 			for _, c := range projectContainers {
 				status := c.Status
-
-				sessionInfo := c.Session
 				if c.IsService {
 					status += " (service: " + c.ServiceName + ")"
 				}
+				if c.Platform != "" {
+					status += " [" + platformLabel(c.Platform, c.Emulated) + "]"
+				}
 
-				fmt.Printf("  %-12s %-15s %-20s %s\n",
-					c.ID,
-					c.ShortName,
-					sessionInfo,
-					status,
-				)
+				rowColor := ""
+				if color {
+					rowColor = statusColor(c.Status)
+				}
+				t.addRow(rowColor, c.ID, c.ShortName, c.Session, status)
 			}
+			t.render(os.Stdout)
 		}
 		fmt.Println()
 
 		return nil
 	}
 
-	cmd := mflags.NewCommand(fs, handler,
-		mflags.WithUsage("List all ISO-managed containers"),
-	)
-
-	dispatcher.Dispatch("list", cmd)
+	dispatchCommand(dispatcher, fs, handler, "List all ISO-managed containers")
 }
 
-func listOrphaned() error {
-	orphaned, err := iso.ListOrphaned()
-	if err != nil {
-		return err
+// registerOverviewCommand registers the 'overview' command
+func registerOverviewCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("overview")
+
+	jsonOutput := fs.Bool("json", 0, false, "Output as JSON")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		overviews, err := iso.Overview()
+		if err != nil {
+			return err
+		}
+
+		if *jsonOutput {
+			if overviews == nil {
+				overviews = []iso.ProjectOverview{}
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(overviews)
+		}
+
+		if len(overviews) == 0 {
+			fmt.Println("No ISO projects found")
+			return nil
+		}
+
+		fmt.Printf("%-25s %-9s %-8s %-8s %-6s %-10s %s\n",
+			"PROJECT", "SESSIONS", "RUNNING", "STOPPED", "IMAGE", "VOLUMES", "DIRECTORY")
+		for _, ov := range overviews {
+			image := "no"
+			if ov.ImageExists {
+				image = "yes"
+			}
+			fmt.Printf("%-25s %-9d %-8d %-8d %-6s %-10s %s\n",
+				ov.ProjectName, ov.Sessions, ov.Running, ov.Stopped, image,
+				units.BytesSize(float64(ov.VolumeSize)), ov.ProjectDir)
+		}
+
+		return nil
 	}
 
-	if len(orphaned) == 0 {
-		fmt.Println("No orphaned ISO sessions found")
+	dispatchCommand(dispatcher, fs, handler, "Summarize sessions, containers, images and volume usage across all projects")
+}
+
+// registerDfCommand registers the 'df' command
+func registerDfCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("df")
+
+	verbose := fs.Bool("verbose", 'v', false, "List every image and volume instead of per-project totals")
+	jsonOutput := fs.Bool("json", 'j', false, "Output every image and volume as a JSON array")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		items, err := iso.DiskUsage()
+		if err != nil {
+			return err
+		}
+
+		if *jsonOutput {
+			if items == nil {
+				items = []iso.DiskUsageItem{}
+			}
+			return printJSON(items)
+		}
+
+		if len(items) == 0 {
+			fmt.Println("No ISO images or volumes found")
+			return nil
+		}
+
+		if *verbose {
+			t := newTable("PROJECT", "SESSION", "KIND", "SIZE", "NAME")
+			t.maxWidth = terminalWidth()
+			for _, item := range items {
+				t.addRow("", dfProjectLabel(item), cmp.Or(item.Session, "-"), item.Kind, dfSize(item.Size), item.Name)
+			}
+			t.render(os.Stdout)
+			return nil
+		}
+
+		// Sum each project's items by kind, in the order DiskUsage sorts them
+		kinds := []string{"image", "session", "service", "cache", "shared"}
+		var projects []string
+		totals := make(map[string]map[string]int64)
+		for _, item := range items {
+			project := dfProjectLabel(item)
+			if totals[project] == nil {
+				totals[project] = make(map[string]int64)
+				projects = append(projects, project)
+			}
+			if item.Size > 0 {
+				totals[project][item.Kind] += item.Size
+			}
+		}
+
+		t := newTable("PROJECT", "IMAGES", "SESSION VOLUMES", "SERVICE VOLUMES", "CACHE", "SHARED", "TOTAL")
+		grand := make(map[string]int64)
+		for _, project := range projects {
+			row := []string{project}
+			var total int64
+			for _, kind := range kinds {
+				row = append(row, dfSize(totals[project][kind]))
+				total += totals[project][kind]
+				grand[kind] += totals[project][kind]
+			}
+			t.addRow("", append(row, dfSize(total))...)
+		}
+		row := []string{"TOTAL"}
+		var total int64
+		for _, kind := range kinds {
+			row = append(row, dfSize(grand[kind]))
+			total += grand[kind]
+		}
+		t.addRow("", append(row, dfSize(total))...)
+		t.render(os.Stdout)
+
 		return nil
 	}
 
-	fmt.Println("Orphaned ISO sessions (project directory no longer exists):")
+	dispatchCommand(dispatcher, fs, handler, "Show disk space used by ISO images and volumes, per project")
+}
 
-	totalContainers := 0
-	for _, session := range orphaned {
-		fmt.Printf("%s:\n", session.ProjectDir)
-		fmt.Printf("  %-12s %-35s %-18s %s\n",
-			"CONTAINER ID", "NAME", "SESSION", "STATUS")
+// dfProjectLabel names an item's project in df output
+func dfProjectLabel(item iso.DiskUsageItem) string {
+	switch {
+	case item.ProjectName != "":
+		return item.ProjectName
+	case item.Kind == "shared":
+		return "(shared)"
+	default:
+		return "(unknown)"
+	}
+}
 
-		for _, c := range session.Containers {
-			status := c.Status
-			if c.IsService {
-				status += " (service: " + c.ServiceName + ")"
+// dfSize formats a size for df output, with "-" for nothing or unknown
+func dfSize(size int64) string {
+	if size <= 0 {
+		return "-"
+	}
+	return units.BytesSize(float64(size))
+}
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// registerSessionsCommand registers the 'sessions' command
+func registerSessionsCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("sessions")
+
+	noColor := fs.Bool("no-color", 0, false, "Disable colored output")
+	jsonOutput := fs.Bool("json", 'j', false, "Output the sessions as a JSON array")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		sessions, err := iso.Sessions()
+		if err != nil {
+			return err
+		}
+
+		if *jsonOutput {
+			return printJSON(sessions)
+		}
+
+		if len(sessions) == 0 {
+			fmt.Println("No sessions found for this project")
+			return nil
+		}
+
+		active := os.Getenv("ISO_SESSION")
+		color := useColor(*noColor)
+
+		t := newTable("ACTIVE", "SESSION", "CONTAINER", "SERVICES")
+		t.statusColumn = 2
+		t.maxWidth = terminalWidth()
+		for _, s := range sessions {
+			marker := ""
+			if s.Name == active {
+				marker = "*"
+			}
+			containerStatus := s.ContainerStatus
+			if containerStatus == "" {
+				containerStatus = "none"
 			}
+			services := "none"
+			if s.Services > 0 {
+				services = fmt.Sprintf("%d/%d running", s.ServicesRunning, s.Services)
+			}
+
+			rowColor := ""
+			if color {
+				rowColor = statusColor(containerStatus)
+			}
+			t.addRow(rowColor, marker, s.Name, containerStatus, services)
+		}
+		t.render(os.Stdout)
+
+		return nil
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "List the current project's sessions")
+}
+
+// listAllContainers lists all ISO containers, via the daemon when enabled
+func listAllContainers() ([]iso.IsoContainer, error) {
+	if iso.DaemonEnabled() {
+		containers, err := iso.DaemonList()
+		if !errors.Is(err, iso.ErrDaemonUnavailable) {
+			return containers, err
+		}
+		slog.Debug("daemon unavailable, using direct mode", "error", err)
+	}
+
+	return iso.ListAll()
+}
+
+// listProjectSessions prints the names of the current project's sessions,
+// one per line (used by shell completion)
+func listProjectSessions() error {
+	projectRoot, found := iso.ProjectRoot()
+	if !found {
+		return nil
+	}
+
+	containers, err := listAllContainers()
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	var sessions []string
+	for _, c := range containers {
+		if c.ProjectDir != projectRoot || c.Session == "" || seen[c.Session] {
+			continue
+		}
+		seen[c.Session] = true
+		sessions = append(sessions, c.Session)
+	}
+	slices.Sort(sessions)
+	for _, s := range sessions {
+		fmt.Println(s)
+	}
+	return nil
+}
+
+func listOrphaned(color bool) error {
+	orphaned, err := iso.ListOrphaned()
+	if err != nil {
+		return err
+	}
+
+	if len(orphaned) == 0 {
+		fmt.Println("No orphaned ISO sessions found")
+		return nil
+	}
+
+	fmt.Println("Orphaned ISO sessions (project directory no longer exists):")
+
+	width := terminalWidth()
+
+	totalContainers := 0
+	for _, session := range orphaned {
+		fmt.Printf("%s:\n", session.ProjectDir)
+
+		t := newTable("CONTAINER ID", "NAME", "SESSION", "STATUS")
+		t.indent = "  "
+		t.statusColumn = 3
+		t.maxWidth = width
+		for _, c := range session.Containers {
+			status := c.Status
+			if c.IsService {
+				status += " (service: " + c.ServiceName + ")"
+			}
+
+			rowColor := ""
+			if color {
+				rowColor = statusColor(c.Status)
+			}
+			t.addRow(rowColor, c.ID[:12], c.ShortName, c.Session, status)
+			totalContainers++
+		}
+		t.render(os.Stdout)
+		fmt.Println()
+	}
+
+	fmt.Printf("Total orphaned containers: %d\n", totalContainers)
+	fmt.Println("To clean up orphaned sessions, run: iso cleanup --orphaned")
+
+	return nil
+}
+
+// registerPruneCommand registers the 'prune' command
+func registerPruneCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("prune")
+
+	jsonOutput := fs.Bool("json", 0, false, "Output the prune result as JSON")
+	networks := fs.Bool("networks", 0, false, "Remove the project's networks with no attached containers instead of cache volumes")
+	dryRun := fs.Bool("dry-run", 'd', false, "With --networks or --all, list what would be removed without removing it")
+	all := fs.Bool("all", 'a', false, "Remove the cache volumes of every project, not just the current one")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		if *dryRun && !*networks && !*all {
+			return fmt.Errorf("--dry-run is only supported with --networks or --all")
+		}
+		if *all && *networks {
+			return fmt.Errorf("--all can't be combined with --networks")
+		}
+
+		if *all {
+			result, err := iso.PruneAllCaches(*dryRun)
+			if err != nil {
+				return err
+			}
+			return printPruneResult(result, *jsonOutput)
+		}
+
+		// Prune doesn't use a specific session since cache volumes are shared
+		// We just need a client to access the project configuration
+		sessionName, _ := getSession("")
+		client, err := iso.New(sessionName)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		if *networks {
+			pruned, err := client.PruneNetworks(*dryRun)
+			if err != nil {
+				return err
+			}
+			return printPrunedNetworks(pruned, *dryRun, *jsonOutput)
+		}
+
+		result, err := client.Prune()
+		if err != nil {
+			return err
+		}
+		return printPruneResult(result, *jsonOutput)
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "Remove all cache volumes (or dangling networks) for the project, or with --all for every project")
+}
+
+// registerGcCommand registers the 'gc' command
+func registerGcCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("gc")
+
+	dryRun := fs.Bool("dry-run", 'd', false, "List the images that would be removed without removing them")
+	jsonOutput := fs.Bool("json", 'j', false, "Output the result as JSON")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		result, err := iso.GarbageCollectImages(*dryRun)
+		if err != nil {
+			return err
+		}
+
+		if *jsonOutput {
+			return printJSON(result)
+		}
+
+		if len(result.Removed) > 0 {
+			t := newTable("PROJECT", "REASON", "SIZE", "IMAGE")
+			t.maxWidth = terminalWidth()
+			for _, img := range result.Removed {
+				t.addRow("", cmp.Or(img.ProjectName, "(unknown)"), img.Reason, dfSize(img.Size), img.Name)
+			}
+			t.render(os.Stdout)
+		}
+		if result.DryRun {
+			fmt.Printf("Would remove %d image(s), %s\n", len(result.Removed), units.BytesSize(float64(result.BytesFreed)))
+		} else {
+			fmt.Printf("Removed %d image(s), %s freed\n", len(result.Removed), units.BytesSize(float64(result.BytesFreed)))
+		}
+		if len(result.InUse) > 0 {
+			fmt.Printf("Skipped %d in use: %s\n", len(result.InUse), strings.Join(result.InUse, ", "))
+		}
+		return nil
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "Remove untagged ISO images and images of deleted projects")
+}
+
+// registerReapCommand registers the 'reap' command
+func registerReapCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("reap")
+
+	dryRun := fs.Bool("dry-run", 'd', false, "List the idle sessions that would be stopped without stopping them")
+	jsonOutput := fs.Bool("json", 'j', false, "Output the stopped sessions as JSON")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		reaped, err := iso.ReapIdleSessions(*dryRun)
+		if err != nil {
+			return err
+		}
+
+		if *jsonOutput {
+			return printJSON(reaped)
+		}
+
+		if len(reaped) == 0 {
+			fmt.Println("No idle sessions")
+			return nil
+		}
+
+		verb := "Stopped"
+		if *dryRun {
+			verb = "Would stop"
+		}
+		for _, s := range reaped {
+			fmt.Printf("%s idle session %s/%s: %s\n", verb, s.ProjectName, s.Session, strings.Join(s.Containers, ", "))
+		}
+		return nil
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "Stop persistent sessions idle longer than their idle_timeout, across all projects")
+}
+
+// printPruneResult reports the cache volumes removed by prune, as JSON or a
+// summary (listing each volume for a dry run)
+func printPruneResult(result *iso.PruneResult, jsonOutput bool) error {
+	if jsonOutput {
+		return printJSON(result)
+	}
+
+	if result.DryRun {
+		for _, name := range result.Removed {
+			fmt.Println(name)
+		}
+		fmt.Printf("Would remove %d cache volume(s), %s\n", len(result.Removed), units.BytesSize(float64(result.BytesFreed)))
+	} else {
+		fmt.Printf("Removed %d cache volume(s), %s freed\n", len(result.Removed), units.BytesSize(float64(result.BytesFreed)))
+	}
+	if len(result.InUse) > 0 {
+		fmt.Printf("Skipped %d in use: %s\n", len(result.InUse), strings.Join(result.InUse, ", "))
+	}
+	return nil
+}
+
+// printPrunedNetworks reports the networks removed by prune or cleanup
+// --networks, as JSON or one per line
+func printPrunedNetworks(networks []string, dryRun, jsonOutput bool) error {
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Networks []string `json:"networks"`
+			DryRun   bool     `json:"dry_run"`
+		}{Networks: networks, DryRun: dryRun})
+	}
+
+	prefix := ""
+	verb := "Removed"
+	if dryRun {
+		prefix = "[DRY RUN] "
+		verb = "Would remove"
+	}
+	for _, name := range networks {
+		fmt.Printf("%s%s\n", prefix, name)
+	}
+	fmt.Printf("%s%s %d dangling network(s)\n", prefix, verb, len(networks))
+	return nil
+}
+
+// registerCleanupCommand registers the 'cleanup' command
+func registerCleanupCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("cleanup")
+
+	orphaned := fs.Bool("orphaned", 'o', false, "Clean up orphaned sessions")
+	interactive := fs.Bool("interactive", 'i', false, "Ask for confirmation per session")
+	dryRun := fs.Bool("dry-run", 'd', false, "Show what would be cleaned without doing it")
+	networks := fs.Bool("networks", 'n', false, "Remove ISO-created networks with no attached containers, across all projects")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		if !*orphaned && !*networks {
+			return fmt.Errorf("cleanup command requires --orphaned and/or --networks")
+		}
+
+		if *orphaned {
+			if err := cleanupOrphanedSessions(*interactive, *dryRun); err != nil {
+				return err
+			}
+		}
+
+		if *networks {
+			removed, err := iso.CleanupDanglingNetworks(*dryRun)
+			if err != nil {
+				return err
+			}
+			return printPrunedNetworks(removed, *dryRun, false)
+		}
+
+		return nil
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "Clean up orphaned sessions and dangling networks")
+}
+
+// cleanupOrphanedSessions removes sessions whose project directories no
+// longer exist, asking per session when interactive is set
+func cleanupOrphanedSessions(interactive, dryRun bool) error {
+	orphanedSessions, err := iso.ListOrphaned()
+	if err != nil {
+		return err
+	}
+
+	if len(orphanedSessions) == 0 {
+		fmt.Println("No orphaned sessions to clean up")
+		return nil
+	}
+
+	if interactive {
+		return cleanupInteractive(orphanedSessions, dryRun)
+	}
+
+	return cleanupAll(orphanedSessions, dryRun)
+}
+
+// registerCleanCommand registers the 'clean' command
+func registerCleanCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("clean")
+
+	gitignore := fs.Bool("gitignore", 'g', false, "Also add the generated files to .iso/.gitignore")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		removed, err := iso.CleanGenerated(*gitignore)
+		for _, path := range removed {
+			fmt.Printf("Removed %s\n", path)
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(removed) == 0 {
+			fmt.Println("No generated files to clean up")
+		}
+		if *gitignore {
+			fmt.Println("Updated .iso/.gitignore")
+		}
+		return nil
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "Remove generated files (extracted binary, logs) from the .iso directory")
+}
+
+func cleanupInteractive(sessions []iso.OrphanedSession, dryRun bool) error {
+	fmt.Printf("Found %d orphaned session(s):\n\n", len(sessions))
+
+	var sessionsToClean []iso.OrphanedSession
+
+	for _, session := range sessions {
+		serviceCount := 0
+		for _, c := range session.Containers {
+			if c.IsService {
+				serviceCount++
+			}
+		}
+
+		fmt.Printf("Session: %s / %s\n", session.ProjectName, session.Session)
+		fmt.Printf("  Project directory: %s\n", session.ProjectDir)
+		fmt.Printf("  Status: Directory not found\n")
+		fmt.Printf("  Containers: %d", len(session.Containers))
+		if serviceCount > 0 {
+			fmt.Printf(" (%d service(s))", serviceCount)
+		}
+		fmt.Println()
+		fmt.Println()
+
+		if dryRun {
+			fmt.Println("  [DRY RUN] Would stop and remove this session")
+			sessionsToClean = append(sessionsToClean, session)
+		} else {
+			fmt.Print("  Stop and remove this session? [y/N]: ")
+			var response string
+			fmt.Scanln(&response)
+
+			if response == "y" || response == "Y" {
+				sessionsToClean = append(sessionsToClean, session)
+				fmt.Printf("  ✓ Marked for cleanup\n")
+			} else {
+				fmt.Println("  Skipped")
+			}
+		}
+		fmt.Println()
+	}
+
+	if len(sessionsToClean) == 0 {
+		fmt.Println("No sessions selected for cleanup")
+		return nil
+	}
+
+	totalContainers := 0
+	for _, s := range sessionsToClean {
+		totalContainers += len(s.Containers)
+	}
+
+	if dryRun {
+		fmt.Printf("[DRY RUN] Would clean up %d session(s) (%d container(s))\n",
+			len(sessionsToClean), totalContainers)
+	} else {
+		// Clean up the selected sessions
+		count, err := iso.CleanupOrphanedSessions(sessionsToClean, false)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Cleaned up %d session(s) (%d container(s))\n",
+			len(sessionsToClean), count)
+	}
+
+	return nil
+}
+
+func cleanupAll(sessions []iso.OrphanedSession, dryRun bool) error {
+	totalContainers, err := iso.CleanupOrphaned(dryRun)
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		fmt.Printf("[DRY RUN] Would clean up %d orphaned session(s) (%d container(s))\n",
+			len(sessions), totalContainers)
+	} else {
+		fmt.Printf("Cleaned up %d orphaned session(s) (%d container(s))\n",
+			len(sessions), totalContainers)
+	}
+
+	return nil
+}
+
+// reapZombies reaps any zombie child processes
+func reapZombies() {
+	for {
+		var wstatus syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &wstatus, syscall.WNOHANG, nil)
+		if err != nil || pid <= 0 {
+			// No more children to reap
+			break
+		}
+		slog.Debug("reaped child process", "pid", pid, "exit_status", wstatus.ExitStatus())
+	}
+}
+
+// registerInitCommand registers the 'init' command for project initialization
+func registerInitCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("init")
+
+	template := fs.String("template", 0, "", "Initialize from a bundled template instead of generating (works offline, e.g. go, node, python, ruby, rust)")
+	fromTemplate := fs.String("from-template", 0, "", "Same as --template")
+	listTemplates := fs.Bool("list-templates", 0, false, "List the bundled templates")
+	provider := fs.String("provider", 0, "", "Generate with this provider: claude or openai (default: ISO_INIT_PROVIDER env var or claude)")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		if *listTemplates {
+			for _, name := range iso.Templates() {
+				fmt.Println(name)
+			}
+			return nil
+		}
+
+		if *fromTemplate != "" && *template != "" && *fromTemplate != *template {
+			return fmt.Errorf("--template and --from-template name different templates")
+		}
+		if name := cmp.Or(*template, *fromTemplate); name != "" {
+			return iso.InitProjectFromTemplate(name)
+		}
+
+		initProvider, err := iso.NewInitProvider(*provider)
+		if err != nil {
+			return err
+		}
+		return iso.InitProjectWithProvider(initProvider)
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "Initialize .iso directory with AI-generated Dockerfile and services.yml, or from a bundled template")
+}
+
+// registerValidateCommand registers the 'validate' command
+func registerValidateCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("validate")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		problems, err := iso.ValidateProject()
+		if err != nil {
+			return err
+		}
+
+		if len(problems) == 0 {
+			fmt.Println(".iso configuration is valid")
+			return nil
+		}
+
+		fmt.Fprintf(os.Stderr, "Found %d problem(s) in the .iso configuration:\n", len(problems))
+		for _, problem := range problems {
+			fmt.Fprintf(os.Stderr, "  - %s\n", problem)
+		}
+		return &ExitError{Code: 1}
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "Check the .iso config.yml, services.yml and peers.yml for problems without starting anything")
+}
+
+// registerInternalInitCommand registers the '_internal-init' command for container init process
+func registerInternalInitCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("_internal-init")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		// Set up signal handling
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGCHLD)
+
+		// Tell the host that signals are handled and children will be reaped,
+		// so it can start execs
+		if len(args) == 1 {
+			if err := os.MkdirAll(filepath.Dir(args[0]), 0755); err != nil {
+				slog.Warn("failed to create init ready file directory", "error", err)
+			} else if err := os.WriteFile(args[0], nil, 0644); err != nil {
+				slog.Warn("failed to write init ready file", "error", err)
+			}
+		}
+
+		slog.Info("init process started, waiting for signals")
+
+		// With idle_timeout, stop the container once no command has run
+		// through iso for that long
+		idleTimeout, _ := strconv.Atoi(os.Getenv("ISO_IDLE_TIMEOUT"))
+		var idleCheck <-chan time.Time
+		if idleTimeout > 0 {
+			idleTicker := time.NewTicker(30 * time.Second)
+			defer idleTicker.Stop()
+			idleCheck = idleTicker.C
+		}
+
+		// Sleep loop with zombie reaping
+		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case sig := <-sigChan:
+				if sig == syscall.SIGCHLD {
+					// Reap zombie processes
+					reapZombies()
+				} else {
+					slog.Info("received signal, exiting", "signal", sig)
+					return nil
+				}
+			case <-ticker.C:
+				// Periodically reap zombies in case we missed a SIGCHLD
+				reapZombies()
+			case <-idleCheck:
+				if idle, busy := sessionIdleTime(); !busy && idle >= time.Duration(idleTimeout)*time.Second {
+					slog.Info("session idle, stopping container", "idle", idle.Round(time.Second))
+					return &ExitError{Code: iso.IdleExitCode}
+				}
+			}
+		}
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "Run as init process in container (internal use only)")
+}
+
+// registerInternalInitReadyCommand registers the '_internal-init-ready'
+// command, which the host runs to check that the container's init process
+// has written its ready file
+func registerInternalInitReadyCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("_internal-init-ready")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("usage: _internal-init-ready READY_FILE")
+		}
+
+		// Exit code 3 means "not ready yet"; the host treats other failures as
+		// an iso binary without this check
+		if _, err := os.Stat(args[0]); err != nil {
+			return &ExitError{Code: 3}
+		}
+		return nil
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "Check that the container init process is ready (internal use only)")
+}
+
+// isoRunDir is the tmpfs in the session container holding the init ready
+// file, the pid files of running commands and the activity file
+const isoRunDir = "/run/iso"
+
+// activityFile is touched by in-env when a command starts and finishes, for
+// idle_timeout
+const activityFile = isoRunDir + "/last-used"
+
+// touchActivity records that a command started or finished in the session
+func touchActivity() {
+	if err := os.WriteFile(activityFile, nil, 0666); err != nil {
+		slog.Debug("failed to record session activity", "error", err)
+	}
+}
+
+// sessionIdleTime returns how long the session container has gone without a
+// command running through iso: since the last command started or finished, or
+// since init started. busy is set while a command runs.
+func sessionIdleTime() (idle time.Duration, busy bool) {
+	pidFiles, _ := filepath.Glob(filepath.Join(isoRunDir, "exec-*.pid"))
+	for _, pidFile := range pidFiles {
+		// A pid file left by a killed in-env doesn't count
+		if pidFileAlive(pidFile) {
+			return 0, true
+		}
+	}
+
+	var last time.Time
+	for _, name := range []string{activityFile, filepath.Join(isoRunDir, "init.ready")} {
+		if info, err := os.Stat(name); err == nil && info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	if last.IsZero() {
+		return 0, false
+	}
+	return time.Since(last), false
+}
+
+// pidFileAlive reports whether the process whose pid is in pidFile is still
+// running
+func pidFileAlive(pidFile string) bool {
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	return err == nil && syscall.Kill(pid, 0) != syscall.ESRCH
+}
+
+// registerInternalIdleCommand registers the '_internal-idle' command, which
+// the host runs to check whether a session container has been idle for its
+// idle_timeout
+func registerInternalIdleCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("_internal-idle")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("usage: _internal-idle SECONDS")
+		}
+		seconds, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid idle timeout %q", args[0])
+		}
+
+		if idle, busy := sessionIdleTime(); busy || idle < time.Duration(seconds)*time.Second {
+			return &ExitError{Code: 1}
+		}
+		return nil
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "Check whether the session has been idle for SECONDS (internal use only)")
+}
+
+// registerInternalProbeCommand registers the '_internal-probe' command, a single
+// TCP or HTTP readiness check the host runs inside the container
+func registerInternalProbeCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("_internal-probe")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		if len(args) != 1 && len(args) != 2 {
+			return fmt.Errorf("usage: _internal-probe host:port [health-path]")
+		}
+
+		healthPath := ""
+		if len(args) == 2 {
+			healthPath = args[1]
+		}
+		if !probeService(args[0], healthPath) {
+			return &ExitError{Code: 1}
+		}
+		return nil
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "Check that a TCP address accepts connections, or an HTTP path returns 2xx (internal use only)")
+}
+
+// registerInternalSecretCommand registers the '_internal-secret' command, which
+// writes a secret from stdin into the container's secrets tmpfs or removes
+// secrets again
+func registerInternalSecretCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("_internal-secret")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		if len(args) == 4 && args[0] == "write" {
+			uid, err := strconv.Atoi(args[2])
+			if err != nil {
+				return fmt.Errorf("invalid uid %q", args[2])
+			}
+			gid, err := strconv.Atoi(args[3])
+			if err != nil {
+				return fmt.Errorf("invalid gid %q", args[3])
+			}
+
+			f, err := os.OpenFile(args[1], os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0400)
+			if err != nil {
+				return fmt.Errorf("failed to create secret: %w", err)
+			}
+			if _, err := io.Copy(f, os.Stdin); err != nil {
+				f.Close()
+				return fmt.Errorf("failed to write secret: %w", err)
+			}
+			if err := f.Close(); err != nil {
+				return fmt.Errorf("failed to write secret: %w", err)
+			}
+			if err := os.Chown(args[1], uid, gid); err != nil {
+				return fmt.Errorf("failed to set secret owner: %w", err)
+			}
+			return nil
+		}
+
+		if len(args) > 1 && args[0] == "remove" {
+			for _, secretPath := range args[1:] {
+				if err := os.Remove(secretPath); err != nil && !os.IsNotExist(err) {
+					return fmt.Errorf("failed to remove secret: %w", err)
+				}
+			}
+			return nil
+		}
+
+		return fmt.Errorf("usage: _internal-secret write PATH UID GID | remove PATH...")
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "Write or remove a run secret (internal use only)")
+}
+
+// registerInternalChownCommand registers the '_internal-chown' command, which
+// sets the owner of a path in the container, whose image may have no chown
+func registerInternalChownCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("_internal-chown")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		if len(args) != 3 {
+			return fmt.Errorf("usage: _internal-chown UID GID PATH")
+		}
+		uid, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid uid %q", args[0])
+		}
+		gid, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid gid %q", args[1])
+		}
+		return os.Chown(args[2], uid, gid)
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "Set the owner of a path (internal use only)")
+}
+
+// registerInternalSignalCommand registers the '_internal-signal' command, which
+// delivers a signal forwarded from the host to the command in-env started
+func registerInternalSignalCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("_internal-signal")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		if len(args) != 2 {
+			return fmt.Errorf("usage: _internal-signal PIDFILE SIGNAL")
+		}
+		signum, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid signal %q", args[1])
+		}
+
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to read pid file: %w", err)
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return fmt.Errorf("invalid pid file %s: %w", args[0], err)
+		}
+
+		if err := syscall.Kill(pid, syscall.Signal(signum)); err != nil {
+			return fmt.Errorf("failed to signal process %d: %w", pid, err)
+		}
+		return nil
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "Signal the command started by in-env (internal use only)")
+}
+
+// registerInternalJobCommand registers the '_internal-job' command, which runs
+// a detached command on a pseudo-terminal with its output going to
+// JOB_PATH.log, serves `iso attach` on JOB_PATH.sock and records the command's
+// exit code in JOB_PATH.exit when it finishes
+func registerInternalJobCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("_internal-job")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		if len(args) < 2 {
+			return fmt.Errorf("usage: _internal-job JOB_PATH COMMAND...")
+		}
+		return runJob(args[0], args[1:])
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "Run a detached command with its output going to a job log (internal use only)")
+}
+
+// registerInternalJobAttachCommand registers the '_internal-job-attach'
+// command, which connects the terminal to a running detached run for
+// `iso attach` and exits with the job's exit code once it has finished
+func registerInternalJobAttachCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("_internal-job-attach")
 
-			fmt.Printf("  %-12s %-35s %-18s %s\n",
-				c.ID[:12], c.ShortName, c.Session, status)
-			totalContainers++
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("usage: _internal-job-attach JOB_PATH")
 		}
-		fmt.Println()
+		return attachJob(args[0])
 	}
 
-	fmt.Printf("Total orphaned containers: %d\n", totalContainers)
-	fmt.Println("To clean up orphaned sessions, run: iso cleanup --orphaned")
-
-	return nil
+	dispatchCommand(dispatcher, fs, handler, "Attach to a detached run (internal use only)")
 }
 
-// registerPruneCommand registers the 'prune' command
-func registerPruneCommand(dispatcher *mflags.Dispatcher) {
-	fs := mflags.NewFlagSet("prune")
+// keepFinishedJobs is how many finished detached runs keep their logs in the
+// session container; older ones are removed when the next job starts
+const keepFinishedJobs = 10
 
-	handler := func(fs *mflags.FlagSet, args []string) error {
-		// Prune doesn't use a specific session since cache volumes are shared
-		// We just need a client to access the project configuration
-		sessionName, _ := getSession("")
-		client, err := iso.New(sessionName)
+// pruneFinishedJobs removes the files of the finished jobs in dir, except the
+// keep most recently started ones. Running jobs are never removed.
+func pruneFinishedJobs(dir string, keep int) {
+	type job struct {
+		path    string
+		started time.Time
+	}
+	var finished []job
+	pidFiles, _ := filepath.Glob(filepath.Join(dir, "*.pid"))
+	for _, pidFile := range pidFiles {
+		jobPath := strings.TrimSuffix(pidFile, ".pid")
+		if _, err := os.Stat(jobPath + ".exit"); err != nil && pidFileAlive(pidFile) {
+			continue
+		}
+		info, err := os.Stat(pidFile)
 		if err != nil {
-			return err
+			continue
 		}
-		defer client.Close()
-
-		return client.Prune()
+		finished = append(finished, job{path: jobPath, started: info.ModTime()})
+	}
+	if len(finished) <= keep {
+		return
 	}
 
-	cmd := mflags.NewCommand(fs, handler,
-		mflags.WithUsage("Remove all cache volumes for the project"),
-	)
-
-	dispatcher.Dispatch("prune", cmd)
+	slices.SortFunc(finished, func(a, b job) int {
+		return b.started.Compare(a.started)
+	})
+	for _, j := range finished[keep:] {
+		for _, ext := range []string{".log", ".exit", ".pid", ".sock"} {
+			os.Remove(j.path + ext)
+		}
+	}
 }
 
-// registerCleanupCommand registers the 'cleanup' command
-func registerCleanupCommand(dispatcher *mflags.Dispatcher) {
-	fs := mflags.NewFlagSet("cleanup")
+// registerInternalJobLogCommand registers the '_internal-job-log' command,
+// which prints a detached run's log for `iso logs --job` and exits with the
+// job's exit code once it has finished
+func registerInternalJobLogCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("_internal-job-log")
 
-	orphaned := fs.Bool("orphaned", 'o', false, "Clean up orphaned sessions")
-	interactive := fs.Bool("interactive", 'i', false, "Ask for confirmation per session")
-	dryRun := fs.Bool("dry-run", 'd', false, "Show what would be cleaned without doing it")
+	follow := fs.Bool("follow", 'f', false, "Keep printing new output until the job finishes")
+	tail := fs.Int("tail", 'n', -1, "Number of lines to show from the end of the log (default: all)")
 
 	handler := func(fs *mflags.FlagSet, args []string) error {
-		if !*orphaned {
-			return fmt.Errorf("cleanup command requires --orphaned flag")
+		if len(args) != 1 {
+			return fmt.Errorf("usage: _internal-job-log [--follow] [--tail N] JOB_PATH")
 		}
+		jobPath := args[0]
+		jobID := filepath.Base(jobPath)
 
-		orphanedSessions, err := iso.ListOrphaned()
+		logFile, err := os.Open(jobPath + ".log")
+		if os.IsNotExist(err) {
+			return fmt.Errorf("job %s not found", jobID)
+		}
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to open job log: %w", err)
 		}
+		defer logFile.Close()
 
-		if len(orphanedSessions) == 0 {
-			fmt.Println("No orphaned sessions to clean up")
-			return nil
+		if *tail >= 0 {
+			offset, err := tailOffset(logFile, *tail)
+			if err != nil {
+				return fmt.Errorf("failed to read job log: %w", err)
+			}
+			if _, err := logFile.Seek(offset, io.SeekStart); err != nil {
+				return fmt.Errorf("failed to read job log: %w", err)
+			}
 		}
 
-		if *interactive {
-			return cleanupInteractive(orphanedSessions, *dryRun)
-		}
+		for {
+			// Check for the exit code before copying, so output written just
+			// before the job finished isn't missed
+			data, exitErr := os.ReadFile(jobPath + ".exit")
+			if _, err := io.Copy(os.Stdout, logFile); err != nil {
+				return fmt.Errorf("failed to read job log: %w", err)
+			}
 
-		return cleanupAll(orphanedSessions, *dryRun)
+			if exitErr == nil {
+				exitCode, err := strconv.Atoi(strings.TrimSpace(string(data)))
+				if err != nil {
+					return fmt.Errorf("invalid exit code for job %s: %w", jobID, err)
+				}
+				if exitCode != 0 {
+					return &ExitError{Code: exitCode}
+				}
+				return nil
+			}
+			if !pidFileAlive(jobPath + ".pid") {
+				return fmt.Errorf("job %s stopped without recording an exit code", jobID)
+			}
+			if !*follow {
+				return nil
+			}
+			time.Sleep(250 * time.Millisecond)
+		}
 	}
 
-	cmd := mflags.NewCommand(fs, handler,
-		mflags.WithUsage("Clean up orphaned sessions whose project directories no longer exist"),
-	)
-
-	dispatcher.Dispatch("cleanup", cmd)
+	dispatchCommand(dispatcher, fs, handler, "Print the log of a detached run (internal use only)")
 }
 
-func cleanupInteractive(sessions []iso.OrphanedSession, dryRun bool) error {
-	fmt.Printf("Found %d orphaned session(s):\n\n", len(sessions))
-
-	var sessionsToClean []iso.OrphanedSession
+// registerInternalJobsCommand registers the '_internal-jobs' command, which
+// prints the IDs of the detached runs in JOBS_DIR that are still running,
+// most recently started first
+func registerInternalJobsCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("_internal-jobs")
 
-	for _, session := range sessions {
-		serviceCount := 0
-		for _, c := range session.Containers {
-			if c.IsService {
-				serviceCount++
-			}
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("usage: _internal-jobs JOBS_DIR")
 		}
 
-		fmt.Printf("Session: %s / %s\n", session.ProjectName, session.Session)
-		fmt.Printf("  Project directory: %s\n", session.ProjectDir)
-		fmt.Printf("  Status: Directory not found\n")
-		fmt.Printf("  Containers: %d", len(session.Containers))
-		if serviceCount > 0 {
-			fmt.Printf(" (%d service(s))", serviceCount)
+		type job struct {
+			id      string
+			started time.Time
 		}
-		fmt.Println()
-		fmt.Println()
-
-		if dryRun {
-			fmt.Println("  [DRY RUN] Would stop and remove this session")
-			sessionsToClean = append(sessionsToClean, session)
-		} else {
-			fmt.Print("  Stop and remove this session? [y/N]: ")
-			var response string
-			fmt.Scanln(&response)
-
-			if response == "y" || response == "Y" {
-				sessionsToClean = append(sessionsToClean, session)
-				fmt.Printf("  ✓ Marked for cleanup\n")
-			} else {
-				fmt.Println("  Skipped")
+		var jobs []job
+		pidFiles, _ := filepath.Glob(filepath.Join(args[0], "*.pid"))
+		for _, pidFile := range pidFiles {
+			jobPath := strings.TrimSuffix(pidFile, ".pid")
+			if _, err := os.Stat(jobPath + ".exit"); err == nil || !pidFileAlive(pidFile) {
+				continue
 			}
+			info, err := os.Stat(pidFile)
+			if err != nil {
+				continue
+			}
+			jobs = append(jobs, job{id: filepath.Base(jobPath), started: info.ModTime()})
 		}
-		fmt.Println()
-	}
-
-	if len(sessionsToClean) == 0 {
-		fmt.Println("No sessions selected for cleanup")
-		return nil
-	}
-
-	totalContainers := 0
-	for _, s := range sessionsToClean {
-		totalContainers += len(s.Containers)
-	}
 
-	if dryRun {
-		fmt.Printf("[DRY RUN] Would clean up %d session(s) (%d container(s))\n",
-			len(sessionsToClean), totalContainers)
-	} else {
-		// Clean up the selected sessions
-		count, err := iso.CleanupOrphanedSessions(sessionsToClean, false)
-		if err != nil {
-			return err
+		slices.SortFunc(jobs, func(a, b job) int {
+			return b.started.Compare(a.started)
+		})
+		for _, j := range jobs {
+			fmt.Println(j.id)
 		}
-		fmt.Printf("Cleaned up %d session(s) (%d container(s))\n",
-			len(sessionsToClean), count)
+		return nil
 	}
 
-	return nil
+	dispatchCommand(dispatcher, fs, handler, "List the running detached runs (internal use only)")
 }
 
-func cleanupAll(sessions []iso.OrphanedSession, dryRun bool) error {
-	totalContainers, err := iso.CleanupOrphaned(dryRun)
+// tailOffset returns the offset in f of its last n lines
+func tailOffset(f *os.File, n int) (int64, error) {
+	data, err := io.ReadAll(f)
 	if err != nil {
-		return err
+		return 0, err
 	}
-
-	if dryRun {
-		fmt.Printf("[DRY RUN] Would clean up %d orphaned session(s) (%d container(s))\n",
-			len(sessions), totalContainers)
-	} else {
-		fmt.Printf("Cleaned up %d orphaned session(s) (%d container(s))\n",
-			len(sessions), totalContainers)
+	if n == 0 {
+		return int64(len(data)), nil
 	}
 
-	return nil
-}
-
-// reapZombies reaps any zombie child processes
-func reapZombies() {
-	for {
-		var wstatus syscall.WaitStatus
-		pid, err := syscall.Wait4(-1, &wstatus, syscall.WNOHANG, nil)
-		if err != nil || pid <= 0 {
-			// No more children to reap
-			break
+	end := len(data)
+	if end > 0 && data[end-1] == '\n' {
+		end--
+	}
+	for i := end - 1; i >= 0; i-- {
+		if data[i] == '\n' {
+			n--
+			if n == 0 {
+				return int64(i + 1), nil
+			}
 		}
-		slog.Debug("reaped child process", "pid", pid, "exit_status", wstatus.ExitStatus())
 	}
+	return 0, nil
 }
 
-// registerInitCommand registers the 'init' command for project initialization
-func registerInitCommand(dispatcher *mflags.Dispatcher) {
-	fs := mflags.NewFlagSet("init")
+// serviceHostsMarker tags the /etc/hosts lines written by _internal-hosts so
+// they can be replaced on the next run
+const serviceHostsMarker = "# iso-service"
+
+// registerInternalHostsCommand registers the '_internal-hosts' command, which
+// refreshes /etc/hosts entries for service names given as NAME=IP arguments,
+// skipping names that DNS already resolves
+func registerInternalHostsCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("_internal-hosts")
 
 	handler := func(fs *mflags.FlagSet, args []string) error {
-		return iso.InitProject()
-	}
+		const hostsPath = "/etc/hosts"
 
-	cmd := mflags.NewCommand(fs, handler,
-		mflags.WithUsage("Initialize .iso directory with AI-generated Dockerfile and services.yml"),
-	)
+		data, err := os.ReadFile(hostsPath)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", hostsPath, err)
+		}
 
-	dispatcher.Dispatch("init", cmd)
-}
+		// Drop the previous run's entries first so stale IPs don't count as
+		// resolving names below
+		var lines []string
+		for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+			if !strings.HasSuffix(line, serviceHostsMarker) {
+				lines = append(lines, line)
+			}
+		}
+		content := strings.Join(lines, "\n") + "\n"
 
-// registerInternalInitCommand registers the '_internal-init' command for container init process
-func registerInternalInitCommand(dispatcher *mflags.Dispatcher) {
-	fs := mflags.NewFlagSet("_internal-init")
+		// /etc/hosts is bind-mounted by Docker, so it must be rewritten in place
+		if err := os.WriteFile(hostsPath, []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", hostsPath, err)
+		}
 
-	handler := func(fs *mflags.FlagSet, args []string) error {
-		// Set up signal handling
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGCHLD)
+		var added []string
+		for _, arg := range args {
+			name, ip, ok := strings.Cut(arg, "=")
+			if !ok {
+				return fmt.Errorf("invalid entry %q (expected NAME=IP)", arg)
+			}
+			if addrs, err := net.LookupHost(name); err == nil && len(addrs) > 0 {
+				continue
+			}
+			added = append(added, fmt.Sprintf("%s\t%s %s", ip, name, serviceHostsMarker))
+		}
+		if len(added) == 0 {
+			return nil
+		}
 
-		slog.Info("init process started, waiting for signals")
+		content += strings.Join(added, "\n") + "\n"
+		if err := os.WriteFile(hostsPath, []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", hostsPath, err)
+		}
+		return nil
+	}
 
-		// Sleep loop with zombie reaping
-		ticker := time.NewTicker(1 * time.Second)
-		defer ticker.Stop()
+	dispatchCommand(dispatcher, fs, handler, "Write /etc/hosts entries for unresolvable service names (internal use only)")
+}
 
-		for {
-			select {
-			case sig := <-sigChan:
-				if sig == syscall.SIGCHLD {
-					// Reap zombie processes
-					reapZombies()
-				} else {
-					slog.Info("received signal, exiting", "signal", sig)
-					return nil
-				}
-			case <-ticker.C:
-				// Periodically reap zombies in case we missed a SIGCHLD
-				reapZombies()
-			}
+// probeService checks once whether a service is ready: with a health path,
+// http://address<healthPath> must return a 2xx status; otherwise address
+// must accept TCP connections
+func probeService(address, healthPath string) bool {
+	if healthPath == "" {
+		conn, err := net.DialTimeout("tcp", address, 1*time.Second)
+		if err != nil {
+			return false
 		}
+		conn.Close()
+		return true
+	}
+
+	client := &http.Client{Timeout: 1 * time.Second}
+	resp, err := client.Get("http://" + address + healthPath)
+	if err != nil {
+		return false
 	}
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
 
-	cmd := mflags.NewCommand(fs, handler,
-		mflags.WithUsage("Run as init process in container (internal use only)"),
-	)
+// defaultServiceTimeout is how long each service gets to become ready when
+// ISO_SERVICE_TIMEOUT isn't set
+const defaultServiceTimeout = 30 * time.Second
 
-	dispatcher.Dispatch("_internal-init", cmd)
+// serviceTimeoutFromEnv returns the service readiness timeout the host passed
+// in ISO_SERVICE_TIMEOUT (from config.yml's service_timeout)
+func serviceTimeoutFromEnv() time.Duration {
+	value := os.Getenv("ISO_SERVICE_TIMEOUT")
+	if value == "" {
+		return defaultServiceTimeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		slog.Warn("invalid ISO_SERVICE_TIMEOUT, using the default", "value", value, "default", defaultServiceTimeout)
+		return defaultServiceTimeout
+	}
+	return timeout
 }
 
-// waitForServices waits for all services in ISO_SERVICES to be reachable
-func waitForServices(isoServices string) error {
+// waitForServices waits for all services in ISO_SERVICES to be ready, giving
+// each up to timeout. Each entry is service:port, or service:port/path for an
+// HTTP health check.
+func waitForServices(isoServices string, timeout time.Duration) error {
 	services := strings.Split(isoServices, ",")
 
 	for _, serviceSpec := range services {
-		parts := strings.Split(serviceSpec, ":")
-		if len(parts) != 2 {
-			return fmt.Errorf("invalid service spec: %s (expected format: service:port)", serviceSpec)
+		host, portPath, ok := strings.Cut(serviceSpec, ":")
+		if !ok || host == "" {
+			return fmt.Errorf("invalid service spec: %s (expected format: service:port[/path])", serviceSpec)
 		}
 
-		host := parts[0]
-		port := parts[1]
+		port, healthPath := portPath, ""
+		if i := strings.Index(portPath, "/"); i >= 0 {
+			port, healthPath = portPath[:i], portPath[i:]
+		}
+		if _, err := strconv.Atoi(port); err != nil {
+			return fmt.Errorf("invalid service spec: %s (expected format: service:port[/path])", serviceSpec)
+		}
 		address := net.JoinHostPort(host, port)
 
-		slog.Debug("waiting for service", "service", host, "address", address)
+		slog.Debug("waiting for service", "service", host, "address", address, "health_path", healthPath)
 
-		// Try to connect with retries
-		maxAttempts := 30
-		for attempt := 1; attempt <= maxAttempts; attempt++ {
-			conn, err := net.DialTimeout("tcp", address, 1*time.Second)
-			if err == nil {
-				conn.Close()
+		// Try to connect with retries, one a second
+		maxAttempts := int((timeout + time.Second - 1) / time.Second)
+		for attempt := 1; ; attempt++ {
+			if probeService(address, healthPath) {
 				slog.Debug("service ready", "service", host, "address", address)
 				break
 			}
 
-			if attempt == maxAttempts {
-				return fmt.Errorf("service %s not ready after %d attempts", host, maxAttempts)
+			if attempt >= maxAttempts {
+				return fmt.Errorf("service %s not ready after %s (raise service_timeout for slow services)", host, timeout)
+			}
+			if attempt%5 == 0 {
+				slog.Info("waiting for service", "service", host, "address", address, "remaining_attempts", maxAttempts-attempt)
 			}
 
 			time.Sleep(1 * time.Second)
@@ -774,9 +2937,77 @@ func waitForServices(isoServices string) error {
 	return nil
 }
 
+// expandContainerEnv sets each variable passed by the host as
+// ISO_EXPAND_<KEY>=<value> to <value> expanded against the container's
+// environment, e.g. ISO_EXPAND_PATH=/opt/bin:$PATH prepends to the image's PATH
+func expandContainerEnv() {
+	environ := os.Environ()
+	for key, value := range expandedEnv(environ) {
+		os.Setenv(key, value)
+	}
+	for _, env := range environ {
+		if name, _, _ := strings.Cut(env, "="); strings.HasPrefix(name, "ISO_EXPAND_") {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+// expandedEnv returns the variables the ISO_EXPAND_<KEY> entries of environ
+// set, keyed by KEY. Every value expands against environ as it is, before any
+// of them is set, so the result doesn't depend on the order of environ: a
+// reference to another expanded variable sees its value from the container.
+func expandedEnv(environ []string) map[string]string {
+	values := make(map[string]string, len(environ))
+	for _, env := range environ {
+		name, value, _ := strings.Cut(env, "=")
+		values[name] = value
+	}
+	lookup := func(name string) string { return values[name] }
+
+	expanded := make(map[string]string)
+	for name, value := range values {
+		if key, ok := strings.CutPrefix(name, "ISO_EXPAND_"); ok && key != "" {
+			expanded[key] = expandContainerValue(value, lookup)
+		}
+	}
+	return expanded
+}
+
+// expandContainerValue replaces $NAME references in value using lookup. "$$"
+// produces a literal "$"; a "$" not followed by a variable name is kept as is.
+func expandContainerValue(value string, lookup func(string) string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' || i+1 == len(value) {
+			b.WriteByte(value[i])
+			continue
+		}
+
+		if value[i+1] == '$' {
+			b.WriteByte('$')
+			i++
+			continue
+		}
+
+		end := i + 1
+		for end < len(value) && (value[end] == '_' || value[end] >= 'a' && value[end] <= 'z' ||
+			value[end] >= 'A' && value[end] <= 'Z' || end > i+1 && value[end] >= '0' && value[end] <= '9') {
+			end++
+		}
+		if end == i+1 {
+			b.WriteByte('$')
+			continue
+		}
+
+		b.WriteString(lookup(value[i+1 : end]))
+		i = end - 1
+	}
+	return b.String()
+}
+
 // registerInEnvCommand registers the 'in-env run' command
 func registerInEnvCommand(dispatcher *mflags.Dispatcher) {
-	fs := mflags.NewFlagSet("in-env run")
+	fs := newFlagSet("in-env run")
 	fs.AllowUnknownFlags(true)
 
 	handler := func(fs *mflags.FlagSet, args []string) error {
@@ -793,15 +3024,21 @@ func registerInEnvCommand(dispatcher *mflags.Dispatcher) {
 			workDir = "/workspace"
 		}
 
-		// Wait for services to be ready if ISO_SERVICES is set
-		if isoServices := os.Getenv("ISO_SERVICES"); isoServices != "" {
-			if err := waitForServices(isoServices); err != nil {
+		// Expand config.yml environment values that reference container variables
+		expandContainerEnv()
+
+		// Wait for services to be ready if ISO_SERVICES is set. `iso run` already
+		// waits from the host and sets ISO_SERVICES_READY; this is the fallback
+		// for direct in-env usage.
+		if isoServices := os.Getenv("ISO_SERVICES"); isoServices != "" && os.Getenv("ISO_SERVICES_READY") == "" {
+			if err := waitForServices(isoServices, serviceTimeoutFromEnv()); err != nil {
 				return err
 			}
 		}
 
-		// Execute pre-run.sh if it exists
-		preRunScript := fmt.Sprintf("%s/.iso/pre-run.sh", workDir)
+		// Execute the pre-run script if it exists. A pre_run path set in
+		// config.yml must exist; the default .iso/pre-run.sh is optional.
+		preRunScript, preRunSet := hookScript("ISO_PRE_RUN", workDir, "pre-run.sh")
 		if _, err := os.Stat(preRunScript); err == nil {
 			// Script exists, execute it
 			cmd := exec.Command("bash", preRunScript)
@@ -813,97 +3050,201 @@ func registerInEnvCommand(dispatcher *mflags.Dispatcher) {
 				if exitErr, ok := err.(*exec.ExitError); ok {
 					return &ExitError{Code: exitErr.ExitCode()}
 				}
-				return fmt.Errorf("failed to execute pre-run.sh: %w", err)
+				return fmt.Errorf("failed to execute %s: %w", preRunScript, err)
 			}
+		} else if preRunSet {
+			return fmt.Errorf("pre_run script %s not found: %w", preRunScript, err)
 		}
 
-		// Execute the main command
+		// Execute the main command, recording session activity for
+		// idle_timeout before and after it
+		touchActivity()
+		defer touchActivity()
 		mainCmd := exec.Command(command[0], command[1:]...)
 		mainCmd.Stdout = os.Stdout
 		mainCmd.Stderr = os.Stderr
 		mainCmd.Stdin = os.Stdin
 
+		if err := mainCmd.Start(); err != nil {
+			return fmt.Errorf("failed to execute command: %w", err)
+		}
+
+		// Record the command's pid so the host can forward signals to it
+		if pidFile := os.Getenv("ISO_PID_FILE"); pidFile != "" {
+			if err := os.WriteFile(pidFile, []byte(strconv.Itoa(mainCmd.Process.Pid)), 0644); err != nil {
+				slog.Debug("failed to write pid file, signals won't be forwarded", "error", err)
+			} else {
+				defer os.Remove(pidFile)
+			}
+		}
+
 		mainExitCode := 0
-		if err := mainCmd.Run(); err != nil {
+		if err := mainCmd.Wait(); err != nil {
 			if exitErr, ok := err.(*exec.ExitError); ok {
 				mainExitCode = exitErr.ExitCode()
+				// Report a command killed by a signal like a shell does
+				if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+					mainExitCode = 128 + int(status.Signal())
+				}
 			} else {
 				return fmt.Errorf("failed to execute command: %w", err)
 			}
 		}
 
-		// Execute post-run.sh if it exists
-		postRunScript := fmt.Sprintf("%s/.iso/post-run.sh", workDir)
+		// Execute the post-run script if it exists, telling it what ran and
+		// how it exited. With post_run_required, its failure fails a
+		// successful run.
+		postRunScript, postRunSet := hookScript("ISO_POST_RUN", workDir, "post-run.sh")
+		postRunRequired := os.Getenv("ISO_POST_RUN_REQUIRED") == "1"
+		postRunExitCode := 0
 		if _, err := os.Stat(postRunScript); err == nil {
 			// Script exists, execute it
 			cmd := exec.Command("bash", postRunScript)
+			cmd.Env = append(os.Environ(),
+				fmt.Sprintf("ISO_COMMAND=%s", iso.ShellJoin(command)),
+				fmt.Sprintf("ISO_EXIT_CODE=%d", mainExitCode),
+			)
 			cmd.Stdout = os.Stdout
 			cmd.Stderr = os.Stderr
 			cmd.Stdin = os.Stdin
 
 			if err := cmd.Run(); err != nil {
+				postRunExitCode = 1
 				if exitErr, ok := err.(*exec.ExitError); ok {
-					slog.Warn("post-run.sh exited with non-zero code", "exit_code", exitErr.ExitCode())
+					postRunExitCode = exitErr.ExitCode()
+					slog.Warn("post-run script exited with non-zero code", "script", postRunScript, "exit_code", exitErr.ExitCode())
 				} else {
-					slog.Warn("failed to execute post-run.sh", "error", err)
+					slog.Warn("failed to execute post-run script", "script", postRunScript, "error", err)
 				}
 			}
+		} else if postRunSet {
+			postRunExitCode = 1
+			slog.Warn("post_run script not found", "script", postRunScript)
 		}
 
-		// Return the main command's exit code
+		// Return the main command's exit code, or the post-run script's if
+		// it's required and the command succeeded
 		if mainExitCode != 0 {
 			return &ExitError{Code: mainExitCode}
 		}
+		if postRunRequired && postRunExitCode != 0 {
+			return &ExitError{Code: postRunExitCode}
+		}
 
 		return nil
 	}
 
-	cmd := mflags.NewCommand(fs, handler,
-		mflags.WithUsage("Run a command with pre/post hooks (internal use inside container)"),
-	)
+	dispatchCommand(dispatcher, fs, handler, "Run a command with pre/post hooks (internal use inside container)")
+}
 
-	dispatcher.Dispatch("in-env run", cmd)
+// hookScript returns the hook script path in-env was given in envKey, or the
+// default .iso/<name> under workDir, and whether it was given
+func hookScript(envKey, workDir, name string) (string, bool) {
+	if script := os.Getenv(envKey); script != "" {
+		return script, true
+	}
+	return filepath.Join(workDir, ".iso", name), false
 }
 
 // registerAgentHelpCommand registers the 'agent-help' command
 func registerAgentHelpCommand(dispatcher *mflags.Dispatcher) {
-	fs := mflags.NewFlagSet("agent-help")
+	fs := newFlagSet("agent-help")
 
 	handler := func(fs *mflags.FlagSet, args []string) error {
 		fmt.Print(agentHelpContent)
 		return nil
 	}
 
-	cmd := mflags.NewCommand(fs, handler,
-		mflags.WithUsage("Output markdown documentation for AI agents"),
-	)
-
-	dispatcher.Dispatch("agent-help", cmd)
+	dispatchCommand(dispatcher, fs, handler, "Output markdown documentation for AI agents")
 }
 
 // registerVersionCommand registers the 'version' command
 func registerVersionCommand(dispatcher *mflags.Dispatcher) {
-	fs := mflags.NewFlagSet("version")
+	fs := newFlagSet("version")
 
 	handler := func(fs *mflags.FlagSet, args []string) error {
-		if version == "dev" {
-			fmt.Printf("iso %s (commit: %s)\n", version, commit)
+		v := version
+		// go install builds carry the module version instead of ldflags
+		if info, ok := debug.ReadBuildInfo(); ok && v == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		fmt.Printf("iso %s (commit: %s)\n", v, commit)
+		fmt.Printf("go: %s\n", runtime.Version())
+		fmt.Printf("platform: %s/%s\n", runtime.GOOS, runtime.GOARCH)
+
+		archs := iso.EmbeddedBinaryArchs()
+		if len(archs) == 0 {
+			fmt.Println("linux binaries: none")
 		} else {
-			fmt.Printf("iso %s\n", version)
+			fmt.Printf("linux binaries: %s\n", strings.Join(archs, ", "))
+		}
+
+		serverVersion, apiVersion, err := iso.DockerVersion()
+		if err != nil {
+			fmt.Printf("docker: unavailable (%v)\n", err)
+		} else {
+			fmt.Printf("docker: %s (API %s)\n", serverVersion, apiVersion)
+		}
+		return nil
+	}
+
+	dispatchCommand(dispatcher, fs, handler, "Show version information")
+}
+
+// registerDebugContainerCommand registers the hidden '__debug-container' command.
+// This is a break-glass tool: it opens a plain shell in the session container
+// without going through /iso in-env, so it works even when the container's init
+// or the extracted iso binary is broken.
+func registerDebugContainerCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("__debug-container")
+
+	session := fs.String("session", 's', "", "Session name (default: ISO_SESSION env var or ephemeral)")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		sessionName, _ := getSession(*session)
+		client, err := iso.New(sessionName)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		exitCode, err := client.DebugShell()
+		if err != nil {
+			return err
+		}
+		if exitCode != 0 {
+			return &ExitError{Code: exitCode}
 		}
 		return nil
 	}
 
-	cmd := mflags.NewCommand(fs, handler,
-		mflags.WithUsage("Show version information"),
-	)
+	dispatchCommand(dispatcher, fs, handler, "Open a raw diagnostic shell in the container, bypassing iso init and hooks (internal use only)")
+}
+
+// registerServeCommand registers the 'serve' command
+func registerServeCommand(dispatcher *mflags.Dispatcher) {
+	fs := newFlagSet("serve")
+
+	socket := fs.String("socket", 0, "", "Unix socket path (default: ISO_DAEMON_SOCKET or $XDG_RUNTIME_DIR/iso.sock)")
+
+	handler := func(fs *mflags.FlagSet, args []string) error {
+		socketPath := *socket
+		if socketPath == "" {
+			socketPath = iso.DaemonSocketPath()
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		return iso.Serve(ctx, socketPath)
+	}
 
-	dispatcher.Dispatch("version", cmd)
+	dispatchCommand(dispatcher, fs, handler, "Run the iso daemon that speeds up run/status/list when ISO_DAEMON=1")
 }
 
 // registerPeersUpCommand registers the 'peers up' command
 func registerPeersUpCommand(dispatcher *mflags.Dispatcher) {
-	fs := mflags.NewFlagSet("peers up")
+	fs := newFlagSet("peers up")
 
 	handler := func(fs *mflags.FlagSet, args []string) error {
 		// Peers use a fixed "peers" session internally
@@ -916,16 +3257,12 @@ func registerPeersUpCommand(dispatcher *mflags.Dispatcher) {
 		return client.PeersUp(args)
 	}
 
-	cmd := mflags.NewCommand(fs, handler,
-		mflags.WithUsage("Start all or specific peer containers"),
-	)
-
-	dispatcher.Dispatch("peers up", cmd)
+	dispatchCommand(dispatcher, fs, handler, "Start all or specific peer containers")
 }
 
 // registerPeersDownCommand registers the 'peers down' command
 func registerPeersDownCommand(dispatcher *mflags.Dispatcher) {
-	fs := mflags.NewFlagSet("peers down")
+	fs := newFlagSet("peers down")
 
 	handler := func(fs *mflags.FlagSet, args []string) error {
 		client, err := iso.New("peers")
@@ -937,16 +3274,12 @@ func registerPeersDownCommand(dispatcher *mflags.Dispatcher) {
 		return client.PeersDown()
 	}
 
-	cmd := mflags.NewCommand(fs, handler,
-		mflags.WithUsage("Stop and remove all peer containers"),
-	)
-
-	dispatcher.Dispatch("peers down", cmd)
+	dispatchCommand(dispatcher, fs, handler, "Stop and remove all peer containers")
 }
 
 // registerPeersExecCommand registers the 'peers exec' command
 func registerPeersExecCommand(dispatcher *mflags.Dispatcher) {
-	fs := mflags.NewFlagSet("peers exec")
+	fs := newFlagSet("peers exec")
 
 	all := fs.Bool("all", 'a', false, "Execute command on all peers")
 
@@ -989,16 +3322,12 @@ func registerPeersExecCommand(dispatcher *mflags.Dispatcher) {
 		return nil
 	}
 
-	cmd := mflags.NewCommand(fs, handler,
-		mflags.WithUsage("Execute a command in a peer container"),
-	)
-
-	dispatcher.Dispatch("peers exec", cmd)
+	dispatchCommand(dispatcher, fs, handler, "Execute a command in a peer container")
 }
 
 // registerPeersShellCommand registers the 'peers shell' command
 func registerPeersShellCommand(dispatcher *mflags.Dispatcher) {
-	fs := mflags.NewFlagSet("peers shell")
+	fs := newFlagSet("peers shell")
 
 	handler := func(fs *mflags.FlagSet, args []string) error {
 		if len(args) != 1 {
@@ -1023,16 +3352,12 @@ func registerPeersShellCommand(dispatcher *mflags.Dispatcher) {
 		return nil
 	}
 
-	cmd := mflags.NewCommand(fs, handler,
-		mflags.WithUsage("Open an interactive shell in a peer container"),
-	)
-
-	dispatcher.Dispatch("peers shell", cmd)
+	dispatchCommand(dispatcher, fs, handler, "Open an interactive shell in a peer container")
 }
 
 // registerPeersStatusCommand registers the 'peers status' command
 func registerPeersStatusCommand(dispatcher *mflags.Dispatcher) {
-	fs := mflags.NewFlagSet("peers status")
+	fs := newFlagSet("peers status")
 
 	handler := func(fs *mflags.FlagSet, args []string) error {
 		client, err := iso.New("peers")
@@ -1073,9 +3398,5 @@ func registerPeersStatusCommand(dispatcher *mflags.Dispatcher) {
 		return nil
 	}
 
-	cmd := mflags.NewCommand(fs, handler,
-		mflags.WithUsage("Show peer container status"),
-	)
-
-	dispatcher.Dispatch("peers status", cmd)
+	dispatchCommand(dispatcher, fs, handler, "Show peer container status")
 }
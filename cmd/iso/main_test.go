@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"miren.dev/mflags"
+)
+
+func TestExpandContainerValue(t *testing.T) {
+	vars := map[string]string{
+		"PATH":  "/usr/bin:/bin",
+		"HOME":  "/root",
+		"A1":    "digits",
+		"_priv": "underscore",
+	}
+	lookup := func(name string) string { return vars[name] }
+
+	cases := []struct {
+		value string
+		want  string
+	}{
+		{"plain", "plain"},
+		{"/opt/bin:$PATH", "/opt/bin:/usr/bin:/bin"},
+		{"$HOME/.cache", "/root/.cache"},
+		{"$HOME$HOME", "/root/root"},
+		{"$UNSET/x", "/x"},
+		{"cost: $$5", "cost: $5"},
+		{"$$HOME", "$HOME"},
+		{"$$$HOME", "$/root"},
+		{"trailing $", "trailing $"},
+		{"$", "$"},
+		{"$1abc", "$1abc"},
+		{"$A1", "digits"},
+		{"$A1B", ""},
+		{"$_priv", "underscore"},
+		{"${HOME}", "${HOME}"},
+		{"$HOME-dir", "/root-dir"},
+		{"a $ b", "a $ b"},
+	}
+
+	for _, tc := range cases {
+		if got := expandContainerValue(tc.value, lookup); got != tc.want {
+			t.Errorf("expandContainerValue(%q) = %q, want %q", tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestExpandedEnv(t *testing.T) {
+	environ := []string{
+		"PATH=/usr/bin",
+		"B=/image-b",
+		"ISO_EXPAND_A=$B/x",
+		"ISO_EXPAND_B=/opt:$PATH",
+		"ISO_EXPAND_PATH=/tools:$PATH",
+	}
+	want := map[string]string{
+		"A":    "/image-b/x",
+		"B":    "/opt:/usr/bin",
+		"PATH": "/tools:/usr/bin",
+	}
+
+	// The result must not depend on the order of the environment
+	for range 2 {
+		if got := expandedEnv(environ); !maps.Equal(got, want) {
+			t.Errorf("expandedEnv(%v) = %v, want %v", environ, got, want)
+		}
+		environ = []string{environ[4], environ[3], environ[2], environ[1], environ[0]}
+	}
+}
+
+func TestParseEnvFile(t *testing.T) {
+	t.Setenv("ISO_TEST_HOST_VAR", "from-host")
+
+	cases := []struct {
+		name    string
+		content string
+		want    []string
+		wantErr string
+	}{
+		{"plain", "A=1\nB=two words\n", []string{"A=1", "B=two words"}, ""},
+		{"blank lines and comments", "\n# comment\n  # indented comment\nA=1\n\n", []string{"A=1"}, ""},
+		{"export prefix", "export A=1\n", []string{"A=1"}, ""},
+		{"whitespace around key and value", "  A = 1  \n", []string{"A=1"}, ""},
+		{"empty value", "A=\n", []string{"A="}, ""},
+		{"inline comment", "A=1 # the answer\n", []string{"A=1"}, ""},
+		{"hash without space", "A=a#b\n", []string{"A=a#b"}, ""},
+		{"single quotes are literal", `A='x $HOME \n # y'` + "\n", []string{`A=x $HOME \n # y`}, ""},
+		{"double quote escapes", `A="line1\nline2 \"q\" back\\slash \t"` + "\n", []string{"A=line1\nline2 \"q\" back\\slash \\t"}, ""},
+		{"comment after quoted value", `A="x # kept" # dropped` + "\n", []string{"A=x # kept"}, ""},
+		{"equals in value", "A=b=c\n", []string{"A=b=c"}, ""},
+		{"bare key from host", "ISO_TEST_HOST_VAR\n", []string{"ISO_TEST_HOST_VAR=from-host"}, ""},
+		{"bare key unset on host", "ISO_TEST_UNSET_VAR\n", nil, ""},
+		{"CRLF line endings", "A=1\r\nB=2\r\n", []string{"A=1", "B=2"}, ""},
+		{"invalid name", "A=1\n1BAD=x\n", nil, ":2: invalid variable name \"1BAD\""},
+		{"missing name", "=x\n", nil, `invalid variable name ""`},
+		{"unterminated single quote", "A='x\n", nil, ":1: unterminated quote in value of A"},
+		{"unterminated double quote", `A="x\"` + "\n", nil, ":1: unterminated quote in value of A"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ".env")
+			if err := os.WriteFile(path, []byte(tc.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := parseEnvFile(path)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("parseEnvFile() error = %v, want one containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseEnvFile() error = %v", err)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("parseEnvFile() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := parseEnvFile(filepath.Join(t.TempDir(), "missing.env")); err == nil {
+		t.Error("parseEnvFile() of a missing file succeeded")
+	}
+}
+
+func TestMergeEnvVars(t *testing.T) {
+	cases := []struct {
+		name            string
+		base, overrides []string
+		want            []string
+	}{
+		{"no overrides", []string{"A=1", "B=2"}, nil, []string{"A=1", "B=2"}},
+		{"-e replaces the env file in place", []string{"A=1", "B=2"}, []string{"A=cli"}, []string{"A=cli", "B=2"}},
+		{"new keys are appended", []string{"A=1"}, []string{"C=3"}, []string{"A=1", "C=3"}},
+		{"last override wins", []string{"A=1"}, []string{"A=2", "A=3"}, []string{"A=3"}},
+		{"empty override value", []string{"A=1"}, []string{"A="}, []string{"A="}},
+	}
+
+	for _, tc := range cases {
+		base := slices.Clone(tc.base)
+		if got := mergeEnvVars(tc.base, tc.overrides); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: mergeEnvVars(%q, %q) = %q, want %q", tc.name, tc.base, tc.overrides, got, tc.want)
+		}
+		if !slices.Equal(tc.base, base) {
+			t.Errorf("%s: mergeEnvVars modified base to %q", tc.name, tc.base)
+		}
+	}
+}
+
+func TestPruneFinishedJobs(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour)
+	writeJob := func(id string, started time.Time, finished bool) {
+		t.Helper()
+		jobPath := filepath.Join(dir, id)
+		files := map[string]string{".pid": strconv.Itoa(os.Getpid()), ".log": "output\n"}
+		if finished {
+			files[".exit"] = "0"
+		}
+		for ext, data := range files {
+			if err := os.WriteFile(jobPath+ext, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.Chtimes(jobPath+".pid", started, started); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The oldest job is still running (this process is alive)
+	writeJob("running", start, false)
+	for i := range 12 {
+		writeJob(fmt.Sprintf("job%02d", i), start.Add(time.Duration(i+1)*time.Minute), true)
+	}
+
+	pruneFinishedJobs(dir, 10)
+
+	logs, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	var kept []string
+	for _, log := range logs {
+		kept = append(kept, strings.TrimSuffix(filepath.Base(log), ".log"))
+	}
+	want := []string{"job02", "job03", "job04", "job05", "job06", "job07", "job08", "job09", "job10", "job11", "running"}
+	if !slices.Equal(kept, want) {
+		t.Errorf("kept jobs = %v, want %v", kept, want)
+	}
+	for _, ext := range []string{".exit", ".pid"} {
+		if _, err := os.Stat(filepath.Join(dir, "job00"+ext)); !os.IsNotExist(err) {
+			t.Errorf("job00%s not removed", ext)
+		}
+	}
+}
+
+func TestCompletionCommands(t *testing.T) {
+	saved := completionCommands
+	completionCommands = nil
+	defer func() { completionCommands = saved }()
+
+	registerCommands(mflags.NewDispatcher("iso"))
+
+	commands := make(map[string]completionCommand)
+	for _, c := range completionCommands {
+		if internalCommand(c.name) {
+			t.Errorf("internal command %q offered for completion", c.name)
+		}
+		if _, ok := commands[c.name]; ok {
+			t.Errorf("command %q recorded twice", c.name)
+		}
+		commands[c.name] = c
+	}
+
+	run, ok := commands["run"]
+	if !ok {
+		t.Fatal("run not offered for completion")
+	}
+	if !slices.Contains(run.flags, completionFlag{name: "session", short: 's', value: true}) {
+		t.Errorf("run flags = %v, want --session/-s with a value", run.flags)
+	}
+	if !slices.Contains(run.flags, completionFlag{name: "watch", short: 'w'}) {
+		t.Errorf("run flags = %v, want --watch/-w", run.flags)
+	}
+	if _, ok := commands["peers exec"]; !ok {
+		t.Error("peers exec not offered for completion")
+	}
+}
+
+func TestTakeListFlags(t *testing.T) {
+	fs := newFlagSet("test")
+	fs.String("session", 's', "", "")
+	copyOut := fs.StringList("copy-out", "")
+	listFlagSets["test"] = fs
+	defer delete(listFlagSets, "test")
+
+	// A --session value that looks like a list flag and anything after "--"
+	// are left alone
+	args := []string{"test", "--copy-out", "a:b", "-s", "--copy-out", "--copy-out=c:d", "cmd", "--", "--copy-out", "x"}
+	rest, err := takeListFlags(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"test", "-s", "--copy-out", "cmd", "--", "--copy-out", "x"}; !slices.Equal(rest, want) {
+		t.Errorf("rest = %q, want %q", rest, want)
+	}
+	if want := []string{"a:b", "c:d"}; !slices.Equal(*copyOut, want) {
+		t.Errorf("--copy-out values = %q, want %q", *copyOut, want)
+	}
+
+	if _, err := takeListFlags([]string{"test", "--copy-out"}); err == nil {
+		t.Error("expected an error for --copy-out without a value")
+	}
+	if rest, _ := takeListFlags([]string{"other", "--copy-out", "a:b"}); len(rest) != 3 {
+		t.Errorf("flags of other commands taken: %q", rest)
+	}
+}
+
+func TestDetachKeys(t *testing.T) {
+	cases := []struct {
+		inputs [][]byte
+		want   string
+		detach bool
+	}{
+		{inputs: [][]byte{[]byte("ls\r")}, want: "ls\r"},
+		{inputs: [][]byte{{'a', ctrlP, ctrlQ, 'b'}}, want: "a", detach: true},
+		// The sequence may arrive split across reads
+		{inputs: [][]byte{{'a', ctrlP}, {ctrlQ}}, want: "a", detach: true},
+		// A Ctrl+P not followed by Ctrl+Q still reaches the job
+		{inputs: [][]byte{{ctrlP}, {'x'}}, want: string([]byte{ctrlP, 'x'})},
+		{inputs: [][]byte{{ctrlP, ctrlP, ctrlQ}}, want: string([]byte{ctrlP}), detach: true},
+	}
+	for _, tc := range cases {
+		var keys detachKeys
+		var got []byte
+		detach := false
+		for _, input := range tc.inputs {
+			out, d := keys.filter(input)
+			got = append(got, out...)
+			if d {
+				detach = true
+				break
+			}
+		}
+		if string(got) != tc.want || detach != tc.detach {
+			t.Errorf("filter(%q) = %q, %v; want %q, %v", tc.inputs, got, detach, tc.want, tc.detach)
+		}
+	}
+}
+
+func TestRunJobAttach(t *testing.T) {
+	if _, err := os.Stat("/dev/ptmx"); err != nil {
+		t.Skip("no pseudo-terminals")
+	}
+	// Unix socket paths are limited to about 100 bytes
+	dir, err := os.MkdirTemp("", "iso-jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	jobPath := filepath.Join(dir, "job1")
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runJob(jobPath, []string{"sh", "-c", "echo started; read line; echo got $line; exit 3"})
+	}()
+
+	var conn net.Conn
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		conn, err = net.Dial("unix", jobPath+".sock")
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job socket not served: %v", err)
+		}
+	}
+	defer conn.Close()
+
+	// Output written before attaching is replayed from the log
+	readUntil := func(want string) {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var got []byte
+		buf := make([]byte, 1024)
+		for !bytes.Contains(got, []byte(want)) {
+			n, err := conn.Read(buf)
+			got = append(got, buf[:n]...)
+			if err != nil {
+				t.Fatalf("output %q doesn't contain %q: %v", got, want, err)
+			}
+		}
+	}
+	readUntil("started")
+
+	if err := writeJobFrame(conn, jobFrameResize, []byte{0, 40, 0, 120}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeJobFrame(conn, jobFrameInput, []byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	readUntil("got hello")
+
+	// The job ending disconnects the client
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.Copy(io.Discard, conn); err != nil {
+		t.Errorf("reading until the job ends: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("runJob() error = %v", err)
+	}
+
+	if data, err := os.ReadFile(jobPath + ".exit"); err != nil || string(data) != "3" {
+		t.Errorf("exit code = %q, %v; want 3", data, err)
+	}
+	if log, _ := os.ReadFile(jobPath + ".log"); !bytes.Contains(log, []byte("got hello")) {
+		t.Errorf("log = %q, want the job's output", log)
+	}
+	if _, err := os.Stat(jobPath + ".sock"); !os.IsNotExist(err) {
+		t.Errorf("job socket left behind: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// openPty opens a new pseudo-terminal and returns its master and slave ends
+func openPty() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open /dev/ptmx: %w", err)
+	}
+
+	var unlock int32
+	var n uint32
+	err = ptyIoctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock))
+	if err == nil {
+		err = ptyIoctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n))
+	}
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to set up pseudo-terminal: %w", err)
+	}
+
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to open pseudo-terminal: %w", err)
+	}
+	return master, slave, nil
+}
+
+// setPtySize sets the window size of the pseudo-terminal behind f, which
+// signals SIGWINCH to its foreground process group
+func setPtySize(f *os.File, rows, cols uint16) error {
+	ws := struct{ rows, cols, x, y uint16 }{rows: rows, cols: cols}
+	return ptyIoctl(f, syscall.TIOCSWINSZ, unsafe.Pointer(&ws))
+}
+
+// ptyIoctl runs an ioctl on f without taking it out of non-blocking mode (as
+// f.Fd() would), so closing f still interrupts pending reads
+func ptyIoctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	if err := conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// errNoPty is returned where a pseudo-terminal is needed; detached runs only
+// happen inside (Linux) session containers
+var errNoPty = errors.New("pseudo-terminals are only supported on Linux")
+
+func openPty() (master, slave *os.File, err error) {
+	return nil, nil, errNoPty
+}
+
+func setPtySize(f *os.File, rows, cols uint16) error {
+	return errNoPty
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/moby/term"
+)
+
+// ANSI color codes used for status output
+const (
+	colorReset = "\033[0m"
+	colorGreen = "\033[32m"
+	colorGray  = "\033[90m"
+)
+
+// useColor reports whether output to stdout should be colorized: not disabled
+// by --no-color or NO_COLOR, and stdout is a terminal
+func useColor(noColor bool) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return term.IsTerminal(os.Stdout.Fd())
+}
+
+// statusColor picks the color for a container status: green when running,
+// gray otherwise
+func statusColor(status string) string {
+	if strings.HasPrefix(status, "Up") || strings.HasSuffix(status, "running") {
+		return colorGreen
+	}
+	return colorGray
+}
+
+// terminalWidth returns the width of the terminal on stdout, or 0 if stdout
+// isn't a terminal
+func terminalWidth() int {
+	if !term.IsTerminal(os.Stdout.Fd()) {
+		return 0
+	}
+	ws, err := term.GetWinsize(os.Stdout.Fd())
+	if err != nil {
+		return 0
+	}
+	return int(ws.Width)
+}
+
+// table renders rows in columns sized to their content. The last column is
+// truncated to fit maxWidth when set.
+type table struct {
+	headers []string
+	rows    [][]string
+	// colors holds an optional ANSI color per row for the statusColumn cell
+	colors       []string
+	statusColumn int
+	indent       string
+	maxWidth     int
+}
+
+// newTable creates a table with the given column headers
+func newTable(headers ...string) *table {
+	return &table{headers: headers, statusColumn: -1}
+}
+
+// addRow appends a row, colorizing its status column cell with color ("" for none)
+func (t *table) addRow(color string, cells ...string) {
+	t.rows = append(t.rows, cells)
+	t.colors = append(t.colors, color)
+}
+
+// render writes the table to w
+func (t *table) render(w io.Writer) {
+	widths := make([]int, len(t.headers))
+	for i, h := range t.headers {
+		widths[i] = utf8.RuneCountInString(h)
+	}
+	for _, row := range t.rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+
+	// Shrink the last column so lines fit the terminal
+	last := len(widths) - 1
+	if t.maxWidth > 0 && last >= 0 {
+		used := utf8.RuneCountInString(t.indent)
+		for _, width := range widths[:last] {
+			used += width + 2
+		}
+		widths[last] = max(min(widths[last], t.maxWidth-used), utf8.RuneCountInString(t.headers[last]))
+	}
+
+	t.renderRow(w, t.headers, widths, "")
+	for i, row := range t.rows {
+		t.renderRow(w, row, widths, t.colors[i])
+	}
+}
+
+// renderRow writes a single padded row
+func (t *table) renderRow(w io.Writer, cells []string, widths []int, color string) {
+	var b strings.Builder
+	b.WriteString(t.indent)
+	for i, cell := range cells {
+		cell = truncate(cell, widths[i])
+		padded := cell
+		if i < len(cells)-1 {
+			padded += strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)+2)
+		}
+		if i == t.statusColumn && color != "" {
+			padded = color + cell + colorReset + padded[len(cell):]
+		}
+		b.WriteString(padded)
+	}
+	fmt.Fprintln(w, strings.TrimRight(b.String(), " "))
+}
+
+// truncate shortens s to at most width runes, marking the cut with "…"
+func truncate(s string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:width-1]) + "…"
+}
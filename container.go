@@ -1,23 +1,40 @@
 package iso
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
+	"net"
 	"os"
 	"os/signal"
 	"os/user"
+	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
+	"github.com/moby/go-archive"
 	"github.com/moby/term"
+	"golang.org/x/sync/errgroup"
 )
 
 // containerManager handles container lifecycle operations
@@ -38,34 +55,92 @@ type containerManager struct {
 	isoDir              string
 	tempIsoPath         string // Path to extracted Linux iso binary
 	config              *Config
+	// selectedServices, when non-empty, restricts the services started for a
+	// run to these names (see RunOptions.WithServices)
+	selectedServices []string
+	// noServices skips starting any services for a run (see
+	// RunOptions.NoServices)
+	noServices bool
+	// privilegedOverride, when set, replaces config.Privileged for the session
+	// container (see RunOptions.Privileged)
+	privilegedOverride *bool
+	// noAutoRebuild keeps using an existing image even when .iso/Dockerfile
+	// or the build args changed since it was built
+	noAutoRebuild bool
+	// buildNoCache builds without Docker's layer cache (iso build --no-cache)
+	buildNoCache bool
+	// buildPull re-pulls the Dockerfile's base images when building
+	// (iso build --pull)
+	buildPull bool
+	// events receives run events while a run with RunOptions.EventsPath is in
+	// progress (nil otherwise)
+	events *eventLog
+	// hostEnv is the host environment execs take env_passthrough, TERM and
+	// SSH_AUTH_SOCK from, set by the daemon to the calling CLI's environment
+	// (nil for os.Environ())
+	hostEnv []string
+
+	// execMu guards the state of the exec in progress, which signalExec and
+	// restoreTerminal use from signal handlers
+	execMu sync.Mutex
+	// execContainer and execPidFile locate the running command for
+	// signalExec ("" when no command is running)
+	execContainer string
+	execPidFile   string
+	// termState is the terminal state to restore while execAttached has the
+	// terminal in raw mode
+	termState *term.State
+
+	// hostGatewayOnce guards hostGatewayNeeded, which records whether the
+	// daemon needs an explicit host.docker.internal mapping (see extraHosts)
+	hostGatewayOnce   sync.Once
+	hostGatewayNeeded bool
 }
 
-// newContainerManager creates a new container manager
+// newContainerManager creates a new container manager for the project
+// containing the current directory
 func newContainerManager(session string) (*containerManager, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	return newContainerManagerIn(session, cwd)
+}
+
+// newContainerManagerIn creates a new container manager for the project
+// containing dir
+func newContainerManagerIn(session, dir string) (*containerManager, error) {
+	return newContainerManagerWith(session, dir, os.LookupEnv)
+}
+
+// newContainerManagerWith is newContainerManagerIn with the host variables
+// the configuration references looked up with lookup
+func newContainerManagerWith(session, dir string, lookup hostLookup) (*containerManager, error) {
 	// Default to "default" session if not specified
 	if session == "" {
 		session = "default"
 	}
 
-	docker, err := newDockerClient()
-	if err != nil {
-		return nil, err
-	}
-
 	// Try to find .iso directory
-	isoDir, projectRoot, found := findIsoDir()
+	isoDir, projectRoot, found := findIsoDirFrom(dir)
 	if !found {
-		return nil, fmt.Errorf("no .iso directory found - please create one with a Dockerfile and optional services.yml")
+		return nil, ErrNoIsoDir
 	}
 
 	// Load config if it exists
-	config, err := loadConfigFile(isoDir)
+	config, err := loadConfigFileWith(isoDir, lookup)
 	if err != nil {
 		return nil, err
 	}
 
 	// Load services if they exist
-	services, err := loadServicesFile(isoDir)
+	services, err := loadServicesFileWith(isoDir, lookup)
+	if err != nil {
+		return nil, err
+	}
+
+	docker, err := newDockerClientFor(config.Context)
 	if err != nil {
 		return nil, err
 	}
@@ -77,12 +152,12 @@ func newContainerManager(session string) (*containerManager, error) {
 	}
 
 	// Detect git worktree to determine project names
-	baseProjectName, worktreeProjectName := detectGitWorktree(projectRoot)
+	baseProjectName, worktreeProjectName := projectNames(projectRoot, config)
 
 	dockerfilePath := filepath.Join(isoDir, "Dockerfile")
 
-	// Check if Dockerfile exists
-	if _, err := os.Stat(dockerfilePath); os.IsNotExist(err) {
+	// Check if Dockerfile exists (not needed when using a prebuilt image)
+	if _, err := os.Stat(dockerfilePath); os.IsNotExist(err) && config.Image == "" {
 		return nil, fmt.Errorf("Dockerfile not found at %s", dockerfilePath)
 	}
 
@@ -91,16 +166,27 @@ func newContainerManager(session string) (*containerManager, error) {
 	// Container and network names use worktreeProjectName (isolated per worktree)
 	// Cache volumes will use baseProjectName (shared across worktrees)
 	imageName := fmt.Sprintf("%s-shell", worktreeProjectName)
+	if config.BuildTarget != "" {
+		imageName += "-" + strings.ToLower(config.BuildTarget)
+	}
+	if config.ImageName != "" {
+		imageName = config.ImageName
+	}
+	if config.Image != "" {
+		imageName = config.Image
+	}
 
-	var networkName, containerName string
-	if session == "default" {
-		networkName = fmt.Sprintf("%s-network", worktreeProjectName)
-		containerName = fmt.Sprintf("%s-shell", worktreeProjectName)
-	} else {
-		networkName = fmt.Sprintf("%s-%s-network", worktreeProjectName, session)
-		containerName = fmt.Sprintf("%s-%s-shell", worktreeProjectName, session)
+	// Services built from the repository run the image they're built into
+	for name, service := range services {
+		if service.Build != "" {
+			service.Image = fmt.Sprintf("%s-svc-%s", worktreeProjectName, strings.ToLower(name))
+			services[name] = service
+		}
 	}
 
+	networkName := sessionNetworkName(worktreeProjectName, session)
+	containerName := sessionContainerName(worktreeProjectName, session)
+
 	// Get Docker architecture to determine which binary to use
 	arch, err := docker.getArchitecture()
 	if err != nil {
@@ -154,17 +240,80 @@ func (cm *containerManager) close() error {
 	return cm.docker.close()
 }
 
+// maxDockerNameLength bounds the names iso generates for containers, networks
+// and volumes. Deep cache paths and long session names otherwise produce
+// names Docker (or the hostnames derived from them) can't take.
+const maxDockerNameLength = 64
+
+// limitDockerName returns prefix+suffix, or, when that's longer than
+// maxDockerNameLength, prefix truncated and followed by a short hash of the
+// full name, so distinct long names stay distinct. The suffix is kept intact,
+// since names are recognized by it (e.g. "-network").
+func limitDockerName(prefix, suffix string) string {
+	name := prefix + suffix
+	if len(name) <= maxDockerNameLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	hash := hex.EncodeToString(sum[:])[:8]
+	keep := maxDockerNameLength - len(suffix) - len(hash) - 1
+	return strings.TrimRight(prefix[:keep], "-_.") + "-" + hash + suffix
+}
+
+// sessionContainerName returns the name of a session's main container:
+// <project>-shell, or <project>-<session>-shell for named sessions
+func sessionContainerName(projectName, session string) string {
+	if session == "default" {
+		return limitDockerName(projectName, "-shell")
+	}
+	return limitDockerName(projectName+"-"+session, "-shell")
+}
+
+// sessionNetworkName returns the name of a session's network: <project>-network,
+// or <project>-<session>-network for named sessions
+func sessionNetworkName(projectName, session string) string {
+	if session == "default" {
+		return limitDockerName(projectName, "-network")
+	}
+	return limitDockerName(projectName+"-"+session, "-network")
+}
+
 // getVolumeNameForPath generates a Docker volume name for a container path
 // Session-specific volumes are removed when the session is stopped
 // Uses worktreeProjectName to isolate volumes per worktree
 func (cm *containerManager) getVolumeNameForPath(path string) string {
+	return cm.volumeNameForPathWith(cm.docker, path)
+}
+
+// volumeNameForPathWith is getVolumeNameForPath, looking up a legacy volume
+// through docker, for cleanup after the manager's context is cancelled
+func (cm *containerManager) volumeNameForPathWith(docker *dockerClient, path string) string {
 	// Sanitize the path to create a valid volume name
 	// Replace / with - and remove leading/trailing dashes
 	sanitized := strings.ReplaceAll(strings.Trim(path, "/"), "/", "-")
 	if cm.session == "default" {
-		return fmt.Sprintf("%s-%s", cm.worktreeProjectName, sanitized)
+		return resolveVolumeName(docker, fmt.Sprintf("%s-%s", cm.worktreeProjectName, sanitized))
+	}
+	return resolveVolumeName(docker, fmt.Sprintf("%s-%s-%s", cm.worktreeProjectName, cm.session, sanitized))
+}
+
+// resolveVolumeName returns name shortened by limitDockerName, unless name is
+// over-long and a volume already exists under it: names weren't shortened
+// before, and switching to the short name would leave that volume's data
+// behind for an empty one
+func resolveVolumeName(docker *dockerClient, name string) string {
+	short := limitDockerName(name, "")
+	if short == name || docker == nil {
+		return short
+	}
+	if exists, err := docker.volumeExists(short); err == nil && exists {
+		return short
+	}
+	if exists, err := docker.volumeExists(name); err == nil && exists {
+		slog.Debug("reusing volume with legacy long name", "volume", name)
+		return name
 	}
-	return fmt.Sprintf("%s-%s-%s", cm.worktreeProjectName, cm.session, sanitized)
+	return short
 }
 
 // getCacheVolumeNameForPath generates a Docker volume name for a cache path
@@ -174,7 +323,169 @@ func (cm *containerManager) getCacheVolumeNameForPath(path string) string {
 	// Sanitize the path to create a valid volume name
 	// Replace / with - and remove leading/trailing dashes
 	sanitized := strings.ReplaceAll(strings.Trim(path, "/"), "/", "-")
-	return fmt.Sprintf("%s-cache-%s", cm.baseProjectName, sanitized)
+	return resolveVolumeName(cm.docker, fmt.Sprintf("%s-cache-%s", cm.baseProjectName, sanitized))
+}
+
+// withUserLabels returns labels merged over the user labels from config.yml,
+// so the reserved iso.* labels always win. The user label keys are recorded
+// in iso.user-labels to tell them apart from labels inherited from the image.
+func (cm *containerManager) withUserLabels(labels map[string]string) map[string]string {
+	merged := make(map[string]string, len(cm.config.Labels)+len(labels)+1)
+	maps.Copy(merged, cm.config.Labels)
+	if len(cm.config.Labels) > 0 {
+		merged["iso.user-labels"] = strings.Join(slices.Sorted(maps.Keys(cm.config.Labels)), ",")
+	}
+	maps.Copy(merged, labels)
+	return merged
+}
+
+// volumeLabels returns the labels for a volume of the given kind (one of the
+// volumeKind constants) belonging to project, and to session for session
+// volumes, merged over the user labels
+func (cm *containerManager) volumeLabels(project, kind, session string) map[string]string {
+	labels := map[string]string{
+		"iso.managed":      "true",
+		"iso.project.name": project,
+		"iso.volume":       kind,
+	}
+	if session != "" {
+		labels["iso.session"] = session
+	}
+	return cm.withUserLabels(labels)
+}
+
+// expandHomeDir expands a leading ~ in a host path to the user's home
+// directory, returning the path unchanged if it can't be determined
+func expandHomeDir(hostPath string) string {
+	if hostPath != "~" && !strings.HasPrefix(hostPath, "~/") {
+		return hostPath
+	}
+	usr, err := user.Current()
+	if err != nil {
+		return hostPath
+	}
+	return filepath.Join(usr.HomeDir, hostPath[1:])
+}
+
+// containerSSHAgentSocket is where the forwarded SSH agent socket is mounted
+// in the container
+const containerSSHAgentSocket = "/run/host-ssh-agent.sock"
+
+// dockerDesktopSSHAgentSocket is the socket Docker Desktop for Mac exposes
+// in its VM for the macOS SSH agent, since host sockets can't be bind-mounted
+const dockerDesktopSSHAgentSocket = "/run/host-services/ssh-auth.sock"
+
+// sshAgentSocket returns the host path of the SSH agent socket to mount when
+// forward_ssh_agent is set, or "" if forwarding is off or there is no agent
+func (cm *containerManager) sshAgentSocket() string {
+	if !cm.config.ForwardSSHAgent {
+		return ""
+	}
+	if runtime.GOOS == "darwin" {
+		return dockerDesktopSSHAgentSocket
+	}
+	socket := cm.hostGetenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return ""
+	}
+	if _, err := os.Stat(socket); err != nil {
+		return ""
+	}
+	return socket
+}
+
+// hostGetenv returns the host environment variable name from hostEnv, or the
+// process environment outside the daemon
+func (cm *containerManager) hostGetenv(name string) string {
+	if cm.hostEnv == nil {
+		return os.Getenv(name)
+	}
+	for _, entry := range cm.hostEnv {
+		if key, value, ok := strings.Cut(entry, "="); ok && key == name {
+			return value
+		}
+	}
+	return ""
+}
+
+// hostGatewayName is the name add_host_gateway maps to the Docker host
+const hostGatewayName = "host.docker.internal"
+
+// extraHosts returns the /etc/hosts entries for a container: hosts plus, with
+// add_host_gateway, host.docker.internal mapped to the Docker host. Docker
+// Desktop resolves that name itself, so the mapping is only added elsewhere.
+func (cm *containerManager) extraHosts(hosts []string) []string {
+	if !cm.config.AddHostGateway {
+		return hosts
+	}
+	cm.hostGatewayOnce.Do(func() {
+		desktop, err := cm.docker.isDockerDesktop()
+		if err != nil {
+			// The mapping works on Docker Desktop too, it's just redundant
+			slog.Debug("failed to detect Docker Desktop, adding the host gateway mapping", "error", err)
+		}
+		cm.hostGatewayNeeded = !desktop
+	})
+	if !cm.hostGatewayNeeded {
+		return hosts
+	}
+	return withHostGateway(hosts)
+}
+
+// withHostGateway appends the host-gateway mapping of host.docker.internal
+// to hosts, unless an entry already maps that name
+func withHostGateway(hosts []string) []string {
+	for _, entry := range hosts {
+		name := entry
+		if i := strings.IndexAny(entry, ":="); i >= 0 {
+			name = entry[:i]
+		}
+		if name == hostGatewayName {
+			return hosts
+		}
+	}
+	return append(slices.Clone(hosts), hostGatewayName+":host-gateway")
+}
+
+// getServiceVolumeName returns the Docker volume backing a service's named
+// volume. It is shared by the worktree's persistent sessions and survives
+// `iso stop`, so service data (e.g. a database) persists.
+func (cm *containerManager) getServiceVolumeName(serviceName, volumeName string) string {
+	return fmt.Sprintf("%s-svc-%s-%s", cm.worktreeProjectName, serviceName, volumeName)
+}
+
+// serviceBinds returns the bind mount strings for a service's volumes. For
+// fresh per-run services, named volumes are returned as anonymous volume
+// paths instead, which Docker removes along with the container.
+func (cm *containerManager) serviceBinds(serviceName string, config ServiceConfig, fresh bool) (binds []string, anonymous map[string]struct{}) {
+	for _, volume := range config.volumes {
+		source := expandHomeDir(volume.source)
+		if volume.named {
+			if fresh {
+				if anonymous == nil {
+					anonymous = make(map[string]struct{})
+				}
+				anonymous[volume.target] = struct{}{}
+				continue
+			}
+			source = cm.getServiceVolumeName(serviceName, volume.source)
+		}
+
+		bind := source + ":" + volume.target
+		if volume.mode != "" {
+			bind += ":" + volume.mode
+		}
+		binds = append(binds, bind)
+	}
+	return binds, anonymous
+}
+
+// getSharedVolumeName generates the Docker volume name for a shared volume
+// Shared volumes are global: the name carries no project or session, so every
+// project that declares the same name mounts the same volume. They are never
+// removed by stop or prune.
+func getSharedVolumeName(name string) string {
+	return fmt.Sprintf("iso-shared-%s", name)
 }
 
 // getCacheBindMounts returns bind mount strings for cache paths.
@@ -185,8 +496,7 @@ func (cm *containerManager) getCacheBindMounts() ([]string, error) {
 
 	for _, cachePath := range cm.config.Cache {
 		if cacheDir != "" {
-			sanitized := strings.ReplaceAll(strings.Trim(cachePath, "/"), "/", "-")
-			hostPath := filepath.Join(cacheDir, sanitized)
+			hostPath := cacheHostDir(cacheDir, cachePath)
 			if err := os.MkdirAll(hostPath, 0777); err != nil {
 				return nil, fmt.Errorf("failed to create cache dir %s: %w", hostPath, err)
 			}
@@ -200,10 +510,29 @@ func (cm *containerManager) getCacheBindMounts() ([]string, error) {
 	return binds, nil
 }
 
+// cacheHostDir returns the directory under ISO_CACHE_DIR (cacheDir) backing
+// the cache path cachePath
+func cacheHostDir(cacheDir, cachePath string) string {
+	return filepath.Join(cacheDir, strings.ReplaceAll(strings.Trim(cachePath, "/"), "/", "-"))
+}
+
+// sessionVolumePaths returns the container paths backed by session volumes:
+// the configured volumes plus writable overlays on a read-only workspace
+func (cm *containerManager) sessionVolumePaths() []string {
+	paths := append([]string{}, cm.config.Volumes...)
+	if cm.config.ReadOnlyWorkspace {
+		paths = append(paths, cm.config.WritablePaths...)
+	}
+	if cm.config.PersistHistory {
+		paths = append(paths, historyDir)
+	}
+	return paths
+}
+
 // ensureVolumes creates Docker volumes for configured volume and cache paths
 func (cm *containerManager) ensureVolumes() error {
 	// Create session-specific volumes
-	for _, volumePath := range cm.config.Volumes {
+	for _, volumePath := range cm.sessionVolumePaths() {
 		volumeName := cm.getVolumeNameForPath(volumePath)
 
 		// Check if volume exists
@@ -214,9 +543,49 @@ func (cm *containerManager) ensureVolumes() error {
 
 		if !exists {
 			slog.Debug("creating volume", "volume", volumeName, "path", volumePath)
-			if err := cm.docker.createVolume(volumeName); err != nil {
+			if err := cm.docker.createVolume(volumeName, cm.volumeLabels(cm.worktreeProjectName, volumeKindSession, cm.session)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Create global shared volumes
+	for _, entry := range cm.config.SharedVolumes {
+		name, containerPath, _ := strings.Cut(entry, ":")
+		volumeName := getSharedVolumeName(name)
+
+		exists, err := cm.docker.volumeExists(volumeName)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			slog.Debug("creating shared volume", "volume", volumeName, "path", containerPath)
+			if err := cm.docker.createVolume(volumeName, map[string]string{"iso.managed": "true", "iso.volume": volumeKindShared}); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Create named volumes for the persistent services
+	for serviceName, config := range cm.activeServices() {
+		for _, volume := range config.volumes {
+			if !volume.named {
+				continue
+			}
+			volumeName := cm.getServiceVolumeName(serviceName, volume.source)
+
+			exists, err := cm.docker.volumeExists(volumeName)
+			if err != nil {
 				return err
 			}
+
+			if !exists {
+				slog.Debug("creating service volume", "volume", volumeName, "service", serviceName, "path", volume.target)
+				if err := cm.docker.createVolume(volumeName, cm.volumeLabels(cm.worktreeProjectName, volumeKindService, "")); err != nil {
+					return err
+				}
+			}
 		}
 	}
 
@@ -233,7 +602,7 @@ func (cm *containerManager) ensureVolumes() error {
 
 			if !exists {
 				slog.Debug("creating cache volume", "volume", volumeName, "path", cachePath)
-				if err := cm.docker.createVolume(volumeName); err != nil {
+				if err := cm.docker.createVolume(volumeName, cm.volumeLabels(cm.baseProjectName, volumeKindCache, "")); err != nil {
 					return err
 				}
 			}
@@ -243,16 +612,104 @@ func (cm *containerManager) ensureVolumes() error {
 	return nil
 }
 
+// gitDirBinds returns a bind mount for the repository's git directory when the
+// project is a linked worktree and mount_git_dir isn't disabled. The directory
+// is mounted at its host path, which is where the worktree's .git file points.
+func (cm *containerManager) gitDirBinds() []string {
+	if cm.config.MountGitDir != nil && !*cm.config.MountGitDir {
+		return nil
+	}
+
+	_, _, gitDir := detectGitWorktree(cm.projectRoot)
+	if gitDir == "" {
+		return nil
+	}
+
+	slog.Debug("mounting worktree git directory", "path", gitDir)
+	return []string{fmt.Sprintf("%s:%s", gitDir, gitDir)}
+}
+
+// buildHashLabel is the image label holding the buildHash of the inputs the
+// image was built from
+const buildHashLabel = "iso.build.hash"
+
+// buildOptions returns the settings for building .iso/Dockerfile. The image
+// is labeled with the build hash.
+func (cm *containerManager) buildOptions() (imageBuildOptions, error) {
+	opts := imageBuildOptions{
+		target:  cm.config.BuildTarget,
+		noCache: cm.buildNoCache,
+		pull:    cm.buildPull,
+	}
+	if len(cm.config.BuildArgs) > 0 {
+		opts.buildArgs = maps.Clone(cm.config.BuildArgs)
+	}
+
+	dockerfile, err := os.ReadFile(cm.dockerfilePath)
+	if err != nil {
+		return imageBuildOptions{}, fmt.Errorf("failed to read Dockerfile: %w", err)
+	}
+	opts.labels = map[string]string{
+		buildHashLabel:     buildHash(dockerfile, opts),
+		"iso.project.name": cm.worktreeProjectName,
+		"iso.project.dir":  cm.projectRoot,
+	}
+	return opts, nil
+}
+
+// buildHash identifies the inputs of an image build: the Dockerfile, the
+// target and the (expanded) build args. Files the Dockerfile copies in are
+// not included.
+func buildHash(dockerfile []byte, opts imageBuildOptions) string {
+	h := sha256.New()
+	h.Write(dockerfile)
+	fmt.Fprintf(h, "\x00target=%s", opts.target)
+	for _, key := range slices.Sorted(maps.Keys(opts.buildArgs)) {
+		fmt.Fprintf(h, "\x00%s=%s", key, opts.buildArgs[key])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 // ensureImage ensures the Docker image exists, building it if necessary
 func (cm *containerManager) ensureImage() error {
+	if cm.config.Image != "" {
+		return cm.ensurePrebuiltImage(false)
+	}
+
 	exists, err := cm.docker.imageExists(cm.imageName)
 	if err != nil {
 		return err
 	}
 
+	buildOpts, err := cm.buildOptions()
+	if err != nil {
+		return err
+	}
+
+	if exists && !cm.noAutoRebuild {
+		labels, err := cm.docker.imageLabels(cm.imageName)
+		if err != nil {
+			return err
+		}
+		if labels[buildHashLabel] != buildOpts.labels[buildHashLabel] {
+			slog.Info("Dockerfile or build args changed, rebuilding image", "image", cm.imageName)
+			exists = false
+		}
+	}
+
+	// --no-cache and --pull builds are explicitly asked for, so they always build
+	forceBuild := buildOpts.noCache || buildOpts.pull
+	if forceBuild {
+		exists = false
+	}
+
 	if !exists {
+		if cm.config.SharedImage != "" && !forceBuild && cm.pullSharedImage(buildOpts) {
+			return nil
+		}
+
 		slog.Debug("building image", "image", cm.imageName, "dockerfile", cm.dockerfilePath)
-		if err := cm.docker.buildImage(cm.dockerfilePath, cm.imageName); err != nil {
+		if err := cm.docker.buildImage(cm.dockerfilePath, cm.imageName, buildOpts); err != nil {
 			return err
 		}
 		slog.Debug("image built successfully", "image", cm.imageName)
@@ -261,6 +718,73 @@ func (cm *containerManager) ensureImage() error {
 	return nil
 }
 
+// pullSharedImage pulls config.shared_image and tags it as the project image,
+// reporting whether it can be used. A shared image built from a different
+// Dockerfile or build args is ignored unless auto rebuild is disabled, and any
+// failure falls back to building locally.
+func (cm *containerManager) pullSharedImage(buildOpts imageBuildOptions) bool {
+	ref := cm.config.SharedImage
+
+	slog.Info("pulling shared image", "image", ref)
+	if err := cm.docker.pullImage(ref, ""); err != nil {
+		slog.Warn("failed to pull shared image, building instead", "image", ref, "error", err)
+		return false
+	}
+
+	if !cm.noAutoRebuild {
+		labels, err := cm.docker.imageLabels(ref)
+		if err != nil {
+			slog.Warn("failed to inspect shared image, building instead", "image", ref, "error", err)
+			return false
+		}
+		if labels[buildHashLabel] != buildOpts.labels[buildHashLabel] {
+			slog.Info("shared image was built from a different Dockerfile or build args, building instead", "image", ref)
+			return false
+		}
+	}
+
+	if err := cm.docker.client.ImageTag(cm.docker.ctx, ref, cm.imageName); err != nil {
+		slog.Warn("failed to tag shared image, building instead", "image", ref, "error", err)
+		return false
+	}
+	return true
+}
+
+// pushImage tags the project image as ref (default: config.shared_image) and
+// pushes it to the registry, building it first if needed. It returns the
+// pushed reference; references without a tag get ":latest".
+func (cm *containerManager) pushImage(ref string) (string, error) {
+	if cm.config.Image != "" {
+		return "", fmt.Errorf("this project uses the prebuilt image %s, there is no local build to push", cm.config.Image)
+	}
+	if ref == "" {
+		ref = cm.config.SharedImage
+	}
+	if ref == "" {
+		return "", fmt.Errorf("no image reference to push to - use --tag or set shared_image in config.yml")
+	}
+	if strings.Contains(ref, "@") {
+		return "", fmt.Errorf("can't push to digest reference %s, use a tag", ref)
+	}
+	if !strings.Contains(path.Base(ref), ":") {
+		ref += ":latest"
+	}
+
+	if err := cm.ensureImage(); err != nil {
+		return "", err
+	}
+
+	if err := cm.docker.client.ImageTag(cm.docker.ctx, cm.imageName, ref); err != nil {
+		return "", fmt.Errorf("failed to tag image: %w", err)
+	}
+
+	slog.Info("pushing image", "image", ref)
+	if err := cm.docker.pushImage(ref); err != nil {
+		return "", err
+	}
+	return ref, nil
+}
+
 // startContainer starts a new container
 func (cm *containerManager) startContainer() (string, error) {
 	// Determine the mount path
@@ -278,21 +802,12 @@ func (cm *containerManager) startContainer() (string, error) {
 		}
 	}
 
-	// Build ISO_SERVICES environment variable from services with ports
-	var isoServices []string
-	for serviceName, serviceConfig := range cm.services {
-		if serviceConfig.Port > 0 {
-			isoServices = append(isoServices, fmt.Sprintf("%s:%d", serviceName, serviceConfig.Port))
-		}
-	}
-
-	// Create container environment
+	// Create container environment. ISO_SERVICES isn't part of it: the
+	// container outlives the run creating it, and later runs may select other
+	// services, so buildExecConfig sets it per exec.
 	env := []string{
 		fmt.Sprintf("ISO_WORKDIR=%s", cm.config.WorkDir),
 	}
-	if len(isoServices) > 0 {
-		env = append(env, fmt.Sprintf("ISO_SERVICES=%s", strings.Join(isoServices, ",")))
-	}
 
 	// Ensure volumes exist
 	if err := cm.ensureVolumes(); err != nil {
@@ -300,13 +815,27 @@ func (cm *containerManager) startContainer() (string, error) {
 	}
 
 	// Build bind mounts list
+	workspaceBind := fmt.Sprintf("%s:%s", mountPath, cm.config.WorkDir)
+	if cm.config.ReadOnlyWorkspace {
+		workspaceBind += ":ro"
+
+		// The overlay mountpoints must already exist in the host directory,
+		// since Docker can't create them inside a read-only bind mount
+		for _, writablePath := range cm.config.WritablePaths {
+			rel := strings.TrimPrefix(writablePath, cm.config.WorkDir)
+			if err := os.MkdirAll(filepath.Join(mountPath, filepath.FromSlash(rel)), 0755); err != nil {
+				return "", fmt.Errorf("failed to create writable path %s: %w", writablePath, err)
+			}
+		}
+	}
 	binds := []string{
-		fmt.Sprintf("%s:%s", mountPath, cm.config.WorkDir),
+		workspaceBind,
 		fmt.Sprintf("%s:/iso:ro", cm.tempIsoPath),
 	}
+	binds = append(binds, cm.gitDirBinds()...)
 
-	// Add session-specific volume mounts
-	for _, volumePath := range cm.config.Volumes {
+	// Add session-specific volume mounts (including writable workspace overlays)
+	for _, volumePath := range cm.sessionVolumePaths() {
 		volumeName := cm.getVolumeNameForPath(volumePath)
 		binds = append(binds, fmt.Sprintf("%s:%s", volumeName, volumePath))
 	}
@@ -318,26 +847,19 @@ func (cm *containerManager) startContainer() (string, error) {
 	}
 	binds = append(binds, cacheBinds...)
 
-	// Add host directory bind mounts (with ~ expansion)
-	for _, bind := range cm.config.Binds {
-		// Split bind into parts: host:container or host:container:options
-		parts := strings.SplitN(bind, ":", 3)
-		if len(parts) >= 2 {
-			hostPath := parts[0]
-			// Expand ~ to home directory
-			if strings.HasPrefix(hostPath, "~/") {
-				if usr, err := user.Current(); err == nil {
-					hostPath = filepath.Join(usr.HomeDir, hostPath[2:])
-				}
-			} else if hostPath == "~" {
-				if usr, err := user.Current(); err == nil {
-					hostPath = usr.HomeDir
-				}
-			}
-			parts[0] = hostPath
-			bind = strings.Join(parts, ":")
-		}
-		binds = append(binds, bind)
+	// Add global shared volumes
+	for _, entry := range cm.config.SharedVolumes {
+		name, containerPath, _ := strings.Cut(entry, ":")
+		binds = append(binds, fmt.Sprintf("%s:%s", getSharedVolumeName(name), containerPath))
+	}
+
+	// Add host directory bind mounts (normalized when the config was loaded)
+	binds = append(binds, cm.config.Binds...)
+
+	if socket := cm.sshAgentSocket(); socket != "" {
+		binds = append(binds, fmt.Sprintf("%s:%s", socket, containerSSHAgentSocket))
+	} else if cm.config.ForwardSSHAgent {
+		slog.Warn("forward_ssh_agent is set but no SSH agent is running (SSH_AUTH_SOCK is unset or stale), not forwarding it")
 	}
 
 	// Check if this is an ephemeral session
@@ -347,16 +869,25 @@ func (cm *containerManager) startContainer() (string, error) {
 	containerConfig := &container.Config{
 		Image:      cm.imageName,
 		WorkingDir: cm.config.WorkDir,
-		Cmd:        []string{"/iso", "_internal-init"},
+		Cmd:        []string{"/iso", "_internal-init", initReadyFile},
 		Env:        env,
-		Labels: map[string]string{
+		User:       cm.config.User,
+		Labels: cm.withUserLabels(map[string]string{
 			"iso.managed":      "true",
 			"iso.project.name": cm.projectName,
 			"iso.project.dir":  cm.projectRoot,
 			"iso.session":      cm.session,
 			"iso.name":         "shell",
 			"iso.ephemeral":    fmt.Sprintf("%t", isEphemeral),
-		},
+		}),
+	}
+
+	// Persistent sessions with idle_timeout stop themselves when idle, and
+	// are found by ReapIdleSessions through the label
+	if cm.config.idleTimeout > 0 && !isEphemeral {
+		seconds := strconv.Itoa(int(cm.config.idleTimeout / time.Second))
+		containerConfig.Labels[idleTimeoutLabel] = seconds
+		containerConfig.Env = append(containerConfig.Env, "ISO_IDLE_TIMEOUT="+seconds)
 	}
 
 	exposedPorts, portBindings, err := parsePortMappings(cm.config.Ports)
@@ -367,25 +898,36 @@ func (cm *containerManager) startContainer() (string, error) {
 		containerConfig.ExposedPorts = exposedPorts
 	}
 
+	// init runs as config.User when set and must be able to write its marker
+	initDirMode := "mode=0755"
+	if cm.config.User != "" {
+		initDirMode = "mode=1777"
+	}
+
 	// Use AutoRemove for ephemeral containers - they should be cleaned up automatically
 	// Persistent containers need AutoRemove=false so they survive between runs
 	hostConfig := &container.HostConfig{
 		Binds:      binds,
 		AutoRemove: isEphemeral,
-		Privileged: cm.config.Privileged,
-		ExtraHosts: cm.config.ExtraHosts,
+		Privileged: cm.privileged(),
+		ExtraHosts: cm.extraHosts(cm.config.ExtraHosts),
+		ShmSize:    cm.config.shmSizeBytes,
+		// Secrets mounted for a run live in memory only, and the init ready
+		// marker must not survive a container restart
+		Tmpfs: map[string]string{
+			secretsDir:              "mode=0755",
+			path.Dir(initReadyFile): initDirMode,
+		},
 	}
+	maps.Copy(hostConfig.Tmpfs, cm.config.tmpfsMounts)
 	if len(portBindings) > 0 {
 		hostConfig.PortBindings = portBindings
 	}
 
-	// Set up network configuration if we have services
-	var networkConfig *network.NetworkingConfig
-	if len(cm.services) > 0 {
-		networkConfig = &network.NetworkingConfig{
-			EndpointsConfig: map[string]*network.EndpointSettings{
-				cm.networkName: {},
-			},
+	networkConfig := cm.sessionNetworkConfig()
+	if networkConfig != nil {
+		if err := cm.ensureNetwork(); err != nil {
+			return "", err
 		}
 	}
 
@@ -406,120 +948,247 @@ func (cm *containerManager) startContainer() (string, error) {
 		return "", fmt.Errorf("failed to start container: %w", err)
 	}
 
+	if err := cm.waitForInit(resp.ID); err != nil {
+		return "", err
+	}
+
+	if cm.config.PersistHistory {
+		if err := cm.chownHistoryDir(resp.ID); err != nil {
+			return "", err
+		}
+	}
+
 	return resp.ID, nil
 }
 
-// startFreshServices starts fresh service containers for a single run
-// Returns a map of service container IDs that should be stopped after the run
-func (cm *containerManager) startFreshServices(runID string) (map[string]string, error) {
-	if len(cm.services) == 0 {
-		return nil, nil
+// chownHistoryDir gives the history volume to the user execs run as, since
+// Docker creates it owned by root and a non-root shell couldn't write its
+// history there
+func (cm *containerManager) chownHistoryDir(containerID string) error {
+	inspect, err := cm.docker.client.ContainerInspect(cm.docker.ctx, containerID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
 	}
-
-	// Ensure network exists
-	if err := cm.ensureNetwork(); err != nil {
-		return nil, err
+	// Docker records the image's USER for containers created without one
+	execUser := ""
+	if inspect.Config != nil {
+		execUser = inspect.Config.User
+	}
+	if name, _, _ := strings.Cut(execUser, ":"); name == "" || name == "root" || name == "0" {
+		return nil
 	}
 
-	serviceContainerIDs := make(map[string]string)
-
-	// Start each service with unique name
-	for serviceName, config := range cm.services {
-		// Generate unique service container name
-		var containerName string
-		if cm.session == "default" {
-			containerName = fmt.Sprintf("%s_%s-fresh-%s", cm.projectName, serviceName, runID)
-		} else {
-			containerName = fmt.Sprintf("%s-%s_%s-fresh-%s", cm.projectName, cm.session, serviceName, runID)
-		}
+	uid, gid, err := cm.containerOwner(containerID, execUser)
+	if err != nil {
+		return err
+	}
+	if err := cm.execWithInput(containerID, []string{"/iso", "_internal-chown", uid, gid, historyDir}, nil); err != nil {
+		return fmt.Errorf("failed to set the owner of %s: %w", historyDir, err)
+	}
+	return nil
+}
 
-		// Pull the image if it doesn't exist
-		imageExists, err := cm.docker.imageExists(config.Image)
+// initReadyFile is created by the container's init process once it handles
+// signals and reaps children. It lives on a tmpfs, so a restarted container
+// starts without it.
+const initReadyFile = "/run/iso/init.ready"
+
+// initPollInterval is how often waitForInit checks the init process
+const initPollInterval = 50 * time.Millisecond
+
+// initNotReadyExitCode is the exit code of `/iso _internal-init-ready` while
+// the init process is still starting. Any other failure means the container's
+// iso binary predates the check.
+const initNotReadyExitCode = 3
+
+// waitForInit waits until the container's init process is up, so the first
+// exec can't race it and miss signal forwarding or zombie reaping. It fails
+// if the container exits or init isn't ready within config.init_timeout.
+// Containers created by an older ISO, whose init was started without the
+// ready file, aren't waited for.
+func (cm *containerManager) waitForInit(containerID string) error {
+	return waitForInitReady(cm.config.initTimeout, func() (bool, error) {
+		inspect, err := cm.docker.client.ContainerInspect(cm.docker.ctx, containerID)
 		if err != nil {
-			return nil, err
+			return false, fmt.Errorf("failed to inspect container: %w", err)
 		}
-
-		if !imageExists {
-			slog.Debug("pulling image", "image", config.Image)
-			if err := cm.docker.pullImage(config.Image); err != nil {
-				return nil, err
+		if inspect.State == nil || !inspect.State.Running {
+			exitCode := 0
+			if inspect.State != nil {
+				exitCode = inspect.State.ExitCode
 			}
+			return false, fmt.Errorf("container exited with code %d during startup%s", exitCode, cm.serviceLogSuffix(containerID))
 		}
-
-		// Convert environment map to slice
-		var env []string
-		for key, value := range config.Environment {
-			env = append(env, fmt.Sprintf("%s=%s", key, value))
-		}
-
-		// Create container config
-		containerConfig := &container.Config{
-			Image: config.Image,
-			Env:   env,
-			Labels: map[string]string{
-				"iso.managed":      "true",
-				"iso.project.name": cm.projectName,
-				"iso.project.dir":  cm.projectRoot,
-				"iso.session":      cm.session,
-				"iso.service":      "true",
-				"iso.service.name": serviceName,
-				"iso.name":         serviceName,
-				"iso.fresh":        "true",
-			},
+		if inspect.Config != nil && !slices.Contains(inspect.Config.Cmd, initReadyFile) {
+			slog.Debug("container init doesn't write the ready file, assuming ready", "container", containerID)
+			return true, nil
 		}
 
-		// Set command if specified
-		if len(config.Command) > 0 {
-			containerConfig.Cmd = config.Command
+		exitCode, err := cm.docker.execExitCode(containerID, []string{"/iso", "_internal-init-ready", initReadyFile})
+		if err != nil {
+			return false, err
 		}
-
-		hostConfig := &container.HostConfig{
-			AutoRemove: true, // Auto-remove when stopped
-			ExtraHosts: config.ExtraHosts,
+		if exitCode != 0 && exitCode != initNotReadyExitCode {
+			slog.Debug("container iso binary can't report init readiness, assuming ready", "exit_code", exitCode)
 		}
+		return exitCode != initNotReadyExitCode, nil
+	})
+}
 
-		networkConfig := &network.NetworkingConfig{
-			EndpointsConfig: map[string]*network.EndpointSettings{
-				cm.networkName: {
-					Aliases: []string{serviceName}, // Use service name as DNS alias
-				},
-			},
+// waitForInitReady polls check every initPollInterval until it reports the
+// init process ready, check fails, or timeout passes
+func waitForInitReady(timeout time.Duration, check func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ready, err := check()
+		if err != nil {
+			return err
+		}
+		if ready {
+			return nil
 		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("container init process not ready after %s (raise init_timeout in config.yml if the host is slow)", timeout)
+		}
+		time.Sleep(initPollInterval)
+	}
+}
 
-		// Create the service container
-		resp, err := cm.docker.client.ContainerCreate(
-			cm.docker.ctx,
-			containerConfig,
-			hostConfig,
-			networkConfig,
-			nil,
-			containerName,
-		)
+// startFreshServices starts fresh service containers for a single run
+// Returns a map of service container IDs that should be stopped after the run
+func (cm *containerManager) startFreshServices(runID string) (map[string]string, error) {
+	services := cm.activeServices()
+	if len(services) == 0 {
+		return nil, nil
+	}
+
+	// Ensure network exists
+	if err := cm.ensureNetwork(); err != nil {
+		return nil, err
+	}
+
+	// Pull or build any missing service images up front, in parallel
+	if err := cm.ensureServiceImages(); err != nil {
+		return nil, err
+	}
+
+	// Start services in parallel, each after its dependencies. Containers that
+	// started are returned even on failure, so the caller can stop them.
+	var mu sync.Mutex
+	serviceContainerIDs := make(map[string]string)
+	err := startServicesInOrder(services, cm.config.MaxParallel, func(serviceName string, config ServiceConfig) error {
+		containerID, err := cm.startFreshService(serviceName, config, runID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create fresh service container %s: %w", serviceName, err)
+			return err
 		}
 
-		// Start the service container
-		if err := cm.docker.client.ContainerStart(cm.docker.ctx, resp.ID, container.StartOptions{}); err != nil {
-			return nil, fmt.Errorf("failed to start fresh service container %s: %w", serviceName, err)
-		}
+		mu.Lock()
+		serviceContainerIDs[serviceName] = containerID
+		mu.Unlock()
+		return nil
+	})
+	return serviceContainerIDs, err
+}
+
+// startFreshService creates and starts a per-run container for one service,
+// with a name unique to runID, and returns its ID
+func (cm *containerManager) startFreshService(serviceName string, config ServiceConfig, runID string) (string, error) {
+	// Generate unique service container name
+	var containerName string
+	if cm.session == "default" {
+		containerName = fmt.Sprintf("%s_%s-fresh-%s", cm.projectName, serviceName, runID)
+	} else {
+		containerName = fmt.Sprintf("%s-%s_%s-fresh-%s", cm.projectName, cm.session, serviceName, runID)
+	}
+
+	// Convert environment map to slice
+	var env []string
+	for key, value := range config.Environment {
+		env = append(env, fmt.Sprintf("%s=%s", key, value))
+	}
+
+	// Create container config
+	containerConfig := &container.Config{
+		Image: config.Image,
+		Env:   env,
+		Labels: cm.withUserLabels(map[string]string{
+			"iso.managed":      "true",
+			"iso.project.name": cm.projectName,
+			"iso.project.dir":  cm.projectRoot,
+			"iso.session":      cm.session,
+			"iso.service":      "true",
+			"iso.service.name": serviceName,
+			"iso.name":         serviceName,
+			"iso.fresh":        "true",
+		}),
+	}
+
+	// Set command if specified
+	if len(config.Command) > 0 {
+		containerConfig.Cmd = config.Command
+	}
+	if config.Platform != "" {
+		containerConfig.Labels["iso.platform"] = config.Platform
+	}
+
+	// Named volumes become anonymous so the service stays ephemeral
+	binds, anonymousVolumes := cm.serviceBinds(serviceName, config, true)
+	containerConfig.Volumes = anonymousVolumes
+
+	hostConfig := &container.HostConfig{
+		AutoRemove: true, // Auto-remove when stopped, along with anonymous volumes
+		Binds:      binds,
+		ExtraHosts: cm.extraHosts(config.ExtraHosts),
+		ShmSize:    config.shmSizeBytes,
+	}
+
+	networkConfig := &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			cm.networkName: {
+				Aliases: []string{serviceName}, // Use service name as DNS alias
+			},
+		},
+	}
+
+	// Create the service container
+	resp, err := cm.docker.client.ContainerCreate(
+		cm.docker.ctx,
+		containerConfig,
+		hostConfig,
+		networkConfig,
+		config.platform,
+		containerName,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to create fresh service container %s: %w", serviceName, err)
+	}
 
-		serviceContainerIDs[serviceName] = resp.ID
-		slog.Debug("fresh service started", "service", serviceName, "container", containerName)
+	// Start the service container
+	if err := cm.docker.client.ContainerStart(cm.docker.ctx, resp.ID, container.StartOptions{}); err != nil {
+		// AutoRemove only applies once a container has run, so remove it here
+		if rmErr := cm.docker.client.ContainerRemove(cm.docker.ctx, resp.ID, container.RemoveOptions{Force: true, RemoveVolumes: true}); rmErr != nil {
+			slog.Debug("failed to remove unstarted service container", "service", serviceName, "error", rmErr)
+		}
+		return "", fmt.Errorf("failed to start fresh service container %s: %w", serviceName, err)
 	}
 
-	return serviceContainerIDs, nil
+	slog.Debug("fresh service started", "service", serviceName, "container", containerName)
+	return resp.ID, nil
 }
 
-// stopFreshServices stops and removes fresh service containers
+// stopFreshServices stops and removes fresh service containers, even after
+// the operation context was cancelled
 func (cm *containerManager) stopFreshServices(serviceContainerIDs map[string]string) {
 	if len(serviceContainerIDs) == 0 {
 		return
 	}
 
+	docker, cancel := cm.docker.detached(teardownTimeout)
+	defer cancel()
+
 	timeout := 2
 	for serviceName, containerID := range serviceContainerIDs {
-		if err := cm.docker.client.ContainerStop(cm.docker.ctx, containerID, container.StopOptions{
+		if err := docker.client.ContainerStop(docker.ctx, containerID, container.StopOptions{
 			Timeout: &timeout,
 		}); err != nil {
 			// Ignore "already in progress" errors - containers have AutoRemove so Docker is cleaning them up
@@ -531,9 +1200,258 @@ func (cm *containerManager) stopFreshServices(serviceContainerIDs map[string]str
 	}
 }
 
+// shellCommand returns the command that starts the configured interactive
+// shell, falling back to /bin/sh when the image doesn't have it
+func (cm *containerManager) shellCommand() []string {
+	shell := cm.config.Shell
+	if shell == "" {
+		shell = defaultShell
+	}
+	if shell == "/bin/sh" {
+		return []string{shell}
+	}
+
+	// The shell is passed as $0 so it needs no quoting
+	script := `if command -v "$0" >/dev/null 2>&1; then exec "$0"; fi
+echo "iso: $0 not found in the image, using /bin/sh" >&2
+exec /bin/sh`
+	return []string{"/bin/sh", "-c", script, shell}
+}
+
 // runCommand runs a command in the container and returns the exit code
-// envVars is a slice of environment variables in KEY=VALUE format
-func (cm *containerManager) runCommand(command []string, envVars []string, ephemeral bool) (int, error) {
+func (cm *containerManager) runCommand(command []string, opts RunOptions) (int, error) {
+	results, err := cm.runSteps([][]string{command}, opts)
+	if err != nil {
+		return 0, err
+	}
+	return results[0].ExitCode, nil
+}
+
+// runSteps runs each command as its own exec in the same container, so every
+// step gets its own exit code and pre/post hooks. Services and the container
+// are prepared once for the whole sequence. Execution stops at the first
+// failing step unless opts.KeepGoing is set; later steps are then reported as
+// skipped.
+func (cm *containerManager) runSteps(steps [][]string, opts RunOptions) ([]StepResult, error) {
+	if err := cm.selectServices(opts); err != nil {
+		return nil, err
+	}
+	cm.privilegedOverride = opts.Privileged
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	// Check if stdin is a TTY (or a TTY was explicitly requested)
+	isTTY := opts.TTY || term.IsTerminal(os.Stdin.Fd())
+
+	execConfigs := make([]container.ExecOptions, len(steps))
+	for i, step := range steps {
+		execConfigs[i], err = cm.buildExecConfig(step, opts, cwd, isTTY)
+		if err != nil {
+			return nil, err
+		}
+
+		if opts.PrintCommand || opts.DryRun {
+			fmt.Fprint(os.Stderr, formatExecConfig(cm.containerName, execConfigs[i]))
+		}
+	}
+
+	// Read secrets up front so a bad path fails before anything starts
+	secrets, err := readSecrets(opts.Secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]StepResult, len(steps))
+	for i, step := range steps {
+		results[i] = StepResult{Command: step}
+	}
+	if opts.DryRun {
+		return results, nil
+	}
+
+	if opts.EventsPath != "" {
+		events, err := openEventLog(opts.EventsPath)
+		if err != nil {
+			return nil, err
+		}
+		cm.events = events
+		defer func() {
+			events.close()
+			cm.events = nil
+		}()
+	}
+
+	containerID, cleanup, err := cm.prepareRun(opts.Ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		started := time.Now()
+		cleanup()
+		cm.events.phaseDone(RunEvent{Event: EventTeardown}, started)
+	}()
+
+	if opts.WorkDir != "" {
+		if err := cm.checkWorkDir(containerID, execConfigs[0].WorkingDir); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(secrets) > 0 {
+		removeSecrets, err := cm.mountSecrets(containerID, secrets)
+		if err != nil {
+			return nil, err
+		}
+		defer removeSecrets()
+	}
+
+	var sampler *metricsSampler
+	if opts.CaptureMetrics != "" {
+		sampler = cm.startMetricsSampler(containerID, opts.MetricsInterval)
+		defer sampler.halt()
+	}
+
+	failed := false
+	for i := range steps {
+		if failed && !opts.KeepGoing {
+			results[i].Skipped = true
+			continue
+		}
+
+		cm.events.emit(RunEvent{Event: EventCommandStart, Step: i + 1, Command: steps[i]})
+		started := time.Now()
+		exitCode, err := cm.execAttached(containerID, execConfigs[i], opts.Interactive, opts.ExecTimeout)
+		results[i].Duration = time.Since(started)
+		if err != nil {
+			cm.events.phaseDone(RunEvent{Event: EventCommandExit, Step: i + 1, Command: steps[i], Error: err.Error()}, started)
+			if sampler != nil {
+				if metricsErr := writeStepMetrics(opts.CaptureMetrics, sampler, results[:i+1], err); metricsErr != nil {
+					slog.Warn("failed to write metrics", "error", metricsErr)
+				}
+			}
+			return results[:i], err
+		}
+		cm.events.phaseDone(RunEvent{Event: EventCommandExit, Step: i + 1, Command: steps[i], ExitCode: &exitCode}, started)
+
+		results[i].ExitCode = exitCode
+		if exitCode != 0 {
+			failed = true
+		}
+	}
+
+	if sampler != nil {
+		if err := writeStepMetrics(opts.CaptureMetrics, sampler, results, nil); err != nil {
+			return results, err
+		}
+	}
+
+	return results, nil
+}
+
+// runningCommand reports whether a command is executing
+func (cm *containerManager) runningCommand() bool {
+	cm.execMu.Lock()
+	defer cm.execMu.Unlock()
+	return cm.execContainer != ""
+}
+
+// setRunningExec records the command in progress for signalExec
+func (cm *containerManager) setRunningExec(containerID, pidFile string) {
+	cm.execMu.Lock()
+	defer cm.execMu.Unlock()
+	cm.execContainer = containerID
+	cm.execPidFile = pidFile
+}
+
+// signalExec delivers sig to the command in progress, via the pid file its
+// in-env wrapper writes. Docker has no API to signal an exec. It does nothing
+// if no command is running.
+func (cm *containerManager) signalExec(sig syscall.Signal) error {
+	return cm.signalExecWith(cm.docker, sig)
+}
+
+// killExec kills the command in progress once the operation context is
+// cancelled (--timeout expired), so it doesn't keep running in a persistent
+// session after iso gave up on it
+func (cm *containerManager) killExec() {
+	docker, cancel := cm.docker.detached(teardownTimeout)
+	defer cancel()
+	if err := cm.signalExecWith(docker, syscall.SIGKILL); err != nil {
+		slog.Warn("failed to kill command", "error", err)
+	}
+}
+
+// signalExecWith is signalExec using docker
+func (cm *containerManager) signalExecWith(docker *dockerClient, sig syscall.Signal) error {
+	cm.execMu.Lock()
+	containerID, pidFile := cm.execContainer, cm.execPidFile
+	cm.execMu.Unlock()
+	if containerID == "" {
+		return nil
+	}
+
+	cmd := []string{"/iso", "_internal-signal", pidFile, strconv.Itoa(int(sig))}
+	if err := (&containerManager{docker: docker}).execWithInput(containerID, cmd, nil); err != nil {
+		return fmt.Errorf("failed to forward %s: %w", sig, err)
+	}
+	slog.Debug("forwarded signal to command", "signal", sig)
+	return nil
+}
+
+// forwardSignals passes signals from sigChan to the running command until
+// done is closed. If a signal can't be forwarded (e.g. the container's iso
+// binary predates forwarding), it stops catching signals so the next one
+// interrupts iso as usual.
+func (cm *containerManager) forwardSignals(sigChan chan os.Signal, done chan struct{}) {
+	for {
+		select {
+		case sig := <-sigChan:
+			if err := cm.signalExec(sig.(syscall.Signal)); err != nil {
+				slog.Warn("failed to forward signal to command, press Ctrl+C again to exit", "error", err)
+				signal.Stop(sigChan)
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// envValue returns the value of key in a list of KEY=VALUE entries
+func envValue(env []string, key string) (string, bool) {
+	for _, entry := range env {
+		if value, ok := strings.CutPrefix(entry, key+"="); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// restoreTerminal takes the terminal out of raw mode if execAttached put it
+// there, for callers that exit before execAttached returns
+func (cm *containerManager) restoreTerminal() {
+	cm.execMu.Lock()
+	defer cm.execMu.Unlock()
+	if cm.termState != nil {
+		_ = term.RestoreTerminal(os.Stdin.Fd(), cm.termState)
+	}
+}
+
+// prepareRun gets the session ready for an exec: it starts the services
+// (throwaway per-run ones for ephemeral sessions), starts or creates the
+// session container and waits for service readiness. It returns the container
+// ID and a cleanup function to call once the exec has finished.
+func (cm *containerManager) prepareRun(ephemeral bool) (containerID string, cleanup func(), err error) {
+	stopServices := func() {}
+	defer func() {
+		if err != nil {
+			stopServices()
+		}
+	}()
+
 	// Service containers are handled differently depending on the session type.
 	//
 	// Ephemeral sessions get their own throwaway service containers with unique
@@ -551,117 +1469,837 @@ func (cm *containerManager) runCommand(command []string, envVars []string, ephem
 	// because they carried a unique run id the next run failed to see them and
 	// started a *second* set on the same DNS alias (e.g. two `etcd`), hanging
 	// every client that resolved the now-ambiguous hostname.
+	//
+	// serviceContainers maps each service to the container (ID or name) backing
+	// it for this run, so readiness failures can be diagnosed
+	var serviceContainers map[string]string
+
+	// Persistent sessions are shared, so concurrent runs take turns starting
+	// their services and container instead of racing to create them
+	unlock := func() {}
+	if !ephemeral && cm.isoDir != "" {
+		unlock, err = lockSession(cm.isoDir, cm.session)
+		if err != nil {
+			return "", nil, err
+		}
+		defer unlock()
+	}
+
+	servicesStarted := time.Now()
 	if ephemeral {
 		runID := fmt.Sprintf("%d", time.Now().UnixNano())
 		serviceContainerIDs, err := cm.startFreshServices(runID)
+		// Ensure the throwaway services are stopped after the run completes.
+		stopServices = func() { cm.stopFreshServices(serviceContainerIDs) }
 		if err != nil {
-			return 0, err
+			return "", nil, err
 		}
-		// Ensure the throwaway services are stopped after the run completes.
-		defer cm.stopFreshServices(serviceContainerIDs)
-	} else if err := cm.startAllServices(false); err != nil {
-		return 0, err
+		serviceContainers = serviceContainerIDs
+	} else {
+		if err := cm.startAllServices(false); err != nil {
+			return "", nil, err
+		}
+		serviceContainers = make(map[string]string)
+		for serviceName := range cm.activeServices() {
+			serviceContainers[serviceName] = cm.getServiceContainerName(serviceName)
+		}
+	}
+	cm.events.phaseDone(RunEvent{Event: EventServicesStart}, servicesStarted)
+
+	containerStarted := time.Now()
+
+	if err := cm.reconcilePrivileged(); err != nil {
+		return "", nil, err
+	}
+	if err := cm.reconcileUser(); err != nil {
+		return "", nil, err
+	}
+	if err := cm.reconcileHistory(); err != nil {
+		return "", nil, err
+	}
+	if err := cm.reconcileExtraHosts(); err != nil {
+		return "", nil, err
 	}
 
 	// Check if container is already running
 	running, err := cm.docker.isContainerRunning(cm.containerName)
 	if err != nil {
-		return 0, err
+		return "", nil, err
 	}
 
-	var containerID string
 	if !running {
 		// Check if container exists but is stopped
 		exists, err := cm.docker.containerExists(cm.containerName)
 		if err != nil {
-			return 0, err
+			return "", nil, err
 		}
 
 		if exists {
 			// Get container ID and start it
 			containerID, err = cm.docker.getContainerID(cm.containerName)
 			if err != nil {
-				return 0, err
+				return "", nil, err
 			}
 			if err := cm.docker.client.ContainerStart(cm.docker.ctx, containerID, container.StartOptions{}); err != nil {
-				return 0, fmt.Errorf("failed to start container: %w", err)
+				if !isMissingImageError(err) {
+					return "", nil, fmt.Errorf("failed to start container: %w", err)
+				}
+
+				// The image was removed out-of-band (e.g. `docker rmi -f`), so the
+				// stopped container can never start again. Rebuild and recreate it.
+				slog.Warn("container image is missing, rebuilding and recreating container", "container", cm.containerName, "image", cm.imageName)
+				containerID, err = cm.recreateContainer(containerID)
+				if err != nil {
+					return "", nil, err
+				}
+			} else if err := cm.waitForInit(containerID); err != nil {
+				return "", nil, err
 			}
 		} else {
 			// Ensure image exists
 			if err := cm.ensureImage(); err != nil {
-				return 0, err
+				return "", nil, err
 			}
 
 			// Start a new container
 			containerID, err = cm.startContainer()
 			if err != nil {
-				return 0, err
+				return "", nil, err
 			}
 		}
 	} else {
 		containerID, err = cm.docker.getContainerID(cm.containerName)
 		if err != nil {
-			return 0, err
+			return "", nil, err
 		}
 	}
 
-	// Calculate the working directory in the container
-	workDir := cm.config.WorkDir
+	// Containers created before the project had services, or by an older
+	// iso, may not be on the session network yet
+	if len(serviceContainers) > 0 {
+		if err := cm.connectSessionNetwork(containerID); err != nil {
+			return "", nil, err
+		}
+	}
 
-	// Get current working directory
-	cwd, err := os.Getwd()
-	if err != nil {
-		return 0, fmt.Errorf("failed to get current directory: %w", err)
+	cm.events.phaseDone(RunEvent{Event: EventContainerStart}, containerStarted)
+	unlock()
+
+	if cm.config.ServiceHosts {
+		cm.writeServiceHosts(containerID, serviceContainers)
 	}
 
-	// Determine the mount root (same logic as startContainer)
-	var mountRoot string
-	if cm.isoDir != "" {
-		// If using .iso directory, mount root is the project root (parent of .iso)
-		mountRoot = filepath.Dir(cm.isoDir)
-	} else {
-		// Otherwise mount root is the directory containing the Dockerfile
-		dockerfileDir := filepath.Dir(cm.dockerfilePath)
-		mountRoot, err = filepath.Abs(dockerfileDir)
+	// Wait for services from the host, where the service containers' state and
+	// logs are visible. in-env skips its own wait because ISO_SERVICES_READY is set.
+	if err := cm.waitForServicesReady(containerID, serviceContainers); err != nil {
+		return "", nil, err
+	}
+	if err := cm.waitForExternal(containerID); err != nil {
+		return "", nil, err
+	}
+
+	return containerID, stopServices, nil
+}
+
+// secretsDir is the tmpfs mountpoint in the session container that holds the
+// secrets mounted for a run
+const secretsDir = "/run/secrets"
+
+// secretIDPattern matches valid secret ids, which become file names in secretsDir
+var secretIDPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// readSecrets validates secret ids and reads each secret from its host file
+func readSecrets(secrets map[string]string) (map[string][]byte, error) {
+	contents := make(map[string][]byte, len(secrets))
+	for id, hostPath := range secrets {
+		if !secretIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("invalid secret id %q (use letters, digits, '_', '.' or '-')", id)
+		}
+		data, err := os.ReadFile(hostPath)
 		if err != nil {
-			return 0, fmt.Errorf("failed to get absolute path of Dockerfile directory: %w", err)
+			return nil, fmt.Errorf("failed to read secret %q: %w", id, err)
 		}
+		contents[id] = data
 	}
+	return contents, nil
+}
 
-	// Calculate relative path from mount root to current dir
-	relPath, err := filepath.Rel(mountRoot, cwd)
+// mountSecrets writes each secret to secretsDir/<id> in the container, owned
+// by the run user (see secretOwner) with mode 0400. Docker's copy API can't write into tmpfs
+// mounts, so the content is streamed to the iso binary over an exec's stdin.
+// It returns a function that removes the secrets again.
+func (cm *containerManager) mountSecrets(containerID string, secrets map[string][]byte) (func(), error) {
+	inspect, err := cm.docker.client.ContainerInspect(cm.docker.ctx, containerID)
 	if err != nil {
-		return 0, fmt.Errorf("failed to calculate relative path: %w", err)
+		return nil, fmt.Errorf("failed to inspect container: %w", err)
 	}
-
-	// If we're in a subdirectory, use that in the container
-	if relPath != "." && !filepath.IsAbs(relPath) && relPath != ".." && !filepath.HasPrefix(relPath, "..") {
-		workDir = filepath.Join(cm.config.WorkDir, relPath)
+	if _, ok := inspect.HostConfig.Tmpfs[secretsDir]; !ok {
+		return nil, fmt.Errorf("container %s has no tmpfs at %s for secrets (created by an older iso) - run 'iso stop' to recreate it", cm.containerName, secretsDir)
 	}
 
-	// Check if stdin is a TTY
-	isTTY := term.IsTerminal(os.Stdin.Fd())
+	uid, gid, err := cm.secretOwner(containerID)
+	if err != nil {
+		return nil, err
+	}
 
-	// If TTY mode, set terminal to raw mode and handle resize
-	var oldState *term.State
-	if isTTY {
-		// Save current terminal state
-		oldState, err = term.SaveState(os.Stdin.Fd())
+	var paths []string
+	remove := func() {
+		if len(paths) == 0 {
+			return
+		}
+		// Secrets must not outlive the run, even one cut short by Ctrl+C
+		docker, cancel := cm.docker.detached(teardownTimeout)
+		defer cancel()
+		cmd := append([]string{"/iso", "_internal-secret", "remove"}, paths...)
+		if err := (&containerManager{docker: docker}).execWithInput(containerID, cmd, nil); err != nil {
+			slog.Warn("failed to remove secrets", "error", err)
+		}
+	}
+
+	ids := slices.Sorted(maps.Keys(secrets))
+	for _, id := range ids {
+		secretPath := path.Join(secretsDir, id)
+		cmd := []string{"/iso", "_internal-secret", "write", secretPath, uid, gid}
+		if err := cm.execWithInput(containerID, cmd, secrets[id]); err != nil {
+			remove()
+			return nil, fmt.Errorf("failed to mount secret %q: %w", id, err)
+		}
+		paths = append(paths, secretPath)
+		slog.Debug("mounted secret", "id", id, "path", secretPath)
+	}
+
+	return remove, nil
+}
+
+// secretOwner returns the uid and gid secret files are owned by: those of
+// config.User, with user and group names resolved in the container's passwd
+// and group files, otherwise the host user's
+func (cm *containerManager) secretOwner(containerID string) (string, string, error) {
+	if cm.config.User != "" {
+		return cm.containerOwner(containerID, cm.config.User)
+	}
+
+	currentUser, err := user.Current()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get current user: %w", err)
+	}
+	return currentUser.Uid, currentUser.Gid, nil
+}
+
+// containerOwner resolves a Docker user (user[:group], by name or id) to a
+// uid and gid in the container's passwd and group files
+func (cm *containerManager) containerOwner(containerID, execUser string) (string, string, error) {
+	name, group, _ := strings.Cut(execUser, ":")
+	uid, err := cm.containerUserID(containerID, name, "-u")
+	if err != nil {
+		return "", "", err
+	}
+	var gid string
+	switch {
+	case group == "" && isNumeric(name):
+		// A bare uid may have no passwd entry
+		gid = name
+	case group == "":
+		gid, err = cm.containerUserID(containerID, name, "-g")
+	case isNumeric(group):
+		gid = group
+	default:
+		gid, err = cm.containerGroupID(containerID, group)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return uid, gid, nil
+}
+
+// containerUserID resolves user to its uid (flag "-u") or primary gid ("-g")
+// with the container's `id`. A numeric uid resolves to itself for "-u".
+func (cm *containerManager) containerUserID(containerID, user, flag string) (string, error) {
+	if flag == "-u" && isNumeric(user) {
+		return user, nil
+	}
+	out, err := cm.execOutput(containerID, []string{"id", flag, user})
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve user %q in the container: %w", user, err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// containerGroupID resolves a group name to its gid in the container
+func (cm *containerManager) containerGroupID(containerID, group string) (string, error) {
+	out, err := cm.execOutput(containerID, []string{"getent", "group", group})
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve group %q in the container: %w", group, err)
+	}
+	// name:password:gid:members
+	fields := strings.Split(strings.TrimSpace(out), ":")
+	if len(fields) < 3 || !isNumeric(fields[2]) {
+		return "", fmt.Errorf("failed to resolve group %q in the container: unexpected getent output %q", group, out)
+	}
+	return fields[2], nil
+}
+
+// isNumeric reports whether s is a non-negative decimal number, like a uid
+func isNumeric(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 32)
+	return err == nil
+}
+
+// execWithInput runs cmd in the container with input on its stdin and fails
+// with the command's output if it exits non-zero. It runs as root, since the
+// iso helpers it's used for need to chown files.
+func (cm *containerManager) execWithInput(containerID string, cmd []string, input []byte) error {
+	execResp, err := cm.docker.client.ContainerExecCreate(cm.docker.ctx, containerID, container.ExecOptions{
+		User:         "0",
+		Cmd:          cmd,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create exec: %w", err)
+	}
+
+	attachResp, err := cm.docker.client.ContainerExecAttach(cm.docker.ctx, execResp.ID, container.ExecStartOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to attach to exec: %w", err)
+	}
+	defer attachResp.Close()
+
+	if _, err := attachResp.Conn.Write(input); err != nil {
+		return fmt.Errorf("failed to write exec input: %w", err)
+	}
+	if err := attachResp.CloseWrite(); err != nil {
+		return fmt.Errorf("failed to close exec input: %w", err)
+	}
+
+	var output bytes.Buffer
+	if _, err := stdcopy.StdCopy(&output, &output, attachResp.Reader); err != nil {
+		return fmt.Errorf("failed to read exec output: %w", err)
+	}
+
+	inspectResp, err := cm.docker.client.ContainerExecInspect(cm.docker.ctx, execResp.ID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect exec: %w", err)
+	}
+	if inspectResp.ExitCode != 0 {
+		return fmt.Errorf("%s exited with code %d: %s", strings.Join(cmd[:2], " "), inspectResp.ExitCode, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// execOutput runs cmd in the container as root and returns its stdout
+func (cm *containerManager) execOutput(containerID string, cmd []string) (string, error) {
+	execResp, err := cm.docker.client.ContainerExecCreate(cm.docker.ctx, containerID, container.ExecOptions{
+		User:         "0",
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create exec: %w", err)
+	}
+
+	attachResp, err := cm.docker.client.ContainerExecAttach(cm.docker.ctx, execResp.ID, container.ExecStartOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to attach to exec: %w", err)
+	}
+	defer attachResp.Close()
+
+	var stdout, stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, attachResp.Reader); err != nil {
+		return "", fmt.Errorf("failed to read exec output: %w", err)
+	}
+
+	inspectResp, err := cm.docker.client.ContainerExecInspect(cm.docker.ctx, execResp.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect exec: %w", err)
+	}
+	if inspectResp.ExitCode != 0 {
+		return "", fmt.Errorf("%s exited with code %d: %s", strings.Join(cmd[:2], " "), inspectResp.ExitCode, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// sessionNetworkConfig returns the networking config of a new session
+// container: the session network whenever the project has services, even if
+// this run selected none, since later runs reuse the container and the
+// services they start must be reachable from it. Nil without services.
+func (cm *containerManager) sessionNetworkConfig() *network.NetworkingConfig {
+	if len(cm.services) == 0 {
+		return nil
+	}
+	return &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			cm.networkName: {},
+		},
+	}
+}
+
+// connectSessionNetwork attaches the session container to the session network
+// when it isn't on it, so it can reach the services started for a run
+func (cm *containerManager) connectSessionNetwork(containerID string) error {
+	inspect, err := cm.docker.client.ContainerInspect(cm.docker.ctx, containerID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+	if inspect.NetworkSettings != nil {
+		if _, ok := inspect.NetworkSettings.Networks[cm.networkName]; ok {
+			return nil
+		}
+	}
+
+	slog.Debug("connecting container to the session network", "container", cm.containerName, "network", cm.networkName)
+	if err := cm.docker.client.NetworkConnect(cm.docker.ctx, cm.networkName, containerID, nil); err != nil {
+		// A concurrent run may have connected it first
+		if !strings.Contains(err.Error(), "already exists") {
+			return fmt.Errorf("failed to connect container to network %s: %w", cm.networkName, err)
+		}
+	}
+	return nil
+}
+
+// isoServicesEnv returns the ISO_SERVICES value for the run's active
+// services with a port: comma-separated service:port entries, with the
+// health path appended as service:port/path
+func (cm *containerManager) isoServicesEnv() string {
+	var entries []string
+	for serviceName, config := range cm.activeServices() {
+		if config.Port > 0 {
+			entries = append(entries, fmt.Sprintf("%s:%d%s", serviceName, config.Port, config.HealthPath))
+		}
+	}
+	slices.Sort(entries)
+	return strings.Join(entries, ",")
+}
+
+// writeServiceHosts maps each service name to its container's IP on the
+// session network in the container's /etc/hosts, as a fallback for when
+// Docker's DNS doesn't resolve the names. Names that already resolve are left
+// alone. Failures are logged, not returned, since DNS usually works anyway.
+func (cm *containerManager) writeServiceHosts(containerID string, serviceContainers map[string]string) {
+	var entries []string
+	for serviceName, serviceContainer := range serviceContainers {
+		inspect, err := cm.docker.client.ContainerInspect(cm.docker.ctx, serviceContainer)
+		if err != nil {
+			slog.Warn("failed to inspect service container for /etc/hosts", "service", serviceName, "error", err)
+			continue
+		}
+		if inspect.NetworkSettings == nil {
+			continue
+		}
+		if endpoint, ok := inspect.NetworkSettings.Networks[cm.networkName]; ok && endpoint.IPAddress != "" {
+			entries = append(entries, serviceName+"="+endpoint.IPAddress)
+		}
+	}
+	if len(entries) == 0 {
+		return
+	}
+	slices.Sort(entries)
+
+	cmd := append([]string{"/iso", "_internal-hosts"}, entries...)
+	if err := cm.execWithInput(containerID, cmd, nil); err != nil {
+		slog.Warn("failed to write service /etc/hosts entries", "error", err)
+	}
+}
+
+// serviceWaitLogInterval is how many probes pass between progress messages
+// while waiting for a service
+const serviceWaitLogInterval = 5
+
+// serviceProbeInterval is the time between readiness checks of a service
+const serviceProbeInterval = time.Second
+
+// waitForServicesReady waits until every active service with a readiness
+// check is ready: its port accepting TCP connections (or answering
+// health_path with a 2xx status), its health_command exiting 0 and its output
+// matching ready_log, whichever are configured. Services are waited on
+// concurrently, all within one service_timeout. Port probes run from inside
+// the session container (so they use the service's DNS alias on the session
+// network), while the host watches the service containers: a service that
+// exits early fails immediately, and failures include the service's recent
+// logs.
+func (cm *containerManager) waitForServicesReady(containerID string, serviceContainers map[string]string) error {
+	started := time.Now()
+	ctx, cancel := context.WithDeadline(cm.docker.ctx, started.Add(cm.config.serviceTimeout))
+	defer cancel()
+
+	g, ctx := errgroup.WithContext(ctx)
+	for serviceName, config := range cm.activeServices() {
+		if config.Port <= 0 && len(config.HealthCommand) == 0 && config.readyLog == nil {
+			continue
+		}
+		g.Go(func() error {
+			return cm.waitForService(ctx, containerID, serviceName, config, serviceContainers[serviceName], started)
+		})
+	}
+	return g.Wait()
+}
+
+// waitForService checks a service's readiness every serviceProbeInterval
+// until it's ready, its container stops or ctx is done
+func (cm *containerManager) waitForService(ctx context.Context, containerID, serviceName string, config ServiceConfig, serviceContainer string, started time.Time) error {
+	slog.Debug("waiting for service", "service", serviceName, "port", config.Port, "health_path", config.HealthPath,
+		"health_command", config.HealthCommand, "ready_log", config.ReadyLog)
+
+	for attempt := 1; ; attempt++ {
+		pending, err := cm.serviceReadiness(containerID, serviceName, config, serviceContainer)
+		if err != nil {
+			return err
+		}
+		if pending == "" {
+			slog.Debug("service ready", "service", serviceName)
+			cm.events.phaseDone(RunEvent{Event: EventServiceReady, Service: serviceName}, started)
+			return nil
+		}
+		if attempt%serviceWaitLogInterval == 0 {
+			slog.Info("waiting for service", "service", serviceName, "pending", pending)
+		}
+
+		select {
+		case <-time.After(serviceProbeInterval):
+		case <-ctx.Done():
+			// Only service_timeout running out is this service's failure;
+			// an interrupt or another service failing cancels the wait too
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) && cm.docker.ctx.Err() == nil {
+				return fmt.Errorf("service %s not ready after %s: %s (raise service_timeout for slow services)%s",
+					serviceName, cm.config.serviceTimeout, pending, cm.serviceLogSuffix(serviceContainer))
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// serviceReadiness checks once whether a service is ready, returning the
+// check that is still failing ("" when all pass). A service container that
+// stopped is an error.
+func (cm *containerManager) serviceReadiness(containerID, serviceName string, config ServiceConfig, serviceContainer string) (string, error) {
+	startedAt := ""
+	if serviceContainer != "" {
+		inspect, err := cm.docker.client.ContainerInspect(cm.docker.ctx, serviceContainer)
+		if err != nil {
+			return "", fmt.Errorf("service %s stopped before becoming ready: %w", serviceName, err)
+		}
+		if inspect.State != nil {
+			if !inspect.State.Running {
+				return "", fmt.Errorf("service %s exited with code %d before becoming ready%s",
+					serviceName, inspect.State.ExitCode, cm.serviceLogSuffix(serviceContainer))
+			}
+			startedAt = inspect.State.StartedAt
+		}
+	}
+
+	if config.Port > 0 {
+		address := net.JoinHostPort(serviceName, strconv.Itoa(config.Port))
+		ready, err := cm.probeService(containerID, address, config.HealthPath)
+		if err != nil {
+			return "", err
+		}
+		if !ready {
+			if config.HealthPath != "" {
+				return fmt.Sprintf("http://%s%s doesn't return a 2xx status", address, config.HealthPath), nil
+			}
+			return fmt.Sprintf("port %d doesn't accept connections", config.Port), nil
+		}
+	}
+
+	// The other checks need the service's container
+	if serviceContainer == "" {
+		return "", nil
+	}
+
+	if len(config.HealthCommand) > 0 {
+		exitCode, err := cm.docker.execExitCode(serviceContainer, config.HealthCommand)
+		if err != nil {
+			return "", fmt.Errorf("failed to run health_command of service %s: %w", serviceName, err)
+		}
+		if exitCode != 0 {
+			return fmt.Sprintf("health_command exits with code %d", exitCode), nil
+		}
+	}
+
+	if config.readyLog != nil {
+		logs, err := cm.docker.containerLogsSince(serviceContainer, startedAt)
+		if err != nil {
+			return "", err
+		}
+		if !config.readyLog.MatchString(logs) {
+			return fmt.Sprintf("no output matches ready_log %q", config.ReadyLog), nil
+		}
+	}
+	return "", nil
+}
+
+// waitForExternal waits until every config.wait_for endpoint accepts TCP
+// connections from inside the container, or fails after wait_for_timeout
+// listing the endpoints that never became reachable
+func (cm *containerManager) waitForExternal(containerID string) error {
+	if len(cm.config.waitForAddrs) == 0 {
+		return nil
+	}
+
+	started := time.Now()
+	deadline := started.Add(cm.config.waitForTimeout)
+	pending := slices.Clone(cm.config.WaitFor)
+	addresses := make(map[string]string, len(pending))
+	for i, entry := range cm.config.WaitFor {
+		addresses[entry] = cm.config.waitForAddrs[i]
+	}
+
+	for {
+		var unreachable []string
+		for _, entry := range pending {
+			ready, err := cm.probeTCP(containerID, addresses[entry])
+			if err != nil {
+				return err
+			}
+			if !ready {
+				unreachable = append(unreachable, entry)
+				continue
+			}
+			slog.Debug("external dependency ready", "endpoint", entry)
+			cm.events.phaseDone(RunEvent{Event: EventServiceReady, Service: entry}, started)
+		}
+
+		pending = unreachable
+		if len(pending) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("wait_for endpoints not reachable after %s: %s", cm.config.waitForTimeout, strings.Join(pending, ", "))
+		}
+
+		slog.Debug("waiting for external dependencies", "pending", pending)
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// probeTCP checks once whether address accepts TCP connections, dialing from
+// inside the given container via the iso binary
+func (cm *containerManager) probeTCP(containerID, address string) (bool, error) {
+	return cm.probeService(containerID, address, "")
+}
+
+// probeService checks once whether a service is ready from inside the given
+// container: with a health path, http://address<healthPath> must return a
+// 2xx status; otherwise address must accept TCP connections
+func (cm *containerManager) probeService(containerID, address, healthPath string) (bool, error) {
+	cmd := []string{"/iso", "_internal-probe", address}
+	if healthPath != "" {
+		cmd = append(cmd, healthPath)
+	}
+	exitCode, err := cm.docker.execExitCode(containerID, cmd)
+	if err != nil {
+		return false, fmt.Errorf("failed to run readiness probe: %w", err)
+	}
+	return exitCode == 0, nil
+}
+
+// serviceLogSuffix returns the tail of a service container's logs formatted
+// for appending to an error message, or "" if they can't be retrieved
+func (cm *containerManager) serviceLogSuffix(serviceContainer string) string {
+	if serviceContainer == "" {
+		return ""
+	}
+	logs, err := cm.docker.containerLogTail(serviceContainer, 20)
+	if err != nil || strings.TrimSpace(logs) == "" {
+		return ""
+	}
+	return "\nlast log lines:\n" + strings.TrimRight(logs, "\n")
+}
+
+// privileged reports whether the session container should run in privileged
+// mode: the per-run override if one is set, otherwise config.Privileged
+func (cm *containerManager) privileged() bool {
+	if cm.privilegedOverride != nil {
+		return *cm.privilegedOverride
+	}
+	return cm.config.Privileged
+}
+
+// reconcilePrivileged removes the session container when a privileged
+// override is set and the existing container runs in the other mode.
+// Privilege can't be changed on a live container, so prepareRun then creates
+// a replacement with the requested mode, which later runs keep using.
+func (cm *containerManager) reconcilePrivileged() error {
+	if cm.privilegedOverride == nil {
+		return nil
+	}
+
+	exists, err := cm.docker.containerExists(cm.containerName)
+	if err != nil || !exists {
+		return err
+	}
+
+	inspect, err := cm.docker.client.ContainerInspect(cm.docker.ctx, cm.containerName)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+	if inspect.HostConfig != nil && inspect.HostConfig.Privileged == *cm.privilegedOverride {
+		return nil
+	}
+
+	slog.Warn("recreating container to change privileged mode (processes running in it will be stopped)",
+		"container", cm.containerName, "privileged", *cm.privilegedOverride)
+	timeout := 10
+	if _, err := cm.docker.stopAndRemoveContainer(inspect.ID, cm.containerName, timeout); err != nil {
+		return fmt.Errorf("failed to remove container: %w", err)
+	}
+	return nil
+}
+
+// reconcileUser removes the session container when it was created to run as
+// a different user than config.User, including after user was added to or
+// removed from config.yml, so prepareRun creates a replacement running as the
+// configured one
+func (cm *containerManager) reconcileUser() error {
+	exists, err := cm.docker.containerExists(cm.containerName)
+	if err != nil || !exists {
+		return err
+	}
+
+	inspect, err := cm.docker.client.ContainerInspect(cm.docker.ctx, cm.containerName)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+	current := ""
+	if inspect.Config != nil {
+		current = inspect.Config.User
+	}
+	if current == cm.config.User {
+		return nil
+	}
+	if cm.config.User == "" {
+		// Docker records the image's USER for containers created without one
+		imageUser, err := cm.docker.imageUser(inspect.Image)
+		if err != nil {
+			return err
+		}
+		if current == imageUser {
+			return nil
+		}
+	}
+
+	slog.Warn("recreating container to change its user (processes running in it will be stopped)",
+		"container", cm.containerName, "user", cm.config.User)
+	timeout := 10
+	if _, err := cm.docker.stopAndRemoveContainer(inspect.ID, cm.containerName, timeout); err != nil {
+		return fmt.Errorf("failed to remove container: %w", err)
+	}
+	return nil
+}
+
+// reconcileHistory removes the session container when persist_history is set
+// but the container was created without the history volume, so prepareRun
+// creates a replacement that mounts it
+func (cm *containerManager) reconcileHistory() error {
+	if !cm.config.PersistHistory {
+		return nil
+	}
+
+	exists, err := cm.docker.containerExists(cm.containerName)
+	if err != nil || !exists {
+		return err
+	}
+
+	inspect, err := cm.docker.client.ContainerInspect(cm.docker.ctx, cm.containerName)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+	for _, mount := range inspect.Mounts {
+		if mount.Destination == historyDir {
+			return nil
+		}
+	}
+
+	slog.Warn("recreating container to mount the shell history volume (processes running in it will be stopped)",
+		"container", cm.containerName)
+	timeout := 10
+	if _, err := cm.docker.stopAndRemoveContainer(inspect.ID, cm.containerName, timeout); err != nil {
+		return fmt.Errorf("failed to remove container: %w", err)
+	}
+	return nil
+}
+
+// reconcileExtraHosts removes the session container when its /etc/hosts
+// entries differ from extra_hosts and add_host_gateway, e.g. after
+// add_host_gateway was turned on, so prepareRun creates a replacement with
+// the configured ones
+func (cm *containerManager) reconcileExtraHosts() error {
+	exists, err := cm.docker.containerExists(cm.containerName)
+	if err != nil || !exists {
+		return err
+	}
+
+	inspect, err := cm.docker.client.ContainerInspect(cm.docker.ctx, cm.containerName)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+	var current []string
+	if inspect.HostConfig != nil {
+		current = inspect.HostConfig.ExtraHosts
+	}
+	if slices.Equal(current, cm.extraHosts(cm.config.ExtraHosts)) {
+		return nil
+	}
+
+	slog.Warn("recreating container to change its extra hosts (processes running in it will be stopped)",
+		"container", cm.containerName)
+	timeout := 10
+	if _, err := cm.docker.stopAndRemoveContainer(inspect.ID, cm.containerName, timeout); err != nil {
+		return fmt.Errorf("failed to remove container: %w", err)
+	}
+	return nil
+}
+
+// recreateContainer removes the given (unstartable) container, ensures the
+// image exists and starts a replacement, returning the new container ID
+func (cm *containerManager) recreateContainer(containerID string) (string, error) {
+	if _, err := cm.docker.stopAndRemoveContainer(containerID, cm.containerName, 0); err != nil {
+		return "", fmt.Errorf("failed to remove container: %w", err)
+	}
+
+	if err := cm.ensureImage(); err != nil {
+		return "", err
+	}
+
+	return cm.startContainer()
+}
+
+// buildExecConfig resolves the exec configuration for running command in the
+// session container: the working directory mirroring the host directory cwd,
+// the environment and the in-env wrapped command
+func (cm *containerManager) buildExecConfig(command []string, opts RunOptions, cwd string, isTTY bool) (container.ExecOptions, error) {
+	// Calculate the working directory in the container
+	workDir := cm.config.WorkDir
+
+	// Determine the mount root (same logic as startContainer)
+	var err error
+	var mountRoot string
+	if cm.isoDir != "" {
+		// If using .iso directory, mount root is the project root (parent of .iso)
+		mountRoot = filepath.Dir(cm.isoDir)
+	} else {
+		// Otherwise mount root is the directory containing the Dockerfile
+		dockerfileDir := filepath.Dir(cm.dockerfilePath)
+		mountRoot, err = filepath.Abs(dockerfileDir)
 		if err != nil {
-			return 0, fmt.Errorf("failed to save terminal state: %w", err)
+			return container.ExecOptions{}, fmt.Errorf("failed to get absolute path of Dockerfile directory: %w", err)
 		}
+	}
 
-		// Ensure terminal is restored on exit
-		defer func() {
-			if oldState != nil {
-				_ = term.RestoreTerminal(os.Stdin.Fd(), oldState)
-			}
-		}()
+	// Calculate relative path from mount root to current dir
+	relPath, err := filepath.Rel(mountRoot, cwd)
+	if err != nil {
+		return container.ExecOptions{}, fmt.Errorf("failed to calculate relative path: %w", err)
+	}
 
-		// Put terminal into raw mode
-		if _, err := term.MakeRaw(os.Stdin.Fd()); err != nil {
-			return 0, fmt.Errorf("failed to set terminal to raw mode: %w", err)
-		}
+	// If we're in a subdirectory, use that in the container
+	if relPath != "." && !filepath.IsAbs(relPath) && relPath != ".." && !filepath.HasPrefix(relPath, "..") {
+		workDir = filepath.Join(cm.config.WorkDir, relPath)
+	}
+	if opts.WorkDir != "" {
+		workDir = resolveWorkDir(cm.config.WorkDir, opts.WorkDir)
 	}
 
 	// Wrap the command with /iso in-env run to handle pre/post scripts
@@ -671,7 +2309,7 @@ func (cm *containerManager) runCommand(command []string, envVars []string, ephem
 	// The in-env wrapper will use these to run user commands as the host user
 	currentUser, err := user.Current()
 	if err != nil {
-		return 0, fmt.Errorf("failed to get current user: %w", err)
+		return container.ExecOptions{}, fmt.Errorf("failed to get current user: %w", err)
 	}
 
 	// Build exec environment (include ISO_WORKDIR for the in-env command)
@@ -681,29 +2319,55 @@ func (cm *containerManager) runCommand(command []string, envVars []string, ephem
 		fmt.Sprintf("ISO_SESSION=%s", cm.session),
 		fmt.Sprintf("ISO_UID=%s", currentUser.Uid),
 		fmt.Sprintf("ISO_GID=%s", currentUser.Gid),
+		// runCommand waits for services from the host before exec.
+		// ISO_SERVICES is set even when empty, replacing the stale value in
+		// containers created by older versions.
+		"ISO_SERVICES_READY=1",
+		"ISO_SERVICES=" + cm.isoServicesEnv(),
+		fmt.Sprintf("ISO_SERVICE_TIMEOUT=%s", cm.config.serviceTimeout),
+		// in-env records the command's pid here so signals can be forwarded
+		fmt.Sprintf("ISO_PID_FILE=%s/exec-%s.pid", path.Dir(initReadyFile), rand.Text()),
 	}
+	execEnv = append(execEnv, cm.hookEnv()...)
 
 	// If TTY mode, pass through TERM environment variable
 	if isTTY {
-		if termValue := os.Getenv("TERM"); termValue != "" {
-			// Special case: xterm-ghostty -> xterm-256color
-			if termValue == "xterm-ghostty" {
-				termValue = "xterm-256color"
-			}
-			execEnv = append(execEnv, fmt.Sprintf("TERM=%s", termValue))
-		}
+		execEnv = append(execEnv, termEnv(cm.hostGetenv("TERM"))...)
+	}
+
+	// Set before config.yml's environment, which may point HISTFILE elsewhere
+	if cm.config.PersistHistory {
+		execEnv = append(execEnv, "HISTFILE="+historyFile)
 	}
 
-	// Add environment variables from config.yml
+	if cm.sshAgentSocket() != "" {
+		execEnv = append(execEnv, "SSH_AUTH_SOCK="+containerSSHAgentSocket)
+	}
+
+	// Add environment variables from config.yml. Values referencing $VARs are
+	// passed as ISO_EXPAND_<KEY> for in-env to expand against the container's
+	// environment, unless overridden on the command line.
 	for key, value := range cm.config.Environment {
+		if strings.Contains(value, "$") && !slices.ContainsFunc(opts.EnvVars, func(env string) bool {
+			return strings.HasPrefix(env, key+"=")
+		}) {
+			execEnv = append(execEnv, fmt.Sprintf("ISO_EXPAND_%s=%s", key, value))
+			continue
+		}
 		execEnv = append(execEnv, fmt.Sprintf("%s=%s", key, value))
 	}
 
+	// Pass through host variables (these override config.yml)
+	hostEnv := cm.hostEnv
+	if hostEnv == nil {
+		hostEnv = os.Environ()
+	}
+	execEnv = append(execEnv, passthroughEnv(cm.config.EnvPassthrough, hostEnv)...)
+
 	// Add command-line environment variables (these override config.yml)
-	execEnv = append(execEnv, envVars...)
+	execEnv = append(execEnv, opts.EnvVars...)
 
-	// Execute the command in the container
-	// The container runs as root, but in-env will switch to ISO_UID:ISO_GID for user commands
+	// Execute the command in the container, as config.User if one is set
 	execConfig := container.ExecOptions{
 		Cmd:          wrappedCommand,
 		AttachStdout: true,
@@ -712,24 +2376,238 @@ func (cm *containerManager) runCommand(command []string, envVars []string, ephem
 		Tty:          isTTY,
 		WorkingDir:   workDir,
 		Env:          execEnv,
+		User:         cm.config.User,
+	}
+
+	return execConfig, nil
+}
+
+// resolveWorkDir returns the container directory for a RunOptions.WorkDir
+// override: absolute paths as-is, relative ones joined to configWorkDir
+func resolveWorkDir(configWorkDir, override string) string {
+	if path.IsAbs(override) {
+		return path.Clean(override)
+	}
+	return path.Join(configWorkDir, override)
+}
+
+// checkWorkDir returns an error unless workDir is a directory in the
+// container, so a mistyped --workdir fails clearly instead of as an exec error
+func (cm *containerManager) checkWorkDir(containerID, workDir string) error {
+	stat, err := cm.docker.statContainerPath(containerID, workDir)
+	if err != nil {
+		return err
+	}
+	if stat == nil {
+		return fmt.Errorf("workdir %s doesn't exist in the container", workDir)
+	}
+	if !stat.Mode.IsDir() {
+		return fmt.Errorf("workdir %s is not a directory", workDir)
+	}
+	return nil
+}
+
+// passthroughEnv returns the KEY=VALUE entries of environ whose names match
+// one of patterns (path.Match globs such as "AWS_*")
+func passthroughEnv(patterns, environ []string) []string {
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	var env []string
+	for _, kv := range environ {
+		key, _, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			continue
+		}
+		if slices.ContainsFunc(patterns, func(pattern string) bool {
+			matched, _ := path.Match(pattern, key)
+			return matched
+		}) {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// formatExecConfig renders an exec configuration as a human-readable summary
+// followed by an equivalent `docker exec` command line
+func formatExecConfig(containerName string, execConfig container.ExecOptions) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "container: %s\n", containerName)
+	fmt.Fprintf(&b, "workdir:   %s\n", execConfig.WorkingDir)
+	fmt.Fprintf(&b, "tty:       %t\n", execConfig.Tty)
+	fmt.Fprintf(&b, "env:\n")
+	for _, env := range execConfig.Env {
+		fmt.Fprintf(&b, "  %s\n", env)
+	}
+	fmt.Fprintf(&b, "command:   %s\n", ShellJoin(execConfig.Cmd))
+
+	args := []string{"docker", "exec", "-i"}
+	if execConfig.Tty {
+		args = append(args, "-t")
+	}
+	args = append(args, "-w", execConfig.WorkingDir)
+	for _, env := range execConfig.Env {
+		args = append(args, "-e", env)
+	}
+	args = append(args, containerName)
+	args = append(args, execConfig.Cmd...)
+	fmt.Fprintf(&b, "docker:    %s\n", ShellJoin(args))
+
+	return b.String()
+}
+
+// hookEnv returns the variables telling in-env which pre_run and post_run
+// scripts to run, and whether post_run_required is set, per config.yml
+func (cm *containerManager) hookEnv() []string {
+	var env []string
+	if cm.config.PreRun != "" {
+		env = append(env, fmt.Sprintf("ISO_PRE_RUN=%s", cm.config.PreRun))
+	}
+	if cm.config.PostRun != "" {
+		env = append(env, fmt.Sprintf("ISO_POST_RUN=%s", cm.config.PostRun))
+	}
+	if cm.config.PostRunRequired {
+		env = append(env, "ISO_POST_RUN_REQUIRED=1")
+	}
+	return env
+}
+
+// ShellJoin joins args into a command line, single-quoting any argument that
+// contains characters the shell would interpret
+func ShellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && !strings.ContainsFunc(arg, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:,@%+", r))
+		}) {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// termEnv returns the TERM variable to pass through to a TTY exec for the
+// host's termValue, if set
+func termEnv(termValue string) []string {
+	if termValue == "" {
+		return nil
+	}
+	// Special case: xterm-ghostty -> xterm-256color
+	if termValue == "xterm-ghostty" {
+		termValue = "xterm-256color"
+	}
+	return []string{fmt.Sprintf("TERM=%s", termValue)}
+}
+
+// defaultExecSetupTimeout bounds exec creation and attach when no timeout is given
+const defaultExecSetupTimeout = 30 * time.Second
+
+// execAttached creates an exec instance from execConfig, wires it up to the
+// local stdin/stdout/stderr and waits for it to finish, returning its exit
+// code. When execConfig.Tty is set and stdin is a terminal, the local terminal
+// is put into raw mode for the duration of the exec and resize events are
+// forwarded to the container.
+//
+// By default the exec's stdin is closed once local stdin reaches EOF, so
+// commands reading stdin see end-of-input. keepStdinOpen skips that, leaving
+// the exec's stdin open until the command exits on its own (`docker run -i`).
+//
+// setupTimeout bounds creating and attaching to the exec (0 selects
+// defaultExecSetupTimeout), so a hung Docker daemon fails the run instead of
+// blocking forever. It doesn't limit how long the command itself runs.
+func (cm *containerManager) execAttached(containerID string, execConfig container.ExecOptions, keepStdinOpen bool, setupTimeout time.Duration) (int, error) {
+	isTTY := execConfig.Tty
+
+	// Raw mode and resizing only apply when stdin is a real terminal; a TTY
+	// can still be allocated in the container when stdin is a pipe.
+	localTTY := isTTY && term.IsTerminal(os.Stdin.Fd())
+
+	// If TTY mode, set terminal to raw mode and handle resize
+	var oldState *term.State
+	if localTTY {
+		// Save current terminal state
+		var err error
+		oldState, err = term.SaveState(os.Stdin.Fd())
+		if err != nil {
+			return 0, fmt.Errorf("failed to save terminal state: %w", err)
+		}
+
+		// Ensure terminal is restored on exit
+		defer func() {
+			if oldState != nil {
+				_ = term.RestoreTerminal(os.Stdin.Fd(), oldState)
+			}
+			cm.execMu.Lock()
+			cm.termState = nil
+			cm.execMu.Unlock()
+		}()
+
+		// Put terminal into raw mode
+		cm.execMu.Lock()
+		cm.termState = oldState
+		cm.execMu.Unlock()
+		if _, err := term.MakeRaw(os.Stdin.Fd()); err != nil {
+			return 0, fmt.Errorf("failed to set terminal to raw mode: %w", err)
+		}
 	}
 
-	execResp, err := cm.docker.client.ContainerExecCreate(cm.docker.ctx, containerID, execConfig)
+	// Forward signals to the command while it runs. Ctrl+C reaches it
+	// through the raw terminal instead when there is one.
+	if pidFile, ok := envValue(execConfig.Env, "ISO_PID_FILE"); ok {
+		cm.setRunningExec(containerID, pidFile)
+		defer cm.setRunningExec("", "")
+
+		signals := []os.Signal{syscall.SIGTERM}
+		if !localTTY {
+			signals = append(signals, os.Interrupt)
+		}
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, signals...)
+		defer signal.Stop(sigChan)
+
+		done := make(chan struct{})
+		defer close(done)
+		go cm.forwardSignals(sigChan, done)
+	}
+
+	if setupTimeout <= 0 {
+		setupTimeout = defaultExecSetupTimeout
+	}
+	setupCtx, cancelSetup := context.WithTimeout(cm.docker.ctx, setupTimeout)
+	defer cancelSetup()
+
+	execResp, err := cm.docker.client.ContainerExecCreate(setupCtx, containerID, execConfig)
 	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return 0, fmt.Errorf("timed out after %s creating exec - the Docker daemon is not responding", setupTimeout)
+		}
 		return 0, fmt.Errorf("failed to create exec: %w", err)
 	}
 
-	// Attach to the exec instance
-	attachResp, err := cm.docker.client.ContainerExecAttach(cm.docker.ctx, execResp.ID, container.ExecStartOptions{
+	// Attach to the exec instance. The deadline only covers establishing the
+	// connection; the hijacked stream outlives setupCtx.
+	attachResp, err := cm.docker.attachExec(setupCtx, execResp.ID, container.ExecStartOptions{
 		Tty: isTTY,
 	})
 	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return 0, fmt.Errorf("timed out after %s attaching to exec - the Docker daemon is not responding", setupTimeout)
+		}
 		return 0, fmt.Errorf("failed to attach to exec: %w", err)
 	}
 	defer attachResp.Close()
 
+	// Background watchers stop when the exec ends or the Docker context is
+	// cancelled
+	execDone := make(chan struct{})
+	defer close(execDone)
+
 	// If TTY mode, set terminal size and monitor for resize events
-	if isTTY {
+	if localTTY {
 		// Get current terminal size
 		winsize, err := term.GetWinsize(os.Stdin.Fd())
 		if err == nil {
@@ -742,69 +2620,248 @@ func (cm *containerManager) runCommand(command []string, envVars []string, ephem
 			}
 		}
 
-		// Monitor for terminal resize events using SIGWINCH
-		go func() {
-			sigChan := make(chan os.Signal, 1)
-			signal.Notify(sigChan, syscall.SIGWINCH)
-			defer signal.Stop(sigChan)
+		// Monitor for terminal resize events using SIGWINCH
+		go func() {
+			sigChan := make(chan os.Signal, 1)
+			signal.Notify(sigChan, syscall.SIGWINCH)
+			defer signal.Stop(sigChan)
+
+			for {
+				select {
+				case <-sigChan:
+					// Terminal was resized, update container
+					if ws, err := term.GetWinsize(os.Stdin.Fd()); err == nil {
+						_ = cm.docker.client.ContainerExecResize(cm.docker.ctx, execResp.ID, container.ResizeOptions{
+							Height: uint(ws.Height),
+							Width:  uint(ws.Width),
+						})
+					}
+				case <-execDone:
+					return
+				case <-cm.docker.ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	// Copy stdin in background
+	go func() {
+		_, _ = io.Copy(attachResp.Conn, os.Stdin)
+		if keepStdinOpen {
+			return
+		}
+		// Close write side when stdin closes to propagate EOF
+		if closer, ok := attachResp.Conn.(interface{ CloseWrite() error }); ok {
+			closer.CloseWrite()
+		}
+	}()
+
+	// Copy stdout/stderr in background based on TTY mode
+	outputDone := make(chan error, 1)
+	go func() {
+		var err error
+		if isTTY {
+			// TTY mode: use bidirectional connection
+			_, err = io.Copy(os.Stdout, attachResp.Conn)
+		} else {
+			// Non-TTY mode: demultiplex stdout and stderr
+			_, err = stdcopy.StdCopy(os.Stdout, os.Stderr, attachResp.Reader)
+		}
+		outputDone <- err
+	}()
+
+	// Wait for either output to finish or context to be done
+	select {
+	case err := <-outputDone:
+		if err != nil && err != io.EOF {
+			return 0, fmt.Errorf("failed to read output: %w", err)
+		}
+	case <-cm.docker.ctx.Done():
+		cm.killExec()
+		return 0, cm.docker.ctx.Err()
+	}
+
+	// Check exit code
+	inspectResp, err := cm.docker.client.ContainerExecInspect(cm.docker.ctx, execResp.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect exec: %w", err)
+	}
+
+	return inspectResp.ExitCode, nil
+}
+
+// copyFromContainer copies containerPath out of the session's main container
+// to hostPath with `docker cp` semantics: if hostPath is an existing
+// directory the source is placed inside it under its own name. Relative
+// container paths are resolved against the configured workdir.
+func (cm *containerManager) copyFromContainer(containerPath, hostPath string) error {
+	containerID, err := cm.sessionContainer("")
+	if err != nil {
+		return err
+	}
+
+	if !filepath.IsAbs(containerPath) {
+		containerPath = filepath.Join(cm.config.WorkDir, containerPath)
+	}
+
+	content, stat, err := cm.docker.client.CopyFromContainer(cm.docker.ctx, containerID, containerPath)
+	if err != nil {
+		return fmt.Errorf("failed to copy %s from container: %w", containerPath, err)
+	}
+	defer content.Close()
+
+	srcInfo := archive.CopyInfo{
+		Path:   containerPath,
+		Exists: true,
+		IsDir:  stat.Mode.IsDir(),
+	}
+
+	if err := archive.CopyTo(content, srcInfo, hostPath); err != nil {
+		return fmt.Errorf("failed to write %s: %w", hostPath, err)
+	}
+
+	slog.Debug("copied from container", "src", containerPath, "dst", hostPath)
+	return nil
+}
+
+// copyToContainer copies hostPath (a file or directory, recursively) into the
+// session's main container at containerPath, with the same `docker cp`
+// semantics and workdir-relative paths as copyFromContainer. File modes are
+// preserved.
+func (cm *containerManager) copyToContainer(hostPath, containerPath string) error {
+	containerID, err := cm.sessionContainer("")
+	if err != nil {
+		return err
+	}
+
+	if !filepath.IsAbs(containerPath) {
+		containerPath = filepath.Join(cm.config.WorkDir, containerPath)
+	}
+
+	srcInfo, err := archive.CopyInfoSourcePath(hostPath, true)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", hostPath, err)
+	}
+
+	srcArchive, err := archive.TarResource(srcInfo)
+	if err != nil {
+		return fmt.Errorf("failed to archive %s: %w", hostPath, err)
+	}
+	defer srcArchive.Close()
+
+	dstInfo := archive.CopyInfo{Path: containerPath}
+	dstStat, err := cm.docker.statContainerPath(containerID, containerPath)
+	if err != nil {
+		return err
+	}
+	if dstStat != nil {
+		dstInfo.Exists = true
+		dstInfo.IsDir = dstStat.Mode.IsDir()
+	}
+
+	dstDir, content, err := archive.PrepareArchiveCopy(srcArchive, srcInfo, dstInfo)
+	if err != nil {
+		return fmt.Errorf("failed to prepare copy to %s: %w", containerPath, err)
+	}
+	defer content.Close()
+
+	if err := cm.docker.client.CopyToContainer(cm.docker.ctx, containerID, dstDir, content, container.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("failed to copy %s to container: %w", hostPath, err)
+	}
+
+	slog.Debug("copied to container", "src", hostPath, "dst", containerPath)
+	return nil
+}
+
+// debugShell opens a raw shell in the session container, bypassing the
+// /iso in-env wrapper, pre/post hooks and service readiness. It is a
+// break-glass tool for when the container's init or the extracted /iso binary
+// is broken. If the main container isn't running, a throwaway debug container
+// is started from the same image running `sleep infinity` instead of the iso
+// init process, and removed when the shell exits.
+func (cm *containerManager) debugShell() (int, error) {
+	running, err := cm.docker.isContainerRunning(cm.containerName)
+	if err != nil {
+		return 0, err
+	}
+
+	var containerID string
+	if running {
+		containerID, err = cm.docker.getContainerID(cm.containerName)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		if err := cm.ensureImage(); err != nil {
+			return 0, err
+		}
+
+		debugName := cm.containerName + "-debug"
+		slog.Info("container not running, starting debug container", "container", debugName)
+
+		resp, err := cm.docker.client.ContainerCreate(
+			cm.docker.ctx,
+			&container.Config{
+				Image:      cm.imageName,
+				WorkingDir: cm.config.WorkDir,
+				Entrypoint: []string{"sleep"},
+				Cmd:        []string{"infinity"},
+				Labels: cm.withUserLabels(map[string]string{
+					"iso.managed":      "true",
+					"iso.project.name": cm.projectName,
+					"iso.project.dir":  cm.projectRoot,
+					"iso.session":      cm.session,
+					"iso.name":         "debug",
+				}),
+			},
+			&container.HostConfig{
+				Binds: []string{
+					fmt.Sprintf("%s:%s", cm.projectRoot, cm.config.WorkDir),
+					fmt.Sprintf("%s:/iso:ro", cm.tempIsoPath),
+				},
+				AutoRemove: true,
+				Privileged: cm.config.Privileged,
+			},
+			nil,
+			nil,
+			debugName,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create debug container: %w", err)
+		}
+		containerID = resp.ID
+
+		if err := cm.docker.client.ContainerStart(cm.docker.ctx, containerID, container.StartOptions{}); err != nil {
+			return 0, fmt.Errorf("failed to start debug container: %w", err)
+		}
 
-			for {
-				select {
-				case <-sigChan:
-					// Terminal was resized, update container
-					if ws, err := term.GetWinsize(os.Stdin.Fd()); err == nil {
-						_ = cm.docker.client.ContainerExecResize(cm.docker.ctx, execResp.ID, container.ResizeOptions{
-							Height: uint(ws.Height),
-							Width:  uint(ws.Width),
-						})
-					}
-				case <-cm.docker.ctx.Done():
-					return
-				}
+		defer func() {
+			if _, err := cm.docker.stopAndRemoveContainer(containerID, debugName, 0); err != nil {
+				slog.Warn("failed to remove debug container", "container", debugName, "error", err)
 			}
 		}()
 	}
 
-	// Copy stdin in background
-	go func() {
-		_, _ = io.Copy(attachResp.Conn, os.Stdin)
-		// Close write side when stdin closes to propagate EOF
-		if closer, ok := attachResp.Conn.(interface{ CloseWrite() error }); ok {
-			closer.CloseWrite()
-		}
-	}()
-
-	// Copy stdout/stderr in background based on TTY mode
-	outputDone := make(chan error, 1)
-	go func() {
-		var err error
-		if isTTY {
-			// TTY mode: use bidirectional connection
-			_, err = io.Copy(os.Stdout, attachResp.Conn)
-		} else {
-			// Non-TTY mode: demultiplex stdout and stderr
-			_, err = stdcopy.StdCopy(os.Stdout, os.Stderr, attachResp.Reader)
-		}
-		outputDone <- err
-	}()
+	isTTY := term.IsTerminal(os.Stdin.Fd())
 
-	// Wait for either output to finish or context to be done
-	select {
-	case err := <-outputDone:
-		if err != nil && err != io.EOF {
-			return 0, fmt.Errorf("failed to read output: %w", err)
-		}
-	case <-cm.docker.ctx.Done():
-		return 0, cm.docker.ctx.Err()
+	var execEnv []string
+	if isTTY {
+		execEnv = termEnv(cm.hostGetenv("TERM"))
 	}
 
-	// Check exit code
-	inspectResp, err := cm.docker.client.ContainerExecInspect(cm.docker.ctx, execResp.ID)
-	if err != nil {
-		return 0, fmt.Errorf("failed to inspect exec: %w", err)
+	// Prefer bash but fall back to sh, since minimal images may not have bash
+	execConfig := container.ExecOptions{
+		Cmd:          []string{"sh", "-c", "if command -v bash >/dev/null 2>&1; then exec bash; else exec sh; fi"},
+		AttachStdout: true,
+		AttachStderr: true,
+		AttachStdin:  true,
+		Tty:          isTTY,
+		WorkingDir:   cm.config.WorkDir,
+		Env:          execEnv,
 	}
 
-	return inspectResp.ExitCode, nil
+	return cm.execAttached(containerID, execConfig, false, 0)
 }
 
 // resetContainer stops and removes the container but keeps services and volumes
@@ -835,10 +2892,55 @@ func (cm *containerManager) resetContainer() error {
 	return nil
 }
 
-// stopContainer stops and removes the container
-func (cm *containerManager) stopContainer() error {
+// restartContainer stops and removes the session's main container if it
+// exists and immediately recreates it, so it picks up config changes that only
+// apply to a new container. Services and volumes are left untouched.
+func (cm *containerManager) restartContainer() (string, error) {
+	exists, err := cm.docker.containerExists(cm.containerName)
+	if err != nil {
+		return "", err
+	}
+
+	if exists {
+		containerID, err := cm.docker.getContainerID(cm.containerName)
+		if err != nil {
+			return "", err
+		}
+
+		timeout := 10
+		if _, err := cm.docker.stopAndRemoveContainer(containerID, cm.containerName, timeout); err != nil {
+			return "", fmt.Errorf("failed to remove container: %w", err)
+		}
+	}
+
+	if err := cm.ensureImage(); err != nil {
+		return "", err
+	}
+	if err := cm.ensureNetwork(); err != nil {
+		return "", err
+	}
+
+	return cm.startContainer()
+}
+
+// stopContainer stops and removes the container and its services, giving
+// each timeout seconds to exit
+func (cm *containerManager) stopContainer(timeout int) error {
+	return cm.stopContainerWith(cm.docker, timeout)
+}
+
+// teardownSession is stopContainer for cleaning up an ephemeral session after
+// a run: it carries on after Ctrl+C or --timeout cancel the operation context
+func (cm *containerManager) teardownSession(timeout int) error {
+	docker, cancel := cm.docker.detached(time.Duration(timeout)*time.Second + teardownTimeout)
+	defer cancel()
+	return cm.stopContainerWith(docker, timeout)
+}
+
+// stopContainerWith is stopContainer using docker
+func (cm *containerManager) stopContainerWith(docker *dockerClient, timeout int) error {
 	// Use labels to find all containers for this project (main + services)
-	containers, err := cm.docker.listProjectContainers(cm.projectName, cm.session)
+	containers, err := docker.listProjectContainers(cm.projectName, cm.session)
 	if err != nil {
 		return err
 	}
@@ -849,11 +2951,10 @@ func (cm *containerManager) stopContainer() error {
 	}
 
 	// Stop and remove all containers
-	timeout := 10
 	for _, c := range containers {
 		slog.Debug("stopping container", "name", c.Name, "service", c.IsService)
 
-		removed, err := cm.docker.stopAndRemoveContainer(c.ID, c.Name, timeout)
+		removed, err := docker.stopAndRemoveContainer(c.ID, c.Name, timeout)
 		if err != nil {
 			slog.Warn("failed to remove container, continuing cleanup", "name", c.Name, "error", err)
 			continue
@@ -865,7 +2966,7 @@ func (cm *containerManager) stopContainer() error {
 	}
 
 	// Remove the network
-	if err := cm.docker.removeNetwork(cm.networkName); err != nil {
+	if err := docker.removeNetwork(cm.networkName); err != nil {
 		// Don't fail if network removal fails - it might still be in use or already removed
 		if !strings.Contains(err.Error(), "not found") {
 			slog.Warn("failed to remove network", "network", cm.networkName, "error", err)
@@ -873,10 +2974,10 @@ func (cm *containerManager) stopContainer() error {
 	}
 
 	// Remove session-specific volumes
-	for _, volumePath := range cm.config.Volumes {
-		volumeName := cm.getVolumeNameForPath(volumePath)
+	for _, volumePath := range cm.sessionVolumePaths() {
+		volumeName := cm.volumeNameForPathWith(docker, volumePath)
 
-		exists, err := cm.docker.volumeExists(volumeName)
+		exists, err := docker.volumeExists(volumeName)
 		if err != nil {
 			slog.Warn("failed to check volume existence", "volume", volumeName, "error", err)
 			continue
@@ -884,7 +2985,7 @@ func (cm *containerManager) stopContainer() error {
 
 		if exists {
 			slog.Debug("removing volume", "volume", volumeName, "path", volumePath)
-			if err := cm.docker.removeVolume(volumeName); err != nil {
+			if err := docker.removeVolume(volumeName); err != nil {
 				slog.Warn("failed to remove volume", "volume", volumeName, "error", err)
 			}
 		}
@@ -893,13 +2994,13 @@ func (cm *containerManager) stopContainer() error {
 	// For ephemeral sessions, also try to remove any dangling volumes that were created
 	// This is a best-effort cleanup in case volumes weren't properly removed
 	if strings.HasPrefix(cm.session, "eph-") {
-		danglingVolumes, err := cm.docker.listDanglingVolumes()
+		danglingVolumes, err := docker.listDanglingVolumes()
 		if err == nil {
 			sessionPrefix := fmt.Sprintf("%s-%s-", cm.worktreeProjectName, cm.session)
 			for _, volumeName := range danglingVolumes {
 				if strings.HasPrefix(volumeName, sessionPrefix) {
 					slog.Debug("removing dangling ephemeral volume", "volume", volumeName)
-					if err := cm.docker.removeVolume(volumeName); err != nil {
+					if err := docker.removeVolume(volumeName); err != nil {
 						slog.Debug("failed to remove dangling volume", "volume", volumeName, "error", err)
 					}
 				}
@@ -910,8 +3011,62 @@ func (cm *containerManager) stopContainer() error {
 	return nil
 }
 
+// ensurePrebuiltImage makes sure the configured prebuilt image is present,
+// pulling it if missing (or always, when forcePull is set). Digest-pinned
+// references are verified against the local image's repo digests.
+func (cm *containerManager) ensurePrebuiltImage(forcePull bool) error {
+	exists, err := cm.docker.imageExists(cm.imageName)
+	if err != nil {
+		return err
+	}
+
+	if !exists || forcePull {
+		slog.Info("pulling image", "image", cm.imageName)
+		if err := cm.docker.pullImage(cm.imageName, ""); err != nil {
+			return err
+		}
+	}
+
+	repo, digest, pinned := strings.Cut(cm.imageName, "@")
+	if !pinned {
+		return nil
+	}
+
+	repoDigests, err := cm.docker.imageRepoDigests(cm.imageName)
+	if err != nil {
+		return err
+	}
+	if !digestMatches(repoDigests, repo, digest) {
+		return fmt.Errorf("local image for %s does not match pinned digest %s (found %s)", repo, digest, strings.Join(repoDigests, ", "))
+	}
+
+	return nil
+}
+
+// digestMatches reports whether any repo digest records the given digest. The
+// repository is compared by its last path element, ignoring any tag, so that
+// "postgres:16@..." and "docker.io/library/postgres@..." match.
+func digestMatches(repoDigests []string, repo, digest string) bool {
+	repoName, _, _ := strings.Cut(path.Base(repo), ":")
+	for _, rd := range repoDigests {
+		rdRepo, rdDigest, ok := strings.Cut(rd, "@")
+		if !ok || rdDigest != digest {
+			continue
+		}
+		if path.Base(rdRepo) == repoName {
+			return true
+		}
+	}
+	return false
+}
+
 // rebuildImage rebuilds the Docker image
 func (cm *containerManager) rebuildImage() error {
+	// A prebuilt image can't be rebuilt; refresh it from the registry instead
+	if cm.config.Image != "" {
+		return cm.ensurePrebuiltImage(true)
+	}
+
 	// Check if image exists and remove it
 	exists, err := cm.docker.imageExists(cm.imageName)
 	if err != nil {
@@ -925,9 +3080,14 @@ func (cm *containerManager) rebuildImage() error {
 		}
 	}
 
+	buildOpts, err := cm.buildOptions()
+	if err != nil {
+		return err
+	}
+
 	// Build the image
 	slog.Info("building image", "image", cm.imageName, "dockerfile", cm.dockerfilePath)
-	if err := cm.docker.buildImage(cm.dockerfilePath, cm.imageName); err != nil {
+	if err := cm.docker.buildImage(cm.dockerfilePath, cm.imageName, buildOpts); err != nil {
 		return err
 	}
 
@@ -935,7 +3095,72 @@ func (cm *containerManager) rebuildImage() error {
 	return nil
 }
 
-// getStatus returns the status of the container
+// platformImageTag returns the local tag holding imageName's build for
+// platform, e.g. proj-shell:linux-arm64
+func platformImageTag(imageName, platform string) string {
+	return imageName + ":" + strings.ReplaceAll(platform, "/", "-")
+}
+
+// buildPlatforms builds the image for each of platforms with BuildKit. With
+// pushRef set, all platforms go into one multi-arch image pushed to pushRef.
+// Otherwise each build is loaded locally under platformImageTag, and the one
+// matching the Docker host is also tagged as the image runs use.
+func (cm *containerManager) buildPlatforms(platforms []string, pushRef string) error {
+	if cm.config.Image != "" {
+		return fmt.Errorf("--target-platform builds .iso/Dockerfile, but this project uses the prebuilt image %s", cm.config.Image)
+	}
+	for _, platform := range platforms {
+		if _, err := parsePlatform(platform); err != nil {
+			return err
+		}
+	}
+
+	buildOpts, err := cm.buildOptions()
+	if err != nil {
+		return err
+	}
+
+	if pushRef != "" {
+		slog.Info("building multi-platform image", "image", pushRef, "platforms", platforms)
+		if err := buildxBuild(cm.dockerfilePath, pushRef, platforms, buildOpts, true); err != nil {
+			return err
+		}
+		slog.Info("image pushed", "image", pushRef)
+		return nil
+	}
+
+	arch, err := cm.docker.getArchitecture()
+	if err != nil {
+		return err
+	}
+	native := "linux/" + arch
+
+	// Without a registry each platform is built and loaded separately, since
+	// the local image store can only hold one platform per tag
+	builtNative := false
+	for _, platform := range platforms {
+		tag := platformImageTag(cm.imageName, platform)
+		slog.Info("building image", "image", tag, "platform", platform)
+		if err := buildxBuild(cm.dockerfilePath, tag, []string{platform}, buildOpts, false); err != nil {
+			return err
+		}
+
+		if platform == native {
+			if err := cm.docker.client.ImageTag(cm.docker.ctx, tag, cm.imageName); err != nil {
+				return fmt.Errorf("failed to tag image: %w", err)
+			}
+			builtNative = true
+		}
+	}
+
+	if !builtNative {
+		slog.Warn("no image was built for this machine's platform; runs here will build it on demand", "platform", native)
+	}
+	return nil
+}
+
+// getStatus returns the state of the container: "does not exist", "running"
+// or "stopped"
 func (cm *containerManager) getStatus() (string, error) {
 	exists, err := cm.docker.containerExists(cm.containerName)
 	if err != nil {
@@ -943,7 +3168,7 @@ func (cm *containerManager) getStatus() (string, error) {
 	}
 
 	if !exists {
-		return "Container does not exist", nil
+		return "does not exist", nil
 	}
 
 	running, err := cm.docker.isContainerRunning(cm.containerName)
@@ -952,26 +3177,221 @@ func (cm *containerManager) getStatus() (string, error) {
 	}
 
 	if running {
-		return "Container is running", nil
+		return "running", nil
+	}
+
+	return "stopped", nil
+}
+
+// containerDetails fills in the session container's creation time and, if
+// it is running, its uptime and current resource usage
+func (cm *containerManager) containerDetails(status *Status) error {
+	inspect, err := cm.docker.client.ContainerInspect(cm.docker.ctx, cm.containerName)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	if created, err := time.Parse(time.RFC3339Nano, inspect.Created); err == nil {
+		status.Created = created
+	}
+	if inspect.State == nil || !inspect.State.Running {
+		return nil
+	}
+	if started, err := time.Parse(time.RFC3339Nano, inspect.State.StartedAt); err == nil {
+		status.UptimeSeconds = int64(time.Since(started).Seconds())
+	}
+
+	status.MemoryBytes, status.CPUPercent, err = cm.containerUsage(inspect.ID)
+	if err != nil {
+		slog.Debug("failed to get container usage", "error", err)
+	}
+	return nil
+}
+
+// checkHealth reports whether the session container is running and whether
+// each active service's container is running and accepting connections on
+// its port. Ports are probed once, from inside the session container.
+func (cm *containerManager) checkHealth() (*HealthReport, error) {
+	report := &HealthReport{}
+
+	running, err := cm.docker.isContainerRunning(cm.containerName)
+	if err != nil {
+		return nil, err
+	}
+	report.ContainerRunning = running
+
+	var containerID string
+	if running {
+		containerID, err = cm.docker.getContainerID(cm.containerName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	services := cm.activeServices()
+	names := make([]string, 0, len(services))
+	for serviceName := range services {
+		names = append(names, serviceName)
+	}
+	slices.Sort(names)
+
+	for _, serviceName := range names {
+		config := services[serviceName]
+		health := ServiceHealth{Name: serviceName}
+
+		health.Running, err = cm.docker.isContainerRunning(cm.getServiceContainerName(serviceName))
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case !health.Running:
+		case config.Port <= 0:
+			health.Ready = true
+		case containerID != "":
+			address := net.JoinHostPort(serviceName, strconv.Itoa(config.Port))
+			health.Ready, err = cm.probeTCP(containerID, address)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		report.Services = append(report.Services, health)
 	}
 
-	return "Container exists but is stopped", nil
+	return report, nil
 }
 
 // ensureNetwork creates the Docker network if it doesn't exist
 func (cm *containerManager) ensureNetwork() error {
 	exists, err := cm.docker.networkExists(cm.networkName)
 	if err != nil {
-		return err
+		return err
+	}
+
+	if !exists {
+		_, err = cm.docker.createNetwork(cm.networkName, cm.networkLabels())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// networkLabels returns the labels for networks iso creates for the project,
+// which mark them for CleanupDanglingNetworks
+func (cm *containerManager) networkLabels() map[string]string {
+	return cm.withUserLabels(map[string]string{
+		"iso.managed":      "true",
+		"iso.project.name": cm.projectName,
+	})
+}
+
+// pruneNetworks removes the project's networks that no container is attached
+// to, typically left behind by an interrupted stop. With dryRun set it only
+// reports them. Returns the networks removed (or that would be). Networks are
+// matched by the project label networkLabels sets, not by name, since another
+// project's names can share the prefix (app vs app-admin).
+func (cm *containerManager) pruneNetworks(dryRun bool) ([]string, error) {
+	unused, err := cm.docker.listUnusedNetworks("iso.project.name=" + cm.projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []string
+	for _, networkName := range unused {
+		if dryRun {
+			pruned = append(pruned, networkName)
+			continue
+		}
+
+		slog.Debug("removing dangling network", "network", networkName)
+		if err := cm.docker.removeNetwork(networkName); err != nil {
+			// A container may have attached since the network was inspected
+			slog.Warn("failed to remove network", "network", networkName, "error", err)
+			continue
+		}
+		pruned = append(pruned, networkName)
+	}
+
+	return pruned, nil
+}
+
+// sessionContainer finds the session's main container, or with service set the
+// named service's container, by its iso labels. If several match (e.g. fresh
+// per-run services), the most recently created one is used.
+func (cm *containerManager) sessionContainer(service string) (string, error) {
+	args := filters.NewArgs(
+		filters.Arg("label", "iso.managed=true"),
+		filters.Arg("label", fmt.Sprintf("iso.project.name=%s", cm.projectName)),
+		filters.Arg("label", fmt.Sprintf("iso.session=%s", cm.session)),
+	)
+	if service != "" {
+		if _, ok := cm.services[service]; !ok {
+			return "", fmt.Errorf("unknown service %q (not defined in services.yml)", service)
+		}
+		args.Add("label", "iso.service=true")
+		args.Add("label", fmt.Sprintf("iso.name=%s", service))
+	} else {
+		args.Add("label", "iso.name=shell")
+	}
+
+	containers, err := cm.docker.client.ContainerList(cm.docker.ctx, container.ListOptions{All: true, Filters: args})
+	if err != nil {
+		return "", fmt.Errorf("failed to list containers: %w", err)
+	}
+	if len(containers) == 0 {
+		if service != "" {
+			return "", fmt.Errorf("no container for service %s in session %s", service, cm.session)
+		}
+		return "", fmt.Errorf("no container for session %s", cm.session)
+	}
+
+	newest := slices.MaxFunc(containers, func(a, b container.Summary) int {
+		return cmp.Compare(a.Created, b.Created)
+	})
+	return newest.ID, nil
+}
+
+// streamLogs writes the logs of the session's main container (or of service)
+// to stdout and stderr. tail limits the output to the last tail lines (all
+// when negative); follow keeps streaming new output until the container stops.
+func (cm *containerManager) streamLogs(service string, follow bool, tail int, stdout, stderr io.Writer) error {
+	containerID, err := cm.sessionContainer(service)
+	if err != nil {
+		return err
+	}
+
+	inspect, err := cm.docker.client.ContainerInspect(cm.docker.ctx, containerID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	tailOpt := "all"
+	if tail >= 0 {
+		tailOpt = strconv.Itoa(tail)
+	}
+	out, err := cm.docker.client.ContainerLogs(cm.docker.ctx, containerID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     follow,
+		Tail:       tailOpt,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get container logs: %w", err)
 	}
+	defer out.Close()
 
-	if !exists {
-		_, err = cm.docker.createNetwork(cm.networkName)
-		if err != nil {
-			return err
-		}
+	// TTY containers log a raw stream; others multiplex stdout and stderr
+	if inspect.Config != nil && inspect.Config.Tty {
+		_, err = io.Copy(stdout, out)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, out)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read container logs: %w", err)
 	}
-
 	return nil
 }
 
@@ -1018,19 +3438,6 @@ func (cm *containerManager) startService(serviceName string, config ServiceConfi
 		return cm.docker.client.ContainerStart(cm.docker.ctx, containerID, container.StartOptions{})
 	}
 
-	// Pull the image if it doesn't exist
-	imageExists, err := cm.docker.imageExists(config.Image)
-	if err != nil {
-		return err
-	}
-
-	if !imageExists {
-		slog.Info("pulling image", "image", config.Image)
-		if err := cm.docker.pullImage(config.Image); err != nil {
-			return err
-		}
-	}
-
 	// Convert environment map to slice
 	var env []string
 	for key, value := range config.Environment {
@@ -1041,7 +3448,7 @@ func (cm *containerManager) startService(serviceName string, config ServiceConfi
 	containerConfig := &container.Config{
 		Image: config.Image,
 		Env:   env,
-		Labels: map[string]string{
+		Labels: cm.withUserLabels(map[string]string{
 			"iso.managed":      "true",
 			"iso.project.name": cm.projectName,
 			"iso.project.dir":  cm.projectRoot,
@@ -1049,16 +3456,32 @@ func (cm *containerManager) startService(serviceName string, config ServiceConfi
 			"iso.service":      "true",
 			"iso.service.name": serviceName,
 			"iso.name":         serviceName,
-		},
+		}),
 	}
 
 	// Set command if specified
 	if len(config.Command) > 0 {
 		containerConfig.Cmd = config.Command
 	}
+	if config.Platform != "" {
+		containerConfig.Labels["iso.platform"] = config.Platform
+	}
+
+	exposedPorts, portBindings, err := parsePortMappings(config.Publish)
+	if err != nil {
+		return fmt.Errorf("service %q: %w", serviceName, err)
+	}
+	if len(exposedPorts) > 0 {
+		containerConfig.ExposedPorts = exposedPorts
+	}
 
+	binds, _ := cm.serviceBinds(serviceName, config, false)
 	hostConfig := &container.HostConfig{
-		ExtraHosts: config.ExtraHosts,
+		Binds:         binds,
+		PortBindings:  portBindings,
+		ExtraHosts:    cm.extraHosts(config.ExtraHosts),
+		ShmSize:       config.shmSizeBytes,
+		RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyMode(config.Restart)},
 	}
 
 	networkConfig := &network.NetworkingConfig{
@@ -1075,7 +3498,7 @@ func (cm *containerManager) startService(serviceName string, config ServiceConfi
 		containerConfig,
 		hostConfig,
 		networkConfig,
-		nil,
+		config.platform,
 		containerName,
 	)
 	if err != nil {
@@ -1090,9 +3513,108 @@ func (cm *containerManager) startService(serviceName string, config ServiceConfi
 	return nil
 }
 
-// startAllServices starts all service containers
+// selectServices applies a run's service selection (WithServices or
+// NoServices) for activeServices, rejecting unknown service names
+func (cm *containerManager) selectServices(opts RunOptions) error {
+	if opts.NoServices && len(opts.WithServices) > 0 {
+		return fmt.Errorf("--no-services can't be combined with --with-service")
+	}
+	for _, serviceName := range opts.WithServices {
+		if _, ok := cm.services[serviceName]; !ok {
+			return fmt.Errorf("unknown service %q (not defined in services.yml)", serviceName)
+		}
+	}
+	cm.selectedServices = opts.WithServices
+	cm.noServices = opts.NoServices
+	return nil
+}
+
+// activeServices returns the services that should run given the active
+// profiles. Services without profiles always run; services with profiles run
+// only when at least one of their profiles is active. If a run selected
+// specific services, exactly those are returned, and none if it selected no
+// services. Either way the dependencies of returned services are included.
+func (cm *containerManager) activeServices() map[string]ServiceConfig {
+	active := make(map[string]ServiceConfig)
+	if cm.noServices {
+		return active
+	}
+
+	// An explicit selection overrides profiles
+	if len(cm.selectedServices) > 0 {
+		for _, serviceName := range cm.selectedServices {
+			active[serviceName] = cm.services[serviceName]
+		}
+	} else {
+		for serviceName, config := range cm.services {
+			if serviceProfileActive(config, cm.config.ActiveProfiles) {
+				active[serviceName] = config
+			}
+		}
+	}
+
+	// Services always run with their dependencies
+	pending := slices.Collect(maps.Keys(active))
+	for len(pending) > 0 {
+		serviceName := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, dep := range active[serviceName].DependsOn {
+			if _, ok := active[dep]; !ok {
+				active[dep] = cm.services[dep]
+				pending = append(pending, dep)
+			}
+		}
+	}
+	return active
+}
+
+// servicePlatforms returns the active services that pin a platform, sorted by
+// name, noting which run under emulation on this Docker host
+func (cm *containerManager) servicePlatforms() ([]ServicePlatform, error) {
+	var platforms []ServicePlatform
+	nativeArch := ""
+	for serviceName, config := range cm.activeServices() {
+		if config.Platform == "" {
+			continue
+		}
+		if nativeArch == "" {
+			var err error
+			nativeArch, err = cm.docker.getArchitecture()
+			if err != nil {
+				return nil, err
+			}
+		}
+		platforms = append(platforms, ServicePlatform{
+			Name:          serviceName,
+			ContainerName: cm.getServiceContainerName(serviceName),
+			Platform:      config.Platform,
+			Emulated:      platformEmulated(config.Platform, nativeArch),
+		})
+	}
+
+	slices.SortFunc(platforms, func(a, b ServicePlatform) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return platforms, nil
+}
+
+// serviceProfileActive reports whether a service is enabled by activeProfiles
+func serviceProfileActive(config ServiceConfig, activeProfiles []string) bool {
+	if len(config.Profiles) == 0 {
+		return true
+	}
+	for _, profile := range config.Profiles {
+		if slices.Contains(activeProfiles, profile) {
+			return true
+		}
+	}
+	return false
+}
+
+// startAllServices starts all active service containers
 func (cm *containerManager) startAllServices(verbose bool) error {
-	if len(cm.services) == 0 {
+	services := cm.activeServices()
+	if len(services) == 0 {
 		return nil
 	}
 
@@ -1101,8 +3623,19 @@ func (cm *containerManager) startAllServices(verbose bool) error {
 		return err
 	}
 
-	// Start each service
-	for serviceName, config := range cm.services {
+	// Pull or build any missing service images up front, in parallel
+	if err := cm.ensureServiceImages(); err != nil {
+		return err
+	}
+
+	// Services start before the main container, so create their named
+	// volumes here
+	if err := cm.ensureVolumes(); err != nil {
+		return err
+	}
+
+	// Start services in parallel, each after its dependencies
+	return startServicesInOrder(services, cm.config.MaxParallel, func(serviceName string, config ServiceConfig) error {
 		if verbose {
 			slog.Debug("starting service", "service", serviceName)
 		}
@@ -1112,9 +3645,148 @@ func (cm *containerManager) startAllServices(verbose bool) error {
 		if verbose {
 			slog.Debug("service started", "service", serviceName)
 		}
+		return nil
+	})
+}
+
+// startServicesInOrder calls start for every service, running up to limit
+// starts at once. A service is started only after all of its dependencies
+// started successfully; after the first failure no further services start and
+// that error is returned.
+func startServicesInOrder(services map[string]ServiceConfig, limit int, start func(string, ServiceConfig) error) error {
+	order, err := serviceStartOrder(services)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	g, ctx := errgroup.WithContext(context.Background())
+	g.SetLimit(max(limit, 1))
+
+	started := make(map[string]chan struct{}, len(order))
+	for _, serviceName := range order {
+		started[serviceName] = make(chan struct{})
+	}
+
+	// Launching in dependency order means every dependency already holds a
+	// slot (or has finished) when a dependent waits on it, so the limit
+	// can't deadlock
+	for _, serviceName := range order {
+		config := services[serviceName]
+		g.Go(func() error {
+			for _, dep := range config.DependsOn {
+				depStarted, ok := started[dep]
+				if !ok {
+					continue
+				}
+				select {
+				case <-depStarted:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			if err := start(serviceName, config); err != nil {
+				return err
+			}
+			close(started[serviceName])
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
+
+// ensureServiceImages pulls the images of all active services that aren't
+// present locally (for a service's platform, if it sets one), and builds
+// those of services with build set. Pulls and builds run concurrently,
+// bounded by config.MaxParallel.
+func (cm *containerManager) ensureServiceImages() error {
+	type imageRef struct{ name, platform string }
+	images := make(map[imageRef]bool)
+	var builds []ServiceConfig
+	for _, config := range cm.activeServices() {
+		if config.Build != "" {
+			builds = append(builds, config)
+			continue
+		}
+		images[imageRef{config.Image, config.Platform}] = true
+	}
+
+	var g errgroup.Group
+	g.SetLimit(cm.config.MaxParallel)
+
+	for _, config := range builds {
+		g.Go(func() error {
+			return cm.ensureServiceImage(config)
+		})
+	}
+
+	for ref := range images {
+		g.Go(func() error {
+			exists, err := cm.docker.imageExists(ref.name)
+			if err != nil {
+				return err
+			}
+			if exists && ref.platform != "" {
+				// A local image for another platform doesn't count
+				exists, err = cm.docker.imageHasPlatform(ref.name, ref.platform)
+				if err != nil {
+					return err
+				}
+			}
+			if exists {
+				return nil
+			}
+
+			slog.Info("pulling image", "image", ref.name, "platform", ref.platform)
+			return cm.docker.pullImage(ref.name, ref.platform)
+		})
+	}
+
+	return g.Wait()
+}
+
+// ensureServiceImage builds the image of a service with build set when it is
+// missing or its Dockerfile changed, as ensureImage does for the project image
+func (cm *containerManager) ensureServiceImage(config ServiceConfig) error {
+	dockerfile, err := os.ReadFile(config.dockerfilePath)
+	if err != nil {
+		return fmt.Errorf("failed to read Dockerfile: %w", err)
+	}
+	opts := imageBuildOptions{
+		contextDir: config.buildContext,
+		noCache:    cm.buildNoCache,
+		pull:       cm.buildPull,
+	}
+	opts.labels = map[string]string{
+		buildHashLabel:     buildHash(dockerfile, opts),
+		"iso.project.name": cm.worktreeProjectName,
+		"iso.project.dir":  cm.projectRoot,
+	}
+
+	exists, err := cm.docker.imageExists(config.Image)
+	if err != nil {
+		return err
+	}
+	if exists && !cm.noAutoRebuild {
+		labels, err := cm.docker.imageLabels(config.Image)
+		if err != nil {
+			return err
+		}
+		if labels[buildHashLabel] != opts.labels[buildHashLabel] {
+			slog.Info("service Dockerfile changed, rebuilding image", "image", config.Image)
+			exists = false
+		}
+	}
+	if exists && !opts.noCache && !opts.pull {
+		return nil
+	}
+
+	slog.Info("building service image", "image", config.Image, "dockerfile", config.dockerfilePath)
+	return cm.docker.buildImage(config.dockerfilePath, config.Image, opts)
 }
 
 // stopAllServices stops and removes all service containers
@@ -1159,10 +3831,17 @@ func (cm *containerManager) stopAllServices() error {
 }
 
 // pruneCacheVolumes removes all cache volumes for this project
-func (cm *containerManager) pruneCacheVolumes() error {
+func (cm *containerManager) pruneCacheVolumes() (*PruneResult, error) {
+	result := &PruneResult{Removed: []string{}, InUse: []string{}}
 	if len(cm.config.Cache) == 0 {
 		slog.Info("no cache volumes configured")
-		return nil
+		return result, nil
+	}
+
+	// Sizes are best effort; a failure only leaves BytesFreed at zero
+	sizes, err := cm.docker.volumeSizes()
+	if err != nil {
+		slog.Debug("failed to get volume sizes", "error", err)
 	}
 
 	for _, cachePath := range cm.config.Cache {
@@ -1177,26 +3856,30 @@ func (cm *containerManager) pruneCacheVolumes() error {
 		if exists {
 			slog.Info("removing cache volume", "volume", volumeName, "path", cachePath)
 			if err := cm.docker.removeVolume(volumeName); err != nil {
+				if isVolumeInUseError(err) {
+					slog.Warn("cache volume is in use, skipping", "volume", volumeName)
+					result.InUse = append(result.InUse, volumeName)
+					continue
+				}
 				slog.Warn("failed to remove cache volume", "volume", volumeName, "error", err)
+				continue
+			}
+			result.Removed = append(result.Removed, volumeName)
+			if size := sizes[volumeName]; size > 0 {
+				result.BytesFreed += size
 			}
 		} else {
 			slog.Debug("cache volume does not exist", "volume", volumeName)
 		}
 	}
 
-	return nil
+	return result, nil
 }
 
-// pullImage pulls a Docker image from a registry
+// pullImage pulls the project image from its registry, with the registry
+// credentials from the Docker config
 func (cm *containerManager) pullImage() error {
-	out, err := cm.docker.client.ImagePull(cm.docker.ctx, cm.imageName, image.PullOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to pull image: %w", err)
-	}
-	defer out.Close()
-
-	_, err = io.Copy(os.Stdout, out)
-	return err
+	return cm.docker.pullImage(cm.imageName, "")
 }
 
 // cleanupStaleResources removes stale ephemeral containers, volumes, and networks
@@ -1264,7 +3947,7 @@ func (cm *containerManager) cleanupStaleResources() {
 	}
 
 	// Clean up unused networks for this project
-	unusedNetworks, err := cm.docker.listUnusedNetworks()
+	unusedNetworks, err := cm.docker.listUnusedNetworks("")
 	if err != nil {
 		slog.Debug("failed to list unused networks", "error", err)
 		return
@@ -1312,7 +3995,7 @@ func (cm *containerManager) ensurePeersNetwork() error {
 	}
 
 	if !exists {
-		_, err = cm.docker.createNetwork(cm.peersNetworkName)
+		_, err = cm.docker.createNetwork(cm.peersNetworkName, cm.networkLabels())
 		if err != nil {
 			return err
 		}
@@ -1381,6 +4064,7 @@ func (cm *containerManager) startPeer(peerName string, config PeerConfig) (strin
 		fmt.Sprintf("%s:%s", mountPath, cm.config.WorkDir),
 		fmt.Sprintf("%s:/iso:ro", cm.tempIsoPath),
 	}
+	binds = append(binds, cm.gitDirBinds()...)
 
 	// Add session-specific volume mounts
 	for _, volumePath := range cm.config.Volumes {
@@ -1395,26 +4079,15 @@ func (cm *containerManager) startPeer(peerName string, config PeerConfig) (strin
 	}
 	binds = append(binds, cacheBinds...)
 
-	// Add host directory bind mounts (with ~ expansion)
-	for _, bind := range cm.config.Binds {
-		parts := strings.SplitN(bind, ":", 3)
-		if len(parts) >= 2 {
-			hostPath := parts[0]
-			if strings.HasPrefix(hostPath, "~/") {
-				if usr, err := user.Current(); err == nil {
-					hostPath = filepath.Join(usr.HomeDir, hostPath[2:])
-				}
-			} else if hostPath == "~" {
-				if usr, err := user.Current(); err == nil {
-					hostPath = usr.HomeDir
-				}
-			}
-			parts[0] = hostPath
-			bind = strings.Join(parts, ":")
-		}
-		binds = append(binds, bind)
+	// Add global shared volumes
+	for _, entry := range cm.config.SharedVolumes {
+		name, containerPath, _ := strings.Cut(entry, ":")
+		binds = append(binds, fmt.Sprintf("%s:%s", getSharedVolumeName(name), containerPath))
 	}
 
+	// Add host directory bind mounts (normalized when the config was loaded)
+	binds = append(binds, cm.config.Binds...)
+
 	// Convert environment map to slice
 	env := []string{
 		fmt.Sprintf("ISO_WORKDIR=%s", cm.config.WorkDir),
@@ -1433,7 +4106,7 @@ func (cm *containerManager) startPeer(peerName string, config PeerConfig) (strin
 		Cmd:        []string{"/iso", "_internal-init"},
 		Env:        env,
 		Hostname:   config.Hostname,
-		Labels: map[string]string{
+		Labels: cm.withUserLabels(map[string]string{
 			"iso.managed":      "true",
 			"iso.project.name": cm.projectName,
 			"iso.project.dir":  cm.projectRoot,
@@ -1441,7 +4114,7 @@ func (cm *containerManager) startPeer(peerName string, config PeerConfig) (strin
 			"iso.name":         peerName,
 			"iso.peer":         "true",
 			"iso.peer.name":    peerName,
-		},
+		}),
 	}
 
 	exposedPorts, portBindings, err := parsePortMappings(config.Ports)
@@ -1456,7 +4129,9 @@ func (cm *containerManager) startPeer(peerName string, config PeerConfig) (strin
 	hostConfig := &container.HostConfig{
 		Binds:      binds,
 		Privileged: cm.config.Privileged,
-		ExtraHosts: cm.config.ExtraHosts,
+		ExtraHosts: cm.extraHosts(cm.config.ExtraHosts),
+		ShmSize:    cm.config.shmSizeBytes,
+		Tmpfs:      cm.config.tmpfsMounts,
 	}
 
 	if len(portBindings) > 0 {
@@ -1509,7 +4184,7 @@ func (cm *containerManager) startAllPeers(peerNames []string) error {
 	}
 
 	// Also start services and connect them to peers network
-	if len(cm.services) > 0 {
+	if services := cm.activeServices(); len(services) > 0 {
 		// Ensure the regular network exists for services
 		if err := cm.ensureNetwork(); err != nil {
 			return err
@@ -1518,7 +4193,7 @@ func (cm *containerManager) startAllPeers(peerNames []string) error {
 			return err
 		}
 		// Connect services to peers network
-		for serviceName := range cm.services {
+		for serviceName := range services {
 			serviceContainerName := cm.getServiceContainerName(serviceName)
 			containerID, err := cm.docker.getContainerID(serviceContainerName)
 			if err != nil {
@@ -1665,24 +4340,6 @@ func (cm *containerManager) execInPeer(peerName string, command []string, envVar
 	// Check if stdin is a TTY
 	isTTY := term.IsTerminal(os.Stdin.Fd())
 
-	// If TTY mode, set terminal to raw mode
-	var oldState *term.State
-	if isTTY {
-		oldState, err = term.SaveState(os.Stdin.Fd())
-		if err != nil {
-			return 0, fmt.Errorf("failed to save terminal state: %w", err)
-		}
-		defer func() {
-			if oldState != nil {
-				_ = term.RestoreTerminal(os.Stdin.Fd(), oldState)
-			}
-		}()
-
-		if _, err := term.MakeRaw(os.Stdin.Fd()); err != nil {
-			return 0, fmt.Errorf("failed to set terminal to raw mode: %w", err)
-		}
-	}
-
 	// Wrap command with in-env run for pre/post hooks
 	wrappedCommand := append([]string{"/iso", "in-env", "run", "--"}, command...)
 
@@ -1700,14 +4357,10 @@ func (cm *containerManager) execInPeer(peerName string, command []string, envVar
 		fmt.Sprintf("ISO_GID=%s", currentUser.Gid),
 		fmt.Sprintf("ISO_PEER_NAME=%s", peerName),
 	}
+	execEnv = append(execEnv, cm.hookEnv()...)
 
 	if isTTY {
-		if termValue := os.Getenv("TERM"); termValue != "" {
-			if termValue == "xterm-ghostty" {
-				termValue = "xterm-256color"
-			}
-			execEnv = append(execEnv, fmt.Sprintf("TERM=%s", termValue))
-		}
+		execEnv = append(execEnv, termEnv(cm.hostGetenv("TERM"))...)
 	}
 
 	// Add environment from config
@@ -1729,90 +4382,7 @@ func (cm *containerManager) execInPeer(peerName string, command []string, envVar
 		Env:          execEnv,
 	}
 
-	execResp, err := cm.docker.client.ContainerExecCreate(cm.docker.ctx, containerID, execConfig)
-	if err != nil {
-		return 0, fmt.Errorf("failed to create exec: %w", err)
-	}
-
-	// Attach to the exec instance
-	attachResp, err := cm.docker.client.ContainerExecAttach(cm.docker.ctx, execResp.ID, container.ExecStartOptions{
-		Tty: isTTY,
-	})
-	if err != nil {
-		return 0, fmt.Errorf("failed to attach to exec: %w", err)
-	}
-	defer attachResp.Close()
-
-	// If TTY mode, set terminal size and monitor for resize events
-	if isTTY {
-		winsize, err := term.GetWinsize(os.Stdin.Fd())
-		if err == nil {
-			if err := cm.docker.client.ContainerExecResize(cm.docker.ctx, execResp.ID, container.ResizeOptions{
-				Height: uint(winsize.Height),
-				Width:  uint(winsize.Width),
-			}); err != nil {
-				slog.Warn("failed to set initial terminal size", "error", err)
-			}
-		}
-
-		go func() {
-			sigChan := make(chan os.Signal, 1)
-			signal.Notify(sigChan, syscall.SIGWINCH)
-			defer signal.Stop(sigChan)
-
-			for {
-				select {
-				case <-sigChan:
-					if ws, err := term.GetWinsize(os.Stdin.Fd()); err == nil {
-						_ = cm.docker.client.ContainerExecResize(cm.docker.ctx, execResp.ID, container.ResizeOptions{
-							Height: uint(ws.Height),
-							Width:  uint(ws.Width),
-						})
-					}
-				case <-cm.docker.ctx.Done():
-					return
-				}
-			}
-		}()
-	}
-
-	// Copy stdin in background
-	go func() {
-		_, _ = io.Copy(attachResp.Conn, os.Stdin)
-		if closer, ok := attachResp.Conn.(interface{ CloseWrite() error }); ok {
-			closer.CloseWrite()
-		}
-	}()
-
-	// Copy stdout/stderr
-	outputDone := make(chan error, 1)
-	go func() {
-		var err error
-		if isTTY {
-			_, err = io.Copy(os.Stdout, attachResp.Conn)
-		} else {
-			_, err = stdcopy.StdCopy(os.Stdout, os.Stderr, attachResp.Reader)
-		}
-		outputDone <- err
-	}()
-
-	// Wait for output to finish
-	select {
-	case err := <-outputDone:
-		if err != nil && err != io.EOF {
-			return 0, fmt.Errorf("failed to read output: %w", err)
-		}
-	case <-cm.docker.ctx.Done():
-		return 0, cm.docker.ctx.Err()
-	}
-
-	// Check exit code
-	inspectResp, err := cm.docker.client.ContainerExecInspect(cm.docker.ctx, execResp.ID)
-	if err != nil {
-		return 0, fmt.Errorf("failed to inspect exec: %w", err)
-	}
-
-	return inspectResp.ExitCode, nil
+	return cm.execAttached(containerID, execConfig, false, 0)
 }
 
 // PeerStatus represents the status of a peer container
@@ -1883,12 +4453,21 @@ func parsePortMappings(specs []string) (nat.PortSet, nat.PortMap, error) {
 	for _, portSpec := range specs {
 		parts := strings.Split(portSpec, ":")
 		var hostPort, containerPort string
-		if len(parts) == 2 {
-			hostPort = parts[0]
-			containerPort = parts[1]
-		} else {
+		switch len(parts) {
+		case 1:
 			hostPort = parts[0]
 			containerPort = parts[0]
+		case 2:
+			hostPort = parts[0]
+			containerPort = parts[1]
+		default:
+			return nil, nil, fmt.Errorf("invalid port mapping %q (expected \"hostPort:containerPort\" or \"port\")", portSpec)
+		}
+
+		for _, p := range []string{hostPort, containerPort} {
+			if n, err := strconv.Atoi(p); err != nil || n < 1 || n > 65535 {
+				return nil, nil, fmt.Errorf("invalid port mapping %q: %q is not a port number (1-65535)", portSpec, p)
+			}
 		}
 
 		port, err := nat.NewPort("tcp", containerPort)
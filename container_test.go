@@ -1,8 +1,26 @@
 package iso
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/pkg/stdcopy"
 )
 
 // TestServiceContainerNamesAreDeterministic locks the invariant that a
@@ -44,3 +62,1072 @@ func TestServiceContainerNamesAreDeterministic(t *testing.T) {
 		})
 	}
 }
+
+func TestActiveServicesFiltersByProfile(t *testing.T) {
+	cm := &containerManager{
+		services: map[string]ServiceConfig{
+			"postgres": {Image: "postgres"},
+			"kafka":    {Image: "kafka", Profiles: []string{"messaging"}},
+			"jaeger":   {Image: "jaeger", Profiles: []string{"tracing", "debug"}},
+		},
+		config: &Config{ActiveProfiles: []string{"debug"}},
+	}
+
+	active := cm.activeServices()
+	for _, name := range []string{"postgres", "jaeger"} {
+		if _, ok := active[name]; !ok {
+			t.Errorf("expected %q to be active", name)
+		}
+	}
+	if _, ok := active["kafka"]; ok {
+		t.Errorf("kafka should not be active without the messaging profile")
+	}
+}
+
+func TestSelectServices(t *testing.T) {
+	cm := &containerManager{
+		config: &Config{},
+		services: map[string]ServiceConfig{
+			"db":  {Image: "postgres"},
+			"api": {Image: "api", DependsOn: []string{"db"}},
+		},
+	}
+
+	if err := cm.selectServices(RunOptions{NoServices: true}); err != nil {
+		t.Fatalf("selectServices() error = %v", err)
+	}
+	if active := cm.activeServices(); len(active) != 0 {
+		t.Errorf("activeServices() with NoServices = %v, want none", active)
+	}
+
+	if err := cm.selectServices(RunOptions{WithServices: []string{"api"}}); err != nil {
+		t.Fatalf("selectServices() error = %v", err)
+	}
+	if active := cm.activeServices(); len(active) != 2 {
+		t.Errorf("activeServices() with api selected = %v, want api and db", active)
+	}
+
+	if err := cm.selectServices(RunOptions{WithServices: []string{"cache"}}); err == nil {
+		t.Error("selectServices() expected error for an unknown service")
+	}
+	if err := cm.selectServices(RunOptions{WithServices: []string{"db"}, NoServices: true}); err == nil {
+		t.Error("selectServices() expected error combining NoServices and WithServices")
+	}
+}
+
+// TestSessionNetworkAfterRunWithoutServices covers a persistent session whose
+// first run selects no services: its container must still join the session
+// network, and a container that isn't on it is connected when a later run
+// starts services
+func TestSessionNetworkAfterRunWithoutServices(t *testing.T) {
+	var connected []string
+	onNetwork := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /containers/{id}/json", func(w http.ResponseWriter, r *http.Request) {
+		networks := map[string]any{"bridge": map[string]any{}}
+		if onNetwork {
+			networks["app-network"] = map[string]any{}
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"Id":              r.PathValue("id"),
+			"NetworkSettings": map[string]any{"Networks": networks},
+		})
+	})
+	mux.HandleFunc("POST /networks/{name}/connect", func(w http.ResponseWriter, r *http.Request) {
+		connected = append(connected, r.PathValue("name"))
+	})
+
+	cm := &containerManager{
+		docker:        newFakeDocker(t, mux),
+		config:        &Config{},
+		containerName: "app-shell",
+		networkName:   "app-network",
+		services: map[string]ServiceConfig{
+			"db": {Image: "postgres", Port: 5432},
+		},
+	}
+
+	// First run: --no-services
+	if err := cm.selectServices(RunOptions{NoServices: true}); err != nil {
+		t.Fatal(err)
+	}
+	networkConfig := cm.sessionNetworkConfig()
+	if networkConfig == nil || networkConfig.EndpointsConfig["app-network"] == nil {
+		t.Fatalf("sessionNetworkConfig() with no services selected = %+v, want the session network", networkConfig)
+	}
+	if got := cm.isoServicesEnv(); got != "" {
+		t.Errorf("isoServicesEnv() with no services selected = %q, want \"\"", got)
+	}
+
+	// Second run: services again, in a container that missed the network
+	if err := cm.selectServices(RunOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := cm.isoServicesEnv(); got != "db:5432" {
+		t.Errorf("isoServicesEnv() = %q, want %q", got, "db:5432")
+	}
+	if err := cm.connectSessionNetwork("abc123"); err != nil {
+		t.Fatalf("connectSessionNetwork() error = %v", err)
+	}
+	if !slices.Equal(connected, []string{"app-network"}) {
+		t.Errorf("connected to %v, want the session network", connected)
+	}
+
+	onNetwork = true
+	if err := cm.connectSessionNetwork("abc123"); err != nil {
+		t.Fatalf("connectSessionNetwork() error = %v", err)
+	}
+	if len(connected) != 1 {
+		t.Errorf("connectSessionNetwork() reconnected a container already on the network")
+	}
+
+	if cm := (&containerManager{config: &Config{}}); cm.sessionNetworkConfig() != nil {
+		t.Error("sessionNetworkConfig() without services should be nil")
+	}
+}
+
+// TestWaitForServicesReadyConcurrent waits on ready_log services: they are
+// checked concurrently, so services that never become ready fail together
+// after one service_timeout rather than one timeout each
+func TestWaitForServicesReadyConcurrent(t *testing.T) {
+	output := map[string]string{
+		"web-ctr":   "starting\nlistening, server ready\n",
+		"db-ctr":    "initializing data directory\n",
+		"cache-ctr": "loading\n",
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /containers/{id}/json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"Id":    r.PathValue("id"),
+			"State": map[string]any{"Running": true, "StartedAt": "2026-01-01T00:00:00Z"},
+		})
+	})
+	mux.HandleFunc("GET /containers/{id}/logs", func(w http.ResponseWriter, r *http.Request) {
+		stdcopy.NewStdWriter(w, stdcopy.Stdout).Write([]byte(output[r.PathValue("id")]))
+	})
+
+	readyLog := regexp.MustCompile("server ready|accept connections")
+	cm := &containerManager{
+		docker: newFakeDocker(t, mux),
+		config: &Config{serviceTimeout: time.Second},
+		services: map[string]ServiceConfig{
+			"web":   {Image: "web", ReadyLog: readyLog.String(), readyLog: readyLog},
+			"db":    {Image: "postgres", ReadyLog: readyLog.String(), readyLog: readyLog},
+			"cache": {Image: "redis", ReadyLog: readyLog.String(), readyLog: readyLog},
+		},
+	}
+	containers := map[string]string{"web": "web-ctr", "db": "db-ctr", "cache": "cache-ctr"}
+
+	started := time.Now()
+	err := cm.waitForServicesReady("shell", containers)
+	if err == nil || !strings.Contains(err.Error(), "not ready after 1s") || !strings.Contains(err.Error(), "ready_log") {
+		t.Fatalf("waitForServicesReady() error = %v, want a ready_log timeout", err)
+	}
+	if elapsed := time.Since(started); elapsed > 1900*time.Millisecond {
+		t.Errorf("waitForServicesReady() took %s, want one service_timeout for all services", elapsed)
+	}
+
+	output["db-ctr"] += "database system is ready to accept connections\n"
+	output["cache-ctr"] += "server ready\n"
+	if err := cm.waitForServicesReady("shell", containers); err != nil {
+		t.Errorf("waitForServicesReady() error = %v, want every service ready", err)
+	}
+}
+
+// TestPrepareRunRecreatesContainerWithMissingImage covers a stopped session
+// container whose image was removed out-of-band (`docker rmi -f`): starting it
+// fails with "No such image", so prepareRun must remove it and create a new
+// container from a fresh image instead of failing every run
+func TestPrepareRunRecreatesContainerWithMissingImage(t *testing.T) {
+	var requests []string
+	current := "old-container"
+	pulled := false
+	record := func(r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		t.Errorf("unexpected Docker request %s %s", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("GET /containers/json", func(w http.ResponseWriter, r *http.Request) {
+		// The container is stopped, so only listings of all containers show it
+		var containers []map[string]any
+		if r.URL.Query().Get("all") == "1" && current != "" {
+			containers = append(containers, map[string]any{"Id": current, "Names": []string{"/app-shell"}})
+		}
+		json.NewEncoder(w).Encode(containers)
+	})
+	mux.HandleFunc("POST /containers/{id}/start", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		if r.PathValue("id") == "old-container" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "No such image: app-shell:latest"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /containers/{id}/stop", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /containers/{id}", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		current = ""
+		w.WriteHeader(http.StatusNoContent)
+	})
+	// The image was removed, so recovery pulls it again
+	mux.HandleFunc("GET /images/{name}/json", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		if !pulled {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "No such image: alpine:3"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"Id": "sha256:abc", "RepoTags": []string{"alpine:3"}})
+	})
+	mux.HandleFunc("POST /images/create", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		pulled = true
+		json.NewEncoder(w).Encode(map[string]string{"status": "Downloaded newer image for alpine:3"})
+	})
+	mux.HandleFunc("POST /containers/create", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		current = "new-container"
+		json.NewEncoder(w).Encode(map[string]string{"Id": current})
+	})
+	mux.HandleFunc("GET /containers/{id}/json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"Id": r.PathValue("id"), "State": map[string]any{"Running": true}})
+	})
+	handleFakeExecs(t, mux, 0)
+
+	isoDir := filepath.Join(t.TempDir(), ".iso")
+	if err := os.Mkdir(isoDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Requests are handled one at a time, so the handlers share state safely
+	var mu sync.Mutex
+	serial := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		mux.ServeHTTP(w, r)
+	})
+
+	cm := &containerManager{
+		docker:        newFakeDocker(t, serial),
+		config:        &Config{WorkDir: "/workspace", Image: "alpine:3"},
+		containerName: "app-shell",
+		imageName:     "alpine:3",
+		session:       "default",
+		isoDir:        isoDir,
+	}
+
+	containerID, cleanup, err := cm.prepareRun(false)
+	if err != nil {
+		t.Fatalf("prepareRun() error = %v", err)
+	}
+	cleanup()
+	if containerID != "new-container" {
+		t.Errorf("prepareRun() = %q, want the recreated container", containerID)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"POST /containers/old-container/start",
+		"POST /containers/old-container/stop",
+		"DELETE /containers/old-container",
+		"GET /images/alpine:3/json",
+		"POST /images/create",
+		"POST /containers/create",
+		"POST /containers/new-container/start",
+	}
+	if !slices.Equal(requests, want) {
+		t.Errorf("Docker requests = %v, want %v", requests, want)
+	}
+}
+
+// TestShellCommandFallback runs the shell command on the host: a missing
+// shell must fall back to /bin/sh rather than fail the exec
+func TestShellCommandFallback(t *testing.T) {
+	cases := []struct {
+		shell      string
+		wantOut    string
+		wantNotice bool
+	}{
+		{"/bin/sh", "ok\n", false},
+		{"/bin/cat", "echo ok\n", false},
+		{"/nonexistent/bash", "ok\n", true},
+	}
+
+	for _, tc := range cases {
+		cm := &containerManager{config: &Config{Shell: tc.shell}}
+		command := cm.shellCommand()
+
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Stdin = strings.NewReader("echo ok\n")
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("shell %q: %v (stderr: %s)", tc.shell, err, stderr.String())
+		}
+		if string(out) != tc.wantOut {
+			t.Errorf("shell %q output = %q, want %q", tc.shell, out, tc.wantOut)
+		}
+		if got := strings.Contains(stderr.String(), "not found"); got != tc.wantNotice {
+			t.Errorf("shell %q fallback notice = %v, want %v (stderr: %q)", tc.shell, got, tc.wantNotice, stderr.String())
+		}
+	}
+}
+
+func TestSessionVolumePaths(t *testing.T) {
+	cm := &containerManager{config: &Config{
+		Volumes:        []string{"/data"},
+		WritablePaths:  []string{"/workspace/tmp"},
+		PersistHistory: true,
+	}}
+	if got, want := cm.sessionVolumePaths(), []string{"/data", historyDir}; !slices.Equal(got, want) {
+		t.Errorf("sessionVolumePaths() = %v, want %v", got, want)
+	}
+
+	cm.config.ReadOnlyWorkspace = true
+	cm.config.PersistHistory = false
+	if got, want := cm.sessionVolumePaths(), []string{"/data", "/workspace/tmp"}; !slices.Equal(got, want) {
+		t.Errorf("sessionVolumePaths() = %v, want %v", got, want)
+	}
+}
+
+func TestBuildHash(t *testing.T) {
+	dockerfile := []byte("FROM golang:1.24\nARG VERSION\n")
+	base := imageBuildOptions{buildArgs: map[string]string{"VERSION": "1", "GO": "1.24"}}
+	want := buildHash(dockerfile, base)
+
+	reordered := imageBuildOptions{buildArgs: map[string]string{"GO": "1.24", "VERSION": "1"}}
+	if got := buildHash(dockerfile, reordered); got != want {
+		t.Errorf("buildHash depends on build arg order: %s != %s", got, want)
+	}
+
+	changed := map[string]string{
+		"dockerfile": buildHash([]byte("FROM golang:1.25\nARG VERSION\n"), base),
+		"build arg":  buildHash(dockerfile, imageBuildOptions{buildArgs: map[string]string{"VERSION": "2", "GO": "1.24"}}),
+		"target":     buildHash(dockerfile, imageBuildOptions{buildArgs: base.buildArgs, target: "dev"}),
+	}
+	for name, got := range changed {
+		if got == want {
+			t.Errorf("buildHash unchanged after changing the %s", name)
+		}
+	}
+}
+
+func TestDigestMatches(t *testing.T) {
+	const digest = "sha256:4f5e"
+	cases := []struct {
+		name        string
+		repoDigests []string
+		repo        string
+		want        bool
+	}{
+		{"same repo", []string{"postgres@sha256:4f5e"}, "postgres", true},
+		{"fully qualified", []string{"docker.io/library/postgres@sha256:4f5e"}, "postgres:16", true},
+		{"different digest", []string{"postgres@sha256:0000"}, "postgres", false},
+		{"different repo", []string{"mysql@sha256:4f5e"}, "postgres", false},
+		{"no digests", nil, "postgres", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := digestMatches(tc.repoDigests, tc.repo, digest); got != tc.want {
+				t.Fatalf("digestMatches(%v, %q) = %v, want %v", tc.repoDigests, tc.repo, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadSecrets(t *testing.T) {
+	hostPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(hostPath, []byte("s3cret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	secrets, err := readSecrets(map[string]string{"npm-token": hostPath})
+	if err != nil {
+		t.Fatalf("readSecrets() error = %v", err)
+	}
+	if got := string(secrets["npm-token"]); got != "s3cret" {
+		t.Fatalf("secret content = %q, want %q", got, "s3cret")
+	}
+
+	for _, id := range []string{"../etc/passwd", "a/b", ".hidden", ""} {
+		if _, err := readSecrets(map[string]string{id: hostPath}); err == nil {
+			t.Errorf("readSecrets() accepted invalid id %q", id)
+		}
+	}
+
+	if _, err := readSecrets(map[string]string{"missing": hostPath + ".missing"}); err == nil {
+		t.Error("readSecrets() accepted a missing file")
+	}
+}
+
+func TestWithUserLabels(t *testing.T) {
+	cm := &containerManager{config: &Config{Labels: map[string]string{
+		"team":        "infra",
+		"cost-center": "42",
+	}}}
+
+	labels := cm.withUserLabels(map[string]string{"iso.managed": "true", "iso.name": "shell"})
+	if labels["iso.managed"] != "true" || labels["team"] != "infra" {
+		t.Fatalf("withUserLabels() = %v, want iso and user labels merged", labels)
+	}
+
+	// Labels inherited from the image are not reported as user labels
+	labels["maintainer"] = "someone"
+	user := userLabels(labels)
+	if len(user) != 2 || user["team"] != "infra" || user["cost-center"] != "42" {
+		t.Fatalf("userLabels() = %v, want only the config labels", user)
+	}
+}
+
+func TestSSHAgentSocket(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("macOS always uses Docker Desktop's forwarded agent socket")
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	if err := os.WriteFile(socket, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SSH_AUTH_SOCK", socket)
+
+	cm := &containerManager{config: &Config{}}
+	if got := cm.sshAgentSocket(); got != "" {
+		t.Errorf("sshAgentSocket() without forward_ssh_agent = %q, want \"\"", got)
+	}
+
+	cm.config.ForwardSSHAgent = true
+	if got := cm.sshAgentSocket(); got != socket {
+		t.Errorf("sshAgentSocket() = %q, want %q", got, socket)
+	}
+
+	t.Setenv("SSH_AUTH_SOCK", filepath.Join(t.TempDir(), "gone.sock"))
+	if got := cm.sshAgentSocket(); got != "" {
+		t.Errorf("sshAgentSocket() with a stale socket = %q, want \"\"", got)
+	}
+
+	// Through the daemon, the calling CLI's agent is forwarded
+	cm.hostEnv = []string{"SSH_AUTH_SOCK=" + socket}
+	if got := cm.sshAgentSocket(); got != socket {
+		t.Errorf("sshAgentSocket() with the CLI's environment = %q, want %q", got, socket)
+	}
+}
+
+func TestResolveWorkDir(t *testing.T) {
+	cases := []struct {
+		override, want string
+	}{
+		{"services/api", "/workspace/services/api"},
+		{"./tools/", "/workspace/tools"},
+		{"/opt/app/", "/opt/app"},
+		{"..", "/"},
+	}
+	for _, tc := range cases {
+		if got := resolveWorkDir("/workspace", tc.override); got != tc.want {
+			t.Errorf("resolveWorkDir(%q) = %q, want %q", tc.override, got, tc.want)
+		}
+	}
+}
+
+func TestPassthroughEnv(t *testing.T) {
+	environ := []string{"AWS_REGION=eu-west-1", "AWS_SECRET_ACCESS_KEY=abc=", "GITHUB_TOKEN=ghp", "HOME=/home/me", "XAWS_X=1"}
+
+	got := passthroughEnv([]string{"AWS_*", "GITHUB_TOKEN", "MISSING"}, environ)
+	want := []string{"AWS_REGION=eu-west-1", "AWS_SECRET_ACCESS_KEY=abc=", "GITHUB_TOKEN=ghp"}
+	if !slices.Equal(got, want) {
+		t.Errorf("passthroughEnv() = %v, want %v", got, want)
+	}
+
+	if got := passthroughEnv(nil, environ); got != nil {
+		t.Errorf("passthroughEnv() without patterns = %v, want nothing", got)
+	}
+}
+
+// TestPruneNetworksByLabel covers projects whose names share a prefix (app vs
+// app-admin): pruning one must not select the other's networks
+func TestPruneNetworksByLabel(t *testing.T) {
+	networks := []map[string]any{
+		{"Id": "n1", "Name": "app-network", "Labels": map[string]string{"iso.project.name": "app"}},
+		{"Id": "n2", "Name": "app-admin-network", "Labels": map[string]string{"iso.project.name": "app-admin"}},
+	}
+
+	var mu sync.Mutex
+	var removed []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /networks", func(w http.ResponseWriter, r *http.Request) {
+		args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+		if err != nil {
+			t.Errorf("bad network filters: %v", err)
+		}
+		var matched []map[string]any
+		for _, n := range networks {
+			labels := n["Labels"].(map[string]string)
+			if args.Len() == 0 || args.MatchKVList("label", labels) {
+				matched = append(matched, n)
+			}
+		}
+		json.NewEncoder(w).Encode(matched)
+	})
+	mux.HandleFunc("GET /networks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"Id": r.PathValue("id"), "Containers": map[string]any{}})
+	})
+	mux.HandleFunc("DELETE /networks/{name}", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		removed = append(removed, r.PathValue("name"))
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	cm := &containerManager{docker: newFakeDocker(t, mux), projectName: "app", worktreeProjectName: "app"}
+	pruned, err := cm.pruneNetworks(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(pruned, []string{"app-network"}) || !slices.Equal(removed, []string{"app-network"}) {
+		t.Errorf("pruneNetworks() = %v, removed %v, want only app-network", pruned, removed)
+	}
+}
+
+func TestLimitDockerName(t *testing.T) {
+	if got := sessionNetworkName("app", "feature"); got != "app-feature-network" {
+		t.Errorf("sessionNetworkName() = %q, want app-feature-network", got)
+	}
+
+	project := "my-long-project-name"
+	long := sessionNetworkName(project, "a-very-long-session-name-for-a-feature-branch")
+	if len(long) > maxDockerNameLength {
+		t.Errorf("len(%q) = %d, want at most %d", long, len(long), maxDockerNameLength)
+	}
+	if !strings.HasPrefix(long, project+"-") || !strings.HasSuffix(long, "-network") {
+		t.Errorf("sessionNetworkName() = %q, want a %s-...-network name", long, project)
+	}
+	if other := sessionNetworkName(project, "a-very-long-session-name-for-a-feature-brancH"); other == long {
+		t.Errorf("distinct long names both shortened to %q", long)
+	}
+	if again := sessionNetworkName(project, "a-very-long-session-name-for-a-feature-branch"); again != long {
+		t.Errorf("shortened name not deterministic: %q != %q", again, long)
+	}
+
+	cm := &containerManager{baseProjectName: "app"}
+	volume := cm.getCacheVolumeNameForPath("/root/.cache/some/really/deeply/nested/tool/specific/cache/directory")
+	if len(volume) > maxDockerNameLength || !strings.HasPrefix(volume, "app-cache-root-") {
+		t.Errorf("getCacheVolumeNameForPath() = %q, want a shortened app-cache-root-... name", volume)
+	}
+}
+
+func TestResolveVolumeName(t *testing.T) {
+	volumes := map[string]bool{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /volumes/{name}", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if !volumes[name] {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "no such volume"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"Name": name})
+	})
+	cm := &containerManager{docker: newFakeDocker(t, mux), worktreeProjectName: "app", session: "a-very-long-session-name-for-a-feature-branch"}
+
+	path := "/workspace/node_modules/.cache"
+	long := "app-a-very-long-session-name-for-a-feature-branch-workspace-node_modules-.cache"
+	short := limitDockerName(long, "")
+	if got := cm.getVolumeNameForPath(path); got != short {
+		t.Errorf("getVolumeNameForPath() = %q, want the shortened %q", got, short)
+	}
+
+	// A volume created before names were shortened keeps its data
+	volumes[long] = true
+	if got := cm.getVolumeNameForPath(path); got != long {
+		t.Errorf("getVolumeNameForPath() = %q, want the existing %q", got, long)
+	}
+
+	// Once the shortened volume exists, it wins
+	volumes[short] = true
+	if got := cm.getVolumeNameForPath(path); got != short {
+		t.Errorf("getVolumeNameForPath() = %q, want %q", got, short)
+	}
+
+	if got := cm.getVolumeNameForPath("/data"); got != "app-a-very-long-session-name-for-a-feature-branch-data" {
+		t.Errorf("getVolumeNameForPath() = %q, want the unshortened name", got)
+	}
+}
+
+func TestParsePortMappings(t *testing.T) {
+	cases := []struct {
+		spec    string
+		host    string
+		wantErr bool
+	}{
+		{"5432:5432", "5432", false},
+		{"15432:5432", "15432", false},
+		{"8080", "8080", false},
+		{"127.0.0.1:5432:5432", "", true},
+		{"postgres:5432", "", true},
+		{"5432:", "", true},
+		{"70000:5432", "", true},
+	}
+
+	for _, tc := range cases {
+		exposed, bindings, err := parsePortMappings([]string{tc.spec})
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parsePortMappings(%q) error = %v, wantErr %v", tc.spec, err, tc.wantErr)
+			continue
+		}
+		if tc.wantErr {
+			continue
+		}
+		if len(exposed) != 1 {
+			t.Errorf("parsePortMappings(%q) exposed %v, want one port", tc.spec, exposed)
+		}
+		for _, b := range bindings {
+			if len(b) != 1 || b[0].HostPort != tc.host {
+				t.Errorf("parsePortMappings(%q) bindings = %v, want host port %s", tc.spec, b, tc.host)
+			}
+		}
+	}
+}
+
+// TestWaitForInitReady covers an exec issued immediately after the container
+// starts: the wait must not return until init reports ready.
+func TestWaitForInitReady(t *testing.T) {
+	calls := 0
+	err := waitForInitReady(time.Second, func() (bool, error) {
+		calls++
+		return calls == 3, nil
+	})
+	if err != nil {
+		t.Fatalf("waitForInitReady() unexpected error: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("check called %d times, want 3 (returned before init was ready)", calls)
+	}
+
+	err = waitForInitReady(10*time.Millisecond, func() (bool, error) { return false, nil })
+	if err == nil || !strings.Contains(err.Error(), "init_timeout") {
+		t.Errorf("waitForInitReady() error = %v, want timeout mentioning init_timeout", err)
+	}
+
+	exited := errors.New("container exited with code 1 during startup")
+	if err := waitForInitReady(time.Second, func() (bool, error) { return false, exited }); !errors.Is(err, exited) {
+		t.Errorf("waitForInitReady() error = %v, want %v", err, exited)
+	}
+}
+
+// TestWaitForInitLegacyContainer covers restarting a container created before
+// init wrote a ready file: waiting for the file would always time out
+func TestWaitForInitLegacyContainer(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected Docker request %s %s", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("GET /containers/{id}/json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"Id":     r.PathValue("id"),
+			"State":  map[string]any{"Running": true},
+			"Config": map[string]any{"Cmd": []string{"/iso", "_internal-init"}},
+		})
+	})
+
+	cm := &containerManager{
+		docker: newFakeDocker(t, mux),
+		config: &Config{initTimeout: 50 * time.Millisecond},
+	}
+	if err := cm.waitForInit("old-container"); err != nil {
+		t.Fatalf("waitForInit() error = %v, want no wait for a legacy init", err)
+	}
+}
+
+// TestStopFreshServicesAfterCancel covers cleanup after Ctrl+C or --timeout
+// cancelled the operation context: the services must still be stopped
+func TestStopFreshServicesAfterCancel(t *testing.T) {
+	var mu sync.Mutex
+	var stopped []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /containers/{id}/stop", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		stopped = append(stopped, r.PathValue("id"))
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	docker := newFakeDocker(t, mux)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	docker.ctx = ctx
+
+	cm := &containerManager{docker: docker}
+	cm.stopFreshServices(map[string]string{"postgres": "svc-1"})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(stopped, []string{"svc-1"}) {
+		t.Errorf("stopped containers = %v, want [svc-1]", stopped)
+	}
+}
+
+// TestReconcileUser covers changing config.yml's user either way, including
+// removing it, against a container whose image sets a USER
+func TestReconcileUser(t *testing.T) {
+	cases := []struct {
+		name, current, configured string
+		recreate                  bool
+	}{
+		{"unchanged", "1000:1000", "1000:1000", false},
+		{"user added", "app", "1000:1000", true},
+		{"user removed", "1000:1000", "", true},
+		{"image user", "app", "", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			removed := false
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /containers/json", func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode([]map[string]any{{"Id": "c1", "Names": []string{"/app-shell"}}})
+			})
+			mux.HandleFunc("GET /containers/{id}/json", func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(map[string]any{
+					"Id":     "c1",
+					"Image":  "sha256:img",
+					"State":  map[string]any{"Running": true},
+					"Config": map[string]any{"User": tc.current},
+				})
+			})
+			mux.HandleFunc("GET /images/{name}/json", func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(map[string]any{"Id": "sha256:img", "Config": map[string]any{"User": "app"}})
+			})
+			mux.HandleFunc("POST /containers/{id}/stop", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+			mux.HandleFunc("DELETE /containers/{id}", func(w http.ResponseWriter, r *http.Request) {
+				removed = true
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			cm := &containerManager{
+				docker:        newFakeDocker(t, mux),
+				config:        &Config{User: tc.configured},
+				containerName: "app-shell",
+			}
+			if err := cm.reconcileUser(); err != nil {
+				t.Fatal(err)
+			}
+			if removed != tc.recreate {
+				t.Errorf("container removed = %v, want %v", removed, tc.recreate)
+			}
+		})
+	}
+}
+
+// TestSecretOwner covers named users, which must resolve to the container's
+// ids rather than the host's
+func TestSecretOwner(t *testing.T) {
+	mux := http.NewServeMux()
+	outputs := map[string]string{
+		"id -u app":        "1001\n",
+		"id -g app":        "1002\n",
+		"getent group dev": "dev:x:2000:app\n",
+	}
+	var mu sync.Mutex
+	execCmds := map[string]string{}
+	mux.HandleFunc("POST /containers/{id}/exec", func(w http.ResponseWriter, r *http.Request) {
+		var options container.ExecOptions
+		json.NewDecoder(r.Body).Decode(&options)
+		mu.Lock()
+		id := fmt.Sprintf("exec-%d", len(execCmds))
+		execCmds[id] = strings.Join(options.Cmd, " ")
+		mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"Id": id})
+	})
+	mux.HandleFunc("POST /exec/{id}/start", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		output := outputs[execCmds[r.PathValue("id")]]
+		mu.Unlock()
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("failed to hijack exec connection: %v", err)
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.multiplexed-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+		stdcopy.NewStdWriter(conn, stdcopy.Stdout).Write([]byte(output))
+	})
+	mux.HandleFunc("GET /exec/{id}/json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"ID": r.PathValue("id"), "ExitCode": 0})
+	})
+
+	cases := []struct{ user, uid, gid string }{
+		{"app", "1001", "1002"},
+		{"app:dev", "1001", "2000"},
+		{"app:50", "1001", "50"},
+		{"1000", "1000", "1000"},
+		{"1000:1000", "1000", "1000"},
+	}
+	for _, tc := range cases {
+		cm := &containerManager{docker: newFakeDocker(t, mux), config: &Config{User: tc.user}}
+		uid, gid, err := cm.secretOwner("c1")
+		if err != nil {
+			t.Fatalf("secretOwner() with user %q: %v", tc.user, err)
+		}
+		if uid != tc.uid || gid != tc.gid {
+			t.Errorf("secretOwner() with user %q = %s:%s, want %s:%s", tc.user, uid, gid, tc.uid, tc.gid)
+		}
+	}
+}
+
+func TestReconcileHistory(t *testing.T) {
+	cases := []struct {
+		name     string
+		persist  bool
+		mounts   []string
+		recreate bool
+	}{
+		{"mounted", true, []string{"/workspace", historyDir}, false},
+		{"turned on", true, []string{"/workspace"}, true},
+		{"off", false, []string{"/workspace"}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			removed := false
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /containers/json", func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode([]map[string]any{{"Id": "c1", "Names": []string{"/app-shell"}}})
+			})
+			mux.HandleFunc("GET /containers/{id}/json", func(w http.ResponseWriter, r *http.Request) {
+				var mounts []map[string]any
+				for _, m := range tc.mounts {
+					mounts = append(mounts, map[string]any{"Destination": m})
+				}
+				json.NewEncoder(w).Encode(map[string]any{"Id": "c1", "State": map[string]any{"Running": true}, "Mounts": mounts})
+			})
+			mux.HandleFunc("POST /containers/{id}/stop", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+			mux.HandleFunc("DELETE /containers/{id}", func(w http.ResponseWriter, r *http.Request) {
+				removed = true
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			cm := &containerManager{
+				docker:        newFakeDocker(t, mux),
+				config:        &Config{PersistHistory: tc.persist},
+				containerName: "app-shell",
+			}
+			if err := cm.reconcileHistory(); err != nil {
+				t.Fatal(err)
+			}
+			if removed != tc.recreate {
+				t.Errorf("container removed = %v, want %v", removed, tc.recreate)
+			}
+		})
+	}
+}
+
+func TestReconcileExtraHosts(t *testing.T) {
+	cases := []struct {
+		name        string
+		current     []string
+		extraHosts  []string
+		hostGateway bool
+		recreate    bool
+	}{
+		{"unchanged", []string{"api.local:10.0.0.5"}, []string{"api.local:10.0.0.5"}, false, false},
+		{"none", nil, nil, false, false},
+		{"gateway turned on", nil, nil, true, true},
+		{"gateway kept", []string{"host.docker.internal:host-gateway"}, nil, true, false},
+		{"gateway turned off", []string{"host.docker.internal:host-gateway"}, nil, false, true},
+		{"host added", nil, []string{"api.local:10.0.0.5"}, false, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			removed := false
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /info", func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(map[string]any{"OperatingSystem": "Ubuntu 24.04"})
+			})
+			mux.HandleFunc("GET /containers/json", func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode([]map[string]any{{"Id": "c1", "Names": []string{"/app-shell"}}})
+			})
+			mux.HandleFunc("GET /containers/{id}/json", func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(map[string]any{"Id": "c1", "HostConfig": map[string]any{"ExtraHosts": tc.current}})
+			})
+			mux.HandleFunc("POST /containers/{id}/stop", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+			mux.HandleFunc("DELETE /containers/{id}", func(w http.ResponseWriter, r *http.Request) {
+				removed = true
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			cm := &containerManager{
+				docker:        newFakeDocker(t, mux),
+				config:        &Config{ExtraHosts: tc.extraHosts, AddHostGateway: tc.hostGateway},
+				containerName: "app-shell",
+			}
+			if err := cm.reconcileExtraHosts(); err != nil {
+				t.Fatal(err)
+			}
+			if removed != tc.recreate {
+				t.Errorf("container removed = %v, want %v", removed, tc.recreate)
+			}
+		})
+	}
+}
+
+// TestChownHistoryDir covers the history volume's owner, which must be the
+// user execs run as: config.User or, without one, the image's USER
+func TestChownHistoryDir(t *testing.T) {
+	cases := []struct {
+		user string
+		want string
+	}{
+		{"app", "/iso _internal-chown 1001 1002 " + historyDir},
+		{"1000:1000", "/iso _internal-chown 1000 1000 " + historyDir},
+		{"root", ""},
+		{"", ""},
+	}
+
+	for _, tc := range cases {
+		var mu sync.Mutex
+		var execCmds []string
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /containers/{id}/json", func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]any{"Id": "c1", "Config": map[string]any{"User": tc.user}})
+		})
+		mux.HandleFunc("POST /containers/{id}/exec", func(w http.ResponseWriter, r *http.Request) {
+			var options container.ExecOptions
+			json.NewDecoder(r.Body).Decode(&options)
+			mu.Lock()
+			id := fmt.Sprintf("exec-%d", len(execCmds))
+			execCmds = append(execCmds, strings.Join(options.Cmd, " "))
+			mu.Unlock()
+			json.NewEncoder(w).Encode(map[string]string{"Id": id})
+		})
+		mux.HandleFunc("POST /exec/{id}/start", func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			output := map[string]string{"id -u app": "1001\n", "id -g app": "1002\n"}[execCmds[len(execCmds)-1]]
+			mu.Unlock()
+			conn, _, err := http.NewResponseController(w).Hijack()
+			if err != nil {
+				t.Errorf("failed to hijack exec connection: %v", err)
+				return
+			}
+			defer conn.Close()
+			io.WriteString(conn, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.multiplexed-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+			stdcopy.NewStdWriter(conn, stdcopy.Stdout).Write([]byte(output))
+		})
+		mux.HandleFunc("GET /exec/{id}/json", func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]any{"ID": r.PathValue("id"), "ExitCode": 0})
+		})
+
+		cm := &containerManager{docker: newFakeDocker(t, mux), config: &Config{PersistHistory: true}}
+		if err := cm.chownHistoryDir("c1"); err != nil {
+			t.Fatalf("chownHistoryDir() with user %q: %v", tc.user, err)
+		}
+		got := ""
+		if len(execCmds) > 0 && strings.HasPrefix(execCmds[len(execCmds)-1], "/iso") {
+			got = execCmds[len(execCmds)-1]
+		}
+		if got != tc.want {
+			t.Errorf("chownHistoryDir() with user %q ran %q, want %q", tc.user, got, tc.want)
+		}
+	}
+}
+
+func TestStartServicesInOrder(t *testing.T) {
+	services := map[string]ServiceConfig{
+		"app":      {DependsOn: []string{"postgres", "redis"}},
+		"postgres": {},
+		"redis":    {},
+		"mailhog":  {},
+	}
+
+	var mu sync.Mutex
+	var startedOrder []string
+	running, peak := 0, 0
+	err := startServicesInOrder(services, 2, func(name string, _ ServiceConfig) error {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		startedOrder = append(startedOrder, name)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("startServicesInOrder() unexpected error: %v", err)
+	}
+	if len(startedOrder) != len(services) {
+		t.Fatalf("started %v, want all %d services", startedOrder, len(services))
+	}
+	app := slices.Index(startedOrder, "app")
+	if app < slices.Index(startedOrder, "postgres") || app < slices.Index(startedOrder, "redis") {
+		t.Errorf("started %v, want app after postgres and redis", startedOrder)
+	}
+	if peak != 2 {
+		t.Errorf("peak concurrent starts = %d, want 2", peak)
+	}
+
+	// A failed dependency stops its dependents from starting
+	failure := errors.New("redis failed")
+	var attempted []string
+	err = startServicesInOrder(services, 1, func(name string, _ ServiceConfig) error {
+		attempted = append(attempted, name)
+		if name == "redis" {
+			return failure
+		}
+		return nil
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("startServicesInOrder() error = %v, want %v", err, failure)
+	}
+	if slices.Contains(attempted, "app") {
+		t.Errorf("attempted %v, app started although redis failed", attempted)
+	}
+}
+
+func TestWithHostGateway(t *testing.T) {
+	hosts := []string{"db.local:10.0.0.5"}
+	got := withHostGateway(hosts)
+	want := []string{"db.local:10.0.0.5", "host.docker.internal:host-gateway"}
+	if !slices.Equal(got, want) {
+		t.Errorf("withHostGateway() = %v, want %v", got, want)
+	}
+	if len(hosts) != 1 {
+		t.Errorf("withHostGateway() modified its input: %v", hosts)
+	}
+
+	// A user's own mapping of the name wins
+	for _, own := range []string{"host.docker.internal:172.17.0.1", "host.docker.internal=172.17.0.1"} {
+		if got := withHostGateway([]string{own}); !slices.Equal(got, []string{own}) {
+			t.Errorf("withHostGateway(%q) = %v, want it unchanged", own, got)
+		}
+	}
+
+	cm := &containerManager{config: &Config{ExtraHosts: hosts}}
+	if got := cm.extraHosts(hosts); !slices.Equal(got, hosts) {
+		t.Errorf("extraHosts() without add_host_gateway = %v, want %v", got, hosts)
+	}
+}
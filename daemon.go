@@ -0,0 +1,537 @@
+package iso
+
+// The iso daemon (`iso serve`) is an opt-in, long-lived process that keeps a
+// Docker connection and each project's loaded state (config, services,
+// extracted binary, resolved names) warm, so `run`, `status` and `list` in
+// tight loops skip the per-invocation setup. The CLI uses it when ISO_DAEMON=1
+// and falls back to direct mode whenever the daemon can't be reached.
+//
+// Protocol: the CLI connects to the daemon's Unix socket, writes one
+// JSON-encoded daemonRequest, reads one JSON-encoded daemonResponse and closes
+// the connection. For "run" the daemon only prepares the session (services,
+// container, readiness) and resolves the exec configuration; the CLI performs
+// the exec itself over its own Docker connection so stdin, stdout and the TTY
+// stay local to the terminal.
+//
+// Lifecycle: `iso serve` runs in the foreground until interrupted (SIGINT or
+// SIGTERM), then removes its socket. A stale socket left by a crashed daemon
+// is replaced on the next start; a live one makes the second daemon fail.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/moby/term"
+)
+
+// Daemon request operations
+const (
+	daemonOpList   = "list"
+	daemonOpStatus = "status"
+	daemonOpRun    = "run"
+)
+
+// ErrDaemonUnavailable is returned by the Daemon* client functions when no
+// daemon is listening on the socket
+var ErrDaemonUnavailable = errors.New("iso daemon is not running")
+
+// daemonRequest is sent by the CLI to the daemon
+type daemonRequest struct {
+	Op string `json:"op"`
+	// Dir is the CLI's working directory, used to find the project and to
+	// map the working directory into the container
+	Dir     string     `json:"dir,omitempty"`
+	Session string     `json:"session,omitempty"`
+	Command []string   `json:"command,omitempty"`
+	Options RunOptions `json:"options"`
+	// TTY reports whether the CLI will attach a terminal to the exec
+	TTY bool `json:"tty,omitempty"`
+	// Env is the CLI's environment, for env_passthrough and the ${VAR}
+	// references in the project configuration
+	Env []string `json:"env,omitempty"`
+}
+
+// daemonResponse is the daemon's reply to a daemonRequest
+type daemonResponse struct {
+	Error       string                 `json:"error,omitempty"`
+	Containers  []IsoContainer         `json:"containers,omitempty"`
+	Status      *Status                `json:"status,omitempty"`
+	ContainerID string                 `json:"container_id,omitempty"`
+	Exec        *container.ExecOptions `json:"exec,omitempty"`
+	// DockerHost and DockerTLSDir locate the Docker daemon that owns
+	// ContainerID, which the CLI attaches to the exec through
+	DockerHost   string `json:"docker_host,omitempty"`
+	DockerTLSDir string `json:"docker_tls_dir,omitempty"`
+}
+
+// DaemonEnabled reports whether the CLI should use the daemon (ISO_DAEMON=1
+// and no SetDockerContext override)
+func DaemonEnabled() bool {
+	// The daemon's Docker connection can't follow a per-invocation context
+	return os.Getenv("ISO_DAEMON") == "1" && dockerContextOverride == ""
+}
+
+// DaemonSocketPath returns the daemon's Unix socket path: ISO_DAEMON_SOCKET if
+// set, otherwise iso.sock in XDG_RUNTIME_DIR, falling back to a per-user
+// socket in the temp directory
+func DaemonSocketPath() string {
+	if path := os.Getenv("ISO_DAEMON_SOCKET"); path != "" {
+		return path
+	}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "iso.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("iso-%d.sock", os.Getuid()))
+}
+
+// daemonSession is a cached container manager for one project session
+type daemonSession struct {
+	mu sync.Mutex // serializes requests; containerManager isn't concurrency-safe
+	cm *containerManager
+	// stamp is the newest modification time in .iso when cm was loaded, used
+	// to reload after the project configuration changes
+	stamp time.Time
+	// hostVars are the host variables the configuration referenced and the
+	// values they had when cm was loaded, used to reload when a CLI's
+	// environment differs
+	hostVars map[string]hostVar
+}
+
+// hostVar is the value of a host variable, ok reporting whether it was set
+type hostVar struct {
+	value string
+	ok    bool
+}
+
+// hostVarsMatch reports whether lookup gives the referenced host variables
+// the values s was loaded with
+func (s *daemonSession) hostVarsMatch(lookup hostLookup) bool {
+	for name, want := range s.hostVars {
+		value, ok := lookup(name)
+		if (hostVar{value, ok}) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// envLookup returns a hostLookup over env, a list of KEY=value entries. A nil
+// env (from a CLI that doesn't send one) falls back to the daemon's own.
+func envLookup(env []string) hostLookup {
+	if env == nil {
+		return os.LookupEnv
+	}
+	vars := make(map[string]string, len(env))
+	for _, entry := range env {
+		if key, value, ok := strings.Cut(entry, "="); ok {
+			vars[key] = value
+		}
+	}
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+// daemon serves requests from the CLI
+type daemon struct {
+	docker *dockerClient
+
+	mu       sync.Mutex
+	sessions map[string]*daemonSession
+	// loading serializes loading each session, so a slow project load only
+	// holds up requests for that session
+	loading map[string]*sync.Mutex
+}
+
+// Serve runs the daemon on socketPath until ctx is cancelled
+func Serve(ctx context.Context, socketPath string) error {
+	// Refuse to replace a live daemon, but clean up a stale socket
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		conn.Close()
+		return fmt.Errorf("an iso daemon is already listening on %s", socketPath)
+	}
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0700); err != nil {
+		return fmt.Errorf("failed to create socket directory: %w", err)
+	}
+
+	// Docker may not be running yet; requests report it when they need it
+	docker, err := openDockerClient("")
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		docker.close()
+		return fmt.Errorf("failed to listen on %s: %w", socketPath, err)
+	}
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		docker.close()
+		return fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+
+	d := &daemon{
+		docker:   docker,
+		sessions: make(map[string]*daemonSession),
+		loading:  make(map[string]*sync.Mutex),
+	}
+	defer d.close()
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	go d.reapLoop(ctx)
+
+	slog.Info("iso daemon listening", "socket", socketPath)
+
+	var wg sync.WaitGroup
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			slog.Warn("failed to accept connection", "error", err)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.handle(conn)
+		}()
+	}
+
+	wg.Wait()
+	slog.Info("iso daemon stopped")
+	return nil
+}
+
+// daemonReapInterval is how often the daemon stops idle sessions
+const daemonReapInterval = time.Minute
+
+// reapLoop stops sessions idle past their idle_timeout every
+// daemonReapInterval until ctx is cancelled
+func (d *daemon) reapLoop(ctx context.Context) {
+	ticker := time.NewTicker(daemonReapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reaped, err := d.docker.reapIdleSessions(false)
+			if err != nil {
+				slog.Debug("failed to reap idle sessions", "error", err)
+				continue
+			}
+			for _, s := range reaped {
+				slog.Info("stopped idle session", "project", s.ProjectName, "session", s.Session, "containers", len(s.Containers))
+			}
+		}
+	}
+}
+
+// close releases the daemon's Docker connections
+func (d *daemon) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, s := range d.sessions {
+		s.cm.close()
+	}
+	d.docker.close()
+}
+
+// handle serves a single request on conn
+func (d *daemon) handle(conn net.Conn) {
+	defer conn.Close()
+
+	var req daemonRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		slog.Warn("failed to decode daemon request", "error", err)
+		return
+	}
+
+	resp, err := d.dispatch(req)
+	if err != nil {
+		resp = &daemonResponse{Error: err.Error()}
+	}
+
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		slog.Warn("failed to send daemon response", "op", req.Op, "error", err)
+	}
+}
+
+// dispatch performs the operation requested by req
+func (d *daemon) dispatch(req daemonRequest) (*daemonResponse, error) {
+	slog.Debug("daemon request", "op", req.Op, "dir", req.Dir, "session", req.Session)
+
+	switch req.Op {
+	case daemonOpList:
+		containers, err := d.docker.listIsoContainers()
+		if err != nil {
+			return nil, err
+		}
+		return &daemonResponse{Containers: toIsoContainers(containers)}, nil
+
+	case daemonOpStatus:
+		s, err := d.session(req.Dir, req.Session, req.Env)
+		if err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		status, err := (&Client{containerManager: s.cm}).Status()
+		if err != nil {
+			return nil, err
+		}
+		return &daemonResponse{Status: status}, nil
+
+	case daemonOpRun:
+		if req.Options.Ephemeral {
+			return nil, fmt.Errorf("ephemeral runs are not supported by the daemon")
+		}
+
+		s, err := d.session(req.Dir, req.Session, req.Env)
+		if err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if err := s.cm.selectServices(req.Options); err != nil {
+			return nil, err
+		}
+		s.cm.hostEnv = req.Env
+
+		execConfig, err := s.cm.buildExecConfig(req.Command, req.Options, req.Dir, req.TTY)
+		if err != nil {
+			return nil, err
+		}
+
+		// Persistent sessions have nothing to clean up after the exec
+		containerID, _, err := s.cm.prepareRun(false)
+		if err != nil {
+			return nil, err
+		}
+		if req.Options.WorkDir != "" {
+			if err := s.cm.checkWorkDir(containerID, execConfig.WorkingDir); err != nil {
+				return nil, err
+			}
+		}
+		return &daemonResponse{
+			ContainerID:  containerID,
+			Exec:         &execConfig,
+			DockerHost:   s.cm.docker.endpoint.host,
+			DockerTLSDir: s.cm.docker.endpoint.tlsDir,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown daemon operation %q", req.Op)
+	}
+}
+
+// session returns the cached container manager for the project containing
+// dir, loading it on first use and reloading it when .iso has changed or env
+// gives the host variables the configuration references different values
+func (d *daemon) session(dir, session string, env []string) (*daemonSession, error) {
+	isoDir, projectRoot, found := findIsoDirFrom(dir)
+	if !found {
+		return nil, ErrNoIsoDir
+	}
+	if session == "" {
+		session = "default"
+	}
+
+	stamp, err := latestModTime(isoDir)
+	if err != nil {
+		return nil, err
+	}
+
+	key := projectRoot + "\x00" + session
+	d.mu.Lock()
+	loading, ok := d.loading[key]
+	if !ok {
+		loading = &sync.Mutex{}
+		d.loading[key] = loading
+	}
+	d.mu.Unlock()
+
+	// d.mu only guards the maps: loading a project talks to Docker and may
+	// take a while
+	loading.Lock()
+	defer loading.Unlock()
+
+	d.mu.Lock()
+	s, cached := d.sessions[key]
+	d.mu.Unlock()
+
+	lookup := envLookup(env)
+	if cached {
+		if !stamp.After(s.stamp) && s.hostVarsMatch(lookup) {
+			return s, nil
+		}
+		slog.Debug("project configuration changed, reloading", "project", projectRoot, "session", session)
+		s.mu.Lock()
+		s.cm.close()
+		s.mu.Unlock()
+		d.mu.Lock()
+		delete(d.sessions, key)
+		d.mu.Unlock()
+	}
+
+	hostVars := make(map[string]hostVar)
+	cm, err := newContainerManagerWith(session, projectRoot, func(name string) (string, bool) {
+		value, ok := lookup(name)
+		hostVars[name] = hostVar{value, ok}
+		return value, ok
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s = &daemonSession{cm: cm, stamp: stamp, hostVars: hostVars}
+	d.mu.Lock()
+	d.sessions[key] = s
+	d.mu.Unlock()
+	return s, nil
+}
+
+// latestModTime returns the newest modification time of dir and its entries
+func latestModTime(dir string) (time.Time, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to stat %s: %w", dir, err)
+	}
+	latest := info.ModTime()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// daemonCall sends req to the daemon and returns its response
+func daemonCall(req daemonRequest) (*daemonResponse, error) {
+	conn, err := net.Dial("unix", DaemonSocketPath())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDaemonUnavailable, err)
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("failed to send daemon request: %w", err)
+	}
+
+	var resp daemonResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to read daemon response: %w", err)
+	}
+	if resp.Error != "" {
+		if resp.Error == ErrNoIsoDir.Error() {
+			return nil, ErrNoIsoDir
+		}
+		return nil, errors.New(resp.Error)
+	}
+
+	return &resp, nil
+}
+
+// DaemonList returns all ISO-managed containers via the daemon
+func DaemonList() ([]IsoContainer, error) {
+	resp, err := daemonCall(daemonRequest{Op: daemonOpList})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Containers, nil
+}
+
+// DaemonStatus returns the status of a session of the current project via
+// the daemon
+func DaemonStatus(session string) (*Status, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	resp, err := daemonCall(daemonRequest{Op: daemonOpStatus, Dir: cwd, Session: session, Env: os.Environ()})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Status, nil
+}
+
+// DaemonRun runs a command in a persistent session of the current project:
+// the daemon prepares the session and this process attaches to the exec.
+// Returns the command's exit code.
+func DaemonRun(session string, command []string, opts RunOptions) (int, error) {
+	if len(command) == 0 {
+		return 0, fmt.Errorf("no command specified")
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	resp, err := daemonCall(daemonRequest{
+		Op:      daemonOpRun,
+		Dir:     cwd,
+		Session: session,
+		Command: command,
+		Options: opts,
+		TTY:     opts.TTY || term.IsTerminal(os.Stdin.Fd()),
+		Env:     os.Environ(),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Attach through the Docker daemon the session was prepared on, which
+	// depends on the daemon's environment and the project's context. An older
+	// daemon doesn't report it, so use this process's environment.
+	var endpoint *dockerEndpoint
+	if resp.DockerHost != "" {
+		endpoint = &dockerEndpoint{host: resp.DockerHost, tlsDir: resp.DockerTLSDir}
+	}
+	docker, err := openDockerEndpoint(endpoint)
+	if err != nil {
+		return 0, err
+	}
+	defer docker.close()
+
+	cm := &containerManager{docker: docker}
+	return cm.execAttached(resp.ContainerID, *resp.Exec, opts.Interactive, opts.ExecTimeout)
+}
@@ -0,0 +1,127 @@
+package iso
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestDaemonProtocol exercises the socket lifecycle and request/response
+// framing without touching Docker
+func TestDaemonProtocol(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "iso.sock")
+	t.Setenv("ISO_DAEMON_SOCKET", socketPath)
+
+	if _, err := DaemonList(); !errors.Is(err, ErrDaemonUnavailable) {
+		t.Fatalf("DaemonList() without a daemon: got %v, want ErrDaemonUnavailable", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Serve(ctx, socketPath) }()
+
+	// Wait for the socket to accept connections
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("unix", socketPath)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("daemon did not start listening: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := Serve(context.Background(), socketPath); err == nil || !strings.Contains(err.Error(), "already listening") {
+		t.Fatalf("second Serve: got %v, want already listening error", err)
+	}
+
+	_, err := daemonCall(daemonRequest{Op: "bogus"})
+	if err == nil || !strings.Contains(err.Error(), `unknown daemon operation "bogus"`) {
+		t.Fatalf("daemonCall(bogus): got %v, want unknown operation error", err)
+	}
+
+	_, err = daemonCall(daemonRequest{Op: daemonOpRun, Options: RunOptions{Ephemeral: true}})
+	if err == nil || !strings.Contains(err.Error(), "ephemeral") {
+		t.Fatalf("daemonCall(ephemeral run): got %v, want ephemeral error", err)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Serve returned %v", err)
+	}
+	if _, err := DaemonList(); !errors.Is(err, ErrDaemonUnavailable) {
+		t.Fatalf("DaemonList() after shutdown: got %v, want ErrDaemonUnavailable", err)
+	}
+}
+
+// TestDaemonSessionHostVars checks that the daemon expands ${VAR} with the
+// calling CLI's environment and reloads when a referenced value changes
+func TestDaemonSessionHostVars(t *testing.T) {
+	root := t.TempDir()
+	isoDir := filepath.Join(root, ".iso")
+	if err := os.Mkdir(isoDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	config := "image: alpine:3\nenvironment:\n  TOKEN: ${ISO_TEST_TOKEN}\n"
+	if err := os.WriteFile(filepath.Join(isoDir, "config.yml"), []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ISO_TEST_TOKEN", "daemon")
+
+	// Loading a session only pings Docker and asks for its architecture
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Api-Version", fakeDockerAPIVersion)
+		if strings.HasSuffix(r.URL.Path, "/info") {
+			json.NewEncoder(w).Encode(map[string]string{"Architecture": "x86_64"})
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("DOCKER_HOST", "tcp://"+server.Listener.Addr().String())
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	d := &daemon{sessions: make(map[string]*daemonSession), loading: make(map[string]*sync.Mutex)}
+	t.Cleanup(func() {
+		for _, s := range d.sessions {
+			s.cm.close()
+		}
+	})
+
+	first, err := d.session(root, "", []string{"ISO_TEST_TOKEN=one", "OTHER=a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := first.cm.config.Environment["TOKEN"]; got != "one" {
+		t.Fatalf("TOKEN = %q, want the CLI's value %q", got, "one")
+	}
+
+	same, err := d.session(root, "", []string{"ISO_TEST_TOKEN=one", "OTHER=b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same != first {
+		t.Fatal("session reloaded after an unreferenced variable changed")
+	}
+
+	changed, err := d.session(root, "", []string{"OTHER=b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed == first {
+		t.Fatal("session not reloaded after a referenced variable was unset")
+	}
+	if got := changed.cm.config.Environment["TOKEN"]; got != "" {
+		t.Fatalf("TOKEN = %q, want empty", got)
+	}
+}
@@ -0,0 +1,231 @@
+package iso
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/volume"
+)
+
+// Volume kinds, recorded in the iso.volume label and reported by DiskUsage
+const (
+	volumeKindSession = "session"
+	volumeKindCache   = "cache"
+	volumeKindService = "service"
+	volumeKindShared  = "shared"
+)
+
+// DiskUsageItem is one image or volume created by ISO
+type DiskUsageItem struct {
+	// ProjectName is the owning project ("" for shared volumes and images
+	// whose project can't be told)
+	ProjectName string `json:"project"`
+	// Session is set for session volumes created by this version of ISO
+	Session string `json:"session,omitempty"`
+	// Kind is "image", or the volume kind: "session", "cache", "service" or
+	// "shared"
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Size is in bytes, or -1 if Docker didn't report it
+	Size int64 `json:"size"`
+}
+
+// DiskUsage reports the size of every image and volume ISO created, across
+// all projects, sorted by project, kind and name. Volumes created by older
+// versions of ISO carry no iso.* labels and are matched to projects by name.
+// This function does not require being in a project directory
+func DiskUsage() ([]DiskUsageItem, error) {
+	docker, err := newDockerClient()
+	if err != nil {
+		return nil, err
+	}
+	defer docker.close()
+
+	return docker.isoDiskUsage()
+}
+
+// PruneAllCaches removes the cache volumes of every project, independent of
+// the current directory. Volumes used by a container are skipped and listed
+// in InUse. With dryRun, Removed lists the volumes that would be removed and
+// nothing is removed.
+// This function does not require being in a project directory
+func PruneAllCaches(dryRun bool) (*PruneResult, error) {
+	docker, err := newDockerClient()
+	if err != nil {
+		return nil, err
+	}
+	defer docker.close()
+
+	items, err := docker.isoDiskUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PruneResult{Removed: []string{}, InUse: []string{}, DryRun: dryRun}
+	for _, item := range items {
+		if item.Kind != volumeKindCache {
+			continue
+		}
+
+		if dryRun {
+			inUse, err := docker.volumeInUse(item.Name)
+			if err != nil {
+				return nil, err
+			}
+			if inUse {
+				result.InUse = append(result.InUse, item.Name)
+				continue
+			}
+		} else {
+			slog.Info("removing cache volume", "volume", item.Name, "project", item.ProjectName)
+			if err := docker.removeVolume(item.Name); err != nil {
+				if isVolumeInUseError(err) {
+					slog.Warn("cache volume is in use, skipping", "volume", item.Name)
+					result.InUse = append(result.InUse, item.Name)
+					continue
+				}
+				slog.Warn("failed to remove cache volume", "volume", item.Name, "error", err)
+				continue
+			}
+		}
+
+		result.Removed = append(result.Removed, item.Name)
+		if item.Size > 0 {
+			result.BytesFreed += item.Size
+		}
+	}
+
+	return result, nil
+}
+
+// isoDiskUsage lists the images and volumes ISO created, for DiskUsage
+func (d *dockerClient) isoDiskUsage() ([]DiskUsageItem, error) {
+	usage, err := d.client.DiskUsage(d.ctx, types.DiskUsageOptions{
+		Types: []types.DiskUsageObject{types.ImageObject, types.VolumeObject},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get disk usage: %w", err)
+	}
+
+	containers, err := d.listIsoContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	var items []DiskUsageItem
+	projects := make(map[string]bool)
+	for _, c := range containers {
+		projects[c.ProjectName] = true
+	}
+	for _, img := range usage.Images {
+		if item, ok := classifyImage(img); ok {
+			items = append(items, item)
+			if item.ProjectName != "" {
+				projects[item.ProjectName] = true
+			}
+		}
+	}
+	for _, vol := range usage.Volumes {
+		if vol.Labels["iso.managed"] == "true" && vol.Labels["iso.project.name"] != "" {
+			projects[vol.Labels["iso.project.name"]] = true
+		}
+	}
+	for _, vol := range usage.Volumes {
+		if item, ok := classifyVolume(vol, projects); ok {
+			items = append(items, item)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if a.ProjectName != b.ProjectName {
+			return a.ProjectName < b.ProjectName
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+	return items, nil
+}
+
+// classifyImage reports whether img was built by ISO (it carries the build
+// hash label) and which project it belongs to
+func classifyImage(img *image.Summary) (DiskUsageItem, bool) {
+	if img.Labels[buildHashLabel] == "" {
+		return DiskUsageItem{}, false
+	}
+
+	item := DiskUsageItem{
+		ProjectName: img.Labels["iso.project.name"],
+		Kind:        "image",
+		Name:        strings.TrimPrefix(img.ID, "sha256:"),
+		Size:        img.Size,
+	}
+	if len(item.Name) > 12 {
+		item.Name = item.Name[:12]
+	}
+
+	for _, tag := range img.RepoTags {
+		if tag == "<none>:<none>" {
+			continue
+		}
+		item.Name = tag
+		// Images are named <project>-shell, optionally with a build target
+		repo := tag
+		if i := strings.LastIndex(tag, ":"); i > strings.LastIndex(tag, "/") {
+			repo = tag[:i]
+		}
+		if i := strings.LastIndex(repo, "-shell"); i > 0 && item.ProjectName == "" {
+			item.ProjectName = repo[:i]
+		}
+		break
+	}
+	return item, true
+}
+
+// classifyVolume reports whether vol was created by ISO, and its project,
+// kind and session. Labeled volumes describe themselves; unlabeled ones are
+// matched by name against the known project names.
+func classifyVolume(vol *volume.Volume, projects map[string]bool) (DiskUsageItem, bool) {
+	item := DiskUsageItem{Name: vol.Name, Size: -1}
+	if vol.UsageData != nil {
+		item.Size = vol.UsageData.Size
+	}
+
+	if vol.Labels["iso.managed"] == "true" {
+		item.ProjectName = vol.Labels["iso.project.name"]
+		item.Kind = vol.Labels["iso.volume"]
+		item.Session = vol.Labels["iso.session"]
+		return item, item.Kind != ""
+	}
+
+	if strings.HasPrefix(vol.Name, "iso-shared-") {
+		item.Kind = volumeKindShared
+		return item, true
+	}
+
+	// Use the longest matching project, so "app-api" wins over "app"
+	for project := range projects {
+		if strings.HasPrefix(vol.Name, project+"-") && len(project) > len(item.ProjectName) {
+			item.ProjectName = project
+		}
+	}
+	if item.ProjectName == "" {
+		return DiskUsageItem{}, false
+	}
+
+	switch rest := strings.TrimPrefix(vol.Name, item.ProjectName+"-"); {
+	case strings.HasPrefix(rest, "cache-"):
+		item.Kind = volumeKindCache
+	case strings.HasPrefix(rest, "svc-"):
+		item.Kind = volumeKindService
+	default:
+		item.Kind = volumeKindSession
+	}
+	return item, true
+}
@@ -0,0 +1,83 @@
+package iso
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/volume"
+)
+
+func TestClassifyVolume(t *testing.T) {
+	projects := map[string]bool{"app": true, "app-api": true}
+
+	cases := []struct {
+		name    string
+		labels  map[string]string
+		want    DiskUsageItem
+		wantIso bool
+	}{
+		{
+			name:    "app-feature-workspace-data",
+			labels:  map[string]string{"iso.managed": "true", "iso.project.name": "app", "iso.volume": "session", "iso.session": "feature"},
+			want:    DiskUsageItem{ProjectName: "app", Session: "feature", Kind: "session"},
+			wantIso: true,
+		},
+		{name: "app-cache-root-go", want: DiskUsageItem{ProjectName: "app", Kind: "cache"}, wantIso: true},
+		{name: "app-api-svc-db-data", want: DiskUsageItem{ProjectName: "app-api", Kind: "service"}, wantIso: true},
+		{name: "app-api-workspace-tmp", want: DiskUsageItem{ProjectName: "app-api", Kind: "session"}, wantIso: true},
+		{name: "iso-shared-models", want: DiskUsageItem{Kind: "shared"}, wantIso: true},
+		{name: "other-data"},
+		{name: "app_compose_data"},
+	}
+
+	for _, tc := range cases {
+		vol := &volume.Volume{Name: tc.name, Labels: tc.labels, UsageData: &volume.UsageData{Size: 42}}
+		got, ok := classifyVolume(vol, projects)
+		if ok != tc.wantIso {
+			t.Errorf("classifyVolume(%q) ok = %v, want %v", tc.name, ok, tc.wantIso)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		tc.want.Name = tc.name
+		tc.want.Size = 42
+		if got != tc.want {
+			t.Errorf("classifyVolume(%q) = %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestClassifyImage(t *testing.T) {
+	built := map[string]string{buildHashLabel: "abc"}
+
+	cases := []struct {
+		img     image.Summary
+		want    DiskUsageItem
+		wantIso bool
+	}{
+		{
+			img:     image.Summary{ID: "sha256:0123456789abcdef", RepoTags: []string{"app-shell:latest"}, Labels: built, Size: 7},
+			want:    DiskUsageItem{ProjectName: "app", Kind: "image", Name: "app-shell:latest", Size: 7},
+			wantIso: true,
+		},
+		{
+			img:     image.Summary{ID: "sha256:0123456789abcdef", RepoTags: []string{"app-shell-dev:linux-arm64"}, Labels: built, Size: 7},
+			want:    DiskUsageItem{ProjectName: "app", Kind: "image", Name: "app-shell-dev:linux-arm64", Size: 7},
+			wantIso: true,
+		},
+		{
+			img:     image.Summary{ID: "sha256:0123456789abcdef", Labels: map[string]string{buildHashLabel: "abc", "iso.project.name": "app"}, Size: 7},
+			want:    DiskUsageItem{ProjectName: "app", Kind: "image", Name: "0123456789ab", Size: 7},
+			wantIso: true,
+		},
+		{img: image.Summary{ID: "sha256:0123456789abcdef", RepoTags: []string{"postgres:16"}}},
+	}
+
+	for _, tc := range cases {
+		got, ok := classifyImage(&tc.img)
+		if ok != tc.wantIso || got != tc.want {
+			t.Errorf("classifyImage(%v) = %+v, %v, want %+v, %v", tc.img.RepoTags, got, ok, tc.want, tc.wantIso)
+		}
+	}
+}
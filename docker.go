@@ -2,13 +2,25 @@ package iso
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/build"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
@@ -16,26 +28,124 @@ import (
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-units"
 	"github.com/moby/go-archive"
+	"github.com/moby/patternmatcher/ignorefile"
 )
 
 // dockerClient wraps the Docker API client
 type dockerClient struct {
 	client *client.Client
 	ctx    context.Context
+	// endpoint is the daemon the client connects to, which the iso daemon
+	// hands to the CLI so both use the same Docker daemon
+	endpoint dockerEndpoint
 }
 
+// operationCtx is the context Docker clients are created with, see
+// SetOperationContext
+var operationCtx = context.Background()
+
+// SetOperationContext sets the context every Docker operation runs under, so
+// cancelling it (on Ctrl+C, or when a timeout expires) aborts a hung daemon
+// call such as a stuck pull or build instead of blocking forever. It applies
+// to Docker clients created afterwards, so call it before New.
+func SetOperationContext(ctx context.Context) {
+	operationCtx = ctx
+}
+
+// teardownTimeout bounds cleanup after a run, which carries on after Ctrl+C
+// or --timeout cancel the operation context
+const teardownTimeout = 30 * time.Second
+
+// detached returns a copy of d for cleanup, whose operations aren't cancelled
+// with d's context but give up after timeout instead. Call the returned
+// function when done.
+func (d *dockerClient) detached(timeout time.Duration) (*dockerClient, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(d.ctx), timeout)
+	return &dockerClient{client: d.client, ctx: ctx, endpoint: d.endpoint}, cancel
+}
+
+// dockerPingTimeout bounds the initial ping, so an unreachable remote daemon
+// fails fast instead of hanging
+const dockerPingTimeout = 10 * time.Second
+
 // newDockerClient creates a new Docker client
 func newDockerClient() (*dockerClient, error) {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	return newDockerClientFor("")
+}
+
+// newDockerClientFor is newDockerClient for a project whose config.yml
+// selects contextName ("" for none). The endpoint comes from the environment
+// or a Docker CLI context, see resolveDockerEndpoint. Fails early with a clear
+// error if the daemon isn't reachable.
+func newDockerClientFor(contextName string) (*dockerClient, error) {
+	d, err := openDockerClient(contextName)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.ping(); err != nil {
+		d.close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// openDockerClient is newDockerClientFor without contacting the daemon, for
+// long-running processes that should tolerate Docker starting later
+func openDockerClient(contextName string) (*dockerClient, error) {
+	endpoint, err := resolveDockerEndpoint(dockerConfigDir(), contextName)
+	if err != nil {
+		return nil, err
+	}
+	return openDockerEndpoint(endpoint)
+}
+
+// openDockerEndpoint is openDockerClient for endpoint, or for the daemon the
+// environment selects (DOCKER_HOST, DOCKER_CERT_PATH) if endpoint is nil
+func openDockerEndpoint(endpoint *dockerEndpoint) (*dockerClient, error) {
+	opts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+	if endpoint != nil {
+		slog.Debug("using docker context", "context", endpoint.context, "host", endpoint.host)
+		opts = append(opts, client.WithHost(endpoint.host))
+		if endpoint.tlsDir != "" {
+			opts = append(opts, client.WithTLSClientConfig(
+				filepath.Join(endpoint.tlsDir, "ca.pem"),
+				filepath.Join(endpoint.tlsDir, "cert.pem"),
+				filepath.Join(endpoint.tlsDir, "key.pem"),
+			))
+		}
+	}
+
+	cli, err := client.NewClientWithOpts(opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Docker client: %w", err)
 	}
 
-	return &dockerClient{
-		client: cli,
-		ctx:    context.Background(),
-	}, nil
+	d := &dockerClient{
+		client:   cli,
+		ctx:      operationCtx,
+		endpoint: dockerEndpoint{host: cli.DaemonHost(), tlsDir: os.Getenv("DOCKER_CERT_PATH")},
+	}
+	if endpoint != nil {
+		d.endpoint = *endpoint
+	}
+	return d, nil
+}
+
+// ping checks that the daemon is reachable. The client connects lazily, so
+// without it an unreachable daemon surfaces as a connection error in the
+// middle of an operation. The ping also serves API version negotiation.
+func (d *dockerClient) ping() error {
+	ctx, cancel := context.WithTimeout(d.ctx, dockerPingTimeout)
+	defer cancel()
+	resp, err := d.client.Ping(ctx)
+	if err != nil {
+		return fmt.Errorf("Docker daemon not reachable at %s - is Docker running? (%w)", d.client.DaemonHost(), err)
+	}
+	d.client.NegotiateAPIVersionPing(resp)
+	return nil
 }
 
 // close closes the Docker client connection
@@ -92,6 +202,61 @@ func (d *dockerClient) stopAndRemoveContainer(containerID, containerName string,
 	return true, nil
 }
 
+// containerLogTail returns the last n lines of a container's stdout and stderr
+func (d *dockerClient) containerLogTail(containerID string, n int) (string, error) {
+	out, err := d.client.ContainerLogs(d.ctx, containerID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       strconv.Itoa(n),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get container logs: %w", err)
+	}
+	defer out.Close()
+
+	var buf bytes.Buffer
+	if _, err := stdcopy.StdCopy(&buf, &buf, out); err != nil {
+		return "", fmt.Errorf("failed to read container logs: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// containerLogsSince returns a container's stdout and stderr since the given
+// timestamp ("" for all of it)
+func (d *dockerClient) containerLogsSince(containerID, since string) (string, error) {
+	out, err := d.client.ContainerLogs(d.ctx, containerID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Since:      since,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get container logs: %w", err)
+	}
+	defer out.Close()
+
+	var buf bytes.Buffer
+	if _, err := stdcopy.StdCopy(&buf, &buf, out); err != nil {
+		return "", fmt.Errorf("failed to read container logs: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// isMissingImageError reports whether err indicates that a container's image
+// no longer exists
+func isMissingImageError(err error) bool {
+	errStr := strings.ToLower(err.Error())
+	if strings.Contains(errStr, "no such image") {
+		return true
+	}
+	return strings.Contains(errStr, "image") && strings.Contains(errStr, "not found")
+}
+
+// isVolumeInUseError reports whether err indicates that a volume couldn't be
+// removed because a container still uses it
+func isVolumeInUseError(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "volume is in use")
+}
+
 // getArchitecture returns the architecture Docker is using for containers
 func (d *dockerClient) getArchitecture() (string, error) {
 	info, err := d.client.Info(d.ctx)
@@ -110,34 +275,160 @@ func (d *dockerClient) getArchitecture() (string, error) {
 	}
 }
 
+// attachExec attaches to an exec, giving up when ctx is done. The Docker
+// client only honors ctx while dialing, not while it waits for the daemon to
+// upgrade the connection, so a daemon that accepts the connection and never
+// answers would block forever. The attach runs in the background instead, and
+// a connection it establishes after ctx is done is closed.
+func (d *dockerClient) attachExec(ctx context.Context, execID string, options container.ExecStartOptions) (types.HijackedResponse, error) {
+	type result struct {
+		resp types.HijackedResponse
+		err  error
+	}
+	resultChan := make(chan result, 1)
+	go func() {
+		resp, err := d.client.ContainerExecAttach(ctx, execID, options)
+		resultChan <- result{resp: resp, err: err}
+	}()
+
+	select {
+	case res := <-resultChan:
+		return res.resp, res.err
+	case <-ctx.Done():
+		go func() {
+			if res := <-resultChan; res.err == nil {
+				res.resp.Close()
+			}
+		}()
+		return types.HijackedResponse{}, ctx.Err()
+	}
+}
+
+// isDockerDesktop reports whether the daemon is Docker Desktop's, whose VM
+// resolves host.docker.internal on its own
+func (d *dockerClient) isDockerDesktop() (bool, error) {
+	info, err := d.client.Info(d.ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get Docker info: %w", err)
+	}
+	return info.OperatingSystem == "Docker Desktop", nil
+}
+
+// serverVersion returns the Docker daemon's version and the API version the
+// client negotiated with it
+func (d *dockerClient) serverVersion() (string, string, error) {
+	v, err := d.client.ServerVersion(d.ctx)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get Docker version: %w", err)
+	}
+	return v.Version, d.client.ClientVersion(), nil
+}
+
+// imageBuildOptions are the project settings applied to image builds
+type imageBuildOptions struct {
+	// contextDir is the directory sent as the build context ("" for the
+	// project root, the parent of the Dockerfile's .iso directory)
+	contextDir string
+	buildArgs  map[string]string
+	// target is the Dockerfile stage to build ("" for the final stage)
+	target string
+	labels map[string]string
+	// noCache builds every layer from scratch instead of reusing cached ones
+	noCache bool
+	// pull re-pulls base images instead of using the locally cached ones
+	pull bool
+}
+
+// buildContextExcludes returns the patterns of files left out of the build
+// context in buildContext: the entries of its .dockerignore plus the files iso
+// generates in .iso, such as the multi-megabyte extracted Linux binary.
+// dockerfile is the Dockerfile's slash-separated path within the context.
+func buildContextExcludes(buildContext, dockerfile string) ([]string, error) {
+	var excludes []string
+
+	f, err := os.Open(filepath.Join(buildContext, ".dockerignore"))
+	if err == nil {
+		excludes, err = ignorefile.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read .dockerignore: %w", err)
+		}
+		// Like the docker CLI, never exclude what the build itself needs
+		excludes = append(excludes, "!"+dockerfile, "!.dockerignore")
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to open .dockerignore: %w", err)
+	}
+
+	for _, pattern := range generatedIsoFiles {
+		excludes = append(excludes, path.Join(".iso", pattern))
+	}
+	return excludes, nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
 // buildImage builds a Docker image from a Dockerfile
-func (d *dockerClient) buildImage(dockerfilePath, imageName string) error {
-	// Get the directory containing the Dockerfile
-	buildContext := filepath.Dir(filepath.Dir(dockerfilePath))
+func (d *dockerClient) buildImage(dockerfilePath, imageName string, buildOpts imageBuildOptions) error {
+	// Default to the directory containing .iso
+	buildContext := buildOpts.contextDir
 	if buildContext == "" {
-		buildContext = "."
+		buildContext = filepath.Dir(filepath.Dir(dockerfilePath))
+	}
+	dockerfile, err := filepath.Rel(buildContext, dockerfilePath)
+	if err != nil || !filepath.IsLocal(dockerfile) {
+		return fmt.Errorf("Dockerfile %s is outside the build context %s", dockerfilePath, buildContext)
+	}
+	dockerfile = filepath.ToSlash(dockerfile)
+
+	excludes, err := buildContextExcludes(buildContext, dockerfile)
+	if err != nil {
+		return err
 	}
 
 	// Create a tar archive of the build context
-	tar, err := archive.TarWithOptions(buildContext, &archive.TarOptions{})
+	tar, err := archive.TarWithOptions(buildContext, &archive.TarOptions{ExcludePatterns: excludes})
 	if err != nil {
 		return fmt.Errorf("failed to create build context: %w", err)
 	}
 	defer tar.Close()
+	contextSize := &countingReader{r: tar}
 
 	// Build the image
 	opts := build.ImageBuildOptions{
 		Tags:       []string{imageName},
-		Dockerfile: filepath.Join(".iso", filepath.Base(dockerfilePath)),
+		Dockerfile: dockerfile,
 		Remove:     true,
-		Context:    tar,
+		Context:    contextSize,
+		Target:     buildOpts.target,
+		Labels:     buildOpts.labels,
+		NoCache:    buildOpts.noCache,
+		PullParent: buildOpts.pull,
+	}
+	if len(buildOpts.buildArgs) > 0 {
+		opts.BuildArgs = make(map[string]*string, len(buildOpts.buildArgs))
+		for key, value := range buildOpts.buildArgs {
+			opts.BuildArgs[key] = &value
+		}
 	}
 
-	resp, err := d.client.ImageBuild(d.ctx, tar, opts)
+	resp, err := d.client.ImageBuild(d.ctx, contextSize, opts)
 	if err != nil {
 		return fmt.Errorf("failed to build image: %w", err)
 	}
 	defer resp.Body.Close()
+	defer func() {
+		slog.Debug("sent build context", "dir", buildContext, "bytes", contextSize.n, "size", units.HumanSize(float64(contextSize.n)))
+	}()
 
 	// Parse and display build output
 	type buildMessage struct {
@@ -179,6 +470,55 @@ func (d *dockerClient) buildImage(dockerfilePath, imageName string) error {
 	return nil
 }
 
+// buildxArgs returns the `docker buildx build` arguments that build the
+// project Dockerfile for platforms and either push the result to tag or load
+// it into the local image store under tag
+func buildxArgs(dockerfilePath, tag string, platforms []string, buildOpts imageBuildOptions, push bool) []string {
+	args := []string{
+		"buildx", "build",
+		"--platform", strings.Join(platforms, ","),
+		"--tag", tag,
+		"--file", dockerfilePath,
+	}
+	if buildOpts.target != "" {
+		args = append(args, "--target", buildOpts.target)
+	}
+	if buildOpts.noCache {
+		args = append(args, "--no-cache")
+	}
+	if buildOpts.pull {
+		args = append(args, "--pull")
+	}
+	for _, key := range slices.Sorted(maps.Keys(buildOpts.buildArgs)) {
+		args = append(args, "--build-arg", key+"="+buildOpts.buildArgs[key])
+	}
+	for _, key := range slices.Sorted(maps.Keys(buildOpts.labels)) {
+		args = append(args, "--label", key+"="+buildOpts.labels[key])
+	}
+	if push {
+		args = append(args, "--push")
+	} else {
+		args = append(args, "--load")
+	}
+	return append(args, filepath.Dir(filepath.Dir(dockerfilePath)))
+}
+
+// buildxBuild builds with BuildKit through the docker CLI's buildx plugin,
+// which unlike the Engine API build endpoint can target other platforms and
+// produce multi-arch images. Build output goes straight to the terminal.
+func buildxBuild(dockerfilePath, tag string, platforms []string, buildOpts imageBuildOptions, push bool) error {
+	cmd := exec.Command("docker", buildxArgs(dockerfilePath, tag, platforms, buildOpts, push)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return fmt.Errorf("multi-platform builds need the docker CLI with the buildx plugin: %w", err)
+		}
+		return fmt.Errorf("buildx build failed: %w", err)
+	}
+	return nil
+}
+
 // imageExists checks if a Docker image exists
 func (d *dockerClient) imageExists(imageName string) (bool, error) {
 	_, _, err := d.client.ImageInspectWithRaw(d.ctx, imageName)
@@ -191,6 +531,58 @@ func (d *dockerClient) imageExists(imageName string) (bool, error) {
 	return true, nil
 }
 
+// imageHasPlatform reports whether the local image was built for platform
+// ("os/arch[/variant]")
+func (d *dockerClient) imageHasPlatform(imageName, platform string) (bool, error) {
+	inspect, _, err := d.client.ImageInspectWithRaw(d.ctx, imageName)
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect image: %w", err)
+	}
+
+	want, err := parsePlatform(platform)
+	if err != nil {
+		return false, err
+	}
+	if inspect.Os != want.OS || inspect.Architecture != want.Architecture {
+		return false, nil
+	}
+	return want.Variant == "" || inspect.Variant == want.Variant, nil
+}
+
+// imageLabels returns the labels of a local image
+func (d *dockerClient) imageLabels(imageName string) (map[string]string, error) {
+	inspect, _, err := d.client.ImageInspectWithRaw(d.ctx, imageName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image: %w", err)
+	}
+	if inspect.Config == nil {
+		return nil, nil
+	}
+	return inspect.Config.Labels, nil
+}
+
+// imageUser returns the USER a local image runs as ("" for the default)
+func (d *dockerClient) imageUser(imageName string) (string, error) {
+	inspect, _, err := d.client.ImageInspectWithRaw(d.ctx, imageName)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image: %w", err)
+	}
+	if inspect.Config == nil {
+		return "", nil
+	}
+	return inspect.Config.User, nil
+}
+
+// imageRepoDigests returns the repository digests (name@sha256:...) recorded
+// for a local image
+func (d *dockerClient) imageRepoDigests(imageName string) ([]string, error) {
+	inspect, _, err := d.client.ImageInspectWithRaw(d.ctx, imageName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image: %w", err)
+	}
+	return inspect.RepoDigests, nil
+}
+
 // containerExists checks if a container exists
 func (d *dockerClient) containerExists(containerName string) (bool, error) {
 	containers, err := d.client.ContainerList(d.ctx, container.ListOptions{
@@ -249,6 +641,33 @@ func (d *dockerClient) getContainerID(containerName string) (string, error) {
 	return "", fmt.Errorf("container not found: %s", containerName)
 }
 
+// execExitCode runs a short check command in the container, discarding its
+// output, and returns its exit code
+func (d *dockerClient) execExitCode(containerID string, cmd []string) (int, error) {
+	execResp, err := d.client.ContainerExecCreate(d.ctx, containerID, container.ExecOptions{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create exec: %w", err)
+	}
+
+	attachResp, err := d.client.ContainerExecAttach(d.ctx, execResp.ID, container.ExecStartOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to attach to exec: %w", err)
+	}
+	io.Copy(io.Discard, attachResp.Reader)
+	attachResp.Close()
+
+	inspectResp, err := d.client.ContainerExecInspect(d.ctx, execResp.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect exec: %w", err)
+	}
+
+	return inspectResp.ExitCode, nil
+}
+
 // removeImage removes a Docker image
 func (d *dockerClient) removeImage(imageName string) error {
 	_, err := d.client.ImageRemove(d.ctx, imageName, image.RemoveOptions{
@@ -260,10 +679,11 @@ func (d *dockerClient) removeImage(imageName string) error {
 	return nil
 }
 
-// createNetwork creates a Docker network
-func (d *dockerClient) createNetwork(networkName string) (string, error) {
+// createNetwork creates a Docker network with the given labels
+func (d *dockerClient) createNetwork(networkName string, labels map[string]string) (string, error) {
 	resp, err := d.client.NetworkCreate(d.ctx, networkName, network.CreateOptions{
 		Driver: "bridge",
+		Labels: labels,
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to create network: %w", err)
@@ -295,16 +715,44 @@ func (d *dockerClient) removeNetwork(networkName string) error {
 	return nil
 }
 
-// pullImage pulls a Docker image from a registry
-func (d *dockerClient) pullImage(imageName string) error {
-	out, err := d.client.ImagePull(d.ctx, imageName, image.PullOptions{})
+// pullImage pulls a Docker image from a registry, for platform if non-empty,
+// using the registry credentials from the Docker config
+func (d *dockerClient) pullImage(imageName, platform string) error {
+	auth, err := registryAuth(imageName)
+	if err != nil {
+		return err
+	}
+
+	out, err := d.client.ImagePull(d.ctx, imageName, image.PullOptions{Platform: platform, RegistryAuth: auth})
 	if err != nil {
 		return fmt.Errorf("failed to pull image: %w", err)
 	}
 	defer out.Close()
 
-	// Parse and display pull output
-	type pullMessage struct {
+	return printProgress(out, "pull")
+}
+
+// pushImage pushes a local image to its registry, using the registry
+// credentials from the Docker config
+func (d *dockerClient) pushImage(imageName string) error {
+	auth, err := registryAuth(imageName)
+	if err != nil {
+		return err
+	}
+
+	out, err := d.client.ImagePush(d.ctx, imageName, image.PushOptions{RegistryAuth: auth})
+	if err != nil {
+		return fmt.Errorf("failed to push image: %w", err)
+	}
+	defer out.Close()
+
+	return printProgress(out, "push")
+}
+
+// printProgress displays the JSON progress stream of an image pull or push
+// (action) and returns any error it reports
+func printProgress(out io.Reader, action string) error {
+	type progressMessage struct {
 		Status         string `json:"status"`
 		Progress       string `json:"progress"`
 		ProgressDetail struct {
@@ -319,7 +767,7 @@ func (d *dockerClient) pullImage(imageName string) error {
 	lastStatus := ""
 
 	for scanner.Scan() {
-		var msg pullMessage
+		var msg progressMessage
 		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
 			// If we can't parse JSON, just print the raw line
 			fmt.Println(scanner.Text())
@@ -328,7 +776,7 @@ func (d *dockerClient) pullImage(imageName string) error {
 
 		// Handle errors
 		if msg.Error != "" {
-			return fmt.Errorf("pull failed: %s", msg.Error)
+			return fmt.Errorf("%s failed: %s", action, msg.Error)
 		}
 
 		// Display status updates (avoid repeating the same status)
@@ -350,16 +798,17 @@ func (d *dockerClient) pullImage(imageName string) error {
 	}
 
 	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("failed to read pull output: %w", err)
+		return fmt.Errorf("failed to read %s output: %w", action, err)
 	}
 
 	return nil
 }
 
-// createVolume creates a Docker volume
-func (d *dockerClient) createVolume(volumeName string) error {
+// createVolume creates a Docker volume with the given labels
+func (d *dockerClient) createVolume(volumeName string, labels map[string]string) error {
 	_, err := d.client.VolumeCreate(d.ctx, volume.CreateOptions{
-		Name: volumeName,
+		Name:   volumeName,
+		Labels: labels,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to create volume: %w", err)
@@ -367,6 +816,19 @@ func (d *dockerClient) createVolume(volumeName string) error {
 	return nil
 }
 
+// statContainerPath stats a path inside a container, returning nil if it
+// doesn't exist
+func (d *dockerClient) statContainerPath(containerID, path string) (*container.PathStat, error) {
+	stat, err := d.client.ContainerStatPath(d.ctx, containerID, path)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to stat %s in container: %w", path, err)
+	}
+	return &stat, nil
+}
+
 // volumeExists checks if a Docker volume exists
 func (d *dockerClient) volumeExists(volumeName string) (bool, error) {
 	_, err := d.client.VolumeInspect(d.ctx, volumeName)
@@ -388,6 +850,19 @@ func (d *dockerClient) removeVolume(volumeName string) error {
 	return nil
 }
 
+// volumeInUse reports whether any container, running or not, mounts the
+// volume, which keeps Docker from removing it
+func (d *dockerClient) volumeInUse(volumeName string) (bool, error) {
+	containers, err := d.client.ContainerList(d.ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("volume", volumeName)),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to list containers using volume %s: %w", volumeName, err)
+	}
+	return len(containers) > 0, nil
+}
+
 // isoContainerInfo represents an ISO-managed container (internal type)
 type isoContainerInfo struct {
 	ID          string
@@ -397,9 +872,13 @@ type isoContainerInfo struct {
 	ProjectDir  string
 	Session     string
 	Status      string
+	ImageID     string
 	Fresh       bool
 	IsService   bool
 	ServiceName string
+	Platform    string            // Pinned platform of a service container, if any
+	Emulated    bool              // Platform differs from the Docker host's architecture
+	Labels      map[string]string // User labels from config.yml
 }
 
 // listIsoContainers lists all ISO-managed containers
@@ -414,6 +893,10 @@ func (d *dockerClient) listIsoContainers() ([]isoContainerInfo, error) {
 		return nil, fmt.Errorf("failed to list containers: %w", err)
 	}
 
+	// The host architecture is only looked up if a container pins a platform
+	nativeArch := ""
+	var archOnce sync.Once
+
 	var isoContainers []isoContainerInfo
 	for _, c := range containers {
 		name := ""
@@ -422,6 +905,11 @@ func (d *dockerClient) listIsoContainers() ([]isoContainerInfo, error) {
 			name = strings.TrimPrefix(c.Names[0], "/")
 		}
 
+		platform := c.Labels["iso.platform"]
+		if platform != "" {
+			archOnce.Do(func() { nativeArch, _ = d.getArchitecture() })
+		}
+
 		isoContainers = append(isoContainers, isoContainerInfo{
 			ID:          c.ID[:12], // Short ID
 			Name:        name,
@@ -430,15 +918,34 @@ func (d *dockerClient) listIsoContainers() ([]isoContainerInfo, error) {
 			ProjectDir:  c.Labels["iso.project.dir"],
 			Session:     c.Labels["iso.session"],
 			Status:      c.Status,
+			ImageID:     c.ImageID,
 			Fresh:       c.Labels["iso.fresh"] == "true",
 			IsService:   c.Labels["iso.service"] == "true",
 			ServiceName: c.Labels["iso.service.name"],
+			Platform:    platform,
+			Emulated:    platformEmulated(platform, nativeArch),
+			Labels:      userLabels(c.Labels),
 		})
 	}
 
 	return isoContainers, nil
 }
 
+// userLabels returns the config.yml labels of a container, as listed in its
+// iso.user-labels label, or nil if it has none
+func userLabels(labels map[string]string) map[string]string {
+	keys := labels["iso.user-labels"]
+	if keys == "" {
+		return nil
+	}
+
+	user := make(map[string]string)
+	for _, key := range strings.Split(keys, ",") {
+		user[key] = labels[key]
+	}
+	return user
+}
+
 // listProjectContainers lists all ISO-managed containers for a specific project and session
 func (d *dockerClient) listProjectContainers(projectName, session string) ([]isoContainerInfo, error) {
 	containers, err := d.client.ContainerList(d.ctx, container.ListOptions{
@@ -582,6 +1089,28 @@ func (d *dockerClient) listDanglingVolumes() ([]string, error) {
 	return volumeNames, nil
 }
 
+// volumeSizes returns the disk usage of every volume, keyed by volume name.
+// Sizes are only reported for volumes using the local driver; others are -1.
+func (d *dockerClient) volumeSizes() (map[string]int64, error) {
+	usage, err := d.client.DiskUsage(d.ctx, types.DiskUsageOptions{
+		Types: []types.DiskUsageObject{types.VolumeObject},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get disk usage: %w", err)
+	}
+
+	sizes := make(map[string]int64)
+	for _, vol := range usage.Volumes {
+		size := int64(-1)
+		if vol.UsageData != nil {
+			size = vol.UsageData.Size
+		}
+		sizes[vol.Name] = size
+	}
+
+	return sizes, nil
+}
+
 // listPeerContainers lists all ISO-managed peer containers for a specific project
 func (d *dockerClient) listPeerContainers(projectName string) ([]isoContainerInfo, error) {
 	containers, err := d.client.ContainerList(d.ctx, container.ListOptions{
@@ -621,9 +1150,14 @@ func (d *dockerClient) listPeerContainers(projectName string) ([]isoContainerInf
 	return peerContainers, nil
 }
 
-// listUnusedNetworks finds networks with no connected containers
-func (d *dockerClient) listUnusedNetworks() ([]string, error) {
-	networks, err := d.client.NetworkList(d.ctx, network.ListOptions{})
+// listUnusedNetworks finds networks with no connected containers. A non-empty
+// label restricts the search to networks carrying it ("key" or "key=value").
+func (d *dockerClient) listUnusedNetworks(label string) ([]string, error) {
+	opts := network.ListOptions{}
+	if label != "" {
+		opts.Filters = filters.NewArgs(filters.Arg("label", label))
+	}
+	networks, err := d.client.NetworkList(d.ctx, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list networks: %w", err)
 	}
@@ -0,0 +1,110 @@
+package iso
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dockerContextOverride is the Docker context selected with SetDockerContext
+// (iso --context), which takes precedence over all other settings
+var dockerContextOverride string
+
+// dockerEndpoint is the Docker API endpoint of a Docker CLI context
+type dockerEndpoint struct {
+	context string
+	host    string
+	// tlsDir holds ca.pem, cert.pem and key.pem for TLS endpoints ("" if none)
+	tlsDir string
+}
+
+// dockerContextMeta is the subset of a context's meta.json iso uses
+type dockerContextMeta struct {
+	Name      string `json:"Name"`
+	Endpoints map[string]struct {
+		Host string `json:"Host"`
+	} `json:"Endpoints"`
+}
+
+// resolveDockerEndpoint picks the Docker context to connect to, in order:
+// --context, DOCKER_HOST (returns nil so the environment is used as-is),
+// DOCKER_CONTEXT, the project's configured context, then the Docker CLI's
+// current context. Returns nil for the "default" context.
+func resolveDockerEndpoint(configDir, configured string) (*dockerEndpoint, error) {
+	name := dockerContextOverride
+	if name == "" {
+		if os.Getenv("DOCKER_HOST") != "" {
+			return nil, nil
+		}
+		name = os.Getenv("DOCKER_CONTEXT")
+	}
+	if name == "" {
+		name = configured
+	}
+	if name == "" {
+		name = currentDockerContext(configDir)
+	}
+	if name == "" || name == "default" {
+		return nil, nil
+	}
+
+	return loadDockerContext(configDir, name)
+}
+
+// currentDockerContext returns the context selected with `docker context use`
+func currentDockerContext(configDir string) string {
+	if configDir == "" {
+		return ""
+	}
+	data, err := os.ReadFile(filepath.Join(configDir, "config.json"))
+	if err != nil {
+		return ""
+	}
+	var config struct {
+		CurrentContext string `json:"currentContext"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return ""
+	}
+	return config.CurrentContext
+}
+
+// loadDockerContext reads the Docker endpoint of the named context from the
+// Docker CLI context store in configDir
+func loadDockerContext(configDir, name string) (*dockerEndpoint, error) {
+	sum := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(sum[:])
+
+	data, err := os.ReadFile(filepath.Join(configDir, "contexts", "meta", id, "meta.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("docker context %q not found (see `docker context ls`)", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read docker context %q: %w", name, err)
+	}
+
+	var meta dockerContextMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse docker context %q: %w", name, err)
+	}
+
+	host := meta.Endpoints["docker"].Host
+	if host == "" {
+		return nil, fmt.Errorf("docker context %q has no docker endpoint", name)
+	}
+	if strings.HasPrefix(host, "ssh://") {
+		return nil, fmt.Errorf("docker context %q uses an ssh endpoint, which iso doesn't support; forward the socket and use a unix:// or tcp:// context", name)
+	}
+
+	endpoint := &dockerEndpoint{context: name, host: host}
+	tlsDir := filepath.Join(configDir, "contexts", "tls", id, "docker")
+	if _, err := os.Stat(filepath.Join(tlsDir, "cert.pem")); err == nil {
+		endpoint.tlsDir = tlsDir
+	}
+	return endpoint, nil
+}
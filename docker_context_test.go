@@ -0,0 +1,107 @@
+package iso
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeDockerContext adds a context with the given docker host to the
+// context store in configDir
+func writeDockerContext(t *testing.T, configDir, name, host string) {
+	t.Helper()
+	sum := sha256.Sum256([]byte(name))
+	dir := filepath.Join(configDir, "contexts", "meta", hex.EncodeToString(sum[:]))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	meta := `{"Name":"` + name + `","Metadata":{},"Endpoints":{"docker":{"Host":"` + host + `","SkipTLSVerify":false}}}`
+	if err := os.WriteFile(filepath.Join(dir, "meta.json"), []byte(meta), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolveDockerEndpoint(t *testing.T) {
+	configDir := t.TempDir()
+	writeDockerContext(t, configDir, "colima", "unix:///home/me/.colima/default/docker.sock")
+	writeDockerContext(t, configDir, "remote", "tcp://builder:2376")
+	writeDockerContext(t, configDir, "tunnel", "ssh://me@builder")
+	if err := os.WriteFile(filepath.Join(configDir, "config.json"), []byte(`{"currentContext":"remote"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name          string
+		override      string
+		dockerHost    string
+		dockerContext string
+		configured    string
+		want          string
+		wantErr       string
+	}{
+		{name: "current context", want: "tcp://builder:2376"},
+		{name: "configured", configured: "colima", want: "unix:///home/me/.colima/default/docker.sock"},
+		{name: "DOCKER_CONTEXT over config", dockerContext: "remote", configured: "colima", want: "tcp://builder:2376"},
+		{name: "DOCKER_HOST wins", dockerHost: "unix:///var/run/docker.sock", configured: "colima", want: ""},
+		{name: "override wins", override: "colima", dockerHost: "unix:///var/run/docker.sock", want: "unix:///home/me/.colima/default/docker.sock"},
+		{name: "default", configured: "default", want: ""},
+		{name: "unknown", configured: "nope", wantErr: "not found"},
+		{name: "ssh", configured: "tunnel", wantErr: "ssh endpoint"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("DOCKER_HOST", tc.dockerHost)
+			t.Setenv("DOCKER_CONTEXT", tc.dockerContext)
+			dockerContextOverride = tc.override
+			defer func() { dockerContextOverride = "" }()
+
+			endpoint, err := resolveDockerEndpoint(configDir, tc.configured)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("resolveDockerEndpoint() error = %v, want error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveDockerEndpoint() unexpected error: %v", err)
+			}
+
+			got := ""
+			if endpoint != nil {
+				got = endpoint.host
+			}
+			if got != tc.want {
+				t.Errorf("host = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestOpenDockerEndpoint(t *testing.T) {
+	t.Setenv("DOCKER_HOST", "tcp://10.0.0.5:2375")
+	t.Setenv("DOCKER_CERT_PATH", "")
+
+	d, err := openDockerEndpoint(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.close()
+	if d.endpoint.host != "tcp://10.0.0.5:2375" {
+		t.Errorf("endpoint from the environment = %q, want DOCKER_HOST", d.endpoint.host)
+	}
+
+	// The CLI attaches to the endpoint the iso daemon reports, whatever its
+	// own environment selects
+	remote, err := openDockerEndpoint(&dockerEndpoint{host: "tcp://10.0.0.9:2375"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.close()
+	if got := remote.client.DaemonHost(); got != "tcp://10.0.0.9:2375" {
+		t.Errorf("DaemonHost() = %q, want the reported endpoint", got)
+	}
+}
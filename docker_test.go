@@ -0,0 +1,227 @@
+package iso
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/moby/go-archive"
+)
+
+// fakeDockerAPIVersion is the API version clients of newFakeDocker use
+const fakeDockerAPIVersion = "1.47"
+
+// newFakeDocker returns a dockerClient whose daemon is handler, which gets
+// the API paths without their version prefix (e.g. /containers/NAME/json)
+func newFakeDocker(t *testing.T, handler http.Handler) *dockerClient {
+	t.Helper()
+	server := httptest.NewServer(http.StripPrefix("/v"+fakeDockerAPIVersion, handler))
+	t.Cleanup(server.Close)
+
+	cli, err := client.NewClientWithOpts(
+		client.WithHost("tcp://"+server.Listener.Addr().String()),
+		client.WithVersion(fakeDockerAPIVersion),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cli.Close() })
+	return &dockerClient{client: cli, ctx: context.Background()}
+}
+
+// handleFakeExecs serves execs on a newFakeDocker mux: every exec produces no
+// output and exits with exitCode
+func handleFakeExecs(t *testing.T, mux *http.ServeMux, exitCode int) {
+	mux.HandleFunc("POST /containers/{id}/exec", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"Id": "exec-" + r.PathValue("id")})
+	})
+	mux.HandleFunc("POST /exec/{id}/start", func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("failed to hijack exec connection: %v", err)
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	})
+	mux.HandleFunc("GET /exec/{id}/json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"ID": r.PathValue("id"), "ExitCode": exitCode})
+	})
+}
+
+// TestIsMissingImageError covers the start failures that trigger recovery when
+// a session's image was removed out-of-band (e.g. `docker rmi -f proj-shell`)
+// while its stopped container still exists.
+func TestIsMissingImageError(t *testing.T) {
+	cases := []struct {
+		err  string
+		want bool
+	}{
+		{"Error response from daemon: No such image: proj-shell:latest", true},
+		{"Error response from daemon: image with reference sha256:1234 was found but does not match the specified platform", false},
+		{"failed to create task: image \"sha256:1234\": not found", true},
+		{"Error response from daemon: No such container: proj-shell", false},
+		{"Error response from daemon: driver failed programming external connectivity", false},
+	}
+
+	for _, tc := range cases {
+		if got := isMissingImageError(errors.New(tc.err)); got != tc.want {
+			t.Errorf("isMissingImageError(%q) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestIsVolumeInUseError(t *testing.T) {
+	inUse := errors.New("Error response from daemon: remove proj-cache-go-pkg-mod: volume is in use - [4f5e6a7b8c9d]")
+	if !isVolumeInUseError(inUse) {
+		t.Errorf("isVolumeInUseError(%q) = false, want true", inUse)
+	}
+	if notFound := errors.New("Error response from daemon: get proj-cache: no such volume"); isVolumeInUseError(notFound) {
+		t.Errorf("isVolumeInUseError(%q) = true, want false", notFound)
+	}
+}
+
+func TestBuildxArgs(t *testing.T) {
+	platforms := []string{"linux/amd64", "linux/arm64"}
+
+	got := buildxArgs("/src/app/.iso/Dockerfile", "ghcr.io/acme/app-shell:dev", platforms, imageBuildOptions{
+		buildArgs: map[string]string{"VERSION": "1.2", "GO": "1.24"},
+		target:    "dev",
+		noCache:   true,
+		pull:      true,
+	}, true)
+	want := []string{
+		"buildx", "build",
+		"--platform", "linux/amd64,linux/arm64",
+		"--tag", "ghcr.io/acme/app-shell:dev",
+		"--file", "/src/app/.iso/Dockerfile",
+		"--target", "dev",
+		"--no-cache",
+		"--pull",
+		"--build-arg", "GO=1.24",
+		"--build-arg", "VERSION=1.2",
+		"--push",
+		"/src/app",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("buildxArgs(push) = %q, want %q", got, want)
+	}
+
+	got = buildxArgs("/src/app/.iso/Dockerfile", "app-shell:linux-arm64", platforms[1:], imageBuildOptions{}, false)
+	if !slices.Contains(got, "--load") || slices.Contains(got, "--push") {
+		t.Errorf("buildxArgs(load) = %q, want --load and no --push", got)
+	}
+}
+
+func TestBuildContextExcludes(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		".iso/Dockerfile":        "FROM alpine\n",
+		".iso/iso-linux-amd64":   "binary",
+		".iso/startup.log":       "log",
+		".iso/pre-run.sh":        "#!/bin/sh\n",
+		"node_modules/pkg/index": "js",
+		"main.go":                "package main\n",
+		".dockerignore":          "node_modules\n.iso\n!.iso/pre-run.sh\n",
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	excludes, err := buildContextExcludes(dir, ".iso/Dockerfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := archive.TarWithOptions(dir, &archive.TarOptions{ExcludePatterns: excludes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	var files []string
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			files = append(files, hdr.Name)
+		}
+	}
+	slices.Sort(files)
+
+	want := []string{".dockerignore", ".iso/Dockerfile", ".iso/pre-run.sh", "main.go"}
+	if !slices.Equal(files, want) {
+		t.Errorf("build context = %q, want %q", files, want)
+	}
+}
+
+// TestAttachExecUnresponsiveDaemon covers a daemon that accepts the attach
+// connection but never answers: the attach must give up at the deadline
+// instead of blocking forever
+func TestAttachExecUnresponsiveDaemon(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			// Read the request, never respond
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+
+	cli, err := client.NewClientWithOpts(
+		client.WithHost("tcp://"+listener.Addr().String()),
+		client.WithVersion(fakeDockerAPIVersion),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	d := &dockerClient{client: cli, ctx: context.Background()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := d.attachExec(ctx, "exec1", container.ExecStartOptions{})
+		errChan <- err
+	}()
+
+	select {
+	case err := <-errChan:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("attachExec() error = %v, want context.DeadlineExceeded", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("attachExec() blocked past its deadline")
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
 	"runtime"
 )
 
@@ -20,8 +19,25 @@ var linuxBinaryAmd64Gz []byte
 //go:embed build/iso-linux-arm64.gz
 var linuxBinaryArm64Gz []byte
 
-// extractLinuxBinary extracts the embedded Linux iso binary to the .iso directory
-// and returns the path to that file. Reuses existing file if present and valid.
+// EmbeddedBinaryArchs returns the architectures of the Linux iso binaries
+// available to run inside containers. On Linux the current executable stands
+// in for its own architecture's (zeroed) embedded binary.
+func EmbeddedBinaryArchs() []string {
+	var archs []string
+	for _, arch := range []string{"amd64", "arm64"} {
+		compressed := linuxBinaryAmd64Gz
+		if arch == "arm64" {
+			compressed = linuxBinaryArm64Gz
+		}
+		if len(compressed) > 20 || (runtime.GOOS == "linux" && runtime.GOARCH == arch) {
+			archs = append(archs, arch)
+		}
+	}
+	return archs
+}
+
+// extractLinuxBinary extracts the embedded Linux iso binary to the binary cache
+// or the .iso directory (see binaryExtractPath) and returns the path to that file. Reuses existing file if present and valid.
 func extractLinuxBinary(isoDir, arch string) (string, error) {
 	// Determine which compressed binary to use
 	var compressedBinary []byte
@@ -61,8 +77,11 @@ func extractLinuxBinary(isoDir, arch string) (string, error) {
 		return "", fmt.Errorf("failed to decompress binary: %w", err)
 	}
 
-	// Use .iso directory for extracted binary
-	extractPath := filepath.Join(isoDir, fmt.Sprintf("iso-linux-%s", arch))
+	// Use the binary cache (ISO_CACHE_DIR) or the .iso directory
+	extractPath, err := binaryExtractPath(isoDir, arch, decompressed)
+	if err != nil {
+		return "", err
+	}
 
 	// Check if file already exists and has correct size
 	if stat, err := os.Stat(extractPath); err == nil {
@@ -74,8 +93,9 @@ func extractLinuxBinary(isoDir, arch string) (string, error) {
 		os.Remove(extractPath)
 	}
 
-	// Write the decompressed binary to the .iso directory
-	if err := os.WriteFile(extractPath, decompressed, 0755); err != nil {
+	// Write atomically, since other iso processes may be extracting or
+	// running the same file concurrently
+	if err := writeFileAtomic(extractPath, decompressed, 0755); err != nil {
 		return "", fmt.Errorf("failed to write embedded binary: %w", err)
 	}
 
@@ -9,6 +9,16 @@ import (
 	"runtime"
 )
 
+// EmbeddedBinaryArchs returns the architectures of the Linux iso binaries
+// available to run inside containers: only the current executable's on Linux
+// and none elsewhere, since no binaries are embedded
+func EmbeddedBinaryArchs() []string {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+	return []string{runtime.GOARCH}
+}
+
 // extractLinuxBinary returns the current executable path on Linux,
 // or fails on other platforms when binaries are not embedded.
 // This version is used when binaries are not embedded (e.g., go install).
@@ -6,8 +6,15 @@ package iso
 import (
 	"fmt"
 	"os"
+	"runtime"
 )
 
+// EmbeddedBinaryArchs returns the architecture of the current executable,
+// the only binary available when building the Linux binary itself
+func EmbeddedBinaryArchs() []string {
+	return []string{runtime.GOARCH}
+}
+
 // extractLinuxBinary is a stub for when building the Linux binary itself
 func extractLinuxBinary(isoDir, arch string) (string, error) {
 	// When building the Linux binary, just use the current executable
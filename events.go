@@ -0,0 +1,83 @@
+package iso
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Run event types written by RunOptions.EventsPath
+const (
+	EventServicesStart  = "services-start"
+	EventContainerStart = "container-start"
+	EventServiceReady   = "service-ready"
+	EventCommandStart   = "command-start"
+	EventCommandExit    = "command-exit"
+	EventTeardown       = "teardown"
+)
+
+// RunEvent is one line of the NDJSON event log written by
+// RunOptions.EventsPath. Phase-completing events carry the phase duration.
+type RunEvent struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	DurationMS *int64    `json:"duration_ms,omitempty"`
+	Service    string    `json:"service,omitempty"`
+	Step       int       `json:"step,omitempty"`
+	Command    []string  `json:"command,omitempty"`
+	ExitCode   *int      `json:"exit_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// eventLog appends RunEvents to a file as NDJSON. A nil *eventLog discards
+// events, so callers don't need to check whether logging is enabled.
+type eventLog struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// openEventLog creates (or truncates) the event log at path
+func openEventLog(path string) (*eventLog, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create event log: %w", err)
+	}
+	return &eventLog{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// emit writes ev, stamping it with the current time. Write failures are
+// logged rather than failing the run.
+func (l *eventLog) emit(ev RunEvent) {
+	if l == nil {
+		return
+	}
+	ev.Time = time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(ev); err != nil {
+		slog.Warn("failed to write event", "event", ev.Event, "error", err)
+	}
+}
+
+// phaseDone writes a phase-completing event with the time elapsed since started
+func (l *eventLog) phaseDone(ev RunEvent, started time.Time) {
+	if l == nil {
+		return
+	}
+	ms := time.Since(started).Milliseconds()
+	ev.DurationMS = &ms
+	l.emit(ev)
+}
+
+// close closes the event log file
+func (l *eventLog) close() {
+	if l == nil {
+		return
+	}
+	l.f.Close()
+}
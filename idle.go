@@ -0,0 +1,132 @@
+package iso
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// IdleExitCode is the exit code of a session container's init process when
+// it stops the container after idle_timeout without a command running
+const IdleExitCode = 75
+
+// idleTimeoutLabel holds a persistent session container's idle_timeout, in
+// seconds
+const idleTimeoutLabel = "iso.idle-timeout"
+
+// ReapedSession is a session stopped by ReapIdleSessions
+type ReapedSession struct {
+	ProjectName string `json:"project"`
+	Session     string `json:"session"`
+	// Containers lists the containers stopped (or, for a dry run, to be
+	// stopped): the session container, unless its init already stopped it,
+	// and its services
+	Containers []string `json:"containers"`
+}
+
+// ReapIdleSessions stops the persistent sessions, across all projects, that
+// have gone longer than their idle_timeout without a command running through
+// iso. A session container's init stops the container itself when idle, but
+// can't reach the session's service containers; reaping stops those too. The
+// containers are stopped, not removed, so the next run restarts them with
+// their state intact. With dryRun, nothing is stopped.
+// This function does not require being in a project directory
+func ReapIdleSessions(dryRun bool) ([]ReapedSession, error) {
+	docker, err := newDockerClient()
+	if err != nil {
+		return nil, err
+	}
+	defer docker.close()
+
+	return docker.reapIdleSessions(dryRun)
+}
+
+// reapIdleSessions implements ReapIdleSessions, and is run periodically by
+// the daemon
+func (d *dockerClient) reapIdleSessions(dryRun bool) ([]ReapedSession, error) {
+	sessions, err := d.client.ContainerList(d.ctx, container.ListOptions{
+		All: true,
+		Filters: filters.NewArgs(
+			filters.Arg("label", "iso.managed=true"),
+			filters.Arg("label", idleTimeoutLabel),
+		),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	reaped := []ReapedSession{}
+	for _, c := range sessions {
+		timeout, err := strconv.Atoi(c.Labels[idleTimeoutLabel])
+		if err != nil || timeout <= 0 {
+			continue
+		}
+		project, session := c.Labels["iso.project.name"], c.Labels["iso.session"]
+
+		idle, err := d.sessionIdle(c.ID, c.State, timeout)
+		if err != nil {
+			slog.Warn("failed to check whether session is idle", "project", project, "session", session, "error", err)
+			continue
+		}
+		if !idle {
+			continue
+		}
+
+		running, err := d.client.ContainerList(d.ctx, container.ListOptions{
+			Filters: filters.NewArgs(
+				filters.Arg("label", "iso.managed=true"),
+				filters.Arg("label", fmt.Sprintf("iso.project.name=%s", project)),
+				filters.Arg("label", fmt.Sprintf("iso.session=%s", session)),
+				filters.Arg("status", "running"),
+			),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list containers of session %s: %w", session, err)
+		}
+		if len(running) == 0 {
+			continue
+		}
+
+		result := ReapedSession{ProjectName: project, Session: session}
+		for _, rc := range running {
+			name := rc.ID[:12]
+			if len(rc.Names) > 0 {
+				name = rc.Names[0][1:]
+			}
+			if !dryRun {
+				slog.Info("stopping idle session container", "container", name, "project", project, "session", session)
+				stopTimeout := DefaultStopTimeout
+				if err := d.client.ContainerStop(d.ctx, rc.ID, container.StopOptions{Timeout: &stopTimeout}); err != nil {
+					slog.Warn("failed to stop idle session container", "container", name, "error", err)
+					continue
+				}
+			}
+			result.Containers = append(result.Containers, name)
+		}
+		reaped = append(reaped, result)
+	}
+
+	return reaped, nil
+}
+
+// sessionIdle reports whether a session container has been idle for at
+// least timeout seconds: a running one is asked via the iso binary, and a
+// stopped one is idle if its init stopped it for being idle
+func (d *dockerClient) sessionIdle(containerID, state string, timeout int) (bool, error) {
+	if state == container.StateRunning {
+		exitCode, err := d.execExitCode(containerID, []string{"/iso", "_internal-idle", strconv.Itoa(timeout)})
+		if err != nil {
+			return false, err
+		}
+		return exitCode == 0, nil
+	}
+
+	inspect, err := d.client.ContainerInspect(d.ctx, containerID)
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect container: %w", err)
+	}
+	return inspect.State != nil && inspect.State.ExitCode == IdleExitCode, nil
+}
@@ -0,0 +1,164 @@
+package iso
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+)
+
+// Reasons an image is removed by GarbageCollectImages
+const (
+	gcReasonDangling = "dangling"
+	gcReasonOrphaned = "orphaned"
+)
+
+// GCImage is an image removed (or, for a dry run, to be removed) by
+// GarbageCollectImages
+type GCImage struct {
+	ProjectName string `json:"project"`
+	// Name is the image tag, or the short image ID of an untagged image
+	Name string `json:"name"`
+	ID   string `json:"id"`
+	// Reason is "dangling" for an untagged image left behind by a rebuild, or
+	// "orphaned" for an image whose project directory no longer exists
+	Reason string `json:"reason"`
+	Size   int64  `json:"size"`
+}
+
+// GCResult is the outcome of GarbageCollectImages
+type GCResult struct {
+	Removed []GCImage `json:"removed"`
+	// BytesFreed is the combined size of the removed images
+	BytesFreed int64 `json:"bytes_freed"`
+	// InUse lists images that were kept because a container uses them
+	InUse []string `json:"in_use"`
+	// DryRun is set when nothing was removed, and Removed and BytesFreed
+	// describe what would have been
+	DryRun bool `json:"dry_run"`
+}
+
+// GarbageCollectImages removes the images ISO built that are no longer
+// needed: untagged images left behind when a project's image was rebuilt,
+// and images of projects whose directory no longer exists. A project's
+// directory is known from its image's iso.project.dir label or from its
+// containers; images of projects with no known directory are kept. Images
+// used by a container are skipped and listed in InUse. With dryRun, nothing
+// is removed.
+// This function does not require being in a project directory
+func GarbageCollectImages(dryRun bool) (*GCResult, error) {
+	docker, err := newDockerClient()
+	if err != nil {
+		return nil, err
+	}
+	defer docker.close()
+
+	images, err := docker.client.ImageList(docker.ctx, image.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", buildHashLabel)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+
+	containers, err := docker.client.ContainerList(docker.ctx, container.ListOptions{All: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	usedImages := make(map[string]bool)
+	projectDirs := make(map[string][]string)
+	for _, c := range containers {
+		usedImages[c.ImageID] = true
+		if c.Labels["iso.managed"] == "true" && c.Labels["iso.project.dir"] != "" {
+			project := c.Labels["iso.project.name"]
+			projectDirs[project] = append(projectDirs[project], c.Labels["iso.project.dir"])
+		}
+	}
+
+	dirExists := func(dir string) bool {
+		_, err := os.Stat(dir)
+		return !os.IsNotExist(err)
+	}
+
+	result := &GCResult{Removed: []GCImage{}, InUse: []string{}, DryRun: dryRun}
+	for i := range images {
+		gcImage, ok := gcImageCandidate(&images[i], projectDirs, dirExists)
+		if !ok {
+			continue
+		}
+
+		if usedImages[gcImage.ID] {
+			result.InUse = append(result.InUse, gcImage.Name)
+			continue
+		}
+
+		if !dryRun {
+			slog.Info("removing image", "image", gcImage.Name, "reason", gcImage.Reason)
+			_, err := docker.client.ImageRemove(docker.ctx, gcImage.ID, image.RemoveOptions{PruneChildren: true})
+			if err != nil {
+				slog.Warn("failed to remove image", "image", gcImage.Name, "error", err)
+				continue
+			}
+		}
+
+		result.Removed = append(result.Removed, gcImage)
+		result.BytesFreed += gcImage.Size
+	}
+
+	sort.Slice(result.Removed, func(i, j int) bool {
+		a, b := result.Removed[i], result.Removed[j]
+		if a.ProjectName != b.ProjectName {
+			return a.ProjectName < b.ProjectName
+		}
+		return a.Name < b.Name
+	})
+	return result, nil
+}
+
+// gcImageCandidate reports whether img, an image ISO built, should be
+// garbage collected: it is untagged, or every known directory of its project
+// is gone. projectDirs maps project names to the directories their
+// containers were started from.
+func gcImageCandidate(img *image.Summary, projectDirs map[string][]string, dirExists func(string) bool) (GCImage, bool) {
+	item, ok := classifyImage(img)
+	if !ok {
+		return GCImage{}, false
+	}
+
+	gcImage := GCImage{
+		ProjectName: item.ProjectName,
+		Name:        item.Name,
+		ID:          img.ID,
+		Size:        img.Size,
+	}
+
+	tagged := false
+	for _, tag := range img.RepoTags {
+		if tag != "<none>:<none>" {
+			tagged = true
+			break
+		}
+	}
+	if !tagged {
+		gcImage.Reason = gcReasonDangling
+		return gcImage, true
+	}
+
+	dirs := projectDirs[item.ProjectName]
+	if dir := img.Labels["iso.project.dir"]; dir != "" {
+		dirs = append([]string{dir}, dirs...)
+	}
+	if len(dirs) == 0 {
+		return GCImage{}, false
+	}
+	for _, dir := range dirs {
+		if dirExists(dir) {
+			return GCImage{}, false
+		}
+	}
+	gcImage.Reason = gcReasonOrphaned
+	return gcImage, true
+}
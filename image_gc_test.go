@@ -0,0 +1,63 @@
+package iso
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/image"
+)
+
+func TestGCImageCandidate(t *testing.T) {
+	built := map[string]string{buildHashLabel: "abc"}
+	existing := map[string]bool{"/src/app": true}
+	dirExists := func(dir string) bool { return existing[dir] }
+	projectDirs := map[string][]string{
+		"app":   {"/src/app"},
+		"gone":  {"/src/gone"},
+		"moved": {"/src/moved", "/src/app"},
+	}
+
+	cases := []struct {
+		name       string
+		img        image.Summary
+		wantReason string
+	}{
+		{
+			name:       "untagged",
+			img:        image.Summary{ID: "sha256:1", RepoTags: []string{"<none>:<none>"}, Labels: built},
+			wantReason: gcReasonDangling,
+		},
+		{
+			name: "live project",
+			img:  image.Summary{ID: "sha256:2", RepoTags: []string{"app-shell:latest"}, Labels: built},
+		},
+		{
+			name:       "deleted project",
+			img:        image.Summary{ID: "sha256:3", RepoTags: []string{"gone-shell:latest"}, Labels: built},
+			wantReason: gcReasonOrphaned,
+		},
+		{
+			name: "one directory left",
+			img:  image.Summary{ID: "sha256:4", RepoTags: []string{"moved-shell:latest"}, Labels: built},
+		},
+		{
+			name:       "deleted project from label",
+			img:        image.Summary{ID: "sha256:5", RepoTags: []string{"other-shell:latest"}, Labels: map[string]string{buildHashLabel: "abc", "iso.project.dir": "/src/other"}},
+			wantReason: gcReasonOrphaned,
+		},
+		{
+			name: "unknown directory",
+			img:  image.Summary{ID: "sha256:6", RepoTags: []string{"unknown-shell:latest"}, Labels: built},
+		},
+		{
+			name: "not built by iso",
+			img:  image.Summary{ID: "sha256:7", RepoTags: []string{"<none>:<none>"}},
+		},
+	}
+
+	for _, tc := range cases {
+		got, ok := gcImageCandidate(&tc.img, projectDirs, dirExists)
+		if ok != (tc.wantReason != "") || got.Reason != tc.wantReason {
+			t.Errorf("%s: gcImageCandidate = %+v, %v, want reason %q", tc.name, got, ok, tc.wantReason)
+		}
+	}
+}
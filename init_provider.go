@@ -0,0 +1,199 @@
+package iso
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// InitProvider generates the .iso configuration for InitProject from a prompt
+type InitProvider interface {
+	Generate(prompt string) (string, error)
+}
+
+// Init providers selectable with NewInitProvider
+const (
+	InitProviderClaude = "claude"
+	InitProviderOpenAI = "openai"
+)
+
+// defaultOpenAIBaseURL and defaultOpenAIModel are used by the openai provider
+// when ISO_INIT_BASE_URL and ISO_INIT_MODEL aren't set
+const (
+	defaultOpenAIBaseURL = "https://api.openai.com/v1"
+	defaultOpenAIModel   = "gpt-4o-mini"
+)
+
+// openAITimeout bounds a chat completion request of the openai provider
+const openAITimeout = 5 * time.Minute
+
+// NewInitProvider returns the named init provider: "claude" runs the claude
+// CLI in the project directory, "openai" calls an OpenAI-compatible chat
+// completions endpoint configured with ISO_INIT_BASE_URL, ISO_INIT_API_KEY
+// (or OPENAI_API_KEY) and ISO_INIT_MODEL. An empty name uses
+// ISO_INIT_PROVIDER, defaulting to claude.
+func NewInitProvider(name string) (InitProvider, error) {
+	name = cmp.Or(name, os.Getenv("ISO_INIT_PROVIDER"), InitProviderClaude)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	switch name {
+	case InitProviderClaude:
+		// Without claude (air-gapped machines, CI), point at the offline templates
+		if _, err := exec.LookPath("claude"); err != nil {
+			template := SuggestInit(cwd).Template
+			if template == "" {
+				template = "LANG"
+			}
+			return nil, fmt.Errorf("claude CLI not found in PATH - run 'iso init --template %s' to initialize from a bundled template instead (available: %s)", template, strings.Join(Templates(), ", "))
+		}
+		return &claudeProvider{dir: cwd}, nil
+	case InitProviderOpenAI:
+		return &openAIProvider{
+			dir:     cwd,
+			baseURL: strings.TrimSuffix(cmp.Or(os.Getenv("ISO_INIT_BASE_URL"), defaultOpenAIBaseURL), "/"),
+			apiKey:  cmp.Or(os.Getenv("ISO_INIT_API_KEY"), os.Getenv("OPENAI_API_KEY")),
+			model:   cmp.Or(os.Getenv("ISO_INIT_MODEL"), defaultOpenAIModel),
+			client:  &http.Client{Timeout: openAITimeout},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown init provider %q (available: %s, %s)", name, InitProviderClaude, InitProviderOpenAI)
+	}
+}
+
+// claudeProvider generates with the claude CLI, which reads the project
+// itself
+type claudeProvider struct {
+	dir string
+}
+
+func (p *claudeProvider) Generate(prompt string) (string, error) {
+	cmd := exec.Command("claude", "--print", prompt)
+	cmd.Dir = p.dir
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to run claude: %w\nStderr: %s", err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// openAIProvider generates with an OpenAI-compatible chat completions API.
+// The model can't look at the project, so the prompt is sent with a summary
+// of it.
+type openAIProvider struct {
+	dir     string
+	baseURL string
+	apiKey  string
+	model   string
+	client  *http.Client
+}
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIRequest struct {
+	Model    string          `json:"model"`
+	Messages []openAIMessage `json:"messages"`
+}
+
+type openAIResponse struct {
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+	} `json:"choices"`
+}
+
+func (p *openAIProvider) Generate(prompt string) (string, error) {
+	body, err := json.Marshal(openAIRequest{
+		Model: p.model,
+		Messages: []openAIMessage{
+			{Role: "user", Content: prompt + "\n\n" + projectSummary(p.dir)},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	url := p.baseURL + "/chat/completions"
+	req, err := http.NewRequestWithContext(operationCtx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to call %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("%s returned %s: %s", url, resp.Status, strings.TrimSpace(string(snippet)))
+	}
+
+	var completion openAIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		return "", fmt.Errorf("failed to decode response from %s: %w", url, err)
+	}
+	if len(completion.Choices) == 0 {
+		return "", fmt.Errorf("%s returned no choices", url)
+	}
+	return completion.Choices[0].Message.Content, nil
+}
+
+// projectSummaryFileLimit caps how much of each manifest projectSummary
+// includes
+const projectSummaryFileLimit = 4096
+
+// projectSummary describes the project in dir for providers that can't read
+// it: its top-level entries and the start of its language manifests and
+// Docker Compose file
+func projectSummary(dir string) string {
+	var b strings.Builder
+	b.WriteString("Top-level entries of the project directory:\n")
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		fmt.Fprintf(&b, "- %s\n", name)
+	}
+
+	var manifests []string
+	for _, m := range templateMarkers {
+		manifests = append(manifests, m.file)
+	}
+	manifests = append(manifests, composeFiles...)
+	for _, name := range manifests {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		if len(data) > projectSummaryFileLimit {
+			data = append(data[:projectSummaryFileLimit], "\n... (truncated)"...)
+		}
+		fmt.Fprintf(&b, "\nContents of %s:\n%s\n", name, data)
+	}
+	return b.String()
+}
@@ -0,0 +1,93 @@
+package iso
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenAIProviderGenerate(t *testing.T) {
+	var got openAIRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer secret" {
+			t.Errorf("Authorization = %q, want bearer token", auth)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"===DOCKERFILE===\nFROM golang\n===END_DOCKERFILE==="}}]}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/app\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+	t.Setenv("ISO_INIT_BASE_URL", server.URL+"/v1/")
+	t.Setenv("ISO_INIT_API_KEY", "secret")
+	t.Setenv("ISO_INIT_MODEL", "test-model")
+
+	provider, err := NewInitProvider(InitProviderOpenAI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := provider.Generate("generate config")
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if !strings.Contains(response, "FROM golang") {
+		t.Errorf("Generate() = %q, want the completion's content", response)
+	}
+
+	if got.Model != "test-model" || len(got.Messages) != 1 {
+		t.Fatalf("request = %+v, want one message for test-model", got)
+	}
+	content := got.Messages[0].Content
+	for _, want := range []string{"generate config", "- go.mod", "module example.com/app"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("prompt missing %q:\n%s", want, content)
+		}
+	}
+}
+
+func TestOpenAIProviderError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"invalid api key"}`, http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	t.Chdir(t.TempDir())
+	t.Setenv("ISO_INIT_BASE_URL", server.URL)
+
+	provider, err := NewInitProvider(InitProviderOpenAI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Generate("prompt"); err == nil || !strings.Contains(err.Error(), "invalid api key") {
+		t.Errorf("Generate() error = %v, want the endpoint's error", err)
+	}
+}
+
+func TestNewInitProviderSelection(t *testing.T) {
+	t.Setenv("ISO_INIT_PROVIDER", "openai")
+	provider, err := NewInitProvider("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := provider.(*openAIProvider); !ok {
+		t.Errorf("NewInitProvider(\"\") = %T, want ISO_INIT_PROVIDER's provider", provider)
+	}
+
+	if _, err := NewInitProvider("gemini"); err == nil {
+		t.Error("NewInitProvider() expected error for unknown provider")
+	}
+}
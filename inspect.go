@@ -0,0 +1,120 @@
+package iso
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// Inspection is everything ISO knows about a session, for debugging and bug
+// reports: the names it derived, the loaded configuration and the live Docker
+// state of the session's containers
+type Inspection struct {
+	Session         string `json:"session"`
+	ProjectName     string `json:"project"`
+	BaseProjectName string `json:"base_project"`
+	ProjectRoot     string `json:"project_root"`
+	ImageName       string `json:"image"`
+	ContainerName   string `json:"container_name"`
+	NetworkName     string `json:"network"`
+	// Volumes maps container paths to the session volumes backing them
+	Volumes map[string]string `json:"volumes"`
+	// CacheVolumes maps cache paths to the volumes backing them, or to host
+	// directories when ISO_CACHE_DIR is set
+	CacheVolumes map[string]string `json:"cache_volumes"`
+	// Config is the loaded config.yml, with defaults and expansions applied
+	Config *Config `json:"config"`
+	// Services has every service in services.yml, active or not
+	Services map[string]InspectedService `json:"services"`
+	// Container is the Docker inspect of the session container (null when it
+	// doesn't exist)
+	Container *container.InspectResponse `json:"container"`
+}
+
+// InspectedService is a service of an Inspection
+type InspectedService struct {
+	Config ServiceConfig `json:"config"`
+	// Active is set when the service runs with the active profiles
+	Active        bool   `json:"active"`
+	ContainerName string `json:"container_name"`
+	// Binds are the service's mounts as the persistent container gets them
+	Binds []string `json:"binds"`
+	// Container is the Docker inspect of the persistent service container
+	// (null when it doesn't exist, as for ephemeral sessions between runs)
+	Container *container.InspectResponse `json:"container"`
+}
+
+// Inspect returns everything ISO knows about the session: resolved names,
+// volumes, configuration, services and the Docker inspect of each of its
+// containers
+func (c *Client) Inspect() (*Inspection, error) {
+	return c.containerManager.inspect()
+}
+
+// inspect implements Client.Inspect
+func (cm *containerManager) inspect() (*Inspection, error) {
+	ins := &Inspection{
+		Session:         cm.session,
+		ProjectName:     cm.worktreeProjectName,
+		BaseProjectName: cm.baseProjectName,
+		ProjectRoot:     cm.projectRoot,
+		ImageName:       cm.imageName,
+		ContainerName:   cm.containerName,
+		NetworkName:     cm.networkName,
+		Volumes:         make(map[string]string),
+		CacheVolumes:    make(map[string]string),
+		Config:          cm.config,
+		Services:        make(map[string]InspectedService),
+	}
+
+	for _, volumePath := range cm.sessionVolumePaths() {
+		ins.Volumes[volumePath] = cm.getVolumeNameForPath(volumePath)
+	}
+	cacheDir := os.Getenv("ISO_CACHE_DIR")
+	for _, cachePath := range cm.config.Cache {
+		if cacheDir != "" {
+			ins.CacheVolumes[cachePath] = cacheHostDir(cacheDir, cachePath)
+		} else {
+			ins.CacheVolumes[cachePath] = cm.getCacheVolumeNameForPath(cachePath)
+		}
+	}
+
+	var err error
+	ins.Container, err = cm.inspectContainer(cm.containerName)
+	if err != nil {
+		return nil, err
+	}
+
+	active := cm.activeServices()
+	for name, config := range cm.services {
+		binds, _ := cm.serviceBinds(name, config, false)
+		service := InspectedService{
+			Config:        config,
+			ContainerName: cm.getServiceContainerName(name),
+			Binds:         binds,
+		}
+		_, service.Active = active[name]
+		service.Container, err = cm.inspectContainer(service.ContainerName)
+		if err != nil {
+			return nil, err
+		}
+		ins.Services[name] = service
+	}
+	return ins, nil
+}
+
+// inspectContainer returns the Docker inspect of the named container, or nil
+// when it doesn't exist
+func (cm *containerManager) inspectContainer(name string) (*container.InspectResponse, error) {
+	exists, err := cm.docker.containerExists(name)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	inspect, err := cm.docker.client.ContainerInspect(cm.docker.ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container %s: %w", name, err)
+	}
+	return &inspect, nil
+}
@@ -6,12 +6,14 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types/container"
+	"gopkg.in/yaml.v3"
 )
 
 // Client manages the isolated Docker environment
@@ -37,16 +39,171 @@ func (c *Client) Close() error {
 	return c.containerManager.close()
 }
 
+// SetMaxParallel overrides config.max_parallel, the limit on concurrent
+// service starts and image pulls. Values <= 0 are ignored.
+func (c *Client) SetMaxParallel(n int) {
+	if n > 0 {
+		c.containerManager.config.MaxParallel = n
+	}
+}
+
+// SetDockerContext selects the Docker CLI context every Docker connection made
+// afterwards uses, overriding DOCKER_HOST, DOCKER_CONTEXT and the context
+// setting in config.yml. An empty name restores the default resolution.
+func SetDockerContext(name string) {
+	dockerContextOverride = name
+}
+
+// SetAutoRebuild controls whether an existing image is rebuilt when
+// .iso/Dockerfile or the build args changed since it was built (the default)
+func (c *Client) SetAutoRebuild(enabled bool) {
+	c.containerManager.noAutoRebuild = !enabled
+}
+
+// SetNoCache makes image builds ignore Docker's layer cache. It also forces
+// Build to build even when the image is up to date.
+func (c *Client) SetNoCache(noCache bool) {
+	c.containerManager.buildNoCache = noCache
+}
+
+// SetPull makes image builds re-pull the Dockerfile's base images. It also
+// forces Build to build even when the image is up to date.
+func (c *Client) SetPull(pull bool) {
+	c.containerManager.buildPull = pull
+}
+
+// SetActiveProfiles overrides config.active_profiles, selecting which
+// profile-gated services run. An empty slice keeps the configured profiles.
+func (c *Client) SetActiveProfiles(profiles []string) {
+	if len(profiles) > 0 {
+		c.containerManager.config.ActiveProfiles = profiles
+	}
+}
+
+// RunOptions controls how a command is executed by RunWithOptions
+type RunOptions struct {
+	// EnvVars is a slice of environment variables in KEY=VALUE format
+	EnvVars []string
+	// Ephemeral selects the service-container lifecycle: ephemeral sessions get
+	// throwaway per-run services, persistent sessions reuse the session's
+	// long-lived ones.
+	Ephemeral bool
+	// Interactive keeps the command's stdin open after local stdin reaches EOF
+	// (like `docker run -i`), so a REPL fed from a pipe isn't ended early.
+	Interactive bool
+	// TTY allocates a pseudo-terminal for the command even when stdin is not
+	// a terminal. A TTY is always allocated when stdin is a terminal.
+	TTY bool
+	// WithServices, when non-empty, starts and waits for only these services
+	// instead of every active service
+	WithServices []string
+	// NoServices starts and waits for no services at all. It can't be
+	// combined with WithServices.
+	NoServices bool
+	// WorkDir runs the command in this container directory instead of the
+	// one mirroring the current directory. Relative paths are joined to the
+	// configured workdir. The directory must exist in the container.
+	WorkDir string
+	// KeepGoing runs every step of RunSteps even after one fails
+	KeepGoing bool
+	// PrintCommand prints the resolved exec (container, workdir, env and
+	// wrapped command) to stderr before running it.
+	PrintCommand bool
+	// DryRun prints the resolved exec like PrintCommand, then returns without
+	// starting services, the container or the command.
+	DryRun bool
+	// Secrets maps secret ids to host files. Each file is written to
+	// /run/secrets/<id> on a tmpfs in the container for the duration of the
+	// run and removed afterwards, so it never reaches the environment or disk.
+	Secrets map[string]string
+	// ExecTimeout bounds creating and attaching to each exec (default 30s), so
+	// an unresponsive Docker daemon fails the run instead of hanging it. The
+	// command itself may run for any length of time.
+	ExecTimeout time.Duration
+	// CaptureMetrics, when set, samples the container's resource usage while
+	// the command runs and writes a RunMetrics JSON report to this path
+	CaptureMetrics string
+	// MetricsInterval is the CaptureMetrics sample interval (default 1s)
+	MetricsInterval time.Duration
+	// EventsPath, when set, writes a RunEvent per run phase (services,
+	// container, service readiness, each command, teardown) to this file as
+	// NDJSON
+	EventsPath string
+	// Privileged, when set, overrides config.Privileged for the session
+	// container. A container in the other mode is recreated, and the new mode
+	// sticks for later runs until overridden again.
+	Privileged *bool
+}
+
 // Run executes a command in the isolated environment and returns the exit code.
 // envVars is a slice of environment variables in KEY=VALUE format. ephemeral
 // selects the service-container lifecycle: ephemeral sessions get throwaway
 // per-run services, persistent sessions reuse the session's long-lived ones.
 func (c *Client) Run(command []string, envVars []string, ephemeral bool) (int, error) {
+	return c.RunWithOptions(command, RunOptions{
+		EnvVars:   envVars,
+		Ephemeral: ephemeral,
+	})
+}
+
+// RunWithOptions executes a command in the isolated environment and returns
+// the exit code
+func (c *Client) RunWithOptions(command []string, opts RunOptions) (int, error) {
 	if len(command) == 0 {
 		return 0, fmt.Errorf("no command specified")
 	}
 
-	return c.containerManager.runCommand(command, envVars, ephemeral)
+	return c.containerManager.runCommand(command, opts)
+}
+
+// Shell starts the configured interactive shell in the isolated environment
+// and returns its exit code
+func (c *Client) Shell(opts RunOptions) (int, error) {
+	return c.containerManager.runCommand(c.containerManager.shellCommand(), opts)
+}
+
+// StepResult reports the outcome of one step of RunSteps
+type StepResult struct {
+	Command  []string
+	ExitCode int
+	Duration time.Duration
+	// Skipped is set for steps not run because an earlier step failed
+	Skipped bool
+}
+
+// RunSteps runs a sequence of commands in the same container, each as its own
+// exec with its own exit code and pre/post hooks. It stops at the first
+// failing step unless opts.KeepGoing is set.
+func (c *Client) RunSteps(steps [][]string, opts RunOptions) ([]StepResult, error) {
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("no command specified")
+	}
+	for _, step := range steps {
+		if len(step) == 0 {
+			return nil, fmt.Errorf("empty step in command sequence")
+		}
+	}
+
+	return c.containerManager.runSteps(steps, opts)
+}
+
+// DebugShell opens a raw shell in the session container without the in-env
+// wrapper or hooks, for diagnosing a broken init or /iso binary. Returns the
+// shell's exit code.
+func (c *Client) DebugShell() (int, error) {
+	return c.containerManager.debugShell()
+}
+
+// CopyOut copies a file or directory from the session container to the host.
+// Relative container paths are resolved against the configured workdir.
+func (c *Client) CopyOut(containerPath, hostPath string) error {
+	return c.containerManager.copyFromContainer(containerPath, hostPath)
+}
+
+// CopyIn copies a file or directory from the host into the session container.
+// Relative container paths are resolved against the configured workdir.
+func (c *Client) CopyIn(hostPath, containerPath string) error {
+	return c.containerManager.copyToContainer(hostPath, containerPath)
 }
 
 // Start starts all services with verbose output
@@ -82,6 +239,15 @@ func (c *Client) Build() error {
 	return c.containerManager.ensureImage()
 }
 
+// BuildPlatforms builds the Docker image for each of platforms (e.g.
+// "linux/arm64") with BuildKit. With pushRef set the platforms are combined
+// into one multi-arch image pushed to that registry reference. Otherwise each
+// platform is stored locally as <image>:<os>-<arch> and the build matching
+// the Docker host becomes the image runs use.
+func (c *Client) BuildPlatforms(platforms []string, pushRef string) error {
+	return c.containerManager.buildPlatforms(platforms, pushRef)
+}
+
 // Rebuild forces a rebuild of the Docker image
 func (c *Client) Rebuild() error {
 	return c.containerManager.rebuildImage()
@@ -92,27 +258,140 @@ func (c *Client) Reset() error {
 	return c.containerManager.resetContainer()
 }
 
-// Stop stops and removes the container and all services
-func (c *Client) Stop() error {
-	return c.containerManager.stopContainer()
+// Push publishes the project's image to a registry as ref (default: the
+// shared_image config setting), building it first if needed. It returns the
+// pushed reference.
+func (c *Client) Push(ref string) (string, error) {
+	return c.containerManager.pushImage(ref)
+}
+
+// Restart recreates a persistent session's main container, keeping its
+// services and volumes
+func (c *Client) Restart() error {
+	id, err := c.containerManager.restartContainer()
+	if err != nil {
+		return err
+	}
+
+	slog.Info("restarted container", "container", c.containerManager.containerName, "id", id)
+	return nil
+}
+
+// CommandRunning reports whether a run is executing its command (as opposed
+// to preparing the container or cleaning up)
+func (c *Client) CommandRunning() bool {
+	return c.containerManager.runningCommand()
+}
+
+// RestoreTerminal takes the terminal out of the raw mode a TTY run puts it
+// in. Call it before exiting while a run is still in progress.
+func (c *Client) RestoreTerminal() {
+	c.containerManager.restoreTerminal()
+}
+
+// DefaultStopTimeout is how many seconds Stop, StopAll and StopAllSessions
+// give containers to exit before killing them, unless told otherwise
+const DefaultStopTimeout = 10
+
+// Stop stops and removes the container and all services, waiting up to
+// timeout seconds for each to exit before killing it (0 kills immediately)
+func (c *Client) Stop(timeout int) error {
+	return c.containerManager.stopContainer(timeout)
+}
+
+// Cleanup is Stop for removing an ephemeral session after a run. Unlike Stop
+// it isn't cut short when the operation context is cancelled (Ctrl+C or
+// --timeout, see SetOperationContext), so the session's containers, services
+// and volumes don't leak.
+func (c *Client) Cleanup(timeout int) error {
+	return c.containerManager.teardownSession(timeout)
+}
+
+// PruneResult reports what Prune removed
+type PruneResult struct {
+	// Removed lists the cache volumes that were removed
+	Removed []string `json:"removed"`
+	// BytesFreed is the combined size of the removed volumes, where Docker
+	// reported it
+	BytesFreed int64 `json:"bytes_freed"`
+	// InUse lists cache volumes that were kept because a container uses them
+	InUse []string `json:"in_use"`
+	// DryRun is set when nothing was removed, and Removed and BytesFreed
+	// describe what would have been
+	DryRun bool `json:"dry_run"`
 }
 
 // Prune removes all cache volumes for the project
-func (c *Client) Prune() error {
+func (c *Client) Prune() (*PruneResult, error) {
 	return c.containerManager.pruneCacheVolumes()
 }
 
+// LogsOptions configures Logs
+type LogsOptions struct {
+	// Service selects a service container instead of the main container
+	Service string
+	// Follow keeps streaming new output until the container stops
+	Follow bool
+	// Tail limits the output to the last Tail lines; negative shows all
+	Tail int
+}
+
+// Logs writes the output of the session's main container, or of a service
+// container, to stdout and stderr
+func (c *Client) Logs(opts LogsOptions) error {
+	return c.containerManager.streamLogs(opts.Service, opts.Follow, opts.Tail, os.Stdout, os.Stderr)
+}
+
+// PruneNetworks removes the project's networks (session networks and the
+// default peers network) that have no containers attached, such as those left
+// by an interrupted stop. With dryRun set nothing is removed. Returns the
+// networks removed, or that would be removed.
+func (c *Client) PruneNetworks(dryRun bool) ([]string, error) {
+	return c.containerManager.pruneNetworks(dryRun)
+}
+
 // Status returns information about the image and container
 type Status struct {
-	ImageName      string
-	ImageExists    bool
-	ContainerName  string
-	ContainerState string // "does not exist", "running", "stopped"
+	Session        string `json:"session"`
+	ProjectName    string `json:"project"`
+	ImageName      string `json:"image_name"`
+	ImageExists    bool   `json:"image_exists"`
+	ContainerName  string `json:"container_name"`
+	ContainerState string `json:"container_state"` // "does not exist", "running", "stopped"
+	// Created is when the session container was created (zero if it doesn't
+	// exist)
+	Created time.Time `json:"created,omitzero"`
+	// UptimeSeconds is how long the container has been running (0 unless
+	// it is running)
+	UptimeSeconds int64 `json:"uptime_seconds,omitempty"`
+	// MemoryBytes and CPUPercent are the running container's current memory
+	// usage (excluding reclaimable page cache) and CPU usage as a percentage
+	// of one CPU
+	MemoryBytes uint64  `json:"memory_bytes,omitempty"`
+	CPUPercent  float64 `json:"cpu_percent,omitempty"`
+	// Services is the number of active services
+	Services int `json:"services"`
+	// ServicesRunning is set when every active service container is running
+	// (trivially so when there are none)
+	ServicesRunning bool `json:"services_running"`
+	// ServicePlatforms lists the active services that pin a platform
+	ServicePlatforms []ServicePlatform `json:"service_platforms,omitempty"`
+}
+
+// ServicePlatform describes a service pinned to a platform
+type ServicePlatform struct {
+	Name          string `json:"name"`
+	ContainerName string `json:"container_name"`
+	Platform      string `json:"platform"`
+	// Emulated is set when Platform differs from the Docker host's architecture
+	Emulated bool `json:"emulated"`
 }
 
 // Status returns the current status of the image and container
 func (c *Client) Status() (*Status, error) {
 	status := &Status{
+		Session:       c.containerManager.session,
+		ProjectName:   c.containerManager.worktreeProjectName,
 		ImageName:     c.containerManager.imageName,
 		ContainerName: c.containerManager.containerName,
 	}
@@ -131,9 +410,66 @@ func (c *Client) Status() (*Status, error) {
 	}
 	status.ContainerState = containerStatus
 
+	if containerStatus != "does not exist" {
+		if err := c.containerManager.containerDetails(status); err != nil {
+			return nil, err
+		}
+	}
+
+	status.ServicesRunning = true
+	for serviceName := range c.containerManager.activeServices() {
+		status.Services++
+		running, err := c.containerManager.docker.isContainerRunning(c.containerManager.getServiceContainerName(serviceName))
+		if err != nil {
+			return nil, err
+		}
+		if !running {
+			status.ServicesRunning = false
+		}
+	}
+
+	status.ServicePlatforms, err = c.containerManager.servicePlatforms()
+	if err != nil {
+		return nil, err
+	}
+
 	return status, nil
 }
 
+// ServiceHealth is the health of one service container
+type ServiceHealth struct {
+	Name    string
+	Running bool
+	// Ready is set when the service is running and accepts connections on its
+	// port (or has no port to check)
+	Ready bool
+}
+
+// HealthReport is the result of CheckHealth
+type HealthReport struct {
+	ContainerRunning bool
+	Services         []ServiceHealth
+}
+
+// Healthy reports whether the container is running and every service is ready
+func (r *HealthReport) Healthy() bool {
+	if !r.ContainerRunning {
+		return false
+	}
+	for _, s := range r.Services {
+		if !s.Ready {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckHealth checks that the session container is running and every active
+// service is ready. Service ports can only be probed while the container runs.
+func (c *Client) CheckHealth() (*HealthReport, error) {
+	return c.containerManager.checkHealth()
+}
+
 // IsoContainer represents an ISO-managed container
 type IsoContainer struct {
 	ID          string
@@ -145,6 +481,14 @@ type IsoContainer struct {
 	Status      string
 	IsService   bool
 	ServiceName string
+	// Platform is the pinned platform of a service container ("" if native)
+	Platform string
+	// Emulated is set when Platform differs from the Docker host's
+	// architecture, so the container runs under emulation
+	Emulated bool
+	// Labels holds the user labels from config.yml (reserved iso.* labels
+	// are excluded)
+	Labels map[string]string
 }
 
 // OrphanedSession represents a session whose project directory no longer exists
@@ -162,7 +506,11 @@ func (c *Client) List() ([]IsoContainer, error) {
 		return nil, err
 	}
 
-	// Convert internal type to public type
+	return toIsoContainers(dockerContainers), nil
+}
+
+// toIsoContainers converts the internal container type to the public one
+func toIsoContainers(dockerContainers []isoContainerInfo) []IsoContainer {
 	result := make([]IsoContainer, len(dockerContainers))
 	for i, dc := range dockerContainers {
 		result[i] = IsoContainer{
@@ -175,10 +523,12 @@ func (c *Client) List() ([]IsoContainer, error) {
 			Status:      dc.Status,
 			IsService:   dc.IsService,
 			ServiceName: dc.ServiceName,
+			Platform:    dc.Platform,
+			Emulated:    dc.Emulated,
+			Labels:      dc.Labels,
 		}
 	}
-
-	return result, nil
+	return result
 }
 
 // ListAll returns all ISO-managed containers across all projects
@@ -195,22 +545,115 @@ func ListAll() ([]IsoContainer, error) {
 		return nil, err
 	}
 
-	// Convert internal type to public type
-	result := make([]IsoContainer, len(dockerContainers))
-	for i, dc := range dockerContainers {
-		result[i] = IsoContainer{
-			ID:          dc.ID,
-			Name:        dc.Name,
-			ShortName:   dc.ShortName,
-			ProjectName: dc.ProjectName,
-			ProjectDir:  dc.ProjectDir,
-			Session:     dc.Session,
-			Status:      dc.Status,
-			IsService:   dc.IsService,
-			ServiceName: dc.ServiceName,
+	return toIsoContainers(dockerContainers), nil
+}
+
+// ProjectRoot returns the root directory of the project containing the
+// current directory, as recorded in its containers' ProjectDir
+func ProjectRoot() (string, bool) {
+	_, projectRoot, found := findIsoDir()
+	return projectRoot, found
+}
+
+// DockerVersion returns the version of the Docker daemon and the API version
+// negotiated with it. This function does not require being in a project directory
+func DockerVersion() (serverVersion, apiVersion string, err error) {
+	docker, err := newDockerClient()
+	if err != nil {
+		return "", "", err
+	}
+	defer docker.close()
+
+	return docker.serverVersion()
+}
+
+// ProjectOverview summarizes the ISO resources of a single project
+type ProjectOverview struct {
+	ProjectName string `json:"project"`
+	ProjectDir  string `json:"dir"`
+	Sessions    int    `json:"sessions"`
+	Running     int    `json:"running"`
+	Stopped     int    `json:"stopped"`
+	ImageExists bool   `json:"image_exists"`
+	// VolumeSize is the total size in bytes of the project's volumes, as
+	// attributed by DiskUsage
+	VolumeSize int64 `json:"volume_size"`
+}
+
+// Overview summarizes every project with ISO-managed containers: sessions,
+// running vs stopped containers, whether the image exists and volume usage.
+// This function does not require being in a project directory
+func Overview() ([]ProjectOverview, error) {
+	docker, err := newDockerClient()
+	if err != nil {
+		return nil, err
+	}
+	defer docker.close()
+
+	containers, err := docker.listIsoContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := docker.isoDiskUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	overviews := make(map[string]*ProjectOverview)
+	sessions := make(map[string]map[string]bool)
+	// shellImages is the image of a session container of each project, for
+	// projects using a prebuilt image instead of one iso built
+	shellImages := make(map[string]string)
+	for _, c := range containers {
+		ov, ok := overviews[c.ProjectName]
+		if !ok {
+			ov = &ProjectOverview{ProjectName: c.ProjectName, ProjectDir: c.ProjectDir}
+			overviews[c.ProjectName] = ov
+			sessions[c.ProjectName] = make(map[string]bool)
+		}
+
+		sessions[c.ProjectName][c.Session] = true
+		if c.ShortName == "shell" && c.ImageID != "" {
+			shellImages[c.ProjectName] = c.ImageID
+		}
+		if strings.HasPrefix(c.Status, "Up") {
+			ov.Running++
+		} else {
+			ov.Stopped++
 		}
 	}
 
+	for _, item := range items {
+		ov, ok := overviews[item.ProjectName]
+		if !ok {
+			continue
+		}
+		if item.Kind == "image" {
+			ov.ImageExists = true
+		} else if item.Size > 0 {
+			ov.VolumeSize += item.Size
+		}
+	}
+
+	var result []ProjectOverview
+	for name, ov := range overviews {
+		ov.Sessions = len(sessions[name])
+
+		if imageID := shellImages[name]; !ov.ImageExists && imageID != "" {
+			ov.ImageExists, err = docker.imageExists(imageID)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		result = append(result, *ov)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ProjectName < result[j].ProjectName
+	})
+
 	return result, nil
 }
 
@@ -292,11 +735,7 @@ func CleanupOrphaned(dryRun bool) (int, error) {
 		}
 
 		// Track network to remove
-		if session.Session == "default" {
-			networksToRemove[fmt.Sprintf("%s-network", session.ProjectName)] = true
-		} else {
-			networksToRemove[fmt.Sprintf("%s-%s-network", session.ProjectName, session.Session)] = true
-		}
+		networksToRemove[sessionNetworkName(session.ProjectName, session.Session)] = true
 	}
 
 	if !dryRun && len(networksToRemove) > 0 {
@@ -354,11 +793,7 @@ func CleanupOrphanedSessions(sessions []OrphanedSession, dryRun bool) (int, erro
 		}
 
 		// Track network to remove
-		if session.Session == "default" {
-			networksToRemove[fmt.Sprintf("%s-network", session.ProjectName)] = true
-		} else {
-			networksToRemove[fmt.Sprintf("%s-%s-network", session.ProjectName, session.Session)] = true
-		}
+		networksToRemove[sessionNetworkName(session.ProjectName, session.Session)] = true
 	}
 
 	if !dryRun && len(networksToRemove) > 0 {
@@ -378,9 +813,42 @@ func CleanupOrphanedSessions(sessions []OrphanedSession, dryRun bool) (int, erro
 	return totalContainers, nil
 }
 
-// StopAll stops and removes all ISO-managed containers and networks across all projects
+// CleanupDanglingNetworks removes networks created by iso, across all
+// projects, that have no containers attached. With dryRun set nothing is
+// removed. Returns the networks removed, or that would be removed.
+// This function does not require being in a project directory
+func CleanupDanglingNetworks(dryRun bool) ([]string, error) {
+	docker, err := newDockerClient()
+	if err != nil {
+		return nil, err
+	}
+	defer docker.close()
+
+	unused, err := docker.listUnusedNetworks("iso.managed=true")
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, networkName := range unused {
+		if dryRun {
+			removed = append(removed, networkName)
+			continue
+		}
+		if err := docker.removeNetwork(networkName); err != nil {
+			slog.Warn("failed to remove network", "network", networkName, "error", err)
+			continue
+		}
+		removed = append(removed, networkName)
+	}
+
+	return removed, nil
+}
+
+// StopAll stops and removes all ISO-managed containers and networks across all projects,
+// waiting up to timeout seconds for each container to exit.
 // This function does not require being in a project directory
-func StopAll() error {
+func StopAll(timeout int) error {
 	// Get all ISO containers
 	containers, err := ListAll()
 	if err != nil {
@@ -414,7 +882,6 @@ func StopAll() error {
 		}
 
 		// Stop and remove the container
-		timeout := 10
 		if _, err := docker.stopAndRemoveContainer(containerID, c.Name, timeout); err != nil {
 			// Error already logged by helper
 		}
@@ -428,7 +895,7 @@ func StopAll() error {
 
 	// Remove all project networks
 	for projectName := range projectNetworks {
-		networkName := fmt.Sprintf("%s-network", projectName)
+		networkName := sessionNetworkName(projectName, "default")
 		if err := docker.removeNetwork(networkName); err != nil {
 			// Ignore "not found" errors - network was already removed
 			if !strings.Contains(err.Error(), "not found") {
@@ -441,16 +908,84 @@ func StopAll() error {
 	return nil
 }
 
-// StopAllSessions stops and removes all sessions for the current project
+// SessionSummary describes one session of the current project
+type SessionSummary struct {
+	Name string `json:"name"`
+	// ContainerStatus is the Docker status of the session's main container
+	// ("" if only its service containers exist)
+	ContainerStatus string `json:"container_status"`
+	Services        int    `json:"services"`
+	ServicesRunning int    `json:"services_running"`
+}
+
+// Sessions summarizes the sessions of the current project (or git worktree),
+// sorted by name. This function requires being in a project directory
+func Sessions() ([]SessionSummary, error) {
+	isoDir, projectRoot, found := findIsoDir()
+	if !found {
+		return nil, ErrNoIsoDir
+	}
+
+	config, err := loadConfigFile(isoDir)
+	if err != nil {
+		return nil, err
+	}
+	_, worktreeProjectName := projectNames(projectRoot, config)
+
+	docker, err := newDockerClientFor(config.Context)
+	if err != nil {
+		return nil, err
+	}
+	defer docker.close()
+
+	containers, err := docker.listProjectContainersAllSessions(worktreeProjectName)
+	if err != nil {
+		return nil, err
+	}
+
+	bySession := make(map[string]*SessionSummary)
+	for _, c := range containers {
+		if c.Session == "" {
+			continue
+		}
+		s := bySession[c.Session]
+		if s == nil {
+			s = &SessionSummary{Name: c.Session}
+			bySession[c.Session] = s
+		}
+		if c.IsService {
+			s.Services++
+			if strings.HasPrefix(c.Status, "Up") {
+				s.ServicesRunning++
+			}
+		} else {
+			s.ContainerStatus = c.Status
+		}
+	}
+
+	sessions := make([]SessionSummary, 0, len(bySession))
+	for _, s := range bySession {
+		sessions = append(sessions, *s)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Name < sessions[j].Name })
+	return sessions, nil
+}
+
+// StopAllSessions stops and removes all sessions for the current project,
+// waiting up to timeout seconds for each container to exit.
 // This function requires being in a project directory
-func StopAllSessions() error {
+func StopAllSessions(timeout int) error {
 	// Find .iso directory to get project name
-	_, projectRoot, found := findIsoDir()
+	isoDir, projectRoot, found := findIsoDir()
 	if !found {
-		return fmt.Errorf("no .iso directory found - please create one with a Dockerfile and optional services.yml")
+		return ErrNoIsoDir
 	}
 
-	projectName := filepath.Base(projectRoot)
+	config, err := loadConfigFile(isoDir)
+	if err != nil {
+		return err
+	}
+	_, projectName := projectNames(projectRoot, config)
 
 	// Create Docker client
 	docker, err := newDockerClient()
@@ -474,7 +1009,6 @@ func StopAllSessions() error {
 	sessionNetworks := make(map[string]bool)
 
 	// Stop and remove all containers
-	timeout := 10
 	for _, c := range containers {
 		slog.Info("stopping container", "name", c.Name, "session", c.Session)
 
@@ -499,11 +1033,7 @@ func StopAllSessions() error {
 		}
 
 		// Track session networks to remove later
-		if c.Session == "default" {
-			sessionNetworks[fmt.Sprintf("%s-network", projectName)] = true
-		} else {
-			sessionNetworks[fmt.Sprintf("%s-%s-network", projectName, c.Session)] = true
-		}
+		sessionNetworks[sessionNetworkName(projectName, c.Session)] = true
 	}
 
 	// Give Docker a moment to clean up container endpoints before removing networks
@@ -523,8 +1053,98 @@ func StopAllSessions() error {
 	return nil
 }
 
-// InitProject initializes a new .iso directory with AI-generated configuration
+// generatedIsoFiles are the glob patterns, relative to the .iso directory, of
+// files that iso creates itself. Everything else in .iso is user-authored.
+var generatedIsoFiles = []string{
+	"iso-linux-*",
+	"startup.log",
+	"build.log",
+	"session-*.lock",
+}
+
+// CleanGenerated removes generated artifacts (the extracted Linux binary and
+// log files) from the current project's .iso directory, leaving the
+// Dockerfile, config and hook scripts intact. If updateGitignore is set, the
+// generated patterns are also added to .iso/.gitignore. Returns the paths
+// that were removed.
+func CleanGenerated(updateGitignore bool) ([]string, error) {
+	isoDir, _, found := findIsoDir()
+	if !found {
+		return nil, ErrNoIsoDir
+	}
+
+	var removed []string
+	for _, pattern := range generatedIsoFiles {
+		matches, err := filepath.Glob(filepath.Join(isoDir, pattern))
+		if err != nil {
+			return removed, fmt.Errorf("failed to match %s: %w", pattern, err)
+		}
+		for _, match := range matches {
+			if err := os.Remove(match); err != nil {
+				return removed, fmt.Errorf("failed to remove %s: %w", match, err)
+			}
+			removed = append(removed, match)
+		}
+	}
+
+	if updateGitignore {
+		if err := addToGitignore(filepath.Join(isoDir, ".gitignore"), generatedIsoFiles); err != nil {
+			return removed, err
+		}
+	}
+
+	return removed, nil
+}
+
+// addToGitignore appends any of patterns not already listed in the given
+// .gitignore file, creating it if needed
+func addToGitignore(gitignorePath string, patterns []string) error {
+	existing, err := os.ReadFile(gitignorePath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", gitignorePath, err)
+	}
+
+	present := make(map[string]bool)
+	for _, line := range strings.Split(string(existing), "\n") {
+		present[strings.TrimSpace(line)] = true
+	}
+
+	var buf bytes.Buffer
+	buf.Write(existing)
+	if len(existing) > 0 && !bytes.HasSuffix(existing, []byte("\n")) {
+		buf.WriteString("\n")
+	}
+	added := false
+	for _, pattern := range patterns {
+		if !present[pattern] {
+			buf.WriteString(pattern + "\n")
+			added = true
+		}
+	}
+	if !added {
+		return nil
+	}
+
+	if err := os.WriteFile(gitignorePath, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", gitignorePath, err)
+	}
+	return nil
+}
+
+// InitProject initializes a new .iso directory with AI-generated
+// configuration, from the provider selected by ISO_INIT_PROVIDER (claude by
+// default)
 func InitProject() error {
+	provider, err := NewInitProvider("")
+	if err != nil {
+		return err
+	}
+	return InitProjectWithProvider(provider)
+}
+
+// InitProjectWithProvider initializes a new .iso directory with configuration
+// generated by provider
+func InitProjectWithProvider(provider InitProvider) error {
 	// Get current directory
 	cwd, err := os.Getwd()
 	if err != nil {
@@ -539,7 +1159,7 @@ func InitProject() error {
 
 	slog.Info("analyzing project to generate ISO configuration")
 
-	// Prepare the prompt for Claude
+	// Prepare the prompt for the provider
 	prompt := `You are helping initialize an ISO (Isolated Docker Environment) project.
 
 Analyze the current project directory and generate:
@@ -581,24 +1201,15 @@ Please respond with EXACTLY this format:
 
 Be concise and practical. Focus on what this specific project needs.`
 
-	// Call claude CLI with --print mode
-	cmd := exec.Command("claude", "--print", prompt)
-	cmd.Dir = cwd
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to run claude: %w\nStderr: %s", err, stderr.String())
+	response, err := provider.Generate(prompt)
+	if err != nil {
+		return err
 	}
 
-	response := stdout.String()
-
 	// Parse the response
 	dockerfile, services, err := parseInitResponse(response)
 	if err != nil {
-		return fmt.Errorf("failed to parse claude response: %w", err)
+		return fmt.Errorf("failed to parse generated configuration: %w", err)
 	}
 
 	// Create .iso directory
@@ -636,29 +1247,105 @@ Be concise and practical. Focus on what this specific project needs.`
 	return nil
 }
 
-// parseInitResponse parses the Claude response to extract Dockerfile and services.yml
+// Markers delimiting the sections of an init response. Each must be on a
+// line of its own.
+const (
+	dockerfileMarker    = "===DOCKERFILE==="
+	dockerfileEndMarker = "===END_DOCKERFILE==="
+	servicesMarker      = "===SERVICES==="
+	servicesEndMarker   = "===END_SERVICES==="
+	noServicesNeeded    = "NO_SERVICES_NEEDED"
+)
+
+// parseInitResponse parses the provider's response to extract Dockerfile and
+// services.yml. Markers only count on lines of their own, so generated
+// content mentioning them doesn't split a section. Each must appear exactly
+// once and in order; otherwise the error quotes the start of the response.
 func parseInitResponse(response string) (dockerfile, services string, err error) {
-	// Extract Dockerfile
-	dockerfileStart := strings.Index(response, "===DOCKERFILE===")
-	dockerfileEnd := strings.Index(response, "===END_DOCKERFILE===")
-	if dockerfileStart == -1 || dockerfileEnd == -1 {
-		return "", "", fmt.Errorf("invalid response format: missing Dockerfile markers")
+	dockerfile, services, err = parseInitSections(response)
+	if err != nil {
+		return "", "", fmt.Errorf("%w\nResponse began with:\n%s", err, responseSnippet(response))
+	}
+	return dockerfile, services, nil
+}
+
+// parseInitSections implements parseInitResponse without the snippet
+func parseInitSections(response string) (dockerfile, services string, err error) {
+	lines := strings.Split(strings.ReplaceAll(response, "\r\n", "\n"), "\n")
+
+	// Line index of each marker, in the order they must appear
+	markers := []string{dockerfileMarker, dockerfileEndMarker, servicesMarker, servicesEndMarker}
+	positions := make([]int, len(markers))
+	for i, marker := range markers {
+		count := 0
+		for n, line := range lines {
+			if strings.TrimSpace(line) == marker {
+				positions[i] = n
+				count++
+			}
+		}
+		if count != 1 {
+			return "", "", fmt.Errorf("invalid response format: expected one %s line, found %d", marker, count)
+		}
+		if i > 0 && positions[i] < positions[i-1] {
+			return "", "", fmt.Errorf("invalid response format: %s comes before %s", marker, markers[i-1])
+		}
 	}
-	dockerfile = strings.TrimSpace(response[dockerfileStart+len("===DOCKERFILE===") : dockerfileEnd])
 
-	// Extract services
-	servicesStart := strings.Index(response, "===SERVICES===")
-	servicesEnd := strings.Index(response, "===END_SERVICES===")
-	if servicesStart == -1 || servicesEnd == -1 {
-		return "", "", fmt.Errorf("invalid response format: missing services markers")
+	dockerfile = sectionContent(lines[positions[0]+1 : positions[1]])
+	if dockerfile == "" {
+		return "", "", fmt.Errorf("invalid response format: the Dockerfile section is empty")
+	}
+	if !slices.ContainsFunc(strings.Split(dockerfile, "\n"), func(line string) bool {
+		fields := strings.Fields(line)
+		return len(fields) > 0 && strings.EqualFold(fields[0], "FROM")
+	}) {
+		return "", "", fmt.Errorf("invalid response format: the Dockerfile section has no FROM instruction")
 	}
-	servicesContent := strings.TrimSpace(response[servicesStart+len("===SERVICES===") : servicesEnd])
 
-	if servicesContent != "NO_SERVICES_NEEDED" {
-		services = servicesContent
+	servicesContent := sectionContent(lines[positions[2]+1 : positions[3]])
+	if servicesContent == noServicesNeeded {
+		return dockerfile, "", nil
+	}
+	if servicesContent == "" {
+		return "", "", fmt.Errorf("invalid response format: the services section is empty (expected services.yml or %s)", noServicesNeeded)
+	}
+	var servicesDoc struct {
+		Services map[string]any `yaml:"services"`
+	}
+	if err := yaml.Unmarshal([]byte(servicesContent), &servicesDoc); err != nil {
+		return "", "", fmt.Errorf("invalid response format: the services section is not valid YAML: %w", err)
+	}
+	if len(servicesDoc.Services) == 0 {
+		return "", "", fmt.Errorf("invalid response format: the services section has no services")
 	}
+	return dockerfile, servicesContent, nil
+}
 
-	return dockerfile, services, nil
+// sectionContent joins the lines of a response section, dropping a Markdown
+// code fence around them
+func sectionContent(lines []string) string {
+	content := strings.TrimSpace(strings.Join(lines, "\n"))
+	if strings.HasPrefix(content, "```") && strings.HasSuffix(content, "```") {
+		first, rest, _ := strings.Cut(content, "\n")
+		if first != content {
+			content = strings.TrimSpace(strings.TrimSuffix(rest, "```"))
+		}
+	}
+	return content
+}
+
+// responseSnippetLength bounds how much of a malformed response
+// parseInitResponse quotes
+const responseSnippetLength = 300
+
+// responseSnippet returns the start of response for error messages
+func responseSnippet(response string) string {
+	response = strings.TrimSpace(response)
+	if len(response) > responseSnippetLength {
+		return response[:responseSnippetLength] + "..."
+	}
+	return response
 }
 
 // PeersUp starts all or specific peer containers
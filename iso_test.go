@@ -0,0 +1,90 @@
+package iso
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseInitResponse(t *testing.T) {
+	response := "Here is the configuration:\n\n" +
+		"===DOCKERFILE===\n" +
+		"```dockerfile\n" +
+		"FROM golang:1.24\n" +
+		"# Markers inside content don't count: ===END_DOCKERFILE===\n" +
+		"RUN echo ===SERVICES===\n" +
+		"WORKDIR /workspace\n" +
+		"```\n" +
+		"===END_DOCKERFILE===\n\n" +
+		"===SERVICES===\n" +
+		"services:\n" +
+		"  db:\n" +
+		"    image: postgres:16\n" +
+		"    port: 5432\n" +
+		"===END_SERVICES===\n"
+
+	dockerfile, services, err := parseInitResponse(response)
+	if err != nil {
+		t.Fatalf("parseInitResponse() error = %v", err)
+	}
+	wantDockerfile := "FROM golang:1.24\n# Markers inside content don't count: ===END_DOCKERFILE===\nRUN echo ===SERVICES===\nWORKDIR /workspace"
+	if dockerfile != wantDockerfile {
+		t.Errorf("dockerfile = %q, want %q", dockerfile, wantDockerfile)
+	}
+	if !strings.HasPrefix(services, "services:\n  db:") {
+		t.Errorf("services = %q, want the services.yml content", services)
+	}
+}
+
+func TestParseInitResponseNoServices(t *testing.T) {
+	response := "===DOCKERFILE===\r\nFROM alpine\r\n===END_DOCKERFILE===\r\n===SERVICES===\r\nNO_SERVICES_NEEDED\r\n===END_SERVICES===\r\n"
+	dockerfile, services, err := parseInitResponse(response)
+	if err != nil {
+		t.Fatalf("parseInitResponse() error = %v", err)
+	}
+	if dockerfile != "FROM alpine" || services != "" {
+		t.Errorf("parseInitResponse() = %q, %q, want FROM alpine and no services", dockerfile, services)
+	}
+}
+
+func TestParseInitResponseMalformed(t *testing.T) {
+	const services = "===SERVICES===\nNO_SERVICES_NEEDED\n===END_SERVICES===\n"
+	cases := []struct {
+		name     string
+		response string
+		wantErr  string
+	}{
+		{"no markers", "I can't help with that.", "expected one ===DOCKERFILE=== line, found 0"},
+		{"end before start", "===END_DOCKERFILE===\n===DOCKERFILE===\nFROM alpine\n" + services, "===END_DOCKERFILE=== comes before ===DOCKERFILE==="},
+		{"services first", services + "===DOCKERFILE===\nFROM alpine\n===END_DOCKERFILE===\n", "===SERVICES=== comes before ===END_DOCKERFILE==="},
+		{"two dockerfiles", "===DOCKERFILE===\nFROM a\n===END_DOCKERFILE===\n===DOCKERFILE===\nFROM b\n===END_DOCKERFILE===\n" + services, "found 2"},
+		{"unterminated services", "===DOCKERFILE===\nFROM alpine\n===END_DOCKERFILE===\n===SERVICES===\nservices: {}\n", "expected one ===END_SERVICES=== line, found 0"},
+		{"empty dockerfile", "===DOCKERFILE===\n\n===END_DOCKERFILE===\n" + services, "Dockerfile section is empty"},
+		{"not a dockerfile", "===DOCKERFILE===\nSure! Here's a Dockerfile for you.\n===END_DOCKERFILE===\n" + services, "no FROM instruction"},
+		{"bad yaml", "===DOCKERFILE===\nFROM alpine\n===END_DOCKERFILE===\n===SERVICES===\nservices: [db\n===END_SERVICES===\n", "not valid YAML"},
+		{"no services", "===DOCKERFILE===\nFROM alpine\n===END_DOCKERFILE===\n===SERVICES===\nversion: 3\n===END_SERVICES===\n", "has no services"},
+	}
+
+	for _, tc := range cases {
+		_, _, err := parseInitResponse(tc.response)
+		if err == nil {
+			t.Errorf("%s: parseInitResponse() expected error", tc.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%s: error = %q, want it to contain %q", tc.name, err, tc.wantErr)
+		}
+		if !strings.Contains(err.Error(), "Response began with:") {
+			t.Errorf("%s: error = %q, want a snippet of the response", tc.name, err)
+		}
+	}
+}
+
+func TestResponseSnippet(t *testing.T) {
+	long := strings.Repeat("x", responseSnippetLength+50)
+	if got := responseSnippet(long); len(got) != responseSnippetLength+len("...") || !strings.HasSuffix(got, "...") {
+		t.Errorf("responseSnippet() = %d bytes, want truncation to %d", len(got), responseSnippetLength)
+	}
+	if got := responseSnippet("  short\n"); got != "short" {
+		t.Errorf("responseSnippet() = %q, want %q", got, "short")
+	}
+}
@@ -0,0 +1,235 @@
+package iso
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/moby/term"
+)
+
+// jobsDir is the directory in the session container, on the /run/iso tmpfs,
+// where detached runs keep their output (<id>.log), the pid of their
+// _internal-job wrapper (<id>.pid), the socket Attach connects to (<id>.sock)
+// and, once finished, their exit code (<id>.exit). Jobs don't outlive the
+// container.
+var jobsDir = path.Join(path.Dir(initReadyFile), "jobs")
+
+// Job is a command started in the background by RunDetached
+type Job struct {
+	ID            string   `json:"id"`
+	ContainerName string   `json:"container"`
+	ExecID        string   `json:"exec_id"`
+	Command       []string `json:"command"`
+}
+
+// RunDetached starts a command in the session container without attaching to
+// it and returns once it has started. The command runs on a pseudo-terminal
+// in the container; its output goes to a log there that JobLogs reads back,
+// and Attach connects to it. Detached runs need a persistent session: an
+// ephemeral session's services and container would go away with the iso
+// process.
+func (c *Client) RunDetached(command []string, opts RunOptions) (*Job, error) {
+	if len(command) == 0 {
+		return nil, fmt.Errorf("no command specified")
+	}
+
+	return c.containerManager.runDetached(command, opts)
+}
+
+// JobLogs writes the output of a detached run to stdout: the last tail lines
+// (negative shows all) and, with follow, new output until the job finishes.
+// It returns the job's exit code, or 0 while the job is still running.
+func (c *Client) JobLogs(id string, follow bool, tail int) (int, error) {
+	return c.containerManager.jobLogs(id, follow, tail)
+}
+
+// Attach connects the terminal to work running in the session container. With
+// a job ID, or when a detached run is still running (the latest one started),
+// it attaches to that job's terminal: the end of its earlier output is shown,
+// then input, window size changes and keys like Ctrl+C go to the job until it
+// exits or Ctrl+P Ctrl+Q detaches, leaving it running. Otherwise it opens the
+// configured shell like Shell. Returns the exit code of the job or shell, or 0
+// after detaching.
+func (c *Client) Attach(jobID string) (int, error) {
+	return c.containerManager.attach(jobID)
+}
+
+// newJobID returns a short random job ID
+func newJobID() string {
+	return strings.ToLower(rand.Text()[:10])
+}
+
+// runDetached implements Client.RunDetached
+func (cm *containerManager) runDetached(command []string, opts RunOptions) (*Job, error) {
+	if opts.Ephemeral {
+		return nil, fmt.Errorf("detached runs need a persistent session (use --session or set ISO_SESSION)")
+	}
+	if len(opts.Secrets) > 0 {
+		return nil, fmt.Errorf("secrets can't be mounted for a detached run")
+	}
+	if err := cm.selectServices(opts); err != nil {
+		return nil, err
+	}
+	cm.privilegedOverride = opts.Privileged
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	// The job gets a terminal from _internal-job rather than the exec, so
+	// build the config for a TTY (passing TERM through) and then detach it
+	execConfig, err := cm.buildExecConfig(command, opts, cwd, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// Nothing is attached, so _internal-job sends the output to the job's log
+	id := newJobID()
+	execConfig.Cmd = append([]string{"/iso", "_internal-job", "--", path.Join(jobsDir, id)}, execConfig.Cmd...)
+	execConfig.AttachStdin = false
+	execConfig.AttachStdout = false
+	execConfig.AttachStderr = false
+	execConfig.Tty = false
+	execConfig.Detach = true
+
+	if opts.PrintCommand {
+		fmt.Fprint(os.Stderr, formatExecConfig(cm.containerName, execConfig))
+	}
+
+	containerID, cleanup, err := cm.prepareRun(false)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	if opts.WorkDir != "" {
+		if err := cm.checkWorkDir(containerID, execConfig.WorkingDir); err != nil {
+			return nil, err
+		}
+	}
+
+	execResp, err := cm.docker.client.ContainerExecCreate(cm.docker.ctx, containerID, execConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exec: %w", err)
+	}
+	if err := cm.docker.client.ContainerExecStart(cm.docker.ctx, execResp.ID, container.ExecStartOptions{Detach: true}); err != nil {
+		return nil, fmt.Errorf("failed to start exec: %w", err)
+	}
+
+	return &Job{
+		ID:            id,
+		ContainerName: cm.containerName,
+		ExecID:        execResp.ID,
+		Command:       command,
+	}, nil
+}
+
+// jobLogs implements Client.JobLogs by running _internal-job-log in the
+// session container, which reads the job's log where it's written
+func (cm *containerManager) jobLogs(id string, follow bool, tail int) (int, error) {
+	if id == "" || strings.ContainsAny(id, "/.") {
+		return 0, fmt.Errorf("invalid job ID %q", id)
+	}
+
+	running, err := cm.docker.isContainerRunning(cm.containerName)
+	if err != nil {
+		return 0, err
+	}
+	if !running {
+		return 0, fmt.Errorf("session container %s is not running, so it has no jobs", cm.containerName)
+	}
+
+	cmd := []string{"/iso", "_internal-job-log"}
+	if tail >= 0 {
+		cmd = append(cmd, "--tail", strconv.Itoa(tail))
+	}
+	if follow {
+		cmd = append(cmd, "--follow")
+	}
+	cmd = append(cmd, "--", path.Join(jobsDir, id))
+
+	execResp, err := cm.docker.client.ContainerExecCreate(cm.docker.ctx, cm.containerName, container.ExecOptions{
+		Cmd:          cmd,
+		User:         cm.config.User,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create exec: %w", err)
+	}
+
+	attachResp, err := cm.docker.client.ContainerExecAttach(cm.docker.ctx, execResp.ID, container.ExecStartOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to attach to exec: %w", err)
+	}
+	defer attachResp.Close()
+
+	if _, err := stdcopy.StdCopy(os.Stdout, os.Stderr, attachResp.Reader); err != nil {
+		return 0, fmt.Errorf("failed to read job log: %w", err)
+	}
+
+	inspectResp, err := cm.docker.client.ContainerExecInspect(cm.docker.ctx, execResp.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect exec: %w", err)
+	}
+	return inspectResp.ExitCode, nil
+}
+
+// runningJobs returns the IDs of the detached runs still running in the
+// session container, most recently started first
+func (cm *containerManager) runningJobs(containerID string) ([]string, error) {
+	out, err := cm.execOutput(containerID, []string{"/iso", "_internal-jobs", jobsDir})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	return strings.Fields(out), nil
+}
+
+// attach implements Client.Attach
+func (cm *containerManager) attach(jobID string) (int, error) {
+	if strings.ContainsAny(jobID, "/.") {
+		return 0, fmt.Errorf("invalid job ID %q", jobID)
+	}
+	if jobID == "" {
+		running, err := cm.docker.isContainerRunning(cm.containerName)
+		if err != nil {
+			return 0, err
+		}
+		if running {
+			jobs, err := cm.runningJobs(cm.containerName)
+			if err != nil {
+				return 0, err
+			}
+			if len(jobs) > 0 {
+				jobID = jobs[0]
+			}
+		}
+	}
+
+	if jobID == "" {
+		fmt.Fprintln(os.Stderr, "iso: no detached run is running, opening a shell")
+		return cm.runCommand(cm.shellCommand(), RunOptions{
+			Interactive: true,
+			TTY:         true,
+		})
+	}
+
+	execConfig := container.ExecOptions{
+		Cmd:          []string{"/iso", "_internal-job-attach", path.Join(jobsDir, jobID)},
+		User:         cm.config.User,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          term.IsTerminal(os.Stdin.Fd()),
+	}
+
+	fmt.Fprintf(os.Stderr, "iso: attached to job %s (Ctrl+P Ctrl+Q to detach, the job keeps running)\n", jobID)
+	return cm.execAttached(cm.containerName, execConfig, true, 0)
+}
@@ -0,0 +1,221 @@
+package iso
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// defaultMetricsInterval is how often resource usage is sampled when
+// RunOptions.MetricsInterval isn't set
+const defaultMetricsInterval = time.Second
+
+// RunMetrics is the resource usage report written by RunOptions.CaptureMetrics
+type RunMetrics struct {
+	Command  []string `json:"command"`
+	ExitCode int      `json:"exit_code"`
+	// DurationSeconds is the wall time from the first exec to the last
+	DurationSeconds float64 `json:"duration_seconds"`
+	// PeakMemoryBytes is the highest container memory usage sampled, excluding
+	// the reclaimable page cache (like `docker stats`)
+	PeakMemoryBytes uint64 `json:"peak_memory_bytes"`
+	// CPUSeconds is the CPU time the container consumed during the run
+	CPUSeconds float64 `json:"cpu_seconds"`
+	Samples    int     `json:"samples"`
+	IntervalMS int64   `json:"interval_ms"`
+	// Error is set when the run failed before its commands finished, in which
+	// case the report covers the run up to the failure
+	Error string `json:"error,omitempty"`
+}
+
+// metricsSampler periodically samples a container's resource usage
+type metricsSampler struct {
+	cm          *containerManager
+	containerID string
+	interval    time.Duration
+	started     time.Time
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+
+	mu         sync.Mutex
+	peakMemory uint64
+	firstCPU   uint64
+	lastCPU    uint64
+	samples    int
+}
+
+// startMetricsSampler begins sampling containerID every interval (0 selects
+// defaultMetricsInterval) until stop is called
+func (cm *containerManager) startMetricsSampler(containerID string, interval time.Duration) *metricsSampler {
+	if interval <= 0 {
+		interval = defaultMetricsInterval
+	}
+
+	s := &metricsSampler{
+		cm:          cm,
+		containerID: containerID,
+		interval:    interval,
+		started:     time.Now(),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		s.sample()
+		for {
+			select {
+			case <-ticker.C:
+				s.sample()
+			case <-s.stop:
+				return
+			case <-cm.docker.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return s
+}
+
+// sample records one reading of the container's memory and CPU usage
+func (s *metricsSampler) sample() {
+	resp, err := s.cm.docker.client.ContainerStatsOneShot(s.cm.docker.ctx, s.containerID)
+	if err != nil {
+		slog.Debug("failed to sample container stats", "error", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var stats container.StatsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		slog.Debug("failed to decode container stats", "error", err)
+		return
+	}
+
+	memory := statsMemory(&stats)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.peakMemory = max(s.peakMemory, memory)
+	if s.samples == 0 {
+		s.firstCPU = stats.CPUStats.CPUUsage.TotalUsage
+	}
+	s.lastCPU = stats.CPUStats.CPUUsage.TotalUsage
+	s.samples++
+}
+
+// halt stops sampling and waits for the sampling goroutine to exit. It may be
+// called more than once.
+func (s *metricsSampler) halt() {
+	s.stopOnce.Do(func() { close(s.stop) })
+	<-s.done
+}
+
+// finish stops sampling, takes a final sample and returns the report
+func (s *metricsSampler) finish(command []string, exitCode int) RunMetrics {
+	s.halt()
+	s.sample()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return RunMetrics{
+		Command:         command,
+		ExitCode:        exitCode,
+		DurationSeconds: time.Since(s.started).Seconds(),
+		PeakMemoryBytes: s.peakMemory,
+		CPUSeconds:      float64(s.lastCPU-s.firstCPU) / float64(time.Second),
+		Samples:         s.samples,
+		IntervalMS:      s.interval.Milliseconds(),
+	}
+}
+
+// statsMemory returns a container's memory usage from its stats, not
+// counting page cache the kernel can reclaim (like `docker stats`)
+func statsMemory(stats *container.StatsResponse) uint64 {
+	memory := stats.MemoryStats.Usage
+	if inactive, ok := stats.MemoryStats.Stats["inactive_file"]; ok && inactive < memory {
+		memory -= inactive
+	}
+	return memory
+}
+
+// statsCPUPercent returns a container's CPU usage over the stats' sampling
+// period as a percentage of one CPU (so 200 means two busy CPUs), computed
+// like `docker stats`
+func statsCPUPercent(stats *container.StatsResponse) float64 {
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+	cpus := float64(stats.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	return cpuDelta / systemDelta * cpus * 100
+}
+
+// containerUsage returns a running container's current memory usage and
+// CPU percentage. Docker samples the CPU over about a second, so this takes
+// that long.
+func (cm *containerManager) containerUsage(containerID string) (memory uint64, cpuPercent float64, err error) {
+	resp, err := cm.docker.client.ContainerStats(cm.docker.ctx, containerID, false)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get container stats: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var stats container.StatsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return 0, 0, fmt.Errorf("failed to decode container stats: %w", err)
+	}
+	return statsMemory(&stats), statsCPUPercent(&stats), nil
+}
+
+// writeStepMetrics finishes sampler and writes the report for the run of
+// results to path. runErr is recorded when the run failed part way.
+func writeStepMetrics(path string, sampler *metricsSampler, results []StepResult, runErr error) error {
+	var command []string
+	exitCode := 0
+	for i, r := range results {
+		if i > 0 {
+			command = append(command, "--then")
+		}
+		command = append(command, r.Command...)
+		if exitCode == 0 && !r.Skipped {
+			exitCode = r.ExitCode
+		}
+	}
+
+	metrics := sampler.finish(command, exitCode)
+	if runErr != nil {
+		metrics.Error = runErr.Error()
+	}
+	return writeRunMetrics(path, metrics)
+}
+
+// writeRunMetrics writes the metrics report as JSON to path
+func writeRunMetrics(path string, metrics RunMetrics) error {
+	data, err := json.MarshalIndent(metrics, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode metrics: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package iso
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestStatsUsage(t *testing.T) {
+	stats := &container.StatsResponse{}
+	stats.MemoryStats.Usage = 300
+	stats.MemoryStats.Stats = map[string]uint64{"inactive_file": 100}
+	stats.PreCPUStats.CPUUsage.TotalUsage = 1000
+	stats.PreCPUStats.SystemUsage = 10000
+	stats.CPUStats.CPUUsage.TotalUsage = 1500
+	stats.CPUStats.SystemUsage = 20000
+	stats.CPUStats.OnlineCPUs = 4
+
+	if got := statsMemory(stats); got != 200 {
+		t.Errorf("statsMemory() = %d, want 200", got)
+	}
+	if got := statsCPUPercent(stats); got != 20 {
+		t.Errorf("statsCPUPercent() = %v, want 20", got)
+	}
+
+	// A one-shot sample has no previous reading to compare against
+	stats.PreCPUStats = container.CPUStats{}
+	stats.CPUStats.SystemUsage = 0
+	if got := statsCPUPercent(stats); got != 0 {
+		t.Errorf("statsCPUPercent() without a previous sample = %v, want 0", got)
+	}
+}
+
+// TestWriteStepMetricsAfterFailure covers a run whose exec failed: the sampler
+// must stop and a partial report naming the error must still be written
+func TestWriteStepMetricsAfterFailure(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /containers/{id}/stats", func(w http.ResponseWriter, r *http.Request) {
+		stats := container.StatsResponse{}
+		stats.MemoryStats.Usage = 4096
+		json.NewEncoder(w).Encode(stats)
+	})
+
+	cm := &containerManager{docker: newFakeDocker(t, mux)}
+	sampler := cm.startMetricsSampler("app", time.Millisecond)
+	defer sampler.halt()
+
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	results := []StepResult{{Command: []string{"make", "test"}}}
+	if err := writeStepMetrics(path, sampler, results, errors.New("failed to attach to exec")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var metrics RunMetrics
+	if err := json.Unmarshal(data, &metrics); err != nil {
+		t.Fatal(err)
+	}
+	if metrics.Error != "failed to attach to exec" || metrics.PeakMemoryBytes != 4096 {
+		t.Errorf("metrics = %+v, want the error and the sampled memory", metrics)
+	}
+}
@@ -0,0 +1,168 @@
+package iso
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types/registry"
+)
+
+// dockerHubAuthKey is the key Docker Hub credentials are stored under in the
+// Docker config and credential helpers
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+// dockerConfigFile is the subset of the Docker CLI's config.json that holds
+// registry credentials
+type dockerConfigFile struct {
+	Auths       map[string]dockerConfigAuth `json:"auths"`
+	CredsStore  string                      `json:"credsStore"`
+	CredHelpers map[string]string           `json:"credHelpers"`
+}
+
+// dockerConfigAuth is one entry of config.json's auths
+type dockerConfigAuth struct {
+	Auth          string `json:"auth"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+// registryHost returns the registry an image reference lives in, using the
+// same rule as Docker: the first path component is a registry if it contains
+// a "." or ":" or is "localhost", otherwise the image is on Docker Hub
+func registryHost(ref string) string {
+	first, _, ok := strings.Cut(ref, "/")
+	if ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return first
+	}
+	return "docker.io"
+}
+
+// registryAuth returns the encoded X-Registry-Auth value for pulling or
+// pushing ref, with credentials from the Docker CLI config (as written by
+// `docker login`), including credential helpers. Without stored credentials
+// it encodes anonymous access.
+func registryAuth(ref string) (string, error) {
+	authConfig, err := lookupRegistryAuth(dockerConfigDir(), registryHost(ref))
+	if err != nil {
+		slog.Warn("failed to read registry credentials, continuing without them", "image", ref, "error", err)
+		authConfig = registry.AuthConfig{}
+	}
+	return registry.EncodeAuthConfig(authConfig)
+}
+
+// dockerConfigDir returns the Docker CLI config directory ($DOCKER_CONFIG or
+// ~/.docker)
+func dockerConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker")
+}
+
+// lookupRegistryAuth finds the credentials for host in the Docker config in
+// configDir. A missing config or entry yields empty (anonymous) credentials.
+func lookupRegistryAuth(configDir, host string) (registry.AuthConfig, error) {
+	if configDir == "" {
+		return registry.AuthConfig{}, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(configDir, "config.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return registry.AuthConfig{}, nil
+	}
+	if err != nil {
+		return registry.AuthConfig{}, fmt.Errorf("failed to read docker config: %w", err)
+	}
+
+	var config dockerConfigFile
+	if err := json.Unmarshal(data, &config); err != nil {
+		return registry.AuthConfig{}, fmt.Errorf("failed to parse docker config: %w", err)
+	}
+
+	serverAddress := host
+	if host == "docker.io" {
+		serverAddress = dockerHubAuthKey
+	}
+
+	helper := config.CredsStore
+	if h, ok := config.CredHelpers[host]; ok {
+		helper = h
+	}
+	if helper != "" {
+		return credentialHelperAuth(helper, serverAddress)
+	}
+
+	entry, ok := config.Auths[serverAddress]
+	if !ok {
+		// Entries may also be stored with a scheme, e.g. https://ghcr.io
+		entry, ok = config.Auths["https://"+serverAddress]
+	}
+	if !ok {
+		return registry.AuthConfig{}, nil
+	}
+
+	authConfig := registry.AuthConfig{
+		ServerAddress: serverAddress,
+		IdentityToken: entry.IdentityToken,
+	}
+	if entry.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return registry.AuthConfig{}, fmt.Errorf("invalid auth for %s in docker config: %w", host, err)
+		}
+		username, password, ok := strings.Cut(string(decoded), ":")
+		if !ok {
+			return registry.AuthConfig{}, fmt.Errorf("invalid auth for %s in docker config", host)
+		}
+		authConfig.Username = username
+		authConfig.Password = password
+	}
+	return authConfig, nil
+}
+
+// credentialHelperAuth asks the docker-credential-<helper> program for the
+// credentials of serverAddress
+func credentialHelperAuth(helper, serverAddress string) (registry.AuthConfig, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverAddress)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		// Helpers report a missing entry on stdout or stderr and exit non-zero
+		if strings.Contains(string(out)+stderr.String(), "credentials not found") {
+			return registry.AuthConfig{}, nil
+		}
+		return registry.AuthConfig{}, fmt.Errorf("credential helper %s failed: %w", helper, err)
+	}
+
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return registry.AuthConfig{}, fmt.Errorf("failed to parse credential helper %s output: %w", helper, err)
+	}
+
+	authConfig := registry.AuthConfig{ServerAddress: serverAddress}
+	// Helpers return identity tokens with this placeholder username
+	if creds.Username == "<token>" {
+		authConfig.IdentityToken = creds.Secret
+	} else {
+		authConfig.Username = creds.Username
+		authConfig.Password = creds.Secret
+	}
+	return authConfig, nil
+}
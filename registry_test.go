@@ -0,0 +1,64 @@
+package iso
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegistryHost(t *testing.T) {
+	cases := []struct {
+		ref  string
+		want string
+	}{
+		{"postgres:16", "docker.io"},
+		{"acme/app:latest", "docker.io"},
+		{"ghcr.io/acme/app-iso:latest", "ghcr.io"},
+		{"localhost/app", "localhost"},
+		{"registry.local:5000/app:dev", "registry.local:5000"},
+	}
+
+	for _, tc := range cases {
+		if got := registryHost(tc.ref); got != tc.want {
+			t.Errorf("registryHost(%q) = %q, want %q", tc.ref, got, tc.want)
+		}
+	}
+}
+
+func TestLookupRegistryAuth(t *testing.T) {
+	dir := t.TempDir()
+	auth := base64.StdEncoding.EncodeToString([]byte("octocat:s3cret:with-colon"))
+	config := `{"auths": {
+		"ghcr.io": {"auth": "` + auth + `"},
+		"https://index.docker.io/v1/": {"identitytoken": "tok"}
+	}}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := lookupRegistryAuth(dir, "ghcr.io")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Username != "octocat" || got.Password != "s3cret:with-colon" || got.ServerAddress != "ghcr.io" {
+		t.Errorf("ghcr.io auth = %+v", got)
+	}
+
+	got, err = lookupRegistryAuth(dir, "docker.io")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.IdentityToken != "tok" || got.ServerAddress != dockerHubAuthKey {
+		t.Errorf("docker.io auth = %+v", got)
+	}
+
+	got, err = lookupRegistryAuth(dir, "quay.io")
+	if err != nil || got.Username != "" || got.IdentityToken != "" {
+		t.Errorf("quay.io auth = %+v, %v, want anonymous", got, err)
+	}
+
+	if got, err := lookupRegistryAuth(t.TempDir(), "ghcr.io"); err != nil || got.Username != "" {
+		t.Errorf("missing config = %+v, %v, want anonymous", got, err)
+	}
+}
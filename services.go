@@ -1,12 +1,28 @@
 package iso
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/url"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"gopkg.in/yaml.v3"
 )
 
@@ -17,6 +33,7 @@ type Config struct {
 	Volumes     []string          `yaml:"volumes"`
 	Cache       []string          `yaml:"cache"`
 	Binds       []string          `yaml:"binds"`
+	Mounts      []string          `yaml:"mounts"` // alias of Binds, merged into it on load
 	Environment map[string]string `yaml:"environment"`
 	ExtraHosts  []string          `yaml:"extra_hosts"`
 	// Ports publishes container ports on the host in Docker's
@@ -25,22 +42,219 @@ type Config struct {
 	// needs to be reached from the host (e.g. a browser hitting a dev cluster
 	// for OAuth callback testing).
 	Ports []string `yaml:"ports"`
+	// MaxParallel bounds how many service starts and image pulls iso runs
+	// concurrently. Defaults to the number of CPUs, capped at 4.
+	MaxParallel int `yaml:"max_parallel"`
+	// ReadOnlyWorkspace mounts the project root read-only inside the container
+	ReadOnlyWorkspace bool `yaml:"read_only_workspace"`
+	// WritablePaths lists directories under WorkDir (absolute, or relative to
+	// WorkDir) that stay writable on top of a read-only workspace. Each gets a
+	// session volume, so writes never reach the host. Only honored when
+	// ReadOnlyWorkspace is set.
+	WritablePaths []string `yaml:"writable_paths"`
+	// ActiveProfiles selects which profile-gated services run by default
+	ActiveProfiles []string `yaml:"active_profiles"`
+	// Image uses a prebuilt image instead of building .iso/Dockerfile. A
+	// digest-pinned reference (name@sha256:...) is verified against the local
+	// image so every run uses identical image contents.
+	Image string `yaml:"image"`
+	// ShmSize sets the size of /dev/shm (e.g. "1g", "512m"). Docker's default
+	// of 64MB is too small for headless browsers and some databases.
+	ShmSize string `yaml:"shm_size"`
+	// BuildArgs are passed to the .iso/Dockerfile build as --build-arg values.
+	// Host environment variables in values (${VAR}) are expanded on load.
+	BuildArgs map[string]string `yaml:"build_args"`
+	// BuildTarget builds this stage of a multi-stage .iso/Dockerfile instead of
+	// the final one. The image is named per target, so switching targets
+	// never reuses the other target's image.
+	BuildTarget string `yaml:"build_target"`
+	// SharedImage is a registry reference for the image built from
+	// .iso/Dockerfile, published with `iso push`. When the local image is
+	// missing or out of date it is pulled instead of building, as long as it
+	// was built from the same Dockerfile and build args.
+	SharedImage string `yaml:"shared_image"`
+	// Context selects the Docker CLI context (`docker context ls`) to run the
+	// project's containers on, e.g. a Colima VM or a remote builder. DOCKER_HOST,
+	// DOCKER_CONTEXT and `iso --context` take precedence.
+	Context string `yaml:"context"`
+	// ProjectName replaces the project directory's name in the names of the
+	// project's images, containers, networks and volumes
+	ProjectName string `yaml:"project_name"`
+	// ImageName is the tag .iso/Dockerfile is built into, instead of
+	// <project>-shell
+	ImageName string `yaml:"image_name"`
+
+	// SharedVolumes mounts global named volumes shared across all projects,
+	// as "NAME:/container/path" entries. They persist until removed by hand.
+	SharedVolumes []string `yaml:"shared_volumes"`
+	// MountGitDir bind-mounts the repository's git directory into the
+	// container when the project is a linked git worktree, whose .git file
+	// points outside the mounted project root. Defaults to true.
+	MountGitDir *bool `yaml:"mount_git_dir"`
+	// ServiceHosts writes /etc/hosts entries for service names that Docker's
+	// DNS doesn't resolve in the container, refreshed on every run
+	ServiceHosts bool `yaml:"service_hosts"`
+	// Labels are added to every container, volume and network iso creates
+	// for the project, for external tooling. Reserved iso.* keys are rejected.
+	Labels map[string]string `yaml:"labels"`
+	// WaitFor lists dependencies iso doesn't manage, as "host:port" or URLs,
+	// that must accept TCP connections from inside the container before a
+	// run's command starts
+	WaitFor []string `yaml:"wait_for"`
+	// WaitForTimeout bounds the wait for WaitFor endpoints (default "60s")
+	WaitForTimeout string `yaml:"wait_for_timeout"`
+	// InitTimeout bounds how long a newly started container may take for its
+	// init process to come up before the first exec (default "10s")
+	InitTimeout string `yaml:"init_timeout"`
+	// ServiceTimeout bounds how long each service with a port may take to
+	// become ready (default "30s")
+	ServiceTimeout string `yaml:"service_timeout"`
+	// IdleTimeout stops a persistent session after this long without a
+	// command running through iso (e.g. "2h"). Empty never stops it.
+	IdleTimeout string `yaml:"idle_timeout"`
+	// Shell is the interactive shell `iso shell` starts (default "/bin/bash").
+	// Images without it get /bin/sh instead.
+	Shell string `yaml:"shell"`
+	// PersistHistory keeps shell history across runs in a session volume
+	// mounted at historyDir, with HISTFILE pointing into it
+	PersistHistory bool `yaml:"persist_history"`
+	// ForwardSSHAgent mounts the host's SSH agent socket into the container
+	// and points SSH_AUTH_SOCK at it, for git over ssh and deploy tooling
+	ForwardSSHAgent bool `yaml:"forward_ssh_agent"`
+	// AddHostGateway maps host.docker.internal to the Docker host in the
+	// project's containers and services, so they can reach servers running
+	// on the host. Docker Desktop already resolves the name, so there it
+	// does nothing.
+	AddHostGateway bool `yaml:"add_host_gateway"`
+	// EnvPassthrough lists host environment variables (or glob patterns like
+	// "AWS_*") whose values are passed to commands run in the container
+	EnvPassthrough []string `yaml:"env_passthrough"`
+	// User is the user the container and its commands run as, as "uid:gid",
+	// a name from the image, or "host" for the calling user's UID/GID.
+	// $UID and $GID expand to the host user's ids. Empty uses the image's user.
+	User string `yaml:"user"`
+	// PreRun and PostRun are the hook scripts run before and after each
+	// command, as container paths (relative to WorkDir unless absolute).
+	// Empty uses .iso/pre-run.sh and .iso/post-run.sh.
+	PreRun  string `yaml:"pre_run"`
+	PostRun string `yaml:"post_run"`
+	// PostRunRequired makes a failing post-run script fail the run when the
+	// command itself succeeded, instead of only logging a warning
+	PostRunRequired bool `yaml:"post_run_required"`
+	// Tmpfs backs container paths with in-memory tmpfs mounts, for scratch
+	// data that doesn't need to persist, as "/path" or "/path:size=512m"
+	// entries. Options are comma-separated tmpfs mount options.
+	Tmpfs []string `yaml:"tmpfs"`
+
+	shmSizeBytes   int64
+	tmpfsMounts    map[string]string
+	waitForAddrs   []string
+	waitForTimeout time.Duration
+	initTimeout    time.Duration
+	serviceTimeout time.Duration
+	idleTimeout    time.Duration
 }
 
+// defaultWaitForTimeout is how long a run waits for wait_for endpoints when
+// wait_for_timeout isn't set
+const defaultWaitForTimeout = 60 * time.Second
+
+// defaultShell is the shell `iso shell` starts when shell isn't set
+const defaultShell = "/bin/bash"
+
+// historyDir is the container path of the session volume holding shell
+// history when persist_history is set, and historyFile the HISTFILE in it
+const (
+	historyDir  = "/var/lib/iso-history"
+	historyFile = historyDir + "/shell_history"
+)
+
+// defaultServiceTimeout is how long each service gets to become ready when
+// service_timeout isn't set
+const defaultServiceTimeout = 30 * time.Second
+
+// defaultInitTimeout is how long a started container gets for its init
+// process to come up when init_timeout isn't set
+const defaultInitTimeout = 10 * time.Second
+
 // ServiceConfig defines configuration for a service container
 type ServiceConfig struct {
-	Image       string            `yaml:"image"`
+	Image string `yaml:"image"`
+	// Build builds the service's image from a Dockerfile in the repository
+	// instead of using Image: a path, relative to the project root unless
+	// absolute, to a build context directory containing a Dockerfile, or to
+	// the Dockerfile itself (whose directory becomes the context)
+	Build       string            `yaml:"build,omitempty"`
 	Environment map[string]string `yaml:"environment"`
 	Command     []string          `yaml:"command,omitempty"`
 	Port        int               `yaml:"port,omitempty"`
-	ExtraHosts  []string          `yaml:"extra_hosts"`
+	// HealthPath makes readiness an HTTP GET of this path on port that must
+	// return a 2xx status, for services that listen before they're ready.
+	// Without it, readiness is the port accepting TCP connections.
+	HealthPath string `yaml:"health_path,omitempty"`
+	// HealthCommand makes readiness also require this command to exit 0
+	// when run in the service container, e.g. ["pg_isready", "-U", "postgres"]
+	HealthCommand []string `yaml:"health_command,omitempty"`
+	// ReadyLog makes readiness also require the service's output since it
+	// started to match this regular expression
+	ReadyLog   string   `yaml:"ready_log,omitempty"`
+	ExtraHosts []string `yaml:"extra_hosts"`
+	// Profiles makes the service optional: it only runs when one of these
+	// profiles is active. Services without profiles always run.
+	Profiles []string `yaml:"profiles,omitempty"`
+	// ShmSize sets the size of the service's /dev/shm (e.g. "256m")
+	ShmSize string `yaml:"shm_size,omitempty"`
+	// Platform pins the service image's platform (e.g. "linux/amd64"), for
+	// images without a build for the Docker host's architecture. Non-native
+	// platforms run under emulation.
+	Platform string `yaml:"platform,omitempty"`
+	// DependsOn lists services that must be started before this one. They
+	// also run whenever this service does, even if their profile is inactive.
+	DependsOn []string `yaml:"depends_on,omitempty"`
+	// Volumes mounts storage into the service as "NAME:/path" (a named volume
+	// kept across runs) or "/host/path:/path", optionally with ":ro". Fresh
+	// per-run services get anonymous volumes for named entries instead.
+	Volumes []string `yaml:"volumes,omitempty"`
+	// Publish exposes service ports on the host as "hostPort:containerPort"
+	// (or a bare "port"), for connecting host tools to a persistent
+	// session's service. Fresh per-run services are never published.
+	Publish []string `yaml:"publish,omitempty"`
+	// Restart is the restart policy of a persistent session's service
+	// container: "no" (the default), "on-failure" or "always". Fresh per-run
+	// services are never restarted.
+	Restart string `yaml:"restart,omitempty"`
+
+	shmSizeBytes   int64
+	platform       *ocispec.Platform
+	readyLog       *regexp.Regexp
+	volumes        []serviceVolume
+	dockerfilePath string
+	buildContext   string
+}
+
+// serviceVolume is a parsed ServiceConfig.Volumes entry
+type serviceVolume struct {
+	// source is the volume name, or the host path for a bind mount
+	source string
+	target string
+	// mode holds mount options such as "ro" ("" for none)
+	mode  string
+	named bool
 }
 
 // ServicesFile represents the structure of services.yml
 type ServicesFile struct {
+	// Version is the services.yml schema version the file was written for.
+	// Files for a newer version than servicesFileVersion are decoded
+	// leniently, ignoring fields this iso doesn't know.
+	Version  int                      `yaml:"version,omitempty"`
 	Services map[string]ServiceConfig `yaml:"services"`
 }
 
+// servicesFileVersion is the newest services.yml schema version this iso
+// understands
+const servicesFileVersion = 1
+
 // PeerConfig defines configuration for a single peer container
 type PeerConfig struct {
 	Hostname    string            `yaml:"hostname"`
@@ -54,15 +268,55 @@ type PeersFile struct {
 	Peers   map[string]PeerConfig `yaml:"peers"`
 }
 
+// resolveContainerUser expands the config.yml user value: "host" becomes the
+// calling user's uid:gid on Linux (Docker Desktop already maps bind mount
+// ownership elsewhere), and $UID/$GID become the host user's ids, which
+// shells don't usually export
+func resolveContainerUser(value string, lookup hostLookup) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	uid, gid := os.Getuid(), os.Getgid()
+	if value == "host" {
+		if runtime.GOOS != "linux" {
+			return "", nil
+		}
+		return fmt.Sprintf("%d:%d", uid, gid), nil
+	}
+	if strings.Contains(value, "$") && (uid < 0 || gid < 0) {
+		return "", fmt.Errorf("user %q references the host user's ids, which aren't available on %s", value, runtime.GOOS)
+	}
+	value = strings.NewReplacer(
+		"${UID}", strconv.Itoa(uid), "$UID", strconv.Itoa(uid),
+		"${GID}", strconv.Itoa(gid), "$GID", strconv.Itoa(gid),
+	).Replace(value)
+	value = expandHostVars(value, lookup)
+	if strings.ContainsAny(value, "$ ") || strings.Count(value, ":") > 1 || strings.HasPrefix(value, ":") || strings.HasSuffix(value, ":") {
+		return "", fmt.Errorf("user %q is not a valid user (expected \"uid:gid\", a user name or \"host\")", value)
+	}
+	return value, nil
+}
+
 // loadConfigFile loads and parses the .iso/config.yml file
 // Returns default config if the file doesn't exist (config is optional)
 func loadConfigFile(isoDir string) (*Config, error) {
+	return loadConfigFileWith(isoDir, os.LookupEnv)
+}
+
+// loadConfigFileWith is loadConfigFile with the host variables the file
+// references looked up with lookup
+func loadConfigFileWith(isoDir string, lookup hostLookup) (*Config, error) {
 	configPath := filepath.Join(isoDir, "config.yml")
 
 	// Default configuration
 	config := &Config{
-		Privileged: false,
-		WorkDir:    "/workspace",
+		Privileged:     false,
+		WorkDir:        "/workspace",
+		Shell:          defaultShell,
+		MaxParallel:    defaultMaxParallel(),
+		waitForTimeout: defaultWaitForTimeout,
+		initTimeout:    defaultInitTimeout,
+		serviceTimeout: defaultServiceTimeout,
 	}
 
 	// Check if file exists
@@ -82,17 +336,449 @@ func loadConfigFile(isoDir string) (*Config, error) {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
+	config.Image = expandHostVars(config.Image, lookup)
+	config.SharedImage = expandHostVars(config.SharedImage, lookup)
+	expandHostVarsMap(config.Environment, lookup)
+	// Build args are never expanded in a container, so $VAR works there too
+	for key, value := range config.BuildArgs {
+		config.BuildArgs[key] = expandHostVarsWith(unbracedHostVarPattern, value, lookup)
+	}
+
+	config.User, err = resolveContainerUser(config.User, lookup)
+	if err != nil {
+		return nil, err
+	}
+
 	// Ensure workdir has a default if not specified
 	if config.WorkDir == "" {
 		config.WorkDir = "/workspace"
 	}
 
+	// The project root is bind-mounted at workdir, so it must be an absolute
+	// container path and must not be "/" (which would shadow the whole image)
+	if !path.IsAbs(config.WorkDir) {
+		return nil, fmt.Errorf("workdir must be an absolute path, got %q (did you mean %q?)", config.WorkDir, "/"+config.WorkDir)
+	}
+	if path.Clean(config.WorkDir) == "/" {
+		return nil, fmt.Errorf("workdir must not be \"/\" - the project would be mounted over the container's root filesystem")
+	}
+
+	config.WritablePaths, err = normalizeWritablePaths(config.WorkDir, config.WritablePaths)
+	if err != nil {
+		return nil, err
+	}
+
+	config.Binds = append(config.Binds, config.Mounts...)
+	config.Mounts = nil
+	for i, entry := range config.Binds {
+		config.Binds[i], err = normalizeBind(entry, filepath.Dir(isoDir), config.WorkDir, lookup)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Resolve hook scripts against workdir, so in-env gets container paths
+	if config.PreRun != "" && !path.IsAbs(config.PreRun) {
+		config.PreRun = path.Join(config.WorkDir, config.PreRun)
+	}
+	if config.PostRun != "" && !path.IsAbs(config.PostRun) {
+		config.PostRun = path.Join(config.WorkDir, config.PostRun)
+	}
+	if len(config.WritablePaths) > 0 && !config.ReadOnlyWorkspace {
+		slog.Warn("writable_paths is ignored unless read_only_workspace is enabled")
+	}
+
+	if _, digest, pinned := strings.Cut(config.Image, "@"); pinned && !strings.HasPrefix(digest, "sha256:") {
+		return nil, fmt.Errorf("image %q has an unsupported digest (expected name@sha256:...)", config.Image)
+	}
+
+	for _, entry := range config.SharedVolumes {
+		if err := validateSharedVolume(entry); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.ProjectName != "" && !projectNamePattern.MatchString(config.ProjectName) {
+		return nil, fmt.Errorf("project_name %q is not valid (use lowercase letters, digits, '.', '_' and '-', starting with a letter or digit)", config.ProjectName)
+	}
+	if config.ImageName != "" {
+		if config.Image != "" {
+			return nil, fmt.Errorf("image_name can't be combined with image (image_name names the image built from .iso/Dockerfile)")
+		}
+		if strings.Contains(config.ImageName, "@") {
+			return nil, fmt.Errorf("image_name %q must be a tag, not a digest", config.ImageName)
+		}
+		if err := checkImageReference(config.ImageName); err != nil {
+			return nil, fmt.Errorf("image_name: %w", err)
+		}
+	}
+
+	if config.SharedImage != "" && config.Image != "" {
+		return nil, fmt.Errorf("shared_image can't be combined with image (image already skips building)")
+	}
+	if strings.Contains(config.SharedImage, "@") {
+		return nil, fmt.Errorf("shared_image %q must be a tag, not a digest", config.SharedImage)
+	}
+
+	for _, pattern := range config.EnvPassthrough {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return nil, fmt.Errorf("env_passthrough entry %q is not a valid variable name or pattern", pattern)
+		}
+	}
+
+	if config.BuildTarget != "" && !buildTargetPattern.MatchString(config.BuildTarget) {
+		return nil, fmt.Errorf("build_target %q is not a valid stage name", config.BuildTarget)
+	}
+
+	for key := range config.Labels {
+		if key == "iso" || strings.HasPrefix(key, "iso.") {
+			return nil, fmt.Errorf("label %q uses the reserved iso.* prefix", key)
+		}
+	}
+
+	config.shmSizeBytes, err = parseShmSize(config.ShmSize)
+	if err != nil {
+		return nil, err
+	}
+
+	config.tmpfsMounts, err = parseTmpfs(config.Tmpfs, config.WorkDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range config.WaitFor {
+		address, err := parseWaitForAddress(entry)
+		if err != nil {
+			return nil, err
+		}
+		config.waitForAddrs = append(config.waitForAddrs, address)
+	}
+	config.waitForTimeout, err = parseTimeout("wait_for_timeout", config.WaitForTimeout, defaultWaitForTimeout)
+	if err != nil {
+		return nil, err
+	}
+	config.initTimeout, err = parseTimeout("init_timeout", config.InitTimeout, defaultInitTimeout)
+	if err != nil {
+		return nil, err
+	}
+	config.serviceTimeout, err = parseTimeout("service_timeout", config.ServiceTimeout, defaultServiceTimeout)
+	if err != nil {
+		return nil, err
+	}
+	config.idleTimeout, err = parseTimeout("idle_timeout", config.IdleTimeout, 0)
+	if err != nil {
+		return nil, err
+	}
+	if config.idleTimeout > 0 && config.idleTimeout < time.Minute {
+		return nil, fmt.Errorf("idle_timeout must be at least 1m, got %s", config.IdleTimeout)
+	}
+
+	if config.MaxParallel < 0 {
+		return nil, fmt.Errorf("max_parallel must not be negative, got %d", config.MaxParallel)
+	}
+	if config.MaxParallel == 0 {
+		config.MaxParallel = defaultMaxParallel()
+	}
+
 	return config, nil
 }
 
+// normalizeWritablePaths resolves writable_paths entries against workDir and
+// verifies that each one lies strictly inside it
+func normalizeWritablePaths(workDir string, paths []string) ([]string, error) {
+	var normalized []string
+	for _, p := range paths {
+		resolved := p
+		if !path.IsAbs(resolved) {
+			resolved = path.Join(workDir, resolved)
+		}
+		resolved = path.Clean(resolved)
+
+		if !strings.HasPrefix(resolved, path.Clean(workDir)+"/") {
+			return nil, fmt.Errorf("writable path %q must be a subdirectory of workdir %s", p, workDir)
+		}
+		normalized = append(normalized, resolved)
+	}
+	return normalized, nil
+}
+
+// bindOptions are the mount options accepted in binds entries
+var bindOptions = map[string]bool{
+	"ro": true, "rw": true, "z": true, "Z": true, "nocopy": true,
+	"shared": true, "rshared": true, "slave": true, "rslave": true, "private": true, "rprivate": true,
+	"consistent": true, "cached": true, "delegated": true,
+}
+
+// normalizeBind validates a binds (or mounts) entry of the form
+// "host:container[:options]" and returns it with host variables and ~
+// expanded, and a ./ or ../ host path resolved against projectRoot. A host
+// part without a slash names a Docker volume. Container paths that would
+// hide the workdir or the iso binary are rejected.
+func normalizeBind(entry, projectRoot, workDir string, lookup hostLookup) (string, error) {
+	parts := strings.SplitN(expandHostVarsWith(unbracedHostVarPattern, entry, lookup), ":", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("invalid bind %q (expected HOST_PATH:CONTAINER_PATH[:ro])", entry)
+	}
+
+	host := expandHomeDir(parts[0])
+	switch {
+	case host == "." || host == ".." || strings.HasPrefix(host, "./") || strings.HasPrefix(host, "../"):
+		host = filepath.Join(projectRoot, host)
+	case filepath.IsAbs(host):
+		host = filepath.Clean(host)
+	case !sharedVolumeNamePattern.MatchString(host):
+		return "", fmt.Errorf("invalid bind %q: host path must be absolute, start with ~, or be relative to the project as ./path", entry)
+	}
+	parts[0] = host
+
+	target := parts[1]
+	if !path.IsAbs(target) {
+		return "", fmt.Errorf("invalid bind %q: container path must be absolute", entry)
+	}
+	target = path.Clean(target)
+	workDir = path.Clean(workDir)
+	if target == "/" || target == workDir || strings.HasPrefix(workDir, target+"/") {
+		return "", fmt.Errorf("invalid bind %q: mounting over %s would hide the workdir %s", entry, target, workDir)
+	}
+	if target == "/iso" {
+		return "", fmt.Errorf("invalid bind %q: /iso is reserved for the iso binary", entry)
+	}
+	parts[1] = target
+
+	if len(parts) == 3 {
+		for _, option := range strings.Split(parts[2], ",") {
+			if !bindOptions[option] {
+				return "", fmt.Errorf("invalid bind %q: unknown option %q", entry, option)
+			}
+		}
+	}
+	return strings.Join(parts, ":"), nil
+}
+
+// buildTargetPattern matches Dockerfile stage names that can also be used in
+// an image name. Stage names are case-insensitive.
+var buildTargetPattern = regexp.MustCompile(`^[a-zA-Z0-9]+([._-][a-zA-Z0-9]+)*$`)
+
+// sharedVolumeNamePattern matches names Docker accepts for volumes
+var sharedVolumeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// projectNamePattern matches valid project_name values, which become part of
+// image names and so must be lowercase
+var projectNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// validateSharedVolume checks a shared_volumes entry of the form
+// "NAME:/container/path"
+func validateSharedVolume(entry string) error {
+	name, containerPath, ok := strings.Cut(entry, ":")
+	if !ok {
+		return fmt.Errorf("invalid shared volume %q (expected NAME:/container/path)", entry)
+	}
+	if !sharedVolumeNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid shared volume name %q (use letters, digits, '_', '.' or '-')", name)
+	}
+	if !path.IsAbs(containerPath) {
+		return fmt.Errorf("shared volume %q must mount at an absolute container path", entry)
+	}
+	return nil
+}
+
+// parseServiceVolume parses a service volume entry: "NAME:/path" for a named
+// volume or "/host/path:/path" (or "~/host/path:/path") for a bind mount,
+// either optionally followed by ":ro" or ":rw"
+func parseServiceVolume(entry string) (serviceVolume, error) {
+	parts := strings.Split(entry, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return serviceVolume{}, fmt.Errorf("invalid volume %q (expected NAME:/path or /host/path:/path)", entry)
+	}
+
+	volume := serviceVolume{source: parts[0], target: parts[1]}
+	if len(parts) == 3 {
+		if parts[2] != "ro" && parts[2] != "rw" {
+			return serviceVolume{}, fmt.Errorf("invalid volume %q (mode must be ro or rw)", entry)
+		}
+		volume.mode = parts[2]
+	}
+	if !path.IsAbs(volume.target) {
+		return serviceVolume{}, fmt.Errorf("volume %q must mount at an absolute container path", entry)
+	}
+
+	switch {
+	case path.IsAbs(volume.source), volume.source == "~", strings.HasPrefix(volume.source, "~/"):
+	case sharedVolumeNamePattern.MatchString(volume.source):
+		volume.named = true
+	default:
+		return serviceVolume{}, fmt.Errorf("invalid volume %q (source must be a volume name or an absolute host path)", entry)
+	}
+	return volume, nil
+}
+
+// parseShmSize converts a human-readable shm_size ("64m", "1g", "1073741824")
+// to bytes. An empty value returns 0, leaving Docker's default (64MB) in place.
+func parseShmSize(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+	bytes, err := units.RAMInBytes(size)
+	if err != nil {
+		return 0, fmt.Errorf("invalid shm_size %q: %w", size, err)
+	}
+	if bytes <= 0 {
+		return 0, fmt.Errorf("invalid shm_size %q: must be greater than zero", size)
+	}
+	return bytes, nil
+}
+
+// tmpfsFlags are the valueless tmpfs mount options accepted in tmpfs entries
+var tmpfsFlags = map[string]bool{
+	"rw": true, "ro": true, "exec": true, "noexec": true,
+	"suid": true, "nosuid": true, "dev": true, "nodev": true,
+}
+
+// parseTmpfs converts tmpfs entries ("/path" or "/path:size=512m,mode=1777")
+// to the container path to mount options map Docker takes. Paths must be
+// absolute and may not hide the workdir or the mounts iso itself manages.
+func parseTmpfs(entries []string, workDir string) (map[string]string, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	mounts := make(map[string]string, len(entries))
+	workDir = path.Clean(workDir)
+	for _, entry := range entries {
+		target, options, _ := strings.Cut(entry, ":")
+		if !path.IsAbs(target) {
+			return nil, fmt.Errorf("invalid tmpfs %q: path must be absolute", entry)
+		}
+		target = path.Clean(target)
+		if target == "/" || target == workDir || strings.HasPrefix(workDir, target+"/") {
+			return nil, fmt.Errorf("invalid tmpfs %q: mounting over %s would hide the workdir %s", entry, target, workDir)
+		}
+		if target == "/iso" || target == secretsDir || target == path.Dir(initReadyFile) {
+			return nil, fmt.Errorf("invalid tmpfs %q: %s is managed by iso", entry, target)
+		}
+		if _, ok := mounts[target]; ok {
+			return nil, fmt.Errorf("invalid tmpfs %q: %s is listed more than once", entry, target)
+		}
+
+		if options != "" {
+			for _, option := range strings.Split(options, ",") {
+				key, value, hasValue := strings.Cut(option, "=")
+				switch {
+				case key == "size" && hasValue:
+					if size, err := units.RAMInBytes(value); err != nil || size <= 0 {
+						return nil, fmt.Errorf("invalid tmpfs %q: bad size %q", entry, value)
+					}
+				case key == "mode" && hasValue:
+					if _, err := strconv.ParseUint(value, 8, 32); err != nil {
+						return nil, fmt.Errorf("invalid tmpfs %q: mode %q is not an octal number", entry, value)
+					}
+				case !hasValue && tmpfsFlags[key]:
+				default:
+					return nil, fmt.Errorf("invalid tmpfs %q: unknown option %q", entry, option)
+				}
+			}
+		}
+		mounts[target] = options
+	}
+	return mounts, nil
+}
+
+// parseTimeout parses the duration config setting name, returning def when
+// it isn't set
+func parseTimeout(name, value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q (expected a positive duration like 30s)", name, value)
+	}
+	return d, nil
+}
+
+// parseWaitForAddress resolves a wait_for entry to the host:port to dial. URLs
+// without an explicit port use the scheme's default (http 80, https 443).
+func parseWaitForAddress(entry string) (string, error) {
+	if strings.Contains(entry, "://") {
+		u, err := url.Parse(entry)
+		if err != nil {
+			return "", fmt.Errorf("invalid wait_for entry %q: %w", entry, err)
+		}
+		if u.Hostname() == "" {
+			return "", fmt.Errorf("invalid wait_for entry %q: missing host", entry)
+		}
+		port := u.Port()
+		if port == "" {
+			switch u.Scheme {
+			case "http":
+				port = "80"
+			case "https":
+				port = "443"
+			default:
+				return "", fmt.Errorf("invalid wait_for entry %q: no port and no default port for scheme %q", entry, u.Scheme)
+			}
+		}
+		return net.JoinHostPort(u.Hostname(), port), nil
+	}
+
+	host, port, err := net.SplitHostPort(entry)
+	if err != nil || host == "" || port == "" {
+		return "", fmt.Errorf("invalid wait_for entry %q (expected host:port or a URL)", entry)
+	}
+	return entry, nil
+}
+
+// parsePlatform parses an "os/arch[/variant]" platform such as "linux/amd64"
+// or "linux/arm/v7". An empty value returns nil, leaving the choice to Docker.
+func parsePlatform(platform string) (*ocispec.Platform, error) {
+	if platform == "" {
+		return nil, nil
+	}
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || slices.Contains(parts, "") {
+		return nil, fmt.Errorf("invalid platform %q (expected os/arch[/variant], e.g. linux/amd64)", platform)
+	}
+
+	p := &ocispec.Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// platformEmulated reports whether a container for platform runs under
+// emulation on a Docker host with the given native architecture
+func platformEmulated(platform, nativeArch string) bool {
+	p, err := parsePlatform(platform)
+	if err != nil || p == nil || nativeArch == "" {
+		return false
+	}
+
+	arch := p.Architecture
+	switch arch {
+	case "x86_64":
+		arch = "amd64"
+	case "aarch64":
+		arch = "arm64"
+	}
+	return arch != nativeArch
+}
+
+// defaultMaxParallel returns the default concurrency limit: the number of CPUs,
+// capped at 4 so that constrained machines aren't swamped by parallel pulls
+func defaultMaxParallel() int {
+	return min(runtime.NumCPU(), 4)
+}
+
 // loadServicesFile loads and parses the .iso/services.yml file
 // Returns nil if the file doesn't exist (services are optional)
 func loadServicesFile(isoDir string) (map[string]ServiceConfig, error) {
+	return loadServicesFileWith(isoDir, os.LookupEnv)
+}
+
+// loadServicesFileWith is loadServicesFile with the host variables the file
+// references looked up with lookup
+func loadServicesFileWith(isoDir string, lookup hostLookup) (map[string]ServiceConfig, error) {
 	servicesPath := filepath.Join(isoDir, "services.yml")
 
 	// Check if file exists
@@ -107,22 +793,257 @@ func loadServicesFile(isoDir string) (map[string]ServiceConfig, error) {
 		return nil, fmt.Errorf("failed to read services file: %w", err)
 	}
 
-	// Parse YAML
-	var servicesFile ServicesFile
-	if err := yaml.Unmarshal(data, &servicesFile); err != nil {
-		return nil, fmt.Errorf("failed to parse services file: %w", err)
+	servicesFile, err := parseServicesFile(data)
+	if err != nil {
+		return nil, err
 	}
 
 	// Validate services
 	for name, config := range servicesFile.Services {
-		if config.Image == "" {
-			return nil, fmt.Errorf("service %q is missing required 'image' field", name)
+		config, err := normalizeService(name, config, servicesFile.Services, filepath.Dir(isoDir), lookup)
+		if err != nil {
+			return nil, err
 		}
+		servicesFile.Services[name] = config
+	}
+
+	// Reject dependency cycles up front rather than on the first start
+	if _, err := serviceStartOrder(servicesFile.Services); err != nil {
+		return nil, err
 	}
 
 	return servicesFile.Services, nil
 }
 
+// normalizeService validates the services.yml entry name, among services,
+// and fills in its parsed fields. Build paths are resolved against
+// projectRoot, and host variables looked up with lookup.
+func normalizeService(name string, config ServiceConfig, services map[string]ServiceConfig, projectRoot string, lookup hostLookup) (ServiceConfig, error) {
+	config.Image = expandHostVars(config.Image, lookup)
+	config.Build = expandHostVars(config.Build, lookup)
+	expandHostVarsMap(config.Environment, lookup)
+
+	if config.Image == "" && config.Build == "" {
+		return ServiceConfig{}, fmt.Errorf("service %q is missing required 'image' field (or 'build' to build it)", name)
+	}
+
+	var err error
+	if config.Build != "" {
+		if config.Image != "" {
+			return ServiceConfig{}, fmt.Errorf("service %q sets both 'image' and 'build' - use one", name)
+		}
+		if config.Platform != "" {
+			return ServiceConfig{}, fmt.Errorf("service %q: platform can't be combined with build", name)
+		}
+		config.dockerfilePath, config.buildContext, err = resolveServiceBuild(config.Build, projectRoot)
+		if err != nil {
+			return ServiceConfig{}, fmt.Errorf("service %q: %w", name, err)
+		}
+	}
+
+	config.shmSizeBytes, err = parseShmSize(config.ShmSize)
+	if err != nil {
+		return ServiceConfig{}, fmt.Errorf("service %q: %w", name, err)
+	}
+
+	config.platform, err = parsePlatform(config.Platform)
+	if err != nil {
+		return ServiceConfig{}, fmt.Errorf("service %q: %w", name, err)
+	}
+
+	for _, entry := range config.Volumes {
+		volume, err := parseServiceVolume(entry)
+		if err != nil {
+			return ServiceConfig{}, fmt.Errorf("service %q: %w", name, err)
+		}
+		config.volumes = append(config.volumes, volume)
+	}
+
+	if _, _, err := parsePortMappings(config.Publish); err != nil {
+		return ServiceConfig{}, fmt.Errorf("service %q: publish: %w", name, err)
+	}
+
+	switch container.RestartPolicyMode(config.Restart) {
+	case "", container.RestartPolicyDisabled, container.RestartPolicyOnFailure, container.RestartPolicyAlways:
+	default:
+		return ServiceConfig{}, fmt.Errorf("service %q: invalid restart %q (expected no, on-failure or always)", name, config.Restart)
+	}
+
+	if config.HealthPath != "" {
+		if config.Port <= 0 {
+			return ServiceConfig{}, fmt.Errorf("service %q: health_path requires port", name)
+		}
+		// ISO_SERVICES is comma-separated, so the path can't contain one
+		if !strings.HasPrefix(config.HealthPath, "/") || strings.ContainsAny(config.HealthPath, ", \t") {
+			return ServiceConfig{}, fmt.Errorf("service %q: invalid health_path %q (must start with / and contain no commas or spaces)", name, config.HealthPath)
+		}
+	}
+
+	if config.ReadyLog != "" {
+		config.readyLog, err = regexp.Compile(config.ReadyLog)
+		if err != nil {
+			return ServiceConfig{}, fmt.Errorf("service %q: invalid ready_log pattern: %w", name, err)
+		}
+	}
+
+	for _, dep := range config.DependsOn {
+		if _, ok := services[dep]; !ok {
+			return ServiceConfig{}, fmt.Errorf("service %q depends on unknown service %q", name, dep)
+		}
+	}
+	return config, nil
+}
+
+// resolveServiceBuild returns the Dockerfile and build context of a service's
+// build path: a context directory holding a Dockerfile, or a Dockerfile
+func resolveServiceBuild(build, projectRoot string) (dockerfilePath, contextDir string, err error) {
+	build = expandHomeDir(build)
+	if !filepath.IsAbs(build) {
+		build = filepath.Join(projectRoot, build)
+	}
+
+	info, err := os.Stat(build)
+	if err != nil {
+		return "", "", fmt.Errorf("build path %s doesn't exist", build)
+	}
+	if !info.IsDir() {
+		return build, filepath.Dir(build), nil
+	}
+
+	dockerfilePath = filepath.Join(build, "Dockerfile")
+	if _, err := os.Stat(dockerfilePath); err != nil {
+		return "", "", fmt.Errorf("build directory %s has no Dockerfile", build)
+	}
+	return dockerfilePath, build, nil
+}
+
+// hostVarPattern matches ${VAR} and ${VAR:-default} references to host
+// environment variables; unbracedHostVarPattern also matches $VAR
+var (
+	hostVarPattern         = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+	unbracedHostVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+)
+
+// hostLookup looks up a host environment variable referenced by the
+// project's configuration, like os.LookupEnv. The daemon looks them up in the
+// environment of the CLI invocation it serves.
+type hostLookup func(name string) (string, bool)
+
+// expandHostVars replaces ${VAR} in s with the host environment variable, or
+// with default for ${VAR:-default} when VAR is unset or empty. Unknown
+// variables expand to "" (with a debug log). Unbraced $VAR is left for the
+// container to expand.
+func expandHostVars(s string, lookup hostLookup) string {
+	return expandHostVarsWith(hostVarPattern, s, lookup)
+}
+
+// expandHostVarsWith is expandHostVars for the references matched by pattern
+func expandHostVarsWith(pattern *regexp.Regexp, s string, lookup hostLookup) string {
+	return pattern.ReplaceAllStringFunc(s, func(ref string) string {
+		m := pattern.FindStringSubmatch(ref)
+		name := m[1]
+		if name == "" {
+			name = m[4]
+		}
+		value, ok := lookup(name)
+		if value == "" && m[2] != "" {
+			return m[3]
+		}
+		if !ok {
+			slog.Debug("undefined variable in config, using empty value", "variable", name)
+		}
+		return value
+	})
+}
+
+// expandHostVarsMap applies expandHostVars to every value of m in place
+func expandHostVarsMap(m map[string]string, lookup hostLookup) {
+	for key, value := range m {
+		m[key] = expandHostVars(value, lookup)
+	}
+}
+
+// serviceStartOrder returns the names of services ordered so that every
+// service comes after the services it depends on. Independent services are
+// ordered by name, so the order is stable. Dependencies outside services are
+// ignored. A dependency cycle is an error naming the services involved.
+func serviceStartOrder(services map[string]ServiceConfig) ([]string, error) {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int, len(services))
+	order := make([]string, 0, len(services))
+	var path []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			cycle := append(path[slices.Index(path, name):], name)
+			return fmt.Errorf("services have a dependency cycle: %s", strings.Join(cycle, " -> "))
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+		deps := slices.Clone(services[name].DependsOn)
+		slices.Sort(deps)
+		for _, dep := range deps {
+			if _, ok := services[dep]; !ok {
+				continue
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = done
+		order = append(order, name)
+		return nil
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(services)) {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// parseServicesFile decodes services.yml. Unknown fields are errors, so a
+// misspelled or too-new option isn't silently ignored, unless the file
+// declares a newer version than this iso supports.
+func parseServicesFile(data []byte) (*ServicesFile, error) {
+	var header struct {
+		Version int `yaml:"version"`
+	}
+	if err := yaml.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("failed to parse services file: %w", err)
+	}
+
+	var servicesFile ServicesFile
+	if header.Version > servicesFileVersion {
+		slog.Warn("services.yml is for a newer iso, ignoring unknown fields - consider upgrading iso",
+			"version", header.Version, "supported", servicesFileVersion)
+		if err := yaml.Unmarshal(data, &servicesFile); err != nil {
+			return nil, fmt.Errorf("failed to parse services file: %w", err)
+		}
+		return &servicesFile, nil
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&servicesFile); err != nil && err != io.EOF {
+		if strings.Contains(err.Error(), "not found in type") {
+			return nil, fmt.Errorf("failed to parse services file: %w\n(unknown field - check for typos, or upgrade iso if the field was added in a newer version)", err)
+		}
+		return nil, fmt.Errorf("failed to parse services file: %w", err)
+	}
+	return &servicesFile, nil
+}
+
 // loadPeersFile loads and parses the .iso/peers.yml file
 // Returns nil if the file doesn't exist (peers are optional)
 func loadPeersFile(isoDir string) (*PeersFile, error) {
@@ -160,6 +1081,10 @@ func loadPeersFile(isoDir string) (*PeersFile, error) {
 	return &peersFile, nil
 }
 
+// ErrNoIsoDir is returned when neither the current directory nor any of its
+// parents contains a .iso directory
+var ErrNoIsoDir = errors.New("no .iso directory found - please create one with a Dockerfile and optional services.yml")
+
 // findIsoDir searches upward from the current directory to find .iso directory
 // Returns the .iso directory path and the project root directory
 func findIsoDir() (isoPath string, projectRoot string, found bool) {
@@ -168,7 +1093,12 @@ func findIsoDir() (isoPath string, projectRoot string, found bool) {
 		return "", "", false
 	}
 
-	dir := cwd
+	return findIsoDirFrom(cwd)
+}
+
+// findIsoDirFrom searches upward from startDir to find .iso directory
+func findIsoDirFrom(startDir string) (isoPath string, projectRoot string, found bool) {
+	dir := startDir
 	for {
 		isoDir := filepath.Join(dir, ".iso")
 
@@ -190,8 +1120,11 @@ func findIsoDir() (isoPath string, projectRoot string, found bool) {
 }
 
 // detectGitWorktree checks if the project is in a git worktree and returns project names
-// Returns baseProjectName (for shared caches) and worktreeProjectName (for isolated resources)
-func detectGitWorktree(projectRoot string) (baseProjectName, worktreeProjectName string) {
+// Returns baseProjectName (for shared caches) and worktreeProjectName (for isolated resources),
+// plus externalGitDir, the absolute git common directory when the repository data lives
+// outside projectRoot (a linked worktree, whose .git is a file pointing elsewhere), or ""
+// for regular checkouts and directories that aren't in a git repository
+func detectGitWorktree(projectRoot string) (baseProjectName, worktreeProjectName, externalGitDir string) {
 	// Default: use the directory name as both base and worktree project name
 	worktreeProjectName = filepath.Base(projectRoot)
 	baseProjectName = worktreeProjectName
@@ -202,33 +1135,29 @@ func detectGitWorktree(projectRoot string) (baseProjectName, worktreeProjectName
 	gitCommonOut, err := gitCommonCmd.Output()
 	if err != nil {
 		// Not a git repo or git not available - use default
-		return baseProjectName, worktreeProjectName
+		return baseProjectName, worktreeProjectName, ""
+	}
+	gitCommonDir := strings.TrimSpace(string(gitCommonOut))
+
+	// Resolve the common dir to absolute path, relative to projectRoot
+	commonPath := gitCommonDir
+	if !filepath.IsAbs(commonPath) {
+		commonPath = filepath.Join(projectRoot, commonPath)
 	}
+	// Clean the path to resolve any .. or .
+	commonPath = filepath.Clean(commonPath)
+	externalGitDir = outsideDir(commonPath, projectRoot)
 
 	gitDirCmd := exec.Command("git", "-C", projectRoot, "rev-parse", "--git-dir")
 	gitDirOut, err := gitDirCmd.Output()
 	if err != nil {
 		// Can't determine git-dir - use default
-		return baseProjectName, worktreeProjectName
+		return baseProjectName, worktreeProjectName, externalGitDir
 	}
-
-	gitCommonDir := strings.TrimSpace(string(gitCommonOut))
 	gitDir := strings.TrimSpace(string(gitDirOut))
 
 	// If git-common-dir is different from git-dir, we're in a worktree
 	if gitCommonDir != gitDir {
-		// Resolve the common dir to absolute path
-		var commonPath string
-		if filepath.IsAbs(gitCommonDir) {
-			commonPath = gitCommonDir
-		} else {
-			// Relative to projectRoot
-			commonPath = filepath.Join(projectRoot, gitCommonDir)
-		}
-
-		// Clean the path to resolve any .. or .
-		commonPath = filepath.Clean(commonPath)
-
 		// The base repo directory is the parent of the .git directory
 		// e.g., /home/user/myproject/.git -> /home/user/myproject
 		if strings.HasSuffix(commonPath, "/.git") || strings.HasSuffix(commonPath, "\\.git") {
@@ -237,5 +1166,37 @@ func detectGitWorktree(projectRoot string) (baseProjectName, worktreeProjectName
 		}
 	}
 
-	return baseProjectName, worktreeProjectName
+	return baseProjectName, worktreeProjectName, externalGitDir
+}
+
+// outsideDir returns path, with symlinks resolved, unless it is root or
+// inside it, in which case it returns "". Resolved paths are compared so
+// symlinked temp/home directories don't produce false positives.
+func outsideDir(path, root string) string {
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+		return ""
+	}
+	return path
+}
+
+// projectNames returns the base and worktree project names of the project at
+// projectRoot, as detectGitWorktree does, with project_name replacing the
+// repository directory's name. A linked worktree's name stays distinct as
+// <project_name>-<worktree directory>.
+func projectNames(projectRoot string, config *Config) (baseProjectName, worktreeProjectName string) {
+	baseProjectName, worktreeProjectName, _ = detectGitWorktree(projectRoot)
+	if config.ProjectName == "" {
+		return baseProjectName, worktreeProjectName
+	}
+	if worktreeProjectName == baseProjectName {
+		return config.ProjectName, config.ProjectName
+	}
+	return config.ProjectName, config.ProjectName + "-" + worktreeProjectName
 }
@@ -0,0 +1,668 @@
+package iso
+
+import (
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeIsoFile writes name with content into a fresh .iso directory and returns it
+func writeIsoFile(t *testing.T, name, content string) string {
+	t.Helper()
+	isoDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(isoDir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return isoDir
+}
+
+func TestLoadConfigFileWorkDir(t *testing.T) {
+	cases := []struct {
+		name    string
+		config  string
+		want    string
+		wantErr string
+	}{
+		{"default", "privileged: false\n", "/workspace", ""},
+		{"absolute", "workdir: /code\n", "/code", ""},
+		{"relative", "workdir: workspace\n", "", "must be an absolute path"},
+		{"root", "workdir: /\n", "", "must not be \"/\""},
+		{"root unclean", "workdir: /./\n", "", "must not be \"/\""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			isoDir := writeIsoFile(t, "config.yml", tc.config)
+
+			config, err := loadConfigFile(isoDir)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("loadConfigFile() error = %v, want error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfigFile() unexpected error: %v", err)
+			}
+			if config.WorkDir != tc.want {
+				t.Fatalf("WorkDir = %q, want %q", config.WorkDir, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseWaitForAddress(t *testing.T) {
+	cases := []struct {
+		entry   string
+		want    string
+		wantErr bool
+	}{
+		{"host.docker.internal:5432", "host.docker.internal:5432", false},
+		{"[::1]:8080", "[::1]:8080", false},
+		{"http://api.local", "api.local:80", false},
+		{"https://api.local/health", "api.local:443", false},
+		{"http://api.local:8080/ready", "api.local:8080", false},
+		{"redis://cache:6379", "cache:6379", false},
+		{"redis://cache", "", true},
+		{"localhost", "", true},
+		{":5432", "", true},
+	}
+
+	for _, tc := range cases {
+		got, err := parseWaitForAddress(tc.entry)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("parseWaitForAddress(%q) = %q, want error", tc.entry, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseWaitForAddress(%q) unexpected error: %v", tc.entry, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parseWaitForAddress(%q) = %q, want %q", tc.entry, got, tc.want)
+		}
+	}
+}
+
+func TestNormalizeWritablePaths(t *testing.T) {
+	got, err := normalizeWritablePaths("/workspace", []string{"target", "/workspace/tmp/", "./build/out"})
+	if err != nil {
+		t.Fatalf("normalizeWritablePaths() unexpected error: %v", err)
+	}
+	want := []string{"/workspace/target", "/workspace/tmp", "/workspace/build/out"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("normalizeWritablePaths() = %v, want %v", got, want)
+	}
+
+	for _, bad := range []string{"/tmp", "/workspace", "../escape", "/workspace-other"} {
+		if _, err := normalizeWritablePaths("/workspace", []string{bad}); err == nil {
+			t.Errorf("normalizeWritablePaths(%q) expected error", bad)
+		}
+	}
+}
+
+func TestNormalizeBind(t *testing.T) {
+	t.Setenv("ISO_TEST_DATA", "/srv/data")
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"/var/run/docker.sock:/var/run/docker.sock", "/var/run/docker.sock:/var/run/docker.sock"},
+		{"$ISO_TEST_DATA:/data:ro", "/srv/data:/data:ro"},
+		{"${ISO_TEST_DATA}/fixtures/:/fixtures/:ro,z", "/srv/data/fixtures:/fixtures:ro,z"},
+		{"./testdata:/testdata", "/home/me/project/testdata:/testdata"},
+		{"gomod:/go/pkg/mod", "gomod:/go/pkg/mod"},
+		{"/srv/docs:/workspace/docs:ro", "/srv/docs:/workspace/docs:ro"},
+	}
+	for _, tc := range cases {
+		got, err := normalizeBind(tc.in, "/home/me/project", "/workspace", os.LookupEnv)
+		if err != nil {
+			t.Fatalf("normalizeBind(%q) unexpected error: %v", tc.in, err)
+		}
+		if got != tc.want {
+			t.Errorf("normalizeBind(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+
+	for _, bad := range []string{
+		"/srv/data",
+		"relative/path:/data",
+		"/srv/data:data",
+		"/srv/data:/workspace",
+		"/srv/data:/workspace/",
+		"/srv/data:/",
+		"/srv/data:/iso",
+		"/srv/data:/data:readonly",
+	} {
+		if _, err := normalizeBind(bad, "/home/me/project", "/workspace", os.LookupEnv); err == nil {
+			t.Errorf("normalizeBind(%q) expected error", bad)
+		}
+	}
+}
+
+func TestParseTmpfs(t *testing.T) {
+	got, err := parseTmpfs([]string{"/tmp:size=512m", "/workspace/tmp/", "/scratch:size=1g,mode=1777,noexec"}, "/workspace")
+	if err != nil {
+		t.Fatalf("parseTmpfs() unexpected error: %v", err)
+	}
+	want := map[string]string{
+		"/tmp":           "size=512m",
+		"/workspace/tmp": "",
+		"/scratch":       "size=1g,mode=1777,noexec",
+	}
+	if !maps.Equal(got, want) {
+		t.Fatalf("parseTmpfs() = %v, want %v", got, want)
+	}
+
+	for _, bad := range []string{"tmp", "/workspace", "/", "/run/secrets", "/tmp:size=lots", "/tmp:mode=rwx", "/tmp:huge"} {
+		if _, err := parseTmpfs([]string{bad}, "/workspace"); err == nil {
+			t.Errorf("parseTmpfs(%q) expected error", bad)
+		}
+	}
+	if _, err := parseTmpfs([]string{"/tmp", "/tmp/"}, "/workspace"); err == nil {
+		t.Error("parseTmpfs() expected error for a duplicate path")
+	}
+}
+
+func TestLoadServicesFileBuild(t *testing.T) {
+	isoDir := writeIsoFile(t, "services.yml", `
+services:
+  mock:
+    build: mock
+  stub:
+    build: stub/Dockerfile.stub
+`)
+	projectRoot := filepath.Dir(isoDir)
+	for _, p := range []string{"mock/Dockerfile", "stub/Dockerfile.stub"} {
+		if err := os.MkdirAll(filepath.Join(projectRoot, filepath.Dir(p)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(projectRoot, p), []byte("FROM alpine\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	services, err := loadServicesFile(isoDir)
+	if err != nil {
+		t.Fatalf("loadServicesFile() error = %v", err)
+	}
+	if mock := services["mock"]; mock.dockerfilePath != filepath.Join(projectRoot, "mock", "Dockerfile") || mock.buildContext != filepath.Join(projectRoot, "mock") {
+		t.Errorf("mock = Dockerfile %q, context %q", mock.dockerfilePath, mock.buildContext)
+	}
+	if stub := services["stub"]; stub.dockerfilePath != filepath.Join(projectRoot, "stub", "Dockerfile.stub") || stub.buildContext != filepath.Join(projectRoot, "stub") {
+		t.Errorf("stub = Dockerfile %q, context %q", stub.dockerfilePath, stub.buildContext)
+	}
+
+	for _, bad := range []string{
+		"    image: alpine\n    build: mock\n",
+		"    build: missing\n",
+		"    build: stub\n",
+		"    build: mock\n    platform: linux/amd64\n",
+	} {
+		if err := os.WriteFile(filepath.Join(isoDir, "services.yml"), []byte("services:\n  svc:\n"+bad), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadServicesFile(isoDir); err == nil {
+			t.Errorf("loadServicesFile(%q) expected error", bad)
+		}
+	}
+}
+
+func TestLoadServicesFileRestart(t *testing.T) {
+	services, err := loadServicesFile(writeIsoFile(t, "services.yml", "services:\n  db:\n    image: postgres\n    restart: on-failure\n  cache:\n    image: redis\n"))
+	if err != nil {
+		t.Fatalf("loadServicesFile() error = %v", err)
+	}
+	if services["db"].Restart != "on-failure" || services["cache"].Restart != "" {
+		t.Errorf("loadServicesFile() restart = %q, %q", services["db"].Restart, services["cache"].Restart)
+	}
+
+	if _, err := loadServicesFile(writeIsoFile(t, "services.yml", "services:\n  db:\n    image: postgres\n    restart: sometimes\n")); err == nil || !strings.Contains(err.Error(), "restart") {
+		t.Errorf("loadServicesFile() error = %v, want a restart error", err)
+	}
+}
+
+func TestParseShmSize(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"64m", 64 * 1024 * 1024, false},
+		{"1g", 1024 * 1024 * 1024, false},
+		{"1073741824", 1024 * 1024 * 1024, false},
+		{"lots", 0, true},
+		{"0", 0, true},
+	}
+
+	for _, tc := range cases {
+		got, err := parseShmSize(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("parseShmSize(%q) error = %v, wantErr %v", tc.in, err, tc.wantErr)
+		}
+		if got != tc.want {
+			t.Errorf("parseShmSize(%q) = %d, want %d", tc.in, got, tc.want)
+		}
+	}
+}
+
+// gitCmd runs git in dir, failing the test on error
+func gitCmd(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+func TestDetectGitWorktreeGitDir(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	base := t.TempDir()
+	repo := filepath.Join(base, "repo")
+	worktree := filepath.Join(base, "repo-feature")
+
+	if err := os.Mkdir(repo, 0755); err != nil {
+		t.Fatal(err)
+	}
+	gitCmd(t, repo, "init", "-q")
+	gitCmd(t, repo, "commit", "-q", "--allow-empty", "-m", "initial")
+	gitCmd(t, repo, "worktree", "add", "-q", worktree)
+
+	t.Run("main checkout", func(t *testing.T) {
+		if _, _, got := detectGitWorktree(repo); got != "" {
+			t.Fatalf("detectGitWorktree(main checkout) git dir = %q, want empty", got)
+		}
+	})
+
+	t.Run("linked worktree", func(t *testing.T) {
+		want, err := filepath.EvalSymlinks(filepath.Join(repo, ".git"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		base, name, got := detectGitWorktree(worktree)
+		if got != want {
+			t.Fatalf("detectGitWorktree(worktree) git dir = %q, want %q", got, want)
+		}
+		if base != "repo" || name != "repo-feature" {
+			t.Errorf("detectGitWorktree(worktree) names = %q, %q, want repo, repo-feature", base, name)
+		}
+	})
+
+	t.Run("not a repository", func(t *testing.T) {
+		if _, _, got := detectGitWorktree(t.TempDir()); got != "" {
+			t.Fatalf("detectGitWorktree(non-repo) git dir = %q, want empty", got)
+		}
+	})
+}
+
+func TestValidateSharedVolume(t *testing.T) {
+	cases := []struct {
+		entry   string
+		wantErr bool
+	}{
+		{"models:/models", false},
+		{"hf-cache.v2:/root/.cache/huggingface", false},
+		{"models", true},
+		{"models:relative/path", true},
+		{"-bad:/models", true},
+		{"bad/name:/models", true},
+	}
+
+	for _, tc := range cases {
+		err := validateSharedVolume(tc.entry)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("validateSharedVolume(%q) error = %v, wantErr %v", tc.entry, err, tc.wantErr)
+		}
+	}
+}
+
+func TestParseServiceVolume(t *testing.T) {
+	cases := []struct {
+		entry   string
+		want    serviceVolume
+		wantErr bool
+	}{
+		{"pgdata:/var/lib/postgresql/data", serviceVolume{source: "pgdata", target: "/var/lib/postgresql/data", named: true}, false},
+		{"./init:/docker-entrypoint-initdb.d", serviceVolume{}, true},
+		{"/srv/init:/docker-entrypoint-initdb.d:ro", serviceVolume{source: "/srv/init", target: "/docker-entrypoint-initdb.d", mode: "ro"}, false},
+		{"~/certs:/certs:ro", serviceVolume{source: "~/certs", target: "/certs", mode: "ro"}, false},
+		{"pgdata", serviceVolume{}, true},
+		{"pgdata:data", serviceVolume{}, true},
+		{"pgdata:/data:rx", serviceVolume{}, true},
+		{"bad/name:/data", serviceVolume{}, true},
+	}
+
+	for _, tc := range cases {
+		got, err := parseServiceVolume(tc.entry)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseServiceVolume(%q) error = %v, wantErr %v", tc.entry, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parseServiceVolume(%q) = %+v, want %+v", tc.entry, got, tc.want)
+		}
+	}
+}
+
+func TestPlatformEmulated(t *testing.T) {
+	cases := []struct {
+		platform   string
+		nativeArch string
+		want       bool
+	}{
+		{"linux/amd64", "arm64", true},
+		{"linux/amd64", "amd64", false},
+		{"linux/arm64/v8", "arm64", false},
+		{"linux/x86_64", "amd64", false},
+		{"", "arm64", false},
+		{"linux/amd64", "", false},
+		{"amd64", "arm64", false},
+	}
+
+	for _, tc := range cases {
+		if got := platformEmulated(tc.platform, tc.nativeArch); got != tc.want {
+			t.Errorf("platformEmulated(%q, %q) = %v, want %v", tc.platform, tc.nativeArch, got, tc.want)
+		}
+	}
+
+	for _, invalid := range []string{"amd64", "linux/", "linux/arm/v7/extra"} {
+		if _, err := parsePlatform(invalid); err == nil {
+			t.Errorf("parsePlatform(%q) accepted an invalid platform", invalid)
+		}
+	}
+}
+
+func TestWriteTemplate(t *testing.T) {
+	names := Templates()
+	for _, want := range []string{"go", "node", "python", "ruby", "rust"} {
+		if !slices.Contains(names, want) {
+			t.Fatalf("Templates() = %v, missing %q", names, want)
+		}
+	}
+
+	for _, name := range names {
+		isoDir := filepath.Join(t.TempDir(), ".iso")
+		if err := writeTemplate(name, isoDir); err != nil {
+			t.Fatalf("writeTemplate(%q) error = %v", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(isoDir, "Dockerfile")); err != nil {
+			t.Errorf("template %q has no Dockerfile: %v", name, err)
+		}
+		if _, err := loadConfigFile(isoDir); err != nil {
+			t.Errorf("template %q has an invalid config.yml: %v", name, err)
+		}
+	}
+}
+
+func TestLoadServicesFileStrict(t *testing.T) {
+	const unknownField = `services:
+  db:
+    image: postgres:16
+    healthcheck: pg_isready
+`
+	_, err := loadServicesFile(writeIsoFile(t, "services.yml", unknownField))
+	if err == nil || !strings.Contains(err.Error(), "healthcheck") {
+		t.Fatalf("loadServicesFile() error = %v, want an error naming the unknown field", err)
+	}
+
+	// A file for a newer schema version is decoded leniently
+	services, err := loadServicesFile(writeIsoFile(t, "services.yml", "version: 99\n"+unknownField))
+	if err != nil {
+		t.Fatalf("loadServicesFile() with a newer version error = %v", err)
+	}
+	if services["db"].Image != "postgres:16" {
+		t.Fatalf("loadServicesFile() = %v, want the db service", services)
+	}
+
+	if _, err := loadServicesFile(filepath.Join("testdata", ".iso")); err != nil {
+		t.Fatalf("loadServicesFile(testdata) error = %v", err)
+	}
+}
+
+func TestExpandHostVars(t *testing.T) {
+	t.Setenv("ISO_TEST_TOKEN", "s3cret")
+	t.Setenv("ISO_TEST_EMPTY", "")
+
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"${ISO_TEST_TOKEN}", "s3cret"},
+		{"$ISO_TEST_TOKEN:${ISO_TEST_TOKEN}", "$ISO_TEST_TOKEN:s3cret"},
+		{"${ISO_TEST_UNSET}", ""},
+		{"${ISO_TEST_UNSET:-fallback}", "fallback"},
+		{"${ISO_TEST_EMPTY:-fallback}", "fallback"},
+		{"${ISO_TEST_TOKEN:-fallback}", "s3cret"},
+		{"registry.local:5000/app:${ISO_TEST_UNSET:-latest}", "registry.local:5000/app:latest"},
+		{"/opt/bin:$PATH", "/opt/bin:$PATH"},
+		{"plain", "plain"},
+	}
+
+	for _, tc := range cases {
+		if got := expandHostVars(tc.in, os.LookupEnv); got != tc.want {
+			t.Errorf("expandHostVars(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestResolveContainerUser(t *testing.T) {
+	t.Setenv("ISO_TEST_USER", "node")
+	uid, gid := strconv.Itoa(os.Getuid()), strconv.Itoa(os.Getgid())
+
+	host := ""
+	if runtime.GOOS == "linux" {
+		host = uid + ":" + gid
+	}
+
+	cases := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "", want: ""},
+		{in: "1000:1000", want: "1000:1000"},
+		{in: "node", want: "node"},
+		{in: "host", want: host},
+		{in: "$UID", want: uid},
+		{in: "${UID}:${GID}", want: uid + ":" + gid},
+		{in: "${ISO_TEST_USER}", want: "node"},
+		{in: "1000:", wantErr: true},
+		{in: "1:2:3", wantErr: true},
+		{in: "$HOME", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		got, err := resolveContainerUser(tc.in, os.LookupEnv)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("resolveContainerUser(%q) = %q, want error", tc.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("resolveContainerUser(%q) = %q, %v, want %q", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestLoadFilesExpandHostVars(t *testing.T) {
+	t.Setenv("ISO_TEST_PASSWORD", "hunter2")
+
+	config, err := loadConfigFile(writeIsoFile(t, "config.yml", "image: alpine:${ISO_TEST_TAG:-3.20}\nenvironment:\n  DB_PASSWORD: ${ISO_TEST_PASSWORD}\nbuild_args:\n  TOKEN: $ISO_TEST_PASSWORD\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Image != "alpine:3.20" || config.Environment["DB_PASSWORD"] != "hunter2" || config.BuildArgs["TOKEN"] != "hunter2" {
+		t.Errorf("loadConfigFile() = image %q, environment %v, build_args %v", config.Image, config.Environment, config.BuildArgs)
+	}
+
+	services, err := loadServicesFile(writeIsoFile(t, "services.yml", "services:\n  db:\n    image: postgres:${ISO_TEST_PG:-16}\n    environment:\n      POSTGRES_PASSWORD: ${ISO_TEST_PASSWORD}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db := services["db"]; db.Image != "postgres:16" || db.Environment["POSTGRES_PASSWORD"] != "hunter2" {
+		t.Errorf("loadServicesFile() db = image %q, environment %v", db.Image, db.Environment)
+	}
+}
+
+func TestServiceStartOrder(t *testing.T) {
+	services := map[string]ServiceConfig{
+		"app":      {DependsOn: []string{"postgres", "redis"}},
+		"migrate":  {DependsOn: []string{"postgres"}},
+		"postgres": {},
+		"redis":    {},
+		"mailhog":  {},
+	}
+
+	order, err := serviceStartOrder(services)
+	if err != nil {
+		t.Fatalf("serviceStartOrder() unexpected error: %v", err)
+	}
+	want := []string{"postgres", "redis", "app", "mailhog", "migrate"}
+	if !slices.Equal(order, want) {
+		t.Errorf("serviceStartOrder() = %v, want %v", order, want)
+	}
+
+	services["postgres"] = ServiceConfig{DependsOn: []string{"migrate"}}
+	_, err = serviceStartOrder(services)
+	if err == nil || !strings.Contains(err.Error(), "postgres -> migrate -> postgres") {
+		t.Errorf("serviceStartOrder() error = %v, want cycle postgres -> migrate -> postgres", err)
+	}
+}
+
+func TestLoadServicesFileDependsOn(t *testing.T) {
+	isoDir := writeIsoFile(t, "services.yml", "services:\n  app:\n    image: app\n    depends_on: [db]\n")
+	if _, err := loadServicesFile(isoDir); err == nil || !strings.Contains(err.Error(), `unknown service "db"`) {
+		t.Errorf("loadServicesFile() error = %v, want unknown service error", err)
+	}
+}
+
+func TestLoadServicesFileReadinessChecks(t *testing.T) {
+	services, err := loadServicesFile(writeIsoFile(t, "services.yml", "services:\n  db:\n    image: postgres\n    health_command: [pg_isready, -U, postgres]\n    ready_log: 'ready to accept connections'\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := services["db"]
+	if !slices.Equal(db.HealthCommand, []string{"pg_isready", "-U", "postgres"}) {
+		t.Errorf("HealthCommand = %v, want pg_isready -U postgres", db.HealthCommand)
+	}
+	if db.readyLog == nil || !db.readyLog.MatchString("LOG:  database system is ready to accept connections") {
+		t.Errorf("readyLog = %v, want the compiled ready_log pattern", db.readyLog)
+	}
+
+	if _, err := loadServicesFile(writeIsoFile(t, "services.yml", "services:\n  db:\n    image: postgres\n    ready_log: '(unclosed'\n")); err == nil || !strings.Contains(err.Error(), "ready_log") {
+		t.Errorf("loadServicesFile() error = %v, want a ready_log error", err)
+	}
+}
+
+func TestLoadServicesFileHealthPath(t *testing.T) {
+	services, err := loadServicesFile(writeIsoFile(t, "services.yml", "services:\n  api:\n    image: api\n    port: 8080\n    health_path: /healthz?ready=1\n"))
+	if err != nil {
+		t.Fatalf("loadServicesFile() error = %v", err)
+	}
+	if services["api"].HealthPath != "/healthz?ready=1" {
+		t.Errorf("HealthPath = %q, want /healthz?ready=1", services["api"].HealthPath)
+	}
+
+	for _, bad := range []string{
+		"    health_path: /healthz\n",
+		"    port: 8080\n    health_path: healthz\n",
+		"    port: 8080\n    health_path: /a,b\n",
+	} {
+		if _, err := loadServicesFile(writeIsoFile(t, "services.yml", "services:\n  api:\n    image: api\n"+bad)); err == nil || !strings.Contains(err.Error(), "health_path") {
+			t.Errorf("loadServicesFile(%q) error = %v, want a health_path error", bad, err)
+		}
+	}
+}
+
+func TestLoadConfigFileServiceTimeout(t *testing.T) {
+	config, err := loadConfigFile(writeIsoFile(t, "config.yml", "privileged: false\n"))
+	if err != nil {
+		t.Fatalf("loadConfigFile() error = %v", err)
+	}
+	if config.serviceTimeout != defaultServiceTimeout {
+		t.Errorf("serviceTimeout = %s, want the default %s", config.serviceTimeout, defaultServiceTimeout)
+	}
+
+	config, err = loadConfigFile(writeIsoFile(t, "config.yml", "service_timeout: 2m\n"))
+	if err != nil {
+		t.Fatalf("loadConfigFile() error = %v", err)
+	}
+	if config.serviceTimeout != 2*time.Minute {
+		t.Errorf("serviceTimeout = %s, want 2m", config.serviceTimeout)
+	}
+
+	if _, err := loadConfigFile(writeIsoFile(t, "config.yml", "service_timeout: soon\n")); err == nil || !strings.Contains(err.Error(), "service_timeout") {
+		t.Errorf("loadConfigFile() error = %v, want an invalid service_timeout error", err)
+	}
+}
+
+func TestLoadConfigFileHookScripts(t *testing.T) {
+	config, err := loadConfigFile(writeIsoFile(t, "config.yml", "workdir: /code\npre_run: scripts/setup.sh\npost_run: /opt/hooks/report.sh\n"))
+	if err != nil {
+		t.Fatalf("loadConfigFile() error = %v", err)
+	}
+	if config.PreRun != "/code/scripts/setup.sh" {
+		t.Errorf("PreRun = %q, want /code/scripts/setup.sh", config.PreRun)
+	}
+	if config.PostRun != "/opt/hooks/report.sh" {
+		t.Errorf("PostRun = %q, want /opt/hooks/report.sh", config.PostRun)
+	}
+}
+
+func TestLoadConfigFileNames(t *testing.T) {
+	config, err := loadConfigFile(writeIsoFile(t, "config.yml", "project_name: acme-api\nimage_name: acme/api-dev:latest\n"))
+	if err != nil {
+		t.Fatalf("loadConfigFile() error = %v", err)
+	}
+	if config.ProjectName != "acme-api" || config.ImageName != "acme/api-dev:latest" {
+		t.Errorf("loadConfigFile() = project_name %q, image_name %q", config.ProjectName, config.ImageName)
+	}
+
+	for _, bad := range []string{
+		"project_name: Acme API\n",
+		"image_name: Not/Valid\n",
+		"image_name: app@sha256:0000000000000000000000000000000000000000000000000000000000000000\n",
+		"image: alpine\nimage_name: app\n",
+	} {
+		if _, err := loadConfigFile(writeIsoFile(t, "config.yml", bad)); err == nil {
+			t.Errorf("loadConfigFile(%q) expected error", bad)
+		}
+	}
+
+	root := t.TempDir()
+	if base, worktree := projectNames(root, config); base != "acme-api" || worktree != "acme-api" {
+		t.Errorf("projectNames() = %q, %q, want acme-api for both", base, worktree)
+	}
+}
+
+func TestLoadConfigFileIdleTimeout(t *testing.T) {
+	config, err := loadConfigFile(writeIsoFile(t, "config.yml", "idle_timeout: 2h\n"))
+	if err != nil {
+		t.Fatalf("loadConfigFile() error = %v", err)
+	}
+	if config.idleTimeout != 2*time.Hour {
+		t.Errorf("idleTimeout = %s, want 2h", config.idleTimeout)
+	}
+
+	if _, err := loadConfigFile(writeIsoFile(t, "config.yml", "idle_timeout: 10s\n")); err == nil || !strings.Contains(err.Error(), "at least 1m") {
+		t.Errorf("loadConfigFile() error = %v, want a minimum idle_timeout error", err)
+	}
+}
@@ -0,0 +1,44 @@
+package iso
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// sessionLockPath returns the lock file that serializes container creation
+// for a persistent session of the project in isoDir
+func sessionLockPath(isoDir, session string) string {
+	return filepath.Join(isoDir, fmt.Sprintf("session-%s.lock", session))
+}
+
+// lockSession takes an exclusive flock on the session's lock file, waiting
+// for any other iso process that holds it, and returns a function that
+// releases it (safe to call more than once). Concurrent runs of one session
+// (e.g. parallel test runners) would otherwise both see no container and
+// race to create it.
+func lockSession(isoDir, session string) (func(), error) {
+	lockPath := sessionLockPath(isoDir, session)
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session lock %s: %w", lockPath, err)
+	}
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		slog.Info("waiting for another iso run to finish starting the session", "session", session)
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock session %s: %w", session, err)
+	}
+
+	return sync.OnceFunc(func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}), nil
+}
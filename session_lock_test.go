@@ -0,0 +1,72 @@
+package iso
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestLockSessionSerializesRuns launches concurrent "runs" of one session that
+// each create the container if it doesn't exist yet, and checks that exactly
+// one of them creates it
+func TestLockSessionSerializesRuns(t *testing.T) {
+	isoDir := t.TempDir()
+
+	var (
+		exists  atomic.Bool
+		created atomic.Int32
+		wg      sync.WaitGroup
+	)
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := lockSession(isoDir, "default")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer unlock()
+
+			if !exists.Load() {
+				// Widen the window between the check and the create
+				time.Sleep(10 * time.Millisecond)
+				created.Add(1)
+				exists.Store(true)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := created.Load(); got != 1 {
+		t.Errorf("container created %d times, want 1", got)
+	}
+}
+
+func TestLockSessionIsPerSession(t *testing.T) {
+	isoDir := t.TempDir()
+
+	unlock, err := lockSession(isoDir, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	done := make(chan struct{})
+	go func() {
+		unlockB, err := lockSession(isoDir, "b")
+		if err != nil {
+			t.Error(err)
+		} else {
+			unlockB()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("locking session b waited for session a")
+	}
+}
@@ -0,0 +1,144 @@
+package iso
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+)
+
+// templatesFS holds the bundled project templates, one directory per template
+// containing the files to write to .iso
+//
+//go:embed templates
+var templatesFS embed.FS
+
+// Templates returns the names of the bundled project templates
+func Templates() []string {
+	entries, err := templatesFS.ReadDir("templates")
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// templateMarkers maps files that identify a project's language to the
+// bundled template for it, in detection order
+var templateMarkers = []struct {
+	file     string
+	template string
+}{
+	{"go.mod", "go"},
+	{"Cargo.toml", "rust"},
+	{"package.json", "node"},
+	{"pyproject.toml", "python"},
+	{"requirements.txt", "python"},
+	{"setup.py", "python"},
+	{"Gemfile", "ruby"},
+}
+
+// composeFiles are the Docker Compose file names, in Docker's lookup order
+var composeFiles = []string{"compose.yaml", "compose.yml", "docker-compose.yml", "docker-compose.yaml"}
+
+// InitSuggestion describes what `iso init` could start from in a directory
+// without a .iso directory
+type InitSuggestion struct {
+	// Template is the bundled template matching the project's language
+	// ("" if none was detected)
+	Template string
+	// ComposeFile is the Docker Compose file in the directory ("" if none)
+	ComposeFile string
+}
+
+// SuggestInit inspects dir for language and Docker Compose files to suggest
+// how to initialize it
+func SuggestInit(dir string) InitSuggestion {
+	var s InitSuggestion
+	templates := Templates()
+	for _, m := range templateMarkers {
+		if _, err := os.Stat(filepath.Join(dir, m.file)); err == nil && slices.Contains(templates, m.template) {
+			s.Template = m.template
+			break
+		}
+	}
+	for _, name := range composeFiles {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			s.ComposeFile = name
+			break
+		}
+	}
+	return s
+}
+
+// InitProjectFromTemplate creates the .iso directory in the current directory
+// from a bundled template. Unlike InitProject it works offline and always
+// produces the same files.
+func InitProjectFromTemplate(name string) error {
+	if !slices.Contains(Templates(), name) {
+		return fmt.Errorf("unknown template %q (available: %v)", name, Templates())
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	isoDir := filepath.Join(cwd, ".iso")
+	if _, err := os.Stat(isoDir); err == nil {
+		return fmt.Errorf(".iso directory already exists")
+	}
+
+	if err := writeTemplate(name, isoDir); err != nil {
+		return err
+	}
+
+	slog.Info("ISO project initialized from template", "template", name)
+	fmt.Println("\nNext steps:")
+	fmt.Println("  1. Review the files in .iso and adjust them for your project")
+	fmt.Println("  2. Add .iso/services.yml if you need databases or other services")
+	fmt.Println("  3. Run 'iso build' to build the Docker image")
+	fmt.Println("  4. Run 'iso run <command>' to execute commands in the isolated environment")
+
+	return nil
+}
+
+// writeTemplate writes the files of the named template into isoDir, which
+// must not exist yet
+func writeTemplate(name, isoDir string) error {
+	templateDir := path.Join("templates", name)
+	entries, err := templatesFS.ReadDir(templateDir)
+	if err != nil {
+		return fmt.Errorf("failed to read template %s: %w", name, err)
+	}
+
+	if err := os.Mkdir(isoDir, 0755); err != nil {
+		return fmt.Errorf("failed to create .iso directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		data, err := fs.ReadFile(templatesFS, path.Join(templateDir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read template file %s: %w", entry.Name(), err)
+		}
+
+		filePath := filepath.Join(isoDir, entry.Name())
+		if err := os.WriteFile(filePath, data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", entry.Name(), err)
+		}
+		slog.Info("created "+entry.Name(), "path", filePath)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package iso
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSuggestInit(t *testing.T) {
+	cases := []struct {
+		files        []string
+		wantTemplate string
+		wantCompose  string
+	}{
+		{nil, "", ""},
+		{[]string{"go.mod", "docker-compose.yml"}, "go", "docker-compose.yml"},
+		{[]string{"package.json", "Cargo.toml"}, "rust", ""},
+		{[]string{"requirements.txt", "compose.yaml", "docker-compose.yml"}, "python", "compose.yaml"},
+		{[]string{"Gemfile"}, "ruby", ""},
+	}
+
+	for _, tc := range cases {
+		dir := t.TempDir()
+		for _, f := range tc.files {
+			if err := os.WriteFile(filepath.Join(dir, f), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got := SuggestInit(dir)
+		if got.Template != tc.wantTemplate || got.ComposeFile != tc.wantCompose {
+			t.Errorf("SuggestInit(%v) = %+v, want template %q, compose file %q", tc.files, got, tc.wantTemplate, tc.wantCompose)
+		}
+	}
+}
+
+func TestInitProjectWithoutClaude(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+	t.Setenv("PATH", t.TempDir())
+
+	err := InitProject()
+	if err == nil || !strings.Contains(err.Error(), "iso init --template go") {
+		t.Errorf("InitProject() error = %v, want a suggestion to use --template go", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".iso")); !os.IsNotExist(err) {
+		t.Errorf(".iso was created without claude")
+	}
+}
@@ -0,0 +1,173 @@
+package iso
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+
+	"github.com/distribution/reference"
+	"gopkg.in/yaml.v3"
+)
+
+// ValidateProject checks the .iso configuration of the project containing the
+// current directory, returning every problem found rather than stopping at
+// the first like loading does. Docker isn't contacted, so referenced images
+// are only checked for being well-formed. It returns an error only when there
+// is no project to check.
+func ValidateProject() ([]string, error) {
+	isoDir, _, found := findIsoDir()
+	if !found {
+		return nil, ErrNoIsoDir
+	}
+	return validateIsoDir(isoDir), nil
+}
+
+// validateIsoDir returns the problems found in the configuration files of
+// isoDir, each prefixed with the file it was found in
+func validateIsoDir(isoDir string) []string {
+	var problems []string
+	addProblem := func(file string, err error) {
+		problems = append(problems, fmt.Sprintf("%s: %v", file, err))
+	}
+
+	config, err := loadConfigFile(isoDir)
+	if err != nil {
+		addProblem("config.yml", err)
+	} else {
+		for _, err := range validateConfig(isoDir, config) {
+			addProblem("config.yml", err)
+		}
+	}
+
+	for _, err := range validateServicesFile(isoDir) {
+		addProblem("services.yml", err)
+	}
+
+	if _, err := loadPeersFile(isoDir); err != nil {
+		addProblem("peers.yml", err)
+	}
+	return problems
+}
+
+// validateConfig checks a loaded config for problems loadConfigFile leaves
+// until the container is started
+func validateConfig(isoDir string, config *Config) []error {
+	var errs []error
+
+	if config.Image == "" {
+		if _, err := os.Stat(filepath.Join(isoDir, "Dockerfile")); os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("no image is set and .iso/Dockerfile doesn't exist"))
+		}
+	} else if err := checkImageReference(config.Image); err != nil {
+		errs = append(errs, err)
+	}
+	if config.SharedImage != "" {
+		if err := checkImageReference(config.SharedImage); err != nil {
+			errs = append(errs, fmt.Errorf("shared_image: %w", err))
+		}
+	}
+
+	for _, p := range config.Volumes {
+		if !path.IsAbs(p) {
+			errs = append(errs, fmt.Errorf("volume %q must be an absolute container path", p))
+		}
+	}
+	for _, p := range config.Cache {
+		if !path.IsAbs(p) {
+			errs = append(errs, fmt.Errorf("cache %q must be an absolute container path", p))
+		}
+	}
+	return errs
+}
+
+// validateServicesFile checks every service in isoDir's services.yml,
+// reporting duplicate service names, each service's problems and, when the
+// services are otherwise valid, dependency cycles
+func validateServicesFile(isoDir string) []error {
+	data, err := os.ReadFile(filepath.Join(isoDir, "services.yml"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return []error{fmt.Errorf("failed to read services file: %w", err)}
+	}
+
+	// Duplicates make the file fail to parse, so report them by name first
+	if duplicates := duplicateServiceNames(data); len(duplicates) > 0 {
+		var errs []error
+		for _, name := range duplicates {
+			errs = append(errs, fmt.Errorf("service %q is defined more than once", name))
+		}
+		return errs
+	}
+
+	servicesFile, err := parseServicesFile(data)
+	if err != nil {
+		return []error{err}
+	}
+
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(servicesFile.Services)) {
+		config, err := normalizeService(name, servicesFile.Services[name], servicesFile.Services, filepath.Dir(isoDir), os.LookupEnv)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if config.Build != "" {
+			continue
+		}
+		if err := checkImageReference(config.Image); err != nil {
+			errs = append(errs, fmt.Errorf("service %q: %w", name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	if _, err := serviceStartOrder(servicesFile.Services); err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
+// duplicateServiceNames returns the names defined more than once under the
+// services key of a services.yml document, in order of first duplicate
+func duplicateServiceNames(data []byte) []string {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	var duplicates []string
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "services" || root.Content[i+1].Kind != yaml.MappingNode {
+			continue
+		}
+		seen := make(map[string]bool)
+		services := root.Content[i+1].Content
+		for j := 0; j+1 < len(services); j += 2 {
+			name := services[j].Value
+			if seen[name] && !slices.Contains(duplicates, name) {
+				duplicates = append(duplicates, name)
+			}
+			seen[name] = true
+		}
+	}
+	return duplicates
+}
+
+// checkImageReference returns an error when ref isn't a well-formed image
+// reference such as "postgres:16" or "ghcr.io/org/app@sha256:..."
+func checkImageReference(ref string) error {
+	if _, err := reference.ParseNormalizedNamed(ref); err != nil {
+		return fmt.Errorf("image %q is not a valid image reference: %w", ref, err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package iso
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateIsoDir(t *testing.T) {
+	isoDir := writeIsoFile(t, "config.yml", `
+volumes:
+  - /data
+  - node_modules
+cache:
+  - go-build
+`)
+	if err := os.WriteFile(filepath.Join(isoDir, "services.yml"), []byte(`
+services:
+  db:
+    image: postgres:16
+  cache:
+    port: 6379
+  web:
+    image: "Not A Valid/Image"
+    depends_on: [db]
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	problems := validateIsoDir(isoDir)
+	want := []string{
+		`config.yml: no image is set and .iso/Dockerfile doesn't exist`,
+		`config.yml: volume "node_modules" must be an absolute container path`,
+		`config.yml: cache "go-build" must be an absolute container path`,
+		`services.yml: service "cache" is missing required 'image' field`,
+		`services.yml: service "web": image "Not A Valid/Image" is not a valid image reference`,
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("validateIsoDir() = %q, want %d problems", problems, len(want))
+	}
+	for i := range want {
+		if !strings.HasPrefix(problems[i], want[i]) {
+			t.Errorf("problem %d = %q, want prefix %q", i, problems[i], want[i])
+		}
+	}
+}
+
+func TestValidateIsoDirValid(t *testing.T) {
+	isoDir := writeIsoFile(t, "Dockerfile", "FROM alpine\n")
+	if err := os.WriteFile(filepath.Join(isoDir, "services.yml"), []byte(`
+services:
+  db:
+    image: postgres:16
+    port: 5432
+  api:
+    image: ghcr.io/example/api:latest
+    depends_on: [db]
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if problems := validateIsoDir(isoDir); len(problems) != 0 {
+		t.Errorf("validateIsoDir() = %q, want no problems", problems)
+	}
+}
+
+func TestDuplicateServiceNames(t *testing.T) {
+	data := []byte(`
+services:
+  db:
+    image: postgres:16
+  redis:
+    image: redis:7
+  db:
+    image: postgres:15
+`)
+	if got := duplicateServiceNames(data); len(got) != 1 || got[0] != "db" {
+		t.Errorf("duplicateServiceNames() = %v, want [db]", got)
+	}
+}
@@ -0,0 +1,234 @@
+package iso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/moby/patternmatcher"
+)
+
+// watchIgnoredDirs are project directories whose changes never trigger a
+// re-run: version control metadata, and .iso, where runs write logs and locks
+var watchIgnoredDirs = []string{".git", ".hg", ".svn", ".iso"}
+
+// WatchProject watches the project directory for file changes, for re-running
+// a command whenever a source file changes. Changes are collected until none
+// arrive for debounce and then sent as one batch of paths relative to the
+// project root. Batches arriving while the previous one hasn't been received
+// are merged. Version control metadata, .iso and directories the container
+// mounts volumes over (volumes and writable_paths) are ignored, as is
+// anything the project's .gitignore matches, so build outputs written into the
+// project (node_modules, dist, target) don't re-trigger the command. Watching
+// stops, and the channel is closed, when ctx is done.
+func (c *Client) WatchProject(ctx context.Context, debounce time.Duration) (<-chan []string, error) {
+	ignores, err := c.containerManager.watchIgnores()
+	if err != nil {
+		return nil, err
+	}
+	return watchDir(ctx, c.containerManager.projectRoot, ignores, debounce)
+}
+
+// watchIgnores decides which project paths WatchProject ignores
+type watchIgnores struct {
+	// dirs are project-relative directories ignored with everything under them
+	dirs []string
+	// gitignore matches the patterns of the project's .gitignore, nil without one
+	gitignore *patternmatcher.PatternMatcher
+}
+
+// watchIgnores returns what WatchProject ignores for this project
+func (cm *containerManager) watchIgnores() (watchIgnores, error) {
+	ignores := watchIgnores{dirs: slices.Clone(watchIgnoredDirs)}
+	for _, p := range append(slices.Clone(cm.config.Volumes), cm.config.WritablePaths...) {
+		if rel, ok := strings.CutPrefix(path.Clean(p), path.Clean(cm.config.WorkDir)+"/"); ok {
+			ignores.dirs = append(ignores.dirs, rel)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(cm.projectRoot, ".gitignore"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ignores, nil
+	} else if err != nil {
+		return ignores, fmt.Errorf("failed to read .gitignore: %w", err)
+	}
+	patterns := gitignorePatterns(string(data))
+	if len(patterns) > 0 {
+		ignores.gitignore, err = patternmatcher.New(patterns)
+		if err != nil {
+			return ignores, fmt.Errorf("failed to parse .gitignore: %w", err)
+		}
+	}
+	return ignores, nil
+}
+
+// gitignorePatterns converts the lines of a .gitignore into patternmatcher
+// patterns. Patterns without a slash match at any depth, a leading slash
+// anchors to the root, and a trailing slash (directories only) is dropped, so
+// such a pattern also matches a file of that name.
+func gitignorePatterns(data string) []string {
+	var patterns []string
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		negate := strings.HasPrefix(line, "!")
+		line = strings.TrimPrefix(line, "!")
+		line = strings.TrimPrefix(line, "\\")
+		line = strings.TrimSuffix(line, "/")
+		if line == "" {
+			continue
+		}
+
+		if strings.Contains(line, "/") {
+			line = strings.TrimPrefix(line, "/")
+		} else {
+			line = "**/" + line
+		}
+		if negate {
+			line = "!" + line
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns
+}
+
+// ignored reports whether rel, a slash-separated path relative to the
+// watched root, is or is under an ignored directory, or matches .gitignore
+func (w watchIgnores) ignored(rel string) bool {
+	for _, dir := range w.dirs {
+		if rel == dir || strings.HasPrefix(rel, dir+"/") {
+			return true
+		}
+	}
+	if w.gitignore != nil {
+		matched, err := w.gitignore.MatchesOrParentMatches(rel)
+		return err == nil && matched
+	}
+	return false
+}
+
+// watchDir watches root and every directory under it, except ignored ones,
+// sending debounced batches of changed paths as WatchProject describes
+func watchDir(ctx context.Context, root string, ignores watchIgnores, debounce time.Duration) (<-chan []string, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file watcher: %w", err)
+	}
+
+	if err := addWatchTree(watcher, root, root, ignores); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	changes := make(chan []string, 1)
+	go func() {
+		defer close(changes)
+		defer watcher.Close()
+
+		pending := make(map[string]bool)
+		var flush <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op == fsnotify.Chmod {
+					continue
+				}
+				rel, err := filepath.Rel(root, event.Name)
+				if err != nil {
+					continue
+				}
+				rel = filepath.ToSlash(rel)
+				if ignores.ignored(rel) {
+					continue
+				}
+
+				// Directories created after watching started need their own watch
+				if event.Has(fsnotify.Create) {
+					if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+						if err := addWatchTree(watcher, root, event.Name, ignores); err != nil {
+							slog.Warn("failed to watch new directory", "dir", rel, "error", err)
+						}
+					}
+				}
+
+				pending[rel] = true
+				flush = time.After(debounce)
+
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("file watch error", "error", err)
+
+			case <-flush:
+				batch := make([]string, 0, len(pending))
+				for rel := range pending {
+					batch = append(batch, rel)
+				}
+				slices.Sort(batch)
+
+				select {
+				case changes <- batch:
+					pending = make(map[string]bool)
+					flush = nil
+				default:
+					// The last batch is still unread; merge into the next one
+					flush = time.After(debounce)
+				}
+			}
+		}
+	}()
+
+	return changes, nil
+}
+
+// addWatchTree adds dir and the directories under it to watcher, skipping
+// ignored directories
+func addWatchTree(watcher *fsnotify.Watcher, root, dir string, ignores watchIgnores) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// The directory may be gone already
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if rel != "." && ignores.ignored(filepath.ToSlash(rel)) {
+			return filepath.SkipDir
+		}
+
+		if err := watcher.Add(p); err != nil {
+			if errors.Is(err, syscall.ENOSPC) {
+				return fmt.Errorf("failed to watch %s: out of inotify watches (raise fs.inotify.max_user_watches): %w", p, err)
+			}
+			return fmt.Errorf("failed to watch %s: %w", p, err)
+		}
+		return nil
+	})
+}
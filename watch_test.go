@@ -0,0 +1,127 @@
+package iso
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWatchIgnores(t *testing.T) {
+	cm := &containerManager{config: &Config{
+		WorkDir:       "/workspace",
+		Volumes:       []string{"/workspace/node_modules", "/data"},
+		WritablePaths: []string{"/workspace/build/out/"},
+	}}
+
+	ignores, err := cm.watchIgnores()
+	if err != nil {
+		t.Fatalf("watchIgnores() error = %v", err)
+	}
+	for _, rel := range []string{".git", ".git/HEAD", ".iso/startup.log", "node_modules/x/index.js", "build/out/a.o"} {
+		if !ignores.ignored(rel) {
+			t.Errorf("ignored(%q) = false, want true", rel)
+		}
+	}
+	for _, rel := range []string{"main.go", ".github/workflows/ci.yml", "build/main.go", "data/x"} {
+		if ignores.ignored(rel) {
+			t.Errorf("ignored(%q) = true, want false", rel)
+		}
+	}
+}
+
+func TestWatchIgnoresGitignore(t *testing.T) {
+	root := t.TempDir()
+	gitignore := "# build outputs\ndist/\n__pycache__/\n/target\n*.log\n!keep.log\ndocs/_build\n"
+	if err := os.WriteFile(filepath.Join(root, ".gitignore"), []byte(gitignore), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cm := &containerManager{projectRoot: root, config: &Config{WorkDir: "/workspace"}}
+
+	ignores, err := cm.watchIgnores()
+	if err != nil {
+		t.Fatalf("watchIgnores() error = %v", err)
+	}
+	for _, rel := range []string{"dist", "dist/app.js", "web/dist/app.js", "pkg/__pycache__/m.pyc", "target/debug/app", "run.log", "logs/run.log", "docs/_build/index.html"} {
+		if !ignores.ignored(rel) {
+			t.Errorf("ignored(%q) = false, want true", rel)
+		}
+	}
+	for _, rel := range []string{"main.go", "keep.log", "distro/x", "src/target/x.rs", "web/docs/_build/x"} {
+		if ignores.ignored(rel) {
+			t.Errorf("ignored(%q) = true, want false", rel)
+		}
+	}
+}
+
+func TestWatchDir(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := os.WriteFile(filepath.Join(root, ".gitignore"), []byte("dist/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ignores, err := (&containerManager{projectRoot: root, config: &Config{}}).watchIgnores()
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := watchDir(ctx, root, ignores, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("watchDir() error = %v", err)
+	}
+
+	write := func(rel string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(root, rel), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receive := func() []string {
+		t.Helper()
+		select {
+		case batch := <-changes:
+			return batch
+		case <-time.After(5 * time.Second):
+			t.Fatal("no change batch received")
+			return nil
+		}
+	}
+
+	write(".git/index")
+	write("main.go")
+	if batch := receive(); !slices.Equal(batch, []string{"main.go"}) {
+		t.Errorf("batch = %v, want [main.go]", batch)
+	}
+
+	// Files in directories created after watching started are seen too
+	if err := os.Mkdir(filepath.Join(root, "pkg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if batch := receive(); !slices.Equal(batch, []string{"pkg"}) {
+		t.Errorf("batch = %v, want [pkg]", batch)
+	}
+	write("pkg/util.go")
+	if batch := receive(); !slices.Equal(batch, []string{"pkg/util.go"}) {
+		t.Errorf("batch = %v, want [pkg/util.go]", batch)
+	}
+
+	// Output the command writes into a gitignored directory doesn't re-trigger it
+	if err := os.Mkdir(filepath.Join(root, "dist"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write("dist/app.js")
+	write("main.go")
+	if batch := receive(); !slices.Equal(batch, []string{"main.go"}) {
+		t.Errorf("batch = %v, want [main.go]", batch)
+	}
+
+	cancel()
+	for range changes {
+	}
+}